@@ -10,11 +10,18 @@ import (
 
 	"github.com/d44b/pulltrace/internal/server"
 	"github.com/d44b/pulltrace/web"
+	"github.com/spf13/pflag"
 )
 
 func main() {
 	cfg := server.ConfigFromEnv()
 
+	// Flags override the environment for reaching the API server, so the
+	// server can run outside the cluster like kubectl.
+	flags := pflag.NewFlagSet(os.Args[0], pflag.ExitOnError)
+	cfg.Kube.BindFlags(flags)
+	flags.Parse(os.Args[1:]) //nolint:errcheck // ExitOnError
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
@@ -4,15 +4,20 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	ctrd "github.com/d44b/pulltrace/internal/containerd"
+	"github.com/d44b/pulltrace/internal/metrics"
 	"github.com/d44b/pulltrace/internal/model"
+	"github.com/d44b/pulltrace/internal/version"
 )
 
 // allowedSocketPrefixes restricts the agent to containerd sockets, preventing
@@ -26,9 +31,13 @@ type Config struct {
 	NodeName         string
 	ServerURL        string
 	ContainerdSocket string
-	ReportInterval   time.Duration
-	LogLevel         string
-	AgentToken       string
+	// ActiveInterval is the poll period while containerd has ingests in
+	// flight; IdleInterval is the heartbeat period otherwise.
+	ActiveInterval time.Duration
+	IdleInterval   time.Duration
+	LogLevel       string
+	AgentToken     string
+	HTTPAddr       string // if non-empty, serve the node-local API on this address
 }
 
 func ConfigFromEnv() Config {
@@ -38,20 +47,35 @@ func ConfigFromEnv() Config {
 		ContainerdSocket: envOrDefault("PULLTRACE_CONTAINERD_SOCKET", "/run/containerd/containerd.sock"),
 		LogLevel:         envOrDefault("PULLTRACE_LOG_LEVEL", "info"),
 		AgentToken:       os.Getenv("PULLTRACE_AGENT_TOKEN"),
+		HTTPAddr:         os.Getenv("PULLTRACE_AGENT_HTTP_ADDR"),
 	}
 
+	c.ActiveInterval = durationEnv("PULLTRACE_ACTIVE_INTERVAL", 250*time.Millisecond)
+	c.IdleInterval = durationEnv("PULLTRACE_IDLE_INTERVAL", 5*time.Second)
+
+	// A fixed report interval disables adaptive polling.
 	if interval := os.Getenv("PULLTRACE_REPORT_INTERVAL"); interval != "" {
-		if d, err := time.ParseDuration(interval); err == nil {
-			c.ReportInterval = d
+		if d, err := time.ParseDuration(interval); err == nil && d > 0 {
+			c.ActiveInterval = d
+			c.IdleInterval = d
 		}
 	}
-	if c.ReportInterval == 0 {
-		c.ReportInterval = 1 * time.Second
+	if c.IdleInterval < c.ActiveInterval {
+		c.IdleInterval = c.ActiveInterval
 	}
 
 	return c
 }
 
+func durationEnv(key string, defaultVal time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil && d > 0 {
+			return d
+		}
+	}
+	return defaultVal
+}
+
 func envOrDefault(key, defaultVal string) string {
 	if v := os.Getenv(key); v != "" {
 		return v
@@ -64,6 +88,13 @@ type Agent struct {
 	watcher *ctrd.Watcher
 	client  *http.Client
 	logger  *slog.Logger
+	// containerdUp reflects whether the most recent poll reached containerd.
+	containerdUp atomic.Bool
+	// active is true while the agent is polling at ActiveInterval.
+	active bool
+	// pollErrors and reportErrors are cumulative and sent with each report.
+	pollErrors   int64
+	reportErrors int64
 }
 
 func New(cfg Config) *Agent {
@@ -87,11 +118,14 @@ func New(cfg Config) *Agent {
 
 func (a *Agent) Run(ctx context.Context) error {
 	a.logger.Info("starting pulltrace agent",
+		"version", version.Version,
 		"node", a.config.NodeName,
 		"server", a.config.ServerURL,
 		"socket", a.config.ContainerdSocket,
-		"interval", a.config.ReportInterval,
+		"activeInterval", a.config.ActiveInterval,
+		"idleInterval", a.config.IdleInterval,
 		"tokenAuth", a.config.AgentToken != "",
+		"httpAddr", a.config.HTTPAddr,
 	)
 
 	// Validate socket path to prevent connecting to non-containerd sockets.
@@ -105,37 +139,98 @@ func (a *Agent) Run(ctx context.Context) error {
 	defer a.watcher.Close()
 
 	a.logger.Info("connected to containerd")
+	a.setContainerdUp(true)
 
-	ticker := time.NewTicker(a.config.ReportInterval)
-	defer ticker.Stop()
+	if a.config.HTTPAddr != "" {
+		go a.serveHTTP(ctx)
+	}
+
+	timer := time.NewTimer(a.config.ActiveInterval)
+	defer timer.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
 			a.logger.Info("agent shutting down")
 			return nil
-		case <-ticker.C:
+		case <-timer.C:
 			if err := a.pollAndReport(ctx); err != nil {
-				a.logger.Error("poll/report failed", "error", err)
+				if errors.Is(err, ctrd.ErrDisconnected) {
+					a.reconnect(ctx, err)
+				} else {
+					a.logger.Error("poll/report failed", "error", err)
+				}
 			}
+			timer.Reset(a.nextInterval())
 		}
 	}
 }
 
+// nextInterval polls quickly while ingests are in flight so rates stay
+// accurate, and falls back to a slow heartbeat on idle nodes.
+func (a *Agent) nextInterval() time.Duration {
+	active := a.watcher.ActiveIngests() > 0
+	if active != a.active {
+		a.active = active
+		a.logger.Debug("switching poll interval", "active", active)
+	}
+	if active {
+		return a.config.ActiveInterval
+	}
+	return a.config.IdleInterval
+}
+
+// reconnect blocks until the containerd connection is re-established or ctx
+// is cancelled. Polling is pointless while disconnected, so the report loop
+// pauses; the local HTTP API keeps serving and reports not-ready meanwhile.
+func (a *Agent) reconnect(ctx context.Context, cause error) {
+	a.logger.Warn("containerd connection lost, reconnecting", "error", cause)
+	a.setContainerdUp(false)
+
+	start := time.Now()
+	err := a.watcher.Reconnect(ctx, func(attempt int, err error, next time.Duration) {
+		metrics.AgentContainerdReconnectFailures.Inc()
+		a.logger.Warn("containerd reconnect failed",
+			"attempt", attempt,
+			"error", err,
+			"retryIn", next,
+		)
+	})
+	if err != nil {
+		return
+	}
+
+	metrics.AgentContainerdReconnects.Inc()
+	a.setContainerdUp(true)
+	a.logger.Info("reconnected to containerd", "downtime", time.Since(start))
+}
+
 func (a *Agent) pollAndReport(ctx context.Context) error {
+	start := time.Now()
 	states, err := a.watcher.Poll(ctx)
+	metrics.AgentPollDurationSeconds.Observe(time.Since(start).Seconds())
 	if err != nil {
+		a.pollErrors++
+		a.setContainerdUp(false)
 		return fmt.Errorf("polling containerd: %w", err)
 	}
+	a.setContainerdUp(true)
+	metrics.AgentIngestsActive.Set(float64(a.watcher.ActiveIngests()))
 
 	report := model.AgentReport{
-		NodeName:  a.config.NodeName,
-		Timestamp: time.Now(),
-		Pulls:     states,
+		ProtocolVersion:  model.ProtocolVersion,
+		Capabilities:     model.AgentCapabilities,
+		NodeName:         a.config.NodeName,
+		Timestamp:        time.Now(),
+		HeartbeatSeconds: a.config.IdleInterval.Seconds(),
+		AgentVersion:     version.Version,
+		PollErrors:       a.pollErrors,
+		ReportErrors:     a.reportErrors,
+		Pulls:            states,
 	}
 
 	for _, p := range states {
-		a.logger.Info("pull.progress",
+		a.logger.Debug("pull.progress",
 			"imageRef", p.ImageRef,
 			"layers", len(p.Layers),
 			"totalKnown", p.TotalKnown,
@@ -146,7 +241,22 @@ func (a *Agent) pollAndReport(ctx context.Context) error {
 		return nil
 	}
 
-	return a.sendReport(ctx, report)
+	if err := a.sendReport(ctx, report); err != nil {
+		a.reportErrors++
+		metrics.AgentReportFailures.Inc()
+		return err
+	}
+	metrics.AgentReportsSent.Inc()
+	return nil
+}
+
+func (a *Agent) setContainerdUp(up bool) {
+	a.containerdUp.Store(up)
+	if up {
+		metrics.AgentContainerdUp.Set(1)
+	} else {
+		metrics.AgentContainerdUp.Set(0)
+	}
 }
 
 func (a *Agent) sendReport(ctx context.Context, report model.AgentReport) error {
@@ -171,6 +281,12 @@ func (a *Agent) sendReport(ctx context.Context, report model.AgentReport) error
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusConflict {
+		var rej model.ReportRejection
+		if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&rej); err == nil && rej.Error != "" {
+			return fmt.Errorf("server rejected report: %s", rej.Error)
+		}
+	}
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("server returned %d", resp.StatusCode)
 	}
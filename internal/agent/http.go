@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/metrics"
+	"github.com/d44b/pulltrace/internal/model"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// serveHTTP exposes the agent's node-local view so a single node can be
+// debugged without going through the central server.
+func (a *Agent) serveHTTP(ctx context.Context) {
+	srv := &http.Server{
+		Addr:              a.config.HTTPAddr,
+		Handler:           a.httpHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       10 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx) //nolint:errcheck
+	}()
+
+	a.logger.Info("agent http server started", "addr", a.config.HTTPAddr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		a.logger.Error("agent http server failed", "error", err)
+	}
+}
+
+func (a *Agent) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pulls", a.handlePulls)
+	mux.HandleFunc("/healthz", a.handleHealthz)
+	mux.HandleFunc("/readyz", a.handleReadyz)
+	mux.Handle("/metrics", promhttp.HandlerFor(metrics.AgentRegistry, promhttp.HandlerOpts{}))
+	return mux
+}
+
+func (a *Agent) handlePulls(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pulls := a.watcher.Snapshot()
+	if pulls == nil {
+		pulls = []model.PullState{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model.AgentReport{ //nolint:errcheck
+		NodeName:  a.config.NodeName,
+		Timestamp: time.Now(),
+		Pulls:     pulls,
+	})
+}
+
+func (a *Agent) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if !a.containerdUp.Load() {
+		http.Error(w, "containerd unreachable", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok")) //nolint:errcheck
+}
+
+// handleReadyz reports not-ready while the watcher is reconnecting, so the pod
+// drops out of service endpoints until containerd is reachable again.
+func (a *Agent) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if !a.watcher.Connected() {
+		http.Error(w, "reconnecting to containerd", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok")) //nolint:errcheck
+}
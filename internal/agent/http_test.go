@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+func newTestAgent() *Agent {
+	return New(Config{NodeName: "node1", LogLevel: "error"})
+}
+
+func TestHandlePulls_Empty(t *testing.T) {
+	a := newTestAgent()
+	w := httptest.NewRecorder()
+	a.httpHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/pulls", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp model.AgentReport
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if resp.NodeName != "node1" {
+		t.Errorf("NodeName: want node1, got %q", resp.NodeName)
+	}
+	if resp.Pulls == nil || len(resp.Pulls) != 0 {
+		t.Errorf("expected empty pulls array, got %v", resp.Pulls)
+	}
+}
+
+func TestHandlePulls_MethodNotAllowed(t *testing.T) {
+	a := newTestAgent()
+	w := httptest.NewRecorder()
+	a.httpHandler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/pulls", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405, got %d", w.Code)
+	}
+}
+
+func TestHandleHealthz_ReflectsContainerd(t *testing.T) {
+	a := newTestAgent()
+
+	w := httptest.NewRecorder()
+	a.httpHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("before connect: expected 503, got %d", w.Code)
+	}
+
+	a.setContainerdUp(true)
+	w = httptest.NewRecorder()
+	a.httpHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("connected: expected 200, got %d", w.Code)
+	}
+}
+
+func TestHandleMetrics_ExposesAgentSeries(t *testing.T) {
+	a := newTestAgent()
+	a.setContainerdUp(true)
+	w := httptest.NewRecorder()
+	a.httpHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "pulltrace_agent_containerd_up 1") {
+		t.Errorf("expected pulltrace_agent_containerd_up in output:\n%s", w.Body.String())
+	}
+}
+
+func TestHandleReadyz_NotConnected(t *testing.T) {
+	a := newTestAgent()
+	w := httptest.NewRecorder()
+	a.httpHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 while disconnected, got %d", w.Code)
+	}
+}
@@ -0,0 +1,288 @@
+// Package alerts evaluates declarative alerting rules against the pulls a
+// server tracks.
+package alerts
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/metrics"
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+// Engine holds the state of every alert. It is safe for concurrent use.
+type Engine struct {
+	rules []*rule
+	// retention is how long resolved alerts stay listed.
+	retention time.Duration
+
+	mu sync.Mutex
+	// active maps rule and series labels -> pending or firing alert.
+	active   map[string]*model.Alert
+	resolved []model.Alert
+}
+
+// NewEngine returns an engine for rules that lists resolved alerts for
+// retention.
+func NewEngine(rules []Rule, retention time.Duration) (*Engine, error) {
+	compiled, err := compileAll(rules)
+	if err != nil {
+		return nil, err
+	}
+	return &Engine{rules: compiled, retention: retention, active: make(map[string]*model.Alert)}, nil
+}
+
+// Evaluate runs every rule against pulls, the active and recently completed
+// pulls, and returns the alerts that started firing or resolved.
+func (e *Engine) Evaluate(pulls []model.PullStatus, now time.Time) []model.Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var changed []model.Alert
+	seen := make(map[string]bool)
+	byMetric := make(map[Metric][]sample)
+	for _, r := range e.rules {
+		samples, ok := byMetric[r.metric]
+		if !ok {
+			samples = collect(r.metric, pulls, now)
+			byMetric[r.metric] = samples
+		}
+		for _, s := range samples {
+			if !r.selects(s.matchable()) || !r.holds(s.value) {
+				continue
+			}
+			key := r.Name + "\x00" + labelsKey(s.labels)
+			seen[key] = true
+			a, ok := e.active[key]
+			if !ok {
+				a = &model.Alert{
+					Rule:     r.Name,
+					Severity: r.Severity,
+					State:    model.AlertPending,
+					Labels:   s.labels,
+					ActiveAt: now,
+				}
+				e.active[key] = a
+			}
+			a.Value = s.value
+			a.Annotations = s.annotations
+			a.Summary = r.render(a)
+			if a.State == model.AlertPending && now.Sub(a.ActiveAt) >= r.For.Duration {
+				firedAt := now
+				a.State = model.AlertFiring
+				a.FiredAt = &firedAt
+				metrics.AlertTransitions.WithLabelValues(a.Rule, a.Severity, string(a.State)).Inc()
+				changed = append(changed, *a)
+			}
+		}
+	}
+
+	for key, a := range e.active {
+		if seen[key] {
+			continue
+		}
+		delete(e.active, key)
+		if a.State != model.AlertFiring {
+			continue
+		}
+		resolvedAt := now
+		a.State = model.AlertResolved
+		a.ResolvedAt = &resolvedAt
+		metrics.AlertTransitions.WithLabelValues(a.Rule, a.Severity, string(a.State)).Inc()
+		e.resolved = append(e.resolved, *a)
+		changed = append(changed, *a)
+	}
+
+	cutoff := now.Add(-e.retention)
+	kept := e.resolved[:0]
+	for _, a := range e.resolved {
+		if a.ResolvedAt.After(cutoff) {
+			kept = append(kept, a)
+		}
+	}
+	e.resolved = kept
+
+	e.updateMetrics()
+	return changed
+}
+
+// Alerts returns the firing, pending and recently resolved alerts, in that
+// order and oldest first within each state.
+func (e *Engine) Alerts() []model.Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	alerts := make([]model.Alert, 0, len(e.active)+len(e.resolved))
+	for _, a := range e.active {
+		alerts = append(alerts, *a)
+	}
+	alerts = append(alerts, e.resolved...)
+	rank := map[model.AlertState]int{model.AlertFiring: 0, model.AlertPending: 1, model.AlertResolved: 2}
+	sort.SliceStable(alerts, func(i, j int) bool {
+		if rank[alerts[i].State] != rank[alerts[j].State] {
+			return rank[alerts[i].State] < rank[alerts[j].State]
+		}
+		if !alerts[i].ActiveAt.Equal(alerts[j].ActiveAt) {
+			return alerts[i].ActiveAt.Before(alerts[j].ActiveAt)
+		}
+		return alerts[i].Rule < alerts[j].Rule
+	})
+	return alerts
+}
+
+// Reset forgets every alert without resolving it, for a server replica that
+// no longer holds pull state.
+func (e *Engine) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.active = make(map[string]*model.Alert)
+	e.resolved = nil
+	e.updateMetrics()
+}
+
+// updateMetrics sets the alerts gauge from the active alerts. e.mu must be
+// held.
+func (e *Engine) updateMetrics() {
+	metrics.Alerts.Reset()
+	for _, a := range e.active {
+		metrics.Alerts.WithLabelValues(a.Rule, a.Severity, string(a.State)).Inc()
+	}
+}
+
+// sample is one labelled value of a metric. Labels identify the series;
+// annotations describe it and may change without starting a new one.
+type sample struct {
+	labels      map[string]string
+	annotations map[string]string
+	value       float64
+}
+
+// matchable returns the labels and annotations that rule matchers see.
+func (s sample) matchable() map[string]string {
+	if len(s.annotations) == 0 {
+		return s.labels
+	}
+	m := make(map[string]string, len(s.labels)+len(s.annotations))
+	for k, v := range s.annotations {
+		m[k] = v
+	}
+	for k, v := range s.labels {
+		m[k] = v
+	}
+	return m
+}
+
+// collect computes the series of metric from pulls.
+func collect(metric Metric, pulls []model.PullStatus, now time.Time) []sample {
+	var samples []sample
+	switch metric {
+	case MetricPullDuration, MetricPullRate:
+		for _, p := range pulls {
+			if p.CompletedAt != nil {
+				continue
+			}
+			value := p.BytesPerSec
+			if metric == MetricPullDuration {
+				value = now.Sub(p.StartedAt).Seconds()
+			}
+			samples = append(samples, sample{
+				labels:      labelSet("pull", p.ID, "cluster", p.Cluster, "node", p.NodeName, "image", p.ImageRef),
+				annotations: podAnnotations(p.Pods),
+				value:       value,
+			})
+		}
+
+	case MetricPodImageWait:
+		// A pod is blocked for as long as its oldest active pull has run.
+		type podKey struct{ cluster, namespace, pod string }
+		oldest := make(map[podKey]model.PullStatus)
+		for _, p := range pulls {
+			if p.CompletedAt != nil {
+				continue
+			}
+			for _, pod := range p.Pods {
+				k := podKey{p.Cluster, pod.Namespace, pod.PodName}
+				if prev, ok := oldest[k]; !ok || p.StartedAt.Before(prev.StartedAt) {
+					oldest[k] = p
+				}
+			}
+		}
+		for k, p := range oldest {
+			samples = append(samples, sample{
+				labels: labelSet("cluster", k.cluster, "namespace", k.namespace, "pod", k.pod, "node", p.NodeName, "image", p.ImageRef),
+				value:  now.Sub(p.StartedAt).Seconds(),
+			})
+		}
+
+	case MetricNodeFailureRatio:
+		type nodeKey struct{ cluster, node string }
+		type counts struct{ completed, failed int }
+		byNode := make(map[nodeKey]*counts)
+		for _, p := range pulls {
+			if p.CompletedAt == nil {
+				continue
+			}
+			k := nodeKey{p.Cluster, p.NodeName}
+			c, ok := byNode[k]
+			if !ok {
+				c = &counts{}
+				byNode[k] = c
+			}
+			c.completed++
+			if p.Error != "" {
+				c.failed++
+			}
+		}
+		for k, c := range byNode {
+			samples = append(samples, sample{
+				labels: labelSet("cluster", k.cluster, "node", k.node),
+				value:  float64(c.failed) / float64(c.completed),
+			})
+		}
+	}
+	return samples
+}
+
+// podAnnotations describes the pods waiting on a pull: namespace and pod of
+// the first, and pods listing every one as namespace/name. Pods are
+// correlated after a pull starts and come and go while it runs, so they are
+// not part of the series' identity.
+func podAnnotations(pods []model.PodCorrelation) map[string]string {
+	if len(pods) == 0 {
+		return nil
+	}
+	names := make([]string, len(pods))
+	for i, pod := range pods {
+		names[i] = pod.Namespace + "/" + pod.PodName
+	}
+	return labelSet("namespace", pods[0].Namespace, "pod", pods[0].PodName, "pods", strings.Join(names, ","))
+}
+
+// labelSet builds a label map from name/value pairs, leaving out empty
+// values.
+func labelSet(pairs ...string) map[string]string {
+	labels := make(map[string]string, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			labels[pairs[i]] = pairs[i+1]
+		}
+	}
+	return labels
+}
+
+func labelsKey(labels map[string]string) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(labels[name])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
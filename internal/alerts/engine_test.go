@@ -0,0 +1,194 @@
+package alerts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newEngine(t *testing.T, rules ...Rule) *Engine {
+	t.Helper()
+	e, err := NewEngine(rules, 30*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestEngine_ForDurationAndResolve(t *testing.T) {
+	e := newEngine(t, Rule{
+		Name:     "SlowPull",
+		Expr:     "pull_duration_seconds > 300",
+		For:      metav1.Duration{Duration: time.Minute},
+		Severity: "warning",
+		Summary:  "{{ .Labels.image }} on {{ .Labels.node }} has run {{ printf \"%.0f\" .Value }}s",
+	})
+	start := time.Now()
+	pull := model.PullStatus{ID: "node1:big:1@1", NodeName: "node1", ImageRef: "big:1", StartedAt: start}
+
+	if changed := e.Evaluate([]model.PullStatus{pull}, start.Add(4*time.Minute)); len(changed) != 0 {
+		t.Fatalf("below threshold: %+v", changed)
+	}
+	if changed := e.Evaluate([]model.PullStatus{pull}, start.Add(5*time.Minute+time.Second)); len(changed) != 0 {
+		t.Fatalf("pending alert reported as changed: %+v", changed)
+	}
+	if got := e.Alerts(); len(got) != 1 || got[0].State != model.AlertPending {
+		t.Fatalf("alerts = %+v", got)
+	}
+
+	changed := e.Evaluate([]model.PullStatus{pull}, start.Add(6*time.Minute+time.Second))
+	if len(changed) != 1 || changed[0].State != model.AlertFiring || changed[0].FiredAt == nil {
+		t.Fatalf("changed = %+v", changed)
+	}
+	a := changed[0]
+	if a.Rule != "SlowPull" || a.Severity != "warning" || a.Labels["image"] != "big:1" || a.Labels["pull"] != pull.ID {
+		t.Errorf("alert = %+v", a)
+	}
+	if a.Summary != "big:1 on node1 has run 361s" {
+		t.Errorf("summary = %q", a.Summary)
+	}
+
+	// The pull completes, so the alert resolves.
+	completed := start.Add(7 * time.Minute)
+	pull.CompletedAt = &completed
+	changed = e.Evaluate([]model.PullStatus{pull}, completed)
+	if len(changed) != 1 || changed[0].State != model.AlertResolved || changed[0].ResolvedAt == nil {
+		t.Fatalf("changed = %+v", changed)
+	}
+	if got := e.Alerts(); len(got) != 1 || got[0].State != model.AlertResolved {
+		t.Errorf("alerts = %+v", got)
+	}
+
+	// Resolved alerts are listed for the retention period only.
+	e.Evaluate(nil, completed.Add(31*time.Minute))
+	if got := e.Alerts(); len(got) != 0 {
+		t.Errorf("alerts after retention = %+v", got)
+	}
+}
+
+func TestEngine_PendingAlertDropsSilently(t *testing.T) {
+	e := newEngine(t, Rule{Name: "SlowRate", Expr: "pull_bytes_per_second < 1e6", For: metav1.Duration{Duration: time.Minute}})
+	now := time.Now()
+	pull := model.PullStatus{ID: "p", NodeName: "node1", StartedAt: now, BytesPerSec: 1000}
+
+	e.Evaluate([]model.PullStatus{pull}, now)
+	pull.BytesPerSec = 5e6
+	if changed := e.Evaluate([]model.PullStatus{pull}, now.Add(30*time.Second)); len(changed) != 0 {
+		t.Errorf("changed = %+v", changed)
+	}
+	if got := e.Alerts(); len(got) != 0 {
+		t.Errorf("alerts = %+v", got)
+	}
+}
+
+func TestEngine_Matchers(t *testing.T) {
+	e := newEngine(t, Rule{
+		Name:     "ProdSlowPull",
+		Expr:     "pull_duration_seconds >= 60",
+		Matchers: []string{`namespace=~"prod-.*"`, `node!="canary"`},
+	})
+	now := time.Now()
+	started := now.Add(-2 * time.Minute)
+	pull := func(id, node, ns string) model.PullStatus {
+		return model.PullStatus{ID: id, NodeName: node, ImageRef: "app:1", StartedAt: started,
+			Pods: []model.PodCorrelation{{Namespace: ns, PodName: id}}}
+	}
+	changed := e.Evaluate([]model.PullStatus{
+		pull("a", "node1", "prod-eu"),
+		pull("b", "canary", "prod-eu"),
+		pull("c", "node1", "staging"),
+		{ID: "d", NodeName: "node1", StartedAt: started}, // no pod, no namespace
+	}, now)
+	if len(changed) != 1 || changed[0].Labels["pull"] != "a" || changed[0].Annotations["pod"] != "a" {
+		t.Errorf("changed = %+v", changed)
+	}
+}
+
+func TestEngine_PodsDoNotIdentifyPullSeries(t *testing.T) {
+	e := newEngine(t, Rule{
+		Name:    "SlowPull",
+		Expr:    "pull_duration_seconds > 60",
+		For:     metav1.Duration{Duration: time.Minute},
+		Summary: "{{ .Labels.image }} blocks {{ .Annotations.pods }}",
+	})
+	start := time.Now()
+	pull := model.PullStatus{ID: "p", NodeName: "node1", ImageRef: "app:1", StartedAt: start}
+
+	// The pull is correlated to pods only after it becomes pending, and its
+	// first pod goes away before it fires.
+	e.Evaluate([]model.PullStatus{pull}, start.Add(61*time.Second))
+	pull.Pods = []model.PodCorrelation{{Namespace: "default", PodName: "web-0"}, {Namespace: "default", PodName: "web-1"}}
+	if changed := e.Evaluate([]model.PullStatus{pull}, start.Add(90*time.Second)); len(changed) != 0 {
+		t.Fatalf("correlation changed the series: %+v", changed)
+	}
+	pull.Pods = pull.Pods[1:]
+	changed := e.Evaluate([]model.PullStatus{pull}, start.Add(2*time.Minute+time.Second))
+	if len(changed) != 1 || changed[0].State != model.AlertFiring {
+		t.Fatalf("changed = %+v, want the alert pending since the first evaluation to fire", changed)
+	}
+	a := changed[0]
+	if _, ok := a.Labels["pod"]; ok {
+		t.Errorf("labels = %v, want no pod", a.Labels)
+	}
+	if a.Annotations["pod"] != "web-1" || a.Annotations["pods"] != "default/web-1" {
+		t.Errorf("annotations = %v", a.Annotations)
+	}
+	if a.Summary != "app:1 blocks default/web-1" {
+		t.Errorf("summary = %q", a.Summary)
+	}
+
+	pull.Pods = nil
+	if changed := e.Evaluate([]model.PullStatus{pull}, start.Add(3*time.Minute)); len(changed) != 0 {
+		t.Errorf("losing its pods resolved the alert: %+v", changed)
+	}
+}
+
+func TestEngine_PodImageWait(t *testing.T) {
+	e := newEngine(t, Rule{Name: "PodBlocked", Expr: "pod_image_wait_seconds > 180"})
+	now := time.Now()
+	pod := []model.PodCorrelation{{Namespace: "default", PodName: "web-0"}}
+	changed := e.Evaluate([]model.PullStatus{
+		{ID: "app", NodeName: "node1", ImageRef: "app:1", StartedAt: now.Add(-time.Minute), Pods: pod},
+		{ID: "init", NodeName: "node1", ImageRef: "init:1", StartedAt: now.Add(-4 * time.Minute), Pods: pod},
+	}, now)
+	if len(changed) != 1 {
+		t.Fatalf("changed = %+v", changed)
+	}
+	if a := changed[0]; a.Labels["pod"] != "web-0" || a.Labels["image"] != "init:1" || a.Value < 239 {
+		t.Errorf("alert = %+v", a)
+	}
+}
+
+func TestEngine_NodeFailureRatio(t *testing.T) {
+	e := newEngine(t, Rule{Name: "NodePullFailures", Expr: "node_pull_failure_ratio > 0.2"})
+	now := time.Now()
+	done := func(id, node, errMsg string) model.PullStatus {
+		return model.PullStatus{ID: id, NodeName: node, StartedAt: now, CompletedAt: &now, Error: errMsg}
+	}
+	changed := e.Evaluate([]model.PullStatus{
+		done("1", "node1", ""), done("2", "node1", "denied"), done("3", "node1", ""),
+		done("4", "node2", ""), done("5", "node2", ""), done("6", "node2", ""), done("7", "node2", ""), done("8", "node2", "denied"),
+		{ID: "9", NodeName: "node2", StartedAt: now}, // active pulls do not count
+	}, now)
+	if len(changed) != 1 || changed[0].Labels["node"] != "node1" {
+		t.Fatalf("changed = %+v", changed)
+	}
+	if v := changed[0].Value; v < 0.33 || v > 0.34 {
+		t.Errorf("value = %v", v)
+	}
+}
+
+func TestEngine_Reset(t *testing.T) {
+	e := newEngine(t, Rule{Name: "Any", Expr: "pull_duration_seconds >= 0"})
+	now := time.Now()
+	e.Evaluate([]model.PullStatus{{ID: "p", StartedAt: now}}, now)
+	e.Reset()
+	if got := e.Alerts(); len(got) != 0 {
+		t.Errorf("alerts after reset = %+v", got)
+	}
+	if changed := e.Evaluate(nil, now); len(changed) != 0 {
+		t.Errorf("reset alerts resolved: %+v", changed)
+	}
+}
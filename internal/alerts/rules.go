@@ -0,0 +1,208 @@
+package alerts
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/d44b/pulltrace/internal/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Metric is a value rules can compare with a threshold.
+type Metric string
+
+const (
+	// MetricPullDuration is how long each active pull has been running.
+	MetricPullDuration Metric = "pull_duration_seconds"
+	// MetricPullRate is each active pull's download rate.
+	MetricPullRate Metric = "pull_bytes_per_second"
+	// MetricPodImageWait is how long each pod has been waiting for its
+	// longest-running image pull.
+	MetricPodImageWait Metric = "pod_image_wait_seconds"
+	// MetricNodeFailureRatio is the fraction of each node's completed pulls
+	// in the history that failed.
+	MetricNodeFailureRatio Metric = "node_pull_failure_ratio"
+)
+
+var knownMetrics = map[Metric]bool{
+	MetricPullDuration:     true,
+	MetricPullRate:         true,
+	MetricPodImageWait:     true,
+	MetricNodeFailureRatio: true,
+}
+
+// Rule is an alerting rule.
+type Rule struct {
+	Name string `json:"name"`
+	// Expr compares a metric with a threshold, e.g.
+	// "pull_duration_seconds > 300". The operator is one of >, >=, < or <=.
+	Expr string `json:"expr"`
+	// For is how long the condition must hold before the alert fires.
+	For      metav1.Duration `json:"for,omitempty"`
+	Severity string          `json:"severity,omitempty"`
+	// Matchers select series by label, in Prometheus syntax:
+	// `namespace="prod"`, `image=~"ghcr.io/.*"`, `node!="canary"`.
+	Matchers []string `json:"matchers,omitempty"`
+	// Summary is a Go template executed with the model.Alert.
+	Summary string `json:"summary,omitempty"`
+}
+
+// LoadRules reads alerting rules from a YAML or JSON file of the form
+// {"rules": [Rule, ...]}.
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Rules []Rule `json:"rules"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(file.Rules) == 0 {
+		return nil, fmt.Errorf("%s: no rules configured", path)
+	}
+	if _, err := compileAll(file.Rules); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return file.Rules, nil
+}
+
+func compileAll(rules []Rule) ([]*rule, error) {
+	compiled := make([]*rule, 0, len(rules))
+	seen := make(map[string]bool)
+	for _, r := range rules {
+		c, err := compile(r)
+		if err != nil {
+			return nil, err
+		}
+		if seen[r.Name] {
+			return nil, fmt.Errorf("rule %q is listed twice", r.Name)
+		}
+		seen[r.Name] = true
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// rule is a compiled Rule.
+type rule struct {
+	Rule
+	metric    Metric
+	op        string
+	threshold float64
+	matchers  []matcher
+	summary   *template.Template
+}
+
+type matcher struct {
+	label string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+var matcherPattern = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*"(.*)"\s*$`)
+
+func compile(r Rule) (*rule, error) {
+	if r.Name == "" {
+		return nil, fmt.Errorf("rule without a name")
+	}
+	if r.For.Duration < 0 {
+		return nil, fmt.Errorf("rule %q: for must not be negative", r.Name)
+	}
+	fields := strings.Fields(r.Expr)
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("rule %q: expr must be \"<metric> <op> <threshold>\", got %q", r.Name, r.Expr)
+	}
+	c := &rule{Rule: r, metric: Metric(fields[0]), op: fields[1]}
+	if !knownMetrics[c.metric] {
+		return nil, fmt.Errorf("rule %q: unknown metric %q", r.Name, fields[0])
+	}
+	switch c.op {
+	case ">", ">=", "<", "<=":
+	default:
+		return nil, fmt.Errorf("rule %q: unknown operator %q", r.Name, c.op)
+	}
+	threshold, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return nil, fmt.Errorf("rule %q: threshold: %w", r.Name, err)
+	}
+	c.threshold = threshold
+
+	for _, m := range r.Matchers {
+		parts := matcherPattern.FindStringSubmatch(m)
+		if parts == nil {
+			return nil, fmt.Errorf("rule %q: invalid matcher %q", r.Name, m)
+		}
+		mt := matcher{label: parts[1], op: parts[2], value: parts[3]}
+		if mt.op == "=~" || mt.op == "!~" {
+			if mt.re, err = regexp.Compile("^(?:" + mt.value + ")$"); err != nil {
+				return nil, fmt.Errorf("rule %q: matcher %q: %w", r.Name, m, err)
+			}
+		}
+		c.matchers = append(c.matchers, mt)
+	}
+
+	if r.Summary != "" {
+		if c.summary, err = template.New(r.Name).Parse(r.Summary); err != nil {
+			return nil, fmt.Errorf("rule %q: summary: %w", r.Name, err)
+		}
+	}
+	return c, nil
+}
+
+// holds reports whether value meets the rule's condition.
+func (r *rule) holds(value float64) bool {
+	switch r.op {
+	case ">":
+		return value > r.threshold
+	case ">=":
+		return value >= r.threshold
+	case "<":
+		return value < r.threshold
+	default:
+		return value <= r.threshold
+	}
+}
+
+// selects reports whether a series' labels satisfy every matcher. Missing
+// labels match as empty strings.
+func (r *rule) selects(labels map[string]string) bool {
+	for _, m := range r.matchers {
+		v := labels[m.label]
+		var ok bool
+		switch m.op {
+		case "=":
+			ok = v == m.value
+		case "!=":
+			ok = v != m.value
+		case "=~":
+			ok = m.re.MatchString(v)
+		case "!~":
+			ok = !m.re.MatchString(v)
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *rule) render(a *model.Alert) string {
+	if r.summary == nil {
+		return fmt.Sprintf("%s %.4g %s %g", r.metric, a.Value, r.op, r.threshold)
+	}
+	var buf bytes.Buffer
+	if err := r.summary.Execute(&buf, a); err != nil {
+		return fmt.Sprintf("%s: summary: %v", r.Name, err)
+	}
+	return buf.String()
+}
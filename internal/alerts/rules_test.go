@@ -0,0 +1,86 @@
+package alerts
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeRules(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadRules(t *testing.T) {
+	rules, err := LoadRules(writeRules(t, `
+rules:
+  - name: SlowPull
+    expr: pull_duration_seconds > 300
+    severity: warning
+  - name: SlowRegistry
+    expr: pull_bytes_per_second < 1e6
+    for: 60s
+    matchers: ['image=~"registry.internal/.*"']
+  - name: PodBlockedOnImage
+    expr: pod_image_wait_seconds > 180
+    severity: critical
+    matchers: ['namespace="prod"']
+    summary: '{{ .Labels.pod }} waits for {{ .Labels.image }}'
+  - name: NodePullFailures
+    expr: node_pull_failure_ratio > 0.2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 4 || rules[1].For.Duration != time.Minute || rules[2].Matchers[0] != `namespace="prod"` {
+		t.Errorf("rules = %+v", rules)
+	}
+
+	for name, content := range map[string]string{
+		"empty":          `rules: []`,
+		"no name":        `rules: [{expr: "pull_duration_seconds > 1"}]`,
+		"duplicate":      `rules: [{name: a, expr: "pull_duration_seconds > 1"}, {name: a, expr: "pull_duration_seconds > 2"}]`,
+		"unknown metric": `rules: [{name: a, expr: "pull_size_bytes > 1"}]`,
+		"unknown op":     `rules: [{name: a, expr: "pull_duration_seconds == 1"}]`,
+		"bad threshold":  `rules: [{name: a, expr: "pull_duration_seconds > 5m"}]`,
+		"short expr":     `rules: [{name: a, expr: "pull_duration_seconds>1"}]`,
+		"bad matcher":    `rules: [{name: a, expr: "pull_duration_seconds > 1", matchers: ["namespace:prod"]}]`,
+		"bad regexp":     `rules: [{name: a, expr: "pull_duration_seconds > 1", matchers: ['image=~"("']}]`,
+		"bad summary":    `rules: [{name: a, expr: "pull_duration_seconds > 1", summary: "{{ .Labels"}]`,
+		"unknown keys":   `rules: [{name: a, expr: "pull_duration_seconds > 1", labels: {team: x}}]`,
+		"negative for":   `rules: [{name: a, expr: "pull_duration_seconds > 1", for: -1m}]`,
+	} {
+		if _, err := LoadRules(writeRules(t, content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestRuleSelects(t *testing.T) {
+	r, err := compile(Rule{Name: "r", Expr: "pull_duration_seconds > 1", Matchers: []string{
+		`namespace = "prod"`, `image!~"internal/.*"`, `node=~"pool-a-.*|pool-b-.*"`,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		labels map[string]string
+		want   bool
+	}{
+		{map[string]string{"namespace": "prod", "image": "nginx", "node": "pool-a-1"}, true},
+		{map[string]string{"namespace": "prod", "image": "internal/app", "node": "pool-a-1"}, false},
+		{map[string]string{"namespace": "prod", "image": "nginx", "node": "pool-c-1"}, false},
+		{map[string]string{"namespace": "prod-eu", "image": "nginx", "node": "pool-b-1"}, false},
+		{map[string]string{"image": "nginx", "node": "pool-b-1"}, false},
+	}
+	for _, c := range cases {
+		if got := r.selects(c.labels); got != c.want {
+			t.Errorf("selects(%v) = %v, want %v", c.labels, got, c.want)
+		}
+	}
+}
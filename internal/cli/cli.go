@@ -0,0 +1,212 @@
+// Package cli implements kubectl-pulltrace, a kubectl plugin for inspecting
+// image pulls tracked by the pulltrace server.
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/d44b/pulltrace/pkg/client"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const usage = `kubectl pulltrace inspects image pulls tracked by pulltrace.
+
+Usage:
+  kubectl pulltrace list [flags]           List current and recent pulls
+  kubectl pulltrace watch [flags]          Watch pulls as they progress
+  kubectl pulltrace describe POD [flags]   Show the pulls and layers a pod is waiting for
+  kubectl pulltrace top nodes [flags]      Show per-node pull throughput
+
+The server is reached through the API server's service proxy unless
+--server-url is set, e.g. to a local port-forward:
+
+  kubectl -n pulltrace port-forward svc/pulltrace-server 8080:8080
+  kubectl pulltrace list --server-url http://localhost:8080
+
+Flags:
+`
+
+// options holds the flags shared by all commands.
+type options struct {
+	kubeconfig    string
+	context       string
+	namespace     string
+	allNamespaces bool
+
+	serverNamespace string
+	serverService   string
+	serverPort      string
+	serverURL       string
+
+	output string
+
+	out    io.Writer
+	errOut io.Writer
+	now    func() time.Time
+}
+
+func (o *options) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(o.errOut)
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "path to the kubeconfig file")
+	fs.StringVar(&o.context, "context", "", "kubeconfig context to use")
+	fs.StringVar(&o.namespace, "namespace", "", "only show pulls for pods in this namespace")
+	fs.StringVar(&o.namespace, "n", "", "shorthand for --namespace")
+	fs.BoolVar(&o.allNamespaces, "all-namespaces", false, "show pulls for pods in all namespaces")
+	fs.BoolVar(&o.allNamespaces, "A", false, "shorthand for --all-namespaces")
+	fs.StringVar(&o.serverNamespace, "server-namespace", "pulltrace", "namespace of the pulltrace server service")
+	fs.StringVar(&o.serverService, "server-service", "pulltrace-server", "name of the pulltrace server service")
+	fs.StringVar(&o.serverPort, "server-port", "http", "name or number of the server service port")
+	fs.StringVar(&o.serverURL, "server-url", "", "talk to the server directly at this URL instead of the service proxy")
+	fs.StringVar(&o.output, "output", "table", "output format: table, json or yaml")
+	fs.StringVar(&o.output, "o", "table", "shorthand for --output")
+	fs.Usage = func() {
+		fmt.Fprint(o.errOut, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// Run executes the plugin with args (excluding the program name).
+func Run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	o := &options{out: stdout, errOut: stderr, now: time.Now}
+	return o.run(ctx, args)
+}
+
+func (o *options) run(ctx context.Context, args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fs := o.flags("kubectl-pulltrace")
+		if err := fs.Parse(args); errors.Is(err, flag.ErrHelp) {
+			return nil
+		}
+		fs.Usage()
+		return errors.New("a command is required")
+	}
+
+	cmd, args := args[0], args[1:]
+	fs := o.flags(cmd)
+	positional, err := parseInterspersed(fs, args)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	switch o.output {
+	case "table", "json", "yaml":
+	default:
+		return fmt.Errorf("unknown output format %q: must be table, json or yaml", o.output)
+	}
+
+	switch cmd {
+	case "list", "ls", "get":
+		return o.list(ctx)
+	case "watch":
+		return o.watch(ctx)
+	case "describe":
+		if len(positional) != 1 {
+			return errors.New("describe requires exactly one pod name")
+		}
+		return o.describe(ctx, positional[0])
+	case "top":
+		if len(positional) != 1 || !isNodes(positional[0]) {
+			return errors.New(`top only supports "nodes"`)
+		}
+		return o.topNodes(ctx)
+	case "help":
+		fs.Usage()
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+func isNodes(s string) bool {
+	switch s {
+	case "nodes", "node", "no":
+		return true
+	}
+	return false
+}
+
+// parseInterspersed parses flags that may appear before, between or after
+// positional arguments, as kubectl does.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// clientConfig loads kubeconfig the way kubectl does, honouring
+// --kubeconfig, --context, $KUBECONFIG and in-cluster configuration.
+func (o *options) clientConfig() clientcmd.ClientConfig {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = o.kubeconfig
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{
+		CurrentContext: o.context,
+	})
+}
+
+// client returns a pulltrace API client, either for --server-url or for the
+// server service reached through the API server's service proxy.
+func (o *options) client() (*client.Client, error) {
+	if o.serverURL != "" {
+		return client.New(o.serverURL)
+	}
+	config, err := o.clientConfig().ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading kubeconfig: %w", err)
+	}
+	httpClient, err := rest.HTTPClientFor(config)
+	if err != nil {
+		return nil, fmt.Errorf("creating kubernetes client: %w", err)
+	}
+	host, _, err := rest.DefaultServerUrlFor(config)
+	if err != nil {
+		return nil, fmt.Errorf("parsing API server URL: %w", err)
+	}
+	host.Path = path.Join(host.Path, "api/v1/namespaces", o.serverNamespace,
+		"services", o.serverService+":"+o.serverPort, "proxy")
+	return client.New(host.String(), client.WithHTTPClient(httpClient))
+}
+
+// podNamespace returns the namespace a pod name refers to: --namespace, else
+// the kubeconfig context's namespace, else "default".
+func (o *options) podNamespace() string {
+	if o.namespace != "" {
+		return o.namespace
+	}
+	if ns, _, err := o.clientConfig().Namespace(); err == nil && ns != "" {
+		return ns
+	}
+	return "default"
+}
+
+// isTerminal reports whether w is an interactive terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
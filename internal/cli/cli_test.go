@@ -0,0 +1,364 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+
+	"sigs.k8s.io/yaml"
+)
+
+var testNow = time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+func testPulls() []model.PullStatus {
+	done := testNow.Add(-time.Minute)
+	return []model.PullStatus{
+		{
+			ID:              "node1:nginx:1.27@1",
+			NodeName:        "node1",
+			ImageRef:        "nginx:1.27",
+			TotalBytes:      812_000_000,
+			DownloadedBytes: 365_400_000,
+			BytesPerSec:     12_000_000,
+			ETASeconds:      30,
+			Percent:         45,
+			TotalKnown:      true,
+			LayerCount:      2,
+			LayersDone:      1,
+			StartedAt:       testNow.Add(-30 * time.Second),
+			Pods: []model.PodCorrelation{
+				{Namespace: "default", PodName: "web-1", Container: "nginx"},
+			},
+			Layers: []model.LayerStatus{
+				{Digest: "sha256:0123456789abcdef0123", TotalBytes: 12_000_000, DownloadedBytes: 12_000_000, Percent: 100, TotalKnown: true, CompletedAt: &done},
+				{Digest: "sha256:fedcba9876543210fedc", TotalBytes: 800_000_000, DownloadedBytes: 353_400_000, Percent: 44, BytesPerSec: 12_000_000, TotalKnown: true},
+			},
+		},
+		{
+			ID:              "node2:redis:7@1",
+			NodeName:        "node2",
+			ImageRef:        "redis:7",
+			TotalBytes:      40_000_000,
+			DownloadedBytes: 40_000_000,
+			Percent:         100,
+			TotalKnown:      true,
+			StartedAt:       testNow.Add(-2 * time.Minute),
+			CompletedAt:     &done,
+			Pods: []model.PodCorrelation{
+				{Namespace: "prod", PodName: "cache-0", Container: "redis"},
+			},
+		},
+		{
+			ID:              "node1:busybox:latest@1",
+			NodeName:        "node1",
+			ImageRef:        "busybox:latest",
+			DownloadedBytes: 1_000_000,
+			BytesPerSec:     500_000,
+			StartedAt:       testNow.Add(-5 * time.Second),
+		},
+	}
+}
+
+// newTestServer serves pulls on /api/v1/pulls and events on /api/v1/events.
+func newTestServer(t *testing.T, pulls []model.PullStatus, events []model.PullEvent) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/pulls", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(model.APIResponse{Pulls: pulls}) //nolint:errcheck
+	})
+	mux.HandleFunc("/api/v1/events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(": connected\n\n")) //nolint:errcheck
+		for _, ev := range events {
+			data, _ := json.Marshal(ev)
+			w.Write([]byte("data: " + string(data) + "\n\n")) //nolint:errcheck
+		}
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func run(t *testing.T, srv *httptest.Server, args ...string) (string, string, error) {
+	t.Helper()
+	return runContext(t, context.Background(), args, "--server-url", srv.URL)
+}
+
+func runContext(t *testing.T, ctx context.Context, args []string, extra ...string) (string, string, error) {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	o := &options{out: &stdout, errOut: &stderr, now: func() time.Time { return testNow }}
+	err := o.run(ctx, append(args, extra...))
+	return stdout.String(), stderr.String(), err
+}
+
+// watchFor runs watch against srv until timeout and returns its output.
+func watchFor(t *testing.T, srv *httptest.Server, timeout time.Duration, args ...string) string {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	out, _, err := runContext(t, ctx, append([]string{"watch"}, args...), "--server-url", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestList_Table(t *testing.T) {
+	srv := newTestServer(t, testPulls(), nil)
+	out, _, err := run(t, srv, "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want header + 3 rows:\n%s", len(lines), out)
+	}
+	for _, want := range []string{"NODE", "IMAGE", "STATUS", "ETA"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("header %q missing %s", lines[0], want)
+		}
+	}
+	// Sorted by node, then image.
+	for i, want := range []string{"busybox:latest", "nginx:1.27", "redis:7"} {
+		if !strings.Contains(lines[i+1], want) {
+			t.Errorf("row %d = %q, want %s", i+1, lines[i+1], want)
+		}
+	}
+	for _, want := range []string{"Pulling", "45%", "365MB/812MB", "12.0MB/s", "30s", "default/web-1"} {
+		if !strings.Contains(lines[2], want) {
+			t.Errorf("nginx row %q missing %q", lines[2], want)
+		}
+	}
+	if !strings.Contains(lines[1], "<none>") || !strings.Contains(lines[1], " - ") {
+		t.Errorf("uncorrelated pull with unknown size = %q", lines[1])
+	}
+	if !strings.Contains(lines[3], "Completed") {
+		t.Errorf("redis row = %q, want Completed", lines[3])
+	}
+}
+
+func TestList_NamespaceFilter(t *testing.T) {
+	srv := newTestServer(t, testPulls(), nil)
+	out, _, err := run(t, srv, "list", "-n", "prod", "-o", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp model.APIResponse
+	if err := json.Unmarshal([]byte(out), &resp); err != nil {
+		t.Fatalf("output is not an APIResponse: %v\n%s", err, out)
+	}
+	if len(resp.Pulls) != 1 || resp.Pulls[0].ImageRef != "redis:7" {
+		t.Fatalf("got %+v, want only redis:7", resp.Pulls)
+	}
+}
+
+func TestList_YAML(t *testing.T) {
+	srv := newTestServer(t, testPulls(), nil)
+	out, _, err := run(t, srv, "list", "-o", "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp model.APIResponse
+	if err := yaml.Unmarshal([]byte(out), &resp); err != nil {
+		t.Fatalf("output is not YAML: %v", err)
+	}
+	if len(resp.Pulls) != 3 || !strings.Contains(out, "imageRef: nginx:1.27") {
+		t.Fatalf("unexpected YAML output:\n%s", out)
+	}
+}
+
+func TestList_Empty(t *testing.T) {
+	srv := newTestServer(t, nil, nil)
+	out, errOut, err := run(t, srv, "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "" || !strings.Contains(errOut, "No pulls found") {
+		t.Fatalf("stdout=%q stderr=%q", out, errOut)
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	srv := newTestServer(t, testPulls(), nil)
+	out, _, err := run(t, srv, "describe", "web-1", "-n", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Name:", "web-1",
+		"Image:", "nginx:1.27",
+		"Container:", "nginx",
+		"45% (365MB/812MB)",
+		"Layers:", "1/2 done",
+		"sha256:0123456789ab",
+		"sha256:fedcba987654",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("describe output missing %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "redis") {
+		t.Errorf("describe output includes another pod's pull:\n%s", out)
+	}
+}
+
+func TestDescribe_NoPulls(t *testing.T) {
+	srv := newTestServer(t, testPulls(), nil)
+	_, errOut, err := run(t, srv, "describe", "web-1", "-n", "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(errOut, "No image pulls found for pod prod/web-1") {
+		t.Fatalf("stderr = %q", errOut)
+	}
+}
+
+func TestTopNodes(t *testing.T) {
+	srv := newTestServer(t, testPulls(), nil)
+	out, _, err := run(t, srv, "top", "nodes", "-o", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resp struct {
+		Nodes []nodeUsage `json:"nodes"`
+	}
+	if err := json.Unmarshal([]byte(out), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Nodes) != 2 {
+		t.Fatalf("got %d nodes, want 2", len(resp.Nodes))
+	}
+	n1 := resp.Nodes[0]
+	if n1.Node != "node1" || n1.ActivePulls != 2 || n1.BytesPerSec != 12_500_000 || n1.RemainingBytes != 446_600_000 {
+		t.Errorf("node1 = %+v", n1)
+	}
+	n2 := resp.Nodes[1]
+	if n2.Node != "node2" || n2.ActivePulls != 0 || n2.CompletedPulls != 1 || n2.BytesPerSec != 0 {
+		t.Errorf("node2 = %+v", n2)
+	}
+
+	out, _, err = run(t, srv, "top", "nodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "12.5MB/s") {
+		t.Errorf("table output missing node1 rate:\n%s", out)
+	}
+}
+
+func TestWatch_Rows(t *testing.T) {
+	pulls := testPulls()
+	events := []model.PullEvent{
+		{Type: model.EventPullProgress, NodeName: "node1", Pull: &pulls[0]},
+		{Type: model.EventPullCompleted, NodeName: "node2", Pull: &pulls[1]},
+	}
+	srv := newTestServer(t, nil, events)
+
+	out := watchFor(t, srv, 300*time.Millisecond)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "nginx:1.27") || !strings.Contains(lines[2], "Completed") {
+		t.Fatalf("unexpected watch output:\n%s", out)
+	}
+}
+
+func TestWatch_JSONFiltersNamespace(t *testing.T) {
+	pulls := testPulls()
+	events := []model.PullEvent{
+		{Type: model.EventPullProgress, NodeName: "node1", Pull: &pulls[0]},
+		{Type: model.EventPullCompleted, NodeName: "node2", Pull: &pulls[1]},
+	}
+	srv := newTestServer(t, nil, events)
+
+	out := watchFor(t, srv, 300*time.Millisecond, "-o", "json", "--namespace", "prod")
+	var ev model.PullEvent
+	if err := json.Unmarshal([]byte(out), &ev); err != nil {
+		t.Fatalf("want exactly one JSON event: %v\n%s", err, out)
+	}
+	if ev.Type != model.EventPullCompleted || ev.Pull.ImageRef != "redis:7" {
+		t.Fatalf("got %+v", ev)
+	}
+}
+
+func TestRun_Errors(t *testing.T) {
+	srv := newTestServer(t, nil, nil)
+	tests := [][]string{
+		{"frobnicate"},
+		{"describe"},
+		{"top", "pods"},
+		{"list", "-o", "wide"},
+	}
+	for _, args := range tests {
+		if _, _, err := run(t, srv, args...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestClient_ServiceProxy(t *testing.T) {
+	var gotPath string
+	apiserver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		json.NewEncoder(w).Encode(model.APIResponse{Pulls: testPulls()}) //nolint:errcheck
+	}))
+	defer apiserver.Close()
+
+	kubeconfig := filepath.Join(t.TempDir(), "config")
+	err := os.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: test
+  cluster:
+    server: `+apiserver.URL+`
+contexts:
+- name: test
+  context:
+    cluster: test
+    user: test
+users:
+- name: test
+  user:
+    token: secret
+current-context: test
+`), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, _, err := runContext(t, context.Background(), []string{"list", "--kubeconfig", kubeconfig, "--server-namespace", "ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/api/v1/namespaces/ops/services/pulltrace-server:http/proxy/api/v1/pulls"; gotPath != want {
+		t.Errorf("requested %q, want %q", gotPath, want)
+	}
+	if !strings.Contains(out, "nginx:1.27") {
+		t.Errorf("unexpected output:\n%s", out)
+	}
+}
+
+func TestParseInterspersed(t *testing.T) {
+	o := &options{errOut: &bytes.Buffer{}}
+	fs := o.flags("describe")
+	positional, err := parseInterspersed(fs, []string{"-n", "prod", "web-1", "-o", "yaml", "extra"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(positional) != 2 || positional[0] != "web-1" || positional[1] != "extra" {
+		t.Errorf("positional = %v", positional)
+	}
+	if o.namespace != "prod" || o.output != "yaml" {
+		t.Errorf("namespace=%q output=%q", o.namespace, o.output)
+	}
+}
@@ -0,0 +1,313 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+	"github.com/d44b/pulltrace/pkg/client"
+
+	"k8s.io/apimachinery/pkg/util/duration"
+)
+
+// redrawInterval bounds how often the live watch table is repainted.
+const redrawInterval = 500 * time.Millisecond
+
+// matches reports whether p passes the --namespace filter. Without a filter
+// every pull is shown, including pulls not yet correlated to a pod.
+func (o *options) matches(p *model.PullStatus) bool {
+	if o.allNamespaces || o.namespace == "" {
+		return true
+	}
+	for _, pod := range p.Pods {
+		if pod.Namespace == o.namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *options) list(ctx context.Context) error {
+	c, err := o.client()
+	if err != nil {
+		return err
+	}
+	all, err := c.ListPulls(ctx)
+	if err != nil {
+		return err
+	}
+	pulls := make([]model.PullStatus, 0, len(all))
+	for i := range all {
+		if o.matches(&all[i]) {
+			pulls = append(pulls, all[i])
+		}
+	}
+	sortPulls(pulls)
+
+	if o.output != "table" {
+		return printObject(o.out, o.output, model.APIResponse{Pulls: pulls})
+	}
+	if len(pulls) == 0 {
+		fmt.Fprintln(o.errOut, "No pulls found.")
+		return nil
+	}
+	tw := newTabWriter(o.out)
+	fmt.Fprintln(tw, pullColumns)
+	now := o.now()
+	for i := range pulls {
+		printPullRow(tw, &pulls[i], now)
+	}
+	return tw.Flush()
+}
+
+// watchFilter maps the --namespace flag to a server event filter.
+func (o *options) watchFilter() client.WatchFilter {
+	if o.allNamespaces {
+		return client.WatchFilter{}
+	}
+	return client.WatchFilter{Namespace: o.namespace}
+}
+
+func (o *options) watch(ctx context.Context) error {
+	c, err := o.client()
+	if err != nil {
+		return err
+	}
+	events, err := c.Watch(ctx, o.watchFilter())
+	if err != nil {
+		return err
+	}
+
+	if o.output == "table" && isTerminal(o.out) {
+		o.watchLive(events)
+		return nil
+	}
+
+	// Not a terminal: append one row per update, like kubectl get -w.
+	tw := newTabWriter(o.out)
+	if o.output == "table" {
+		fmt.Fprintln(tw, pullColumns)
+	}
+	for ev := range events {
+		switch o.output {
+		case "json":
+			err = printJSON(o.out, ev)
+		case "yaml":
+			fmt.Fprintln(o.out, "---")
+			err = printYAML(o.out, ev)
+		default:
+			printPullRow(tw, ev.Pull, o.now())
+			err = tw.Flush()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// watchLive repaints a table of every pull seen so far as events arrive,
+// until the watch ends.
+func (o *options) watchLive(events <-chan model.PullEvent) {
+	pulls := make(map[string]model.PullStatus)
+	ticker := time.NewTicker(redrawInterval)
+	defer ticker.Stop()
+	dirty := true
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			pulls[ev.Pull.ID] = *ev.Pull
+			dirty = true
+		case <-ticker.C:
+			if !dirty {
+				continue
+			}
+			dirty = false
+			rows := make([]model.PullStatus, 0, len(pulls))
+			for _, p := range pulls {
+				rows = append(rows, p)
+			}
+			sortPulls(rows)
+
+			fmt.Fprint(o.out, "\033[H\033[2J")
+			tw := newTabWriter(o.out)
+			fmt.Fprintln(tw, pullColumns)
+			now := o.now()
+			for i := range rows {
+				printPullRow(tw, &rows[i], now)
+			}
+			tw.Flush() //nolint:errcheck
+		}
+	}
+}
+
+func (o *options) describe(ctx context.Context, pod string) error {
+	namespace := o.podNamespace()
+	c, err := o.client()
+	if err != nil {
+		return err
+	}
+	all, err := c.ListPulls(ctx)
+	if err != nil {
+		return err
+	}
+
+	pulls := []model.PullStatus{}
+	for _, p := range all {
+		for _, pc := range p.Pods {
+			if pc.Namespace == namespace && pc.PodName == pod {
+				pulls = append(pulls, p)
+				break
+			}
+		}
+	}
+	sortPulls(pulls)
+
+	if o.output != "table" {
+		return printObject(o.out, o.output, model.APIResponse{Pulls: pulls})
+	}
+	if len(pulls) == 0 {
+		fmt.Fprintf(o.errOut, "No image pulls found for pod %s/%s.\n", namespace, pod)
+		return nil
+	}
+
+	tw := newTabWriter(o.out)
+	fmt.Fprintf(tw, "Name:\t%s\n", pod)
+	fmt.Fprintf(tw, "Namespace:\t%s\n", namespace)
+	now := o.now()
+	for i := range pulls {
+		p := &pulls[i]
+		fmt.Fprintln(tw)
+		fmt.Fprintf(tw, "Image:\t%s\n", p.ImageRef)
+		if p.Digest != "" {
+			fmt.Fprintf(tw, "Digest:\t%s\n", p.Digest)
+		}
+		for _, pc := range p.Pods {
+			if pc.Namespace == namespace && pc.PodName == pod {
+				fmt.Fprintf(tw, "%s:\t%s\n", correlationLabel(pc.Kind), pc.Container)
+			}
+		}
+		fmt.Fprintf(tw, "Node:\t%s\n", p.NodeName)
+		fmt.Fprintf(tw, "Status:\t%s\n", pullStatus(p))
+		if p.Error != "" {
+			fmt.Fprintf(tw, "Error:\t%s\n", p.Error)
+		}
+		fmt.Fprintf(tw, "Progress:\t%s (%s)\n", pullProgress(p), pullDownloaded(p))
+		fmt.Fprintf(tw, "Rate:\t%s\n", formatRate(p))
+		fmt.Fprintf(tw, "ETA:\t%s\n", pullETA(p))
+		fmt.Fprintf(tw, "Started:\t%s (%s ago)\n", p.StartedAt.Format(time.RFC1123Z), duration.HumanDuration(now.Sub(p.StartedAt)))
+		if p.CompletedAt != nil {
+			fmt.Fprintf(tw, "Completed:\t%s (took %s)\n", p.CompletedAt.Format(time.RFC1123Z), formatDuration(p.CompletedAt.Sub(p.StartedAt)))
+		}
+		fmt.Fprintf(tw, "Layers:\t%d/%d done\n", p.LayersDone, p.LayerCount)
+		if len(p.Layers) > 0 {
+			fmt.Fprintln(tw, "  DIGEST\tSIZE\tDOWNLOADED\tPROGRESS\tRATE")
+			for _, l := range p.Layers {
+				size, progress := "-", "-"
+				if l.TotalKnown {
+					size = formatBytes(l.TotalBytes)
+					progress = fmt.Sprintf("%.0f%%", l.Percent)
+				}
+				rate := "-"
+				if l.Stalled {
+					rate = "stalled"
+				} else if l.CompletedAt == nil {
+					rate = formatBytes(int64(l.BytesPerSec)) + "/s"
+				}
+				fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", shortDigest(l.Digest), size, formatBytes(l.DownloadedBytes), progress, rate)
+			}
+		}
+	}
+	return tw.Flush()
+}
+
+// nodeUsage aggregates the pulls of one node for `top nodes`.
+type nodeUsage struct {
+	Node            string  `json:"node"`
+	ActivePulls     int     `json:"activePulls"`
+	CompletedPulls  int     `json:"completedPulls"`
+	FailedPulls     int     `json:"failedPulls"`
+	BytesPerSec     float64 `json:"bytesPerSec"`
+	DownloadedBytes int64   `json:"downloadedBytes"`
+	// RemainingBytes only counts active pulls whose total size is known.
+	RemainingBytes int64 `json:"remainingBytes"`
+}
+
+func aggregateNodes(pulls []model.PullStatus) []nodeUsage {
+	byNode := make(map[string]*nodeUsage)
+	for i := range pulls {
+		p := &pulls[i]
+		u, ok := byNode[p.NodeName]
+		if !ok {
+			u = &nodeUsage{Node: p.NodeName}
+			byNode[p.NodeName] = u
+		}
+		u.DownloadedBytes += p.DownloadedBytes
+		switch {
+		case p.Error != "":
+			u.FailedPulls++
+		case p.CompletedAt != nil:
+			u.CompletedPulls++
+		default:
+			u.ActivePulls++
+			u.BytesPerSec += p.BytesPerSec
+			if p.TotalKnown && p.TotalBytes > p.DownloadedBytes {
+				u.RemainingBytes += p.TotalBytes - p.DownloadedBytes
+			}
+		}
+	}
+	nodes := make([]nodeUsage, 0, len(byNode))
+	for _, u := range byNode {
+		nodes = append(nodes, *u)
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Node < nodes[j].Node })
+	return nodes
+}
+
+func (o *options) topNodes(ctx context.Context) error {
+	c, err := o.client()
+	if err != nil {
+		return err
+	}
+	pulls, err := c.ListPulls(ctx)
+	if err != nil {
+		return err
+	}
+	nodes := aggregateNodes(pulls)
+
+	if o.output != "table" {
+		return printObject(o.out, o.output, struct {
+			Nodes []nodeUsage `json:"nodes"`
+		}{nodes})
+	}
+	if len(nodes) == 0 {
+		fmt.Fprintln(o.errOut, "No pulls found.")
+		return nil
+	}
+	tw := newTabWriter(o.out)
+	fmt.Fprintln(tw, "NODE\tACTIVE\tCOMPLETED\tFAILED\tRATE\tDOWNLOADED\tREMAINING")
+	for _, u := range nodes {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s/s\t%s\t%s\n",
+			u.Node, u.ActivePulls, u.CompletedPulls, u.FailedPulls,
+			formatBytes(int64(u.BytesPerSec)), formatBytes(u.DownloadedBytes), formatBytes(u.RemainingBytes))
+	}
+	return tw.Flush()
+}
+
+// correlationLabel names the kind of a pod's container or volume for describe.
+func correlationLabel(kind model.CorrelationKind) string {
+	switch kind {
+	case model.CorrelationInitContainer:
+		return "Init Container"
+	case model.CorrelationEphemeralContainer:
+		return "Ephemeral Container"
+	case model.CorrelationImageVolume:
+		return "Image Volume"
+	}
+	return "Container"
+}
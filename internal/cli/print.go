@@ -0,0 +1,166 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/yaml"
+)
+
+const pullColumns = "NODE\tIMAGE\tSTATUS\tPROGRESS\tDOWNLOADED\tRATE\tETA\tPODS\tAGE"
+
+func newTabWriter(w io.Writer) *tabwriter.Writer {
+	return tabwriter.NewWriter(w, 0, 8, 3, ' ', 0)
+}
+
+func printJSON(w io.Writer, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
+
+func printYAML(w io.Writer, v any) error {
+	data, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// printObject writes v as JSON or YAML depending on format.
+func printObject(w io.Writer, format string, v any) error {
+	if format == "yaml" {
+		return printYAML(w, v)
+	}
+	return printJSON(w, v)
+}
+
+func printPullRow(w io.Writer, p *model.PullStatus, now time.Time) {
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+		p.NodeName,
+		p.ImageRef,
+		pullStatus(p),
+		pullProgress(p),
+		pullDownloaded(p),
+		formatRate(p),
+		pullETA(p),
+		pullPods(p),
+		duration.HumanDuration(now.Sub(p.StartedAt)),
+	)
+}
+
+func sortPulls(pulls []model.PullStatus) {
+	sort.SliceStable(pulls, func(i, j int) bool {
+		a, b := &pulls[i], &pulls[j]
+		if a.NodeName != b.NodeName {
+			return a.NodeName < b.NodeName
+		}
+		if a.ImageRef != b.ImageRef {
+			return a.ImageRef < b.ImageRef
+		}
+		return a.StartedAt.Before(b.StartedAt)
+	})
+}
+
+func pullStatus(p *model.PullStatus) string {
+	switch {
+	case p.Error != "":
+		return "Failed"
+	case p.CompletedAt != nil:
+		return "Completed"
+	case p.Stalled:
+		return "Stalled"
+	default:
+		return "Pulling"
+	}
+}
+
+func pullProgress(p *model.PullStatus) string {
+	if !p.TotalKnown && p.CompletedAt == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%.0f%%", math.Floor(p.Percent))
+}
+
+func pullDownloaded(p *model.PullStatus) string {
+	if !p.TotalKnown || p.TotalBytes == 0 {
+		return formatBytes(p.DownloadedBytes)
+	}
+	return formatBytes(p.DownloadedBytes) + "/" + formatBytes(p.TotalBytes)
+}
+
+func formatRate(p *model.PullStatus) string {
+	if p.CompletedAt != nil {
+		return "-"
+	}
+	return formatBytes(int64(p.BytesPerSec)) + "/s"
+}
+
+func pullETA(p *model.PullStatus) string {
+	if p.CompletedAt != nil || p.ETASeconds <= 0 {
+		return "-"
+	}
+	return formatDuration(time.Duration(p.ETASeconds * float64(time.Second)))
+}
+
+func pullPods(p *model.PullStatus) string {
+	if len(p.Pods) == 0 {
+		return "<none>"
+	}
+	seen := make(map[string]bool)
+	var pods []string
+	for _, pod := range p.Pods {
+		key := pod.Namespace + "/" + pod.PodName
+		if !seen[key] {
+			seen[key] = true
+			pods = append(pods, key)
+		}
+	}
+	return strings.Join(pods, ",")
+}
+
+// shortDigest trims a layer digest to the 12 hex characters docker shows.
+func shortDigest(d string) string {
+	algo, hex, ok := strings.Cut(d, ":")
+	if !ok || len(hex) <= 12 {
+		return d
+	}
+	return algo + ":" + hex[:12]
+}
+
+func formatBytes(b int64) string {
+	const unit = 1000
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	v := float64(b) / float64(div)
+	if v >= 100 {
+		return fmt.Sprintf("%.0f%cB", v, "kMGTPE"[exp])
+	}
+	return fmt.Sprintf("%.1f%cB", v, "kMGTPE"[exp])
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
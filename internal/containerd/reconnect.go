@@ -0,0 +1,116 @@
+package containerd
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	containerd "github.com/containerd/containerd/v2/client"
+)
+
+// healthCheckTimeout bounds the IsServing probe used to tell a broken
+// connection apart from a transient RPC failure.
+const healthCheckTimeout = 2 * time.Second
+
+// Backoff describes a jittered exponential backoff schedule.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+	Factor  float64
+	// Jitter is the fraction of each delay that is randomized, in [0, 1].
+	Jitter float64
+}
+
+// DefaultBackoff is used for containerd reconnects.
+var DefaultBackoff = Backoff{
+	Initial: 500 * time.Millisecond,
+	Max:     30 * time.Second,
+	Factor:  2,
+	Jitter:  0.2,
+}
+
+// Delay returns the wait before the given attempt (0-based).
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := float64(b.Initial)
+	for i := 0; i < attempt && d < float64(b.Max); i++ {
+		d *= b.Factor
+	}
+	if d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		d -= d * b.Jitter * rand.Float64()
+	}
+	return time.Duration(d)
+}
+
+// ReconnectFunc is called after every failed reconnect attempt with the
+// attempt number, the error, and the delay before the next attempt.
+type ReconnectFunc func(attempt int, err error, next time.Duration)
+
+// Reconnect re-establishes the containerd connection, retrying with jittered
+// exponential backoff until it succeeds or ctx is cancelled. Tracked pulls are
+// preserved so progress resumes where it left off.
+func (w *Watcher) Reconnect(ctx context.Context, onFailure ReconnectFunc) error {
+	w.disconnect()
+
+	for attempt := 0; ; attempt++ {
+		err := w.dial(ctx)
+		if err == nil {
+			return nil
+		}
+
+		delay := w.backoff.Delay(attempt)
+		if onFailure != nil {
+			onFailure(attempt+1, err, delay)
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-w.stopCh:
+			timer.Stop()
+			return fmt.Errorf("watcher closed")
+		case <-timer.C:
+		}
+	}
+}
+
+// dial opens a new client and verifies that containerd is actually serving,
+// since the underlying gRPC connection is established lazily.
+func (w *Watcher) dial(ctx context.Context) error {
+	c, err := containerd.New(w.socketPath, containerd.WithDefaultNamespace(w.namespace))
+	if err != nil {
+		return fmt.Errorf("connecting to containerd at %s: %w", w.socketPath, err)
+	}
+
+	w.client = c
+	if !w.serving(ctx) {
+		c.Close() //nolint:errcheck
+		w.client = nil
+		return fmt.Errorf("containerd at %s is not serving", w.socketPath)
+	}
+	w.connected.Store(true)
+	return nil
+}
+
+func (w *Watcher) serving(ctx context.Context) bool {
+	if w.client == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+	defer cancel()
+	ok, err := w.client.IsServing(ctx)
+	return err == nil && ok
+}
+
+func (w *Watcher) disconnect() {
+	w.connected.Store(false)
+	if w.client != nil {
+		w.client.Close() //nolint:errcheck
+		w.client = nil
+	}
+}
@@ -0,0 +1,47 @@
+package containerd
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay_Exponential(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Factor: 2}
+	cases := []struct {
+		attempt int
+		expect  time.Duration
+	}{
+		{0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond},
+		{2, 400 * time.Millisecond},
+		{3, 800 * time.Millisecond},
+		{4, time.Second},
+		{50, time.Second},
+	}
+	for _, c := range cases {
+		if got := b.Delay(c.attempt); got != c.expect {
+			t.Errorf("Delay(%d) = %v, want %v", c.attempt, got, c.expect)
+		}
+	}
+}
+
+func TestBackoffDelay_JitterBounds(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: time.Minute, Factor: 2, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		d := b.Delay(1)
+		if d < time.Second || d > 2*time.Second {
+			t.Fatalf("Delay(1) = %v, want within [1s, 2s]", d)
+		}
+	}
+}
+
+func TestPoll_NotConnected(t *testing.T) {
+	w := NewWatcher("/run/containerd/containerd.sock", "")
+	if _, err := w.Poll(context.Background()); err != ErrDisconnected {
+		t.Errorf("expected ErrDisconnected, got %v", err)
+	}
+	if w.Connected() {
+		t.Error("new watcher should not report connected")
+	}
+}
@@ -2,9 +2,11 @@ package containerd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/d44b/pulltrace/internal/model"
@@ -13,12 +15,19 @@ import (
 	"github.com/containerd/containerd/v2/core/content"
 )
 
+// ErrDisconnected is returned by Poll when the containerd connection is found
+// to be broken. Callers should invoke Reconnect before polling again.
+var ErrDisconnected = errors.New("containerd connection lost")
+
 type Watcher struct {
 	socketPath string
 	namespace  string
 	client     *containerd.Client
+	connected  atomic.Bool
+	backoff    Backoff
 	mu         sync.RWMutex
 	pulls      map[string]*pullTracker
+	ingests    int
 	closeOnce  sync.Once
 	stopCh     chan struct{}
 }
@@ -46,6 +55,7 @@ func NewWatcher(socketPath, namespace string) *Watcher {
 	return &Watcher{
 		socketPath: socketPath,
 		namespace:  namespace,
+		backoff:    DefaultBackoff,
 		pulls:      make(map[string]*pullTracker),
 		stopCh:     make(chan struct{}),
 	}
@@ -57,13 +67,20 @@ func (w *Watcher) Connect(ctx context.Context) error {
 		return fmt.Errorf("connecting to containerd at %s: %w", w.socketPath, err)
 	}
 	w.client = c
+	w.connected.Store(true)
 	return nil
 }
 
+// Connected reports whether the watcher currently holds a working connection.
+func (w *Watcher) Connected() bool {
+	return w.connected.Load()
+}
+
 func (w *Watcher) Close() error {
 	var err error
 	w.closeOnce.Do(func() {
 		close(w.stopCh)
+		w.connected.Store(false)
 		if w.client != nil {
 			err = w.client.Close()
 		}
@@ -72,19 +89,24 @@ func (w *Watcher) Close() error {
 }
 
 func (w *Watcher) Poll(ctx context.Context) ([]model.PullState, error) {
-	if w.client == nil {
-		return nil, fmt.Errorf("not connected")
+	if w.client == nil || !w.connected.Load() {
+		return nil, ErrDisconnected
 	}
 
 	store := w.client.ContentStore()
 	statuses, err := store.ListStatuses(ctx, "")
 	if err != nil {
+		if ctx.Err() == nil && !w.serving(ctx) {
+			w.disconnect()
+			return nil, fmt.Errorf("listing content statuses: %w: %v", ErrDisconnected, err)
+		}
 		return nil, fmt.Errorf("listing content statuses: %w", err)
 	}
 
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	w.ingests = len(statuses)
 	activeRefs := make(map[string]bool)
 	for _, status := range statuses {
 		activeRefs[status.Ref] = true
@@ -103,6 +125,27 @@ func (w *Watcher) Poll(ctx context.Context) ([]model.PullState, error) {
 		}
 	}
 
+	states := w.snapshotLocked()
+	w.cleanCompleted()
+	return states, nil
+}
+
+// Snapshot returns the tracked pulls as of the last Poll without contacting
+// containerd.
+func (w *Watcher) Snapshot() []model.PullState {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.snapshotLocked()
+}
+
+// ActiveIngests returns the number of content ingests seen by the last Poll.
+func (w *Watcher) ActiveIngests() int {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.ingests
+}
+
+func (w *Watcher) snapshotLocked() []model.PullState {
 	var states []model.PullState
 	for _, pt := range w.pulls {
 		ps := model.PullState{
@@ -124,9 +167,7 @@ func (w *Watcher) Poll(ctx context.Context) ([]model.PullState, error) {
 		}
 		states = append(states, ps)
 	}
-
-	w.cleanCompleted()
-	return states, nil
+	return states
 }
 
 func (w *Watcher) updateLayerFromStatus(status content.Status) {
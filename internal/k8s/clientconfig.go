@@ -0,0 +1,118 @@
+package k8s
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/pflag"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// ClientConfig says how to reach the API server. By default it loads
+// kubeconfig the way kubectl does, from Kubeconfig or $KUBECONFIG and
+// ~/.kube/config, with Overrides applied, and falls back to the in-cluster
+// service account when there is no kubeconfig.
+type ClientConfig struct {
+	Kubeconfig string
+	Overrides  clientcmd.ConfigOverrides
+	// FakeCluster is the path of a recorded cluster, such as the output of
+	// kubectl get pods,events,nodes -A -o yaml, served from a fake clientset
+	// instead of an API server.
+	FakeCluster string
+}
+
+// BindFlags adds --kubeconfig, --fake-cluster and the standard kubectl
+// overrides such as --context, --server and --token to fs. Values already in
+// c are the defaults.
+func (c *ClientConfig) BindFlags(fs *pflag.FlagSet) {
+	names := clientcmd.RecommendedConfigOverrideFlags("")
+	// The server's own namespace is PULLTRACE_NAMESPACE.
+	names.ContextOverrideFlags.Namespace = clientcmd.FlagInfo{}
+	names.CurrentContext.Default = c.Overrides.CurrentContext
+
+	fs.StringVar(&c.Kubeconfig, "kubeconfig", c.Kubeconfig, "Path to the kubeconfig file")
+	fs.StringVar(&c.FakeCluster, "fake-cluster", c.FakeCluster, "Path of recorded Kubernetes objects to serve from a fake cluster")
+	clientcmd.BindOverrideFlags(&c.Overrides, fs, names)
+}
+
+// NewClient returns a client for the configured cluster and the REST
+// configuration behind it, which is nil for a fake cluster.
+func (c ClientConfig) NewClient() (kubernetes.Interface, *rest.Config, error) {
+	if c.FakeCluster != "" {
+		client, err := LoadFakeCluster(c.FakeCluster)
+		return client, nil, err
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = c.Kubeconfig
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &c.Overrides).ClientConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("loading kubeconfig: %w", err)
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating kubernetes client: %w", err)
+	}
+	return client, config, nil
+}
+
+// LoadFakeCluster returns a fake clientset holding the objects in the YAML or
+// JSON file at path. The file may hold several documents and lists.
+func LoadFakeCluster(path string) (*fake.Clientset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("loading fake cluster: %w", err)
+	}
+	defer f.Close()
+
+	var objects []runtime.Object
+	decoder := utilyaml.NewYAMLOrJSONDecoder(f, 4096)
+	for {
+		var raw runtime.RawExtension
+		if err := decoder.Decode(&raw); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("loading fake cluster %s: %w", path, err)
+		}
+		if len(raw.Raw) == 0 {
+			continue
+		}
+		decoded, err := decodeObjects(raw.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("loading fake cluster %s: %w", path, err)
+		}
+		objects = append(objects, decoded...)
+	}
+	return fake.NewSimpleClientset(objects...), nil
+}
+
+// decodeObjects decodes one object, or the items of a List.
+func decodeObjects(data []byte) ([]runtime.Object, error) {
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	list, ok := obj.(*corev1.List)
+	if !ok {
+		return []runtime.Object{obj}, nil
+	}
+	var objects []runtime.Object
+	for _, item := range list.Items {
+		decoded, err := decodeObjects(item.Raw)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, decoded...)
+	}
+	return objects, nil
+}
@@ -0,0 +1,135 @@
+package k8s
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+current-context: dev
+clusters:
+- name: dev
+  cluster: {server: "https://dev.example:6443"}
+- name: mgmt
+  cluster: {server: "https://mgmt.example:6443"}
+users:
+- name: me
+  user: {token: "secret"}
+contexts:
+- name: dev
+  context: {cluster: dev, user: me}
+- name: mgmt
+  context: {cluster: mgmt, user: me}
+`
+
+// A List as written by kubectl get -o yaml, followed by a second document.
+const testRecordedCluster = `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Pod
+  metadata: {namespace: default, name: web-0}
+  spec:
+    nodeName: node1
+    containers: [{name: app, image: "nginx:1.27"}]
+  status:
+    containerStatuses:
+    - name: app
+      state: {waiting: {reason: ContainerCreating}}
+- apiVersion: v1
+  kind: Node
+  metadata: {name: node1}
+---
+apiVersion: v1
+kind: Event
+metadata: {namespace: default, name: web-0.pulling}
+involvedObject: {kind: Pod, namespace: default, name: web-0}
+reason: Pulling
+message: Pulling image "nginx:1.27"
+source: {host: node1}
+`
+
+func writeFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestClientConfig_Kubeconfig(t *testing.T) {
+	kubeconfig := writeFile(t, "config", testKubeconfig)
+
+	for _, tt := range []struct {
+		name string
+		args []string
+		kube ClientConfig
+		want string
+	}{
+		{"current context", nil, ClientConfig{Kubeconfig: kubeconfig}, "https://dev.example:6443"},
+		{"context from the environment", nil, ClientConfig{Kubeconfig: kubeconfig, Overrides: clientcmd.ConfigOverrides{CurrentContext: "mgmt"}}, "https://mgmt.example:6443"},
+		{"flags override the environment", []string{"--context", "dev"}, ClientConfig{Kubeconfig: kubeconfig, Overrides: clientcmd.ConfigOverrides{CurrentContext: "mgmt"}}, "https://dev.example:6443"},
+		{"server flag", []string{"--kubeconfig", kubeconfig, "--server", "https://other.example"}, ClientConfig{}, "https://other.example"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			tt.kube.BindFlags(fs)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			_, config, err := tt.kube.NewClient()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Host != tt.want {
+				t.Errorf("host = %q, want %q", config.Host, tt.want)
+			}
+			if config.BearerToken != "secret" {
+				t.Errorf("token = %q, want the kubeconfig user's", config.BearerToken)
+			}
+		})
+	}
+}
+
+func TestClientConfig_FakeCluster(t *testing.T) {
+	kube := ClientConfig{FakeCluster: writeFile(t, "cluster.yaml", testRecordedCluster)}
+	pw, err := NewPodWatcher(kube, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pw.RESTConfig() != nil {
+		t.Error("fake cluster has a REST config")
+	}
+	nodes, err := pw.Client().CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
+	if err != nil || len(nodes.Items) != 1 {
+		t.Fatalf("nodes = %v, %v", nodes, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pw.Run(ctx) //nolint:errcheck
+	eventually(t, "caches to sync", pw.HasSynced)
+	if got := pw.GetPodsForImage("node1", "nginx:1.27"); len(got) != 1 || got[0].PodName != "web-0" {
+		t.Errorf("pod correlations = %+v", got)
+	}
+}
+
+func TestLoadFakeCluster_Invalid(t *testing.T) {
+	if _, err := LoadFakeCluster(writeFile(t, "cluster.yaml", "kind: Widget\napiVersion: v9\n")); err == nil {
+		t.Error("expected an error for an unknown kind")
+	}
+	if _, err := LoadFakeCluster(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,245 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	eventComponent = "pulltrace"
+
+	reasonPullProgress  = "PullProgress"
+	reasonPullCompleted = "PullCompleted"
+	reasonPullFailed    = "PullFailed"
+
+	// eventPercentStep is the minimum progress, in percentage points, between
+	// two progress events for the same pull. Pulls without a known total are
+	// gated on time alone.
+	eventPercentStep = 10
+
+	// eventQueueSize bounds pending API writes; events are dropped beyond it.
+	eventQueueSize = 256
+)
+
+// EventEmitter writes Kubernetes Events on pods that are waiting for an image
+// pull, so progress shows up in `kubectl describe pod`. Progress events are
+// rate limited per pull; a summary event is always written on completion, as a
+// Warning if the pull failed.
+type EventEmitter struct {
+	client      kubernetes.Interface
+	logger      *slog.Logger
+	minInterval time.Duration
+	instance    string
+	mu          sync.Mutex
+	// state maps pull ID -> the last progress event written for it.
+	state map[string]*pullEventState
+	queue chan *corev1.Event
+	// seq keeps event names unique when several are written in the same instant.
+	seq atomic.Uint64
+	now func() time.Time
+}
+
+type pullEventState struct {
+	lastAt      time.Time
+	lastPercent float64
+}
+
+// NewEventEmitter returns an emitter that writes at most one progress event
+// per pull every minInterval.
+func NewEventEmitter(client kubernetes.Interface, minInterval time.Duration, logger *slog.Logger) *EventEmitter {
+	instance, _ := os.Hostname()
+	return &EventEmitter{
+		client:      client,
+		logger:      logger,
+		minInterval: minInterval,
+		instance:    instance,
+		state:       make(map[string]*pullEventState),
+		queue:       make(chan *corev1.Event, eventQueueSize),
+		now:         time.Now,
+	}
+}
+
+// Run writes queued events until ctx is cancelled.
+func (e *EventEmitter) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-e.queue:
+			e.create(ctx, ev)
+		}
+	}
+}
+
+// Progress records a progress milestone for pull if enough time and progress
+// have passed since the previous one. It never blocks.
+func (e *EventEmitter) Progress(pull *model.PullStatus) {
+	if len(pull.Pods) == 0 {
+		return
+	}
+
+	e.mu.Lock()
+	now := e.now()
+	st, ok := e.state[pull.ID]
+	if ok && (now.Sub(st.lastAt) < e.minInterval || pull.TotalKnown && pull.Percent-st.lastPercent < eventPercentStep) {
+		e.mu.Unlock()
+		return
+	}
+	if !ok {
+		st = &pullEventState{}
+		e.state[pull.ID] = st
+	}
+	st.lastAt = now
+	st.lastPercent = pull.Percent
+	e.mu.Unlock()
+
+	e.emit(pull, corev1.EventTypeNormal, reasonPullProgress, progressMessage(pull))
+}
+
+// Completed writes a final summary event for pull and forgets its state. A
+// failed pull gets a Warning event carrying its error instead.
+func (e *EventEmitter) Completed(pull *model.PullStatus) {
+	e.mu.Lock()
+	delete(e.state, pull.ID)
+	e.mu.Unlock()
+
+	if len(pull.Pods) == 0 {
+		return
+	}
+	if pull.Error != "" {
+		e.emit(pull, corev1.EventTypeWarning, reasonPullFailed, failedMessage(pull))
+		return
+	}
+	e.emit(pull, corev1.EventTypeNormal, reasonPullCompleted, completedMessage(pull))
+}
+
+// Discard forgets pullID without writing a summary, e.g. for pulls that were
+// force-completed because their agent went silent.
+func (e *EventEmitter) Discard(pullID string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.state, pullID)
+}
+
+func (e *EventEmitter) emit(pull *model.PullStatus, eventType, reason, message string) {
+	now := metav1.NewTime(e.now())
+	seen := make(map[string]bool)
+	for _, pod := range pull.Pods {
+		podKey := pod.Namespace + "/" + pod.PodName
+		if seen[podKey] {
+			continue
+		}
+		seen[podKey] = true
+
+		ev := &corev1.Event{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s.%x%x", pod.PodName, now.UnixNano(), e.seq.Add(1)),
+				Namespace: pod.Namespace,
+			},
+			InvolvedObject: corev1.ObjectReference{
+				Kind:       "Pod",
+				APIVersion: "v1",
+				Namespace:  pod.Namespace,
+				Name:       pod.PodName,
+				UID:        types.UID(pod.PodUID),
+			},
+			Reason:              reason,
+			Message:             message,
+			Type:                eventType,
+			Source:              corev1.EventSource{Component: eventComponent, Host: pull.NodeName},
+			FirstTimestamp:      now,
+			LastTimestamp:       now,
+			Count:               1,
+			ReportingController: eventComponent,
+			ReportingInstance:   e.instance,
+		}
+		select {
+		case e.queue <- ev:
+		default:
+			e.logger.Debug("event queue full, dropping pod event", "pod", podKey, "reason", reason)
+		}
+	}
+}
+
+func (e *EventEmitter) create(ctx context.Context, ev *corev1.Event) {
+	if _, err := e.client.CoreV1().Events(ev.Namespace).Create(ctx, ev, metav1.CreateOptions{}); err != nil {
+		e.logger.Warn("failed to write pod event",
+			"namespace", ev.Namespace,
+			"pod", ev.InvolvedObject.Name,
+			"reason", ev.Reason,
+			"error", err,
+		)
+	}
+}
+
+// progressMessage renders e.g. "Pulling nginx:1.27: 45% of 812MB, ETA 30s, 12MB/s".
+func progressMessage(pull *model.PullStatus) string {
+	if !pull.TotalKnown || pull.TotalBytes == 0 {
+		return fmt.Sprintf("Pulling %s: %s downloaded, %s/s",
+			pull.ImageRef, formatBytes(pull.DownloadedBytes), formatBytes(int64(pull.BytesPerSec)))
+	}
+	msg := fmt.Sprintf("Pulling %s: %.0f%% of %s", pull.ImageRef, math.Floor(pull.Percent), formatBytes(pull.TotalBytes))
+	if pull.ETASeconds > 0 {
+		msg += ", ETA " + formatDuration(time.Duration(pull.ETASeconds*float64(time.Second)))
+	}
+	return msg + ", " + formatBytes(int64(pull.BytesPerSec)) + "/s"
+}
+
+// completedMessage renders e.g. "Pulled nginx:1.27: 812MB in 1m5s (12.5MB/s)".
+func completedMessage(pull *model.PullStatus) string {
+	var elapsed time.Duration
+	if pull.CompletedAt != nil {
+		elapsed = pull.CompletedAt.Sub(pull.StartedAt)
+	}
+	msg := fmt.Sprintf("Pulled %s: %s in %s", pull.ImageRef, formatBytes(pull.TotalBytes), formatDuration(elapsed))
+	if elapsed > 0 {
+		msg += fmt.Sprintf(" (%s/s)", formatBytes(int64(float64(pull.TotalBytes)/elapsed.Seconds())))
+	}
+	return msg
+}
+
+// failedMessage renders e.g. "Failed to pull nginx:1.27 after 1m5s: not found".
+func failedMessage(pull *model.PullStatus) string {
+	var elapsed time.Duration
+	if pull.CompletedAt != nil {
+		elapsed = pull.CompletedAt.Sub(pull.StartedAt)
+	}
+	return fmt.Sprintf("Failed to pull %s after %s: %s", pull.ImageRef, formatDuration(elapsed), pull.Error)
+}
+
+func formatBytes(b int64) string {
+	const unit = 1000
+	if b < unit {
+		return fmt.Sprintf("%dB", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	v := float64(b) / float64(div)
+	if v >= 100 {
+		return fmt.Sprintf("%.0f%cB", v, "kMGTPE"[exp])
+	}
+	return fmt.Sprintf("%.1f%cB", v, "kMGTPE"[exp])
+}
+
+func formatDuration(d time.Duration) string {
+	if d < time.Second {
+		return d.Round(time.Millisecond).String()
+	}
+	return d.Round(time.Second).String()
+}
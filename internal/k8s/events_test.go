@@ -0,0 +1,225 @@
+package k8s
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newTestEmitter(now *time.Time) (*EventEmitter, *fake.Clientset) {
+	client := fake.NewSimpleClientset()
+	e := NewEventEmitter(client, 15*time.Second, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	e.now = func() time.Time { return *now }
+	return e, client
+}
+
+// flush writes every queued event synchronously.
+func flush(e *EventEmitter) {
+	for {
+		select {
+		case ev := <-e.queue:
+			e.create(context.Background(), ev)
+		default:
+			return
+		}
+	}
+}
+
+func testPull(percent float64) *model.PullStatus {
+	return &model.PullStatus{
+		ID:              "node1:nginx:1.27@1",
+		NodeName:        "node1",
+		ImageRef:        "nginx:1.27",
+		TotalBytes:      812_000_000,
+		DownloadedBytes: int64(percent / 100 * 812_000_000),
+		BytesPerSec:     12_000_000,
+		ETASeconds:      30,
+		Percent:         percent,
+		TotalKnown:      true,
+		Pods: []model.PodCorrelation{
+			{Namespace: "default", PodName: "web-1", PodUID: "uid-1", Container: "nginx"},
+			{Namespace: "default", PodName: "web-1", PodUID: "uid-1", Container: "sidecar"},
+			{Namespace: "prod", PodName: "web-2", PodUID: "uid-2", Container: "nginx"},
+		},
+	}
+}
+
+func TestEventEmitter_ProgressWritesOneEventPerPod(t *testing.T) {
+	now := time.Now()
+	e, client := newTestEmitter(&now)
+
+	e.Progress(testPull(45))
+	flush(e)
+
+	events, err := client.CoreV1().Events("default").List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("listing events: %v", err)
+	}
+	if len(events.Items) != 1 {
+		t.Fatalf("expected 1 event in default (containers deduplicated), got %d", len(events.Items))
+	}
+	ev := events.Items[0]
+	if ev.Reason != reasonPullProgress {
+		t.Errorf("Reason: want %s, got %s", reasonPullProgress, ev.Reason)
+	}
+	if ev.InvolvedObject.Name != "web-1" || ev.InvolvedObject.UID != "uid-1" {
+		t.Errorf("unexpected involved object: %+v", ev.InvolvedObject)
+	}
+	want := "Pulling nginx:1.27: 45% of 812MB, ETA 30s, 12.0MB/s"
+	if ev.Message != want {
+		t.Errorf("Message:\n  got  %q\n  want %q", ev.Message, want)
+	}
+
+	prod, _ := client.CoreV1().Events("prod").List(context.Background(), metav1.ListOptions{})
+	if len(prod.Items) != 1 {
+		t.Errorf("expected 1 event in prod, got %d", len(prod.Items))
+	}
+}
+
+func TestEventEmitter_RateLimitsProgress(t *testing.T) {
+	now := time.Now()
+	e, _ := newTestEmitter(&now)
+
+	e.Progress(testPull(10))
+	if got := len(e.queue); got != 2 {
+		t.Fatalf("first progress: expected 2 queued events, got %d", got)
+	}
+	flush(e)
+
+	// Too soon, even though progress advanced.
+	now = now.Add(5 * time.Second)
+	e.Progress(testPull(50))
+	if got := len(e.queue); got != 0 {
+		t.Errorf("within interval: expected no events, got %d", got)
+	}
+
+	// Enough time, but not enough progress.
+	now = now.Add(time.Minute)
+	e.Progress(testPull(15))
+	if got := len(e.queue); got != 0 {
+		t.Errorf("below percent step: expected no events, got %d", got)
+	}
+
+	e.Progress(testPull(60))
+	if got := len(e.queue); got != 2 {
+		t.Errorf("after interval and step: expected 2 events, got %d", got)
+	}
+}
+
+func TestEventEmitter_CompletedSummary(t *testing.T) {
+	now := time.Now()
+	e, client := newTestEmitter(&now)
+
+	pull := testPull(100)
+	pull.StartedAt = now.Add(-65 * time.Second)
+	completed := now
+	pull.CompletedAt = &completed
+
+	e.Progress(testPull(50))
+	e.Completed(pull)
+	flush(e)
+
+	if _, ok := e.state[pull.ID]; ok {
+		t.Error("completed pull state should be forgotten")
+	}
+	events, _ := client.CoreV1().Events("default").List(context.Background(), metav1.ListOptions{})
+	var summary string
+	for _, ev := range events.Items {
+		if ev.Reason == reasonPullCompleted {
+			summary = ev.Message
+		}
+	}
+	if !strings.HasPrefix(summary, "Pulled nginx:1.27: 812MB in 1m5s") {
+		t.Errorf("unexpected summary %q", summary)
+	}
+}
+
+func TestEventEmitter_ProgressWithoutTotal(t *testing.T) {
+	now := time.Now()
+	e, _ := newTestEmitter(&now)
+	pull := testPull(0)
+	pull.TotalKnown = false
+	pull.TotalBytes = 0
+
+	e.Progress(pull)
+	flush(e)
+
+	now = now.Add(5 * time.Second)
+	e.Progress(pull)
+	if got := len(e.queue); got != 0 {
+		t.Errorf("within interval: expected no events, got %d", got)
+	}
+
+	// Percent stays 0 without a total, so only the interval gates.
+	now = now.Add(time.Minute)
+	e.Progress(pull)
+	if got := len(e.queue); got != 2 {
+		t.Errorf("after interval: expected 2 events, got %d", got)
+	}
+}
+
+func TestEventEmitter_FailedSummary(t *testing.T) {
+	now := time.Now()
+	e, client := newTestEmitter(&now)
+
+	pull := testPull(40)
+	pull.StartedAt = now.Add(-12 * time.Second)
+	completed := now
+	pull.CompletedAt = &completed
+	pull.Error = "unexpected EOF"
+
+	e.Completed(pull)
+	flush(e)
+
+	events, _ := client.CoreV1().Events("default").List(context.Background(), metav1.ListOptions{})
+	if len(events.Items) != 1 {
+		t.Fatalf("expected 1 event, got %d", len(events.Items))
+	}
+	ev := events.Items[0]
+	if ev.Type != corev1.EventTypeWarning || ev.Reason != reasonPullFailed {
+		t.Errorf("want Warning %s, got %s %s", reasonPullFailed, ev.Type, ev.Reason)
+	}
+	want := "Failed to pull nginx:1.27 after 12s: unexpected EOF"
+	if ev.Message != want {
+		t.Errorf("Message:\n  got  %q\n  want %q", ev.Message, want)
+	}
+}
+
+func TestEventEmitter_IgnoresUncorrelatedPulls(t *testing.T) {
+	now := time.Now()
+	e, _ := newTestEmitter(&now)
+	pull := testPull(50)
+	pull.Pods = nil
+	e.Progress(pull)
+	e.Completed(pull)
+	if got := len(e.queue); got != 0 {
+		t.Errorf("expected no events without pods, got %d", got)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	cases := []struct {
+		in     int64
+		expect string
+	}{
+		{512, "512B"},
+		{1_500, "1.5kB"},
+		{812_000_000, "812MB"},
+		{12_345_678, "12.3MB"},
+		{2_100_000_000, "2.1GB"},
+	}
+	for _, c := range cases {
+		if got := formatBytes(c.in); got != c.expect {
+			t.Errorf("formatBytes(%d) = %q, want %q", c.in, got, c.expect)
+		}
+	}
+}
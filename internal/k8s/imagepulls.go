@@ -0,0 +1,301 @@
+package k8s
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	imagePullGroup   = "pulltrace.d44b.io"
+	imagePullVersion = "v1alpha1"
+	imagePullKind    = "ImagePull"
+
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "pulltrace"
+	nodeLabel      = imagePullGroup + "/node"
+	pullIDLabel    = imagePullGroup + "/pull-id"
+)
+
+// ImagePullGVR identifies the ImagePull custom resource.
+var ImagePullGVR = schema.GroupVersionResource{
+	Group:    imagePullGroup,
+	Version:  imagePullVersion,
+	Resource: "imagepulls",
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// ImagePullSyncer mirrors the server's pull state into ImagePull custom
+// resources. Each pull gets one object per namespace of its waiting pods, owned
+// by those pods so Kubernetes garbage-collects it with them. Pulls without
+// correlated pods are written to the fallback namespace, if one is set.
+type ImagePullSyncer struct {
+	client    dynamic.Interface
+	namespace string
+	logger    *slog.Logger
+	// applied maps "namespace/name" -> the object last written (as built
+	// locally, plus its resourceVersion), used to skip no-op updates and to
+	// delete objects whose pull is gone.
+	applied map[string]*unstructured.Unstructured
+	primed  bool
+}
+
+// NewImagePullSyncer returns a syncer writing uncorrelated pulls to
+// fallbackNamespace; pass "" to only mirror pulls with waiting pods.
+func NewImagePullSyncer(client dynamic.Interface, fallbackNamespace string, logger *slog.Logger) *ImagePullSyncer {
+	return &ImagePullSyncer{
+		client:    client,
+		namespace: fallbackNamespace,
+		logger:    logger,
+		applied:   make(map[string]*unstructured.Unstructured),
+	}
+}
+
+// Sync creates, updates and deletes ImagePull objects so they match pulls.
+// It is not safe for concurrent use.
+func (s *ImagePullSyncer) Sync(ctx context.Context, pulls []model.PullStatus) error {
+	if !s.primed {
+		if err := s.prime(ctx); err != nil {
+			return err
+		}
+		s.primed = true
+	}
+
+	desired := make(map[string]*unstructured.Unstructured)
+	for i := range pulls {
+		for _, obj := range s.objectsFor(&pulls[i]) {
+			desired[obj.GetNamespace()+"/"+obj.GetName()] = obj
+		}
+	}
+
+	var errs []error
+	for key, obj := range desired {
+		if err := s.apply(ctx, key, obj); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for key, obj := range s.applied {
+		if _, ok := desired[key]; ok {
+			continue
+		}
+		err := s.client.Resource(ImagePullGVR).Namespace(obj.GetNamespace()).Delete(ctx, obj.GetName(), metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			errs = append(errs, fmt.Errorf("deleting imagepull %s: %w", key, err))
+			continue
+		}
+		s.logger.Debug("deleted imagepull", "key", key)
+		delete(s.applied, key)
+	}
+	return errors.Join(errs...)
+}
+
+// prime adopts objects left behind by a previous server instance so they are
+// garbage-collected if their pull no longer exists.
+func (s *ImagePullSyncer) prime(ctx context.Context) error {
+	list, err := s.client.Resource(ImagePullGVR).Namespace("").List(ctx, metav1.ListOptions{
+		LabelSelector: managedByLabel + "=" + managedByValue,
+	})
+	if err != nil {
+		return fmt.Errorf("listing imagepulls: %w", err)
+	}
+	for i := range list.Items {
+		obj := &list.Items[i]
+		s.applied[obj.GetNamespace()+"/"+obj.GetName()] = obj
+	}
+	return nil
+}
+
+func (s *ImagePullSyncer) apply(ctx context.Context, key string, obj *unstructured.Unstructured) error {
+	res := s.client.Resource(ImagePullGVR).Namespace(obj.GetNamespace())
+
+	prev, ok := s.applied[key]
+	if ok && reflect.DeepEqual(prev.Object["spec"], obj.Object["spec"]) &&
+		reflect.DeepEqual(prev.Object["status"], obj.Object["status"]) &&
+		reflect.DeepEqual(prev.GetOwnerReferences(), obj.GetOwnerReferences()) {
+		return nil
+	}
+
+	if !ok {
+		created, err := res.Create(ctx, obj, metav1.CreateOptions{})
+		if err == nil {
+			obj.SetResourceVersion(created.GetResourceVersion())
+			s.applied[key] = obj
+			return nil
+		}
+		if !apierrors.IsAlreadyExists(err) {
+			return fmt.Errorf("creating imagepull %s: %w", key, err)
+		}
+		if prev, err = res.Get(ctx, obj.GetName(), metav1.GetOptions{}); err != nil {
+			return fmt.Errorf("getting imagepull %s: %w", key, err)
+		}
+	}
+
+	obj.SetResourceVersion(prev.GetResourceVersion())
+	updated, err := res.Update(ctx, obj, metav1.UpdateOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+			// Deleted or changed underneath us (e.g. owner pods went away);
+			// recreate or retry on the next sync.
+			delete(s.applied, key)
+		}
+		return fmt.Errorf("updating imagepull %s: %w", key, err)
+	}
+	obj.SetResourceVersion(updated.GetResourceVersion())
+	s.applied[key] = obj
+	return nil
+}
+
+// objectsFor builds the ImagePull objects for a single pull.
+func (s *ImagePullSyncer) objectsFor(pull *model.PullStatus) []*unstructured.Unstructured {
+	byNamespace := make(map[string][]model.PodCorrelation)
+	for _, pod := range pull.Pods {
+		byNamespace[pod.Namespace] = append(byNamespace[pod.Namespace], pod)
+	}
+	if len(byNamespace) == 0 {
+		if s.namespace == "" {
+			return nil
+		}
+		byNamespace[s.namespace] = nil
+	}
+
+	objs := make([]*unstructured.Unstructured, 0, len(byNamespace))
+	for ns, pods := range byNamespace {
+		objs = append(objs, imagePullObject(pull, ns, pods))
+	}
+	return objs
+}
+
+func imagePullObject(pull *model.PullStatus, namespace string, pods []model.PodCorrelation) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(imagePullGroup + "/" + imagePullVersion)
+	obj.SetKind(imagePullKind)
+	obj.SetNamespace(namespace)
+	obj.SetName(imagePullName(pull))
+	obj.SetLabels(map[string]string{
+		managedByLabel: managedByValue,
+		nodeLabel:      truncateLabel(pull.NodeName),
+		pullIDLabel:    pullHash(pull.ID),
+	})
+
+	var owners []metav1.OwnerReference
+	var podRefs []interface{}
+	seen := make(map[string]bool)
+	for _, pod := range pods {
+		podRefs = append(podRefs, map[string]interface{}{
+			"name":      pod.PodName,
+			"container": pod.Container,
+		})
+		if pod.PodUID == "" || seen[pod.PodUID] {
+			continue
+		}
+		seen[pod.PodUID] = true
+		owners = append(owners, metav1.OwnerReference{
+			APIVersion: "v1",
+			Kind:       "Pod",
+			Name:       pod.PodName,
+			UID:        types.UID(pod.PodUID),
+		})
+	}
+	sort.Slice(owners, func(i, j int) bool { return owners[i].Name < owners[j].Name })
+	obj.SetOwnerReferences(owners)
+
+	obj.Object["spec"] = map[string]interface{}{
+		"nodeName": pull.NodeName,
+		"image":    pull.ImageRef,
+	}
+
+	status := map[string]interface{}{
+		"phase":           imagePullPhase(pull),
+		"percent":         roundTo(pull.Percent, 1),
+		"totalBytes":      pull.TotalBytes,
+		"downloadedBytes": pull.DownloadedBytes,
+		"bytesPerSecond":  int64(pull.BytesPerSec),
+		"totalKnown":      pull.TotalKnown,
+		"layerCount":      int64(pull.LayerCount),
+		"layersDone":      int64(pull.LayersDone),
+		"startedAt":       pull.StartedAt.UTC().Format(time.RFC3339),
+	}
+	if pull.ETASeconds > 0 {
+		status["etaSeconds"] = int64(pull.ETASeconds + 0.5)
+	}
+	if pull.CompletedAt != nil {
+		status["completedAt"] = pull.CompletedAt.UTC().Format(time.RFC3339)
+	}
+	if pull.Error != "" {
+		status["error"] = pull.Error
+	}
+	if len(podRefs) > 0 {
+		status["pods"] = podRefs
+	}
+	obj.Object["status"] = status
+	return obj
+}
+
+func imagePullPhase(pull *model.PullStatus) string {
+	switch {
+	case pull.Error != "":
+		return "Failed"
+	case pull.CompletedAt != nil:
+		return "Completed"
+	default:
+		return "Pulling"
+	}
+}
+
+// imagePullName derives a stable DNS-1123 name from the image's repository
+// name and a hash of the pull ID, e.g. "nginx-3fa2b1c4d5".
+func imagePullName(pull *model.PullStatus) string {
+	repo := pull.ImageRef
+	if i := strings.LastIndex(repo, "/"); i >= 0 {
+		repo = repo[i+1:]
+	}
+	if i := strings.IndexAny(repo, ":@"); i >= 0 {
+		repo = repo[:i]
+	}
+	repo = strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(repo), "-"), "-")
+	if len(repo) > 40 {
+		repo = strings.TrimRight(repo[:40], "-")
+	}
+	if repo == "" {
+		repo = "pull"
+	}
+	return repo + "-" + pullHash(pull.ID)
+}
+
+func pullHash(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])[:10]
+}
+
+func truncateLabel(v string) string {
+	if len(v) > 63 {
+		v = strings.TrimRight(v[:63], ".-_")
+	}
+	return v
+}
+
+func roundTo(v float64, places int) float64 {
+	p := 1.0
+	for i := 0; i < places; i++ {
+		p *= 10
+	}
+	return float64(int64(v*p+0.5)) / p
+}
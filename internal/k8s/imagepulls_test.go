@@ -0,0 +1,184 @@
+package k8s
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newTestSyncer(namespace string) (*ImagePullSyncer, *dynamicfake.FakeDynamicClient) {
+	client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{ImagePullGVR: "ImagePullList"})
+	return NewImagePullSyncer(client, namespace, slog.New(slog.NewTextHandler(io.Discard, nil))), client
+}
+
+func listImagePulls(t *testing.T, client *dynamicfake.FakeDynamicClient, namespace string) []unstructured.Unstructured {
+	t.Helper()
+	list, err := client.Resource(ImagePullGVR).Namespace(namespace).List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	return list.Items
+}
+
+func countWrites(client *dynamicfake.FakeDynamicClient, verb string) int {
+	n := 0
+	for _, a := range client.Actions() {
+		if a.GetVerb() == verb {
+			n++
+		}
+	}
+	return n
+}
+
+func TestImagePullSyncer_CreatesOnePerNamespace(t *testing.T) {
+	s, client := newTestSyncer("")
+	if err := s.Sync(context.Background(), []model.PullStatus{*testPull(45)}); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+
+	def := listImagePulls(t, client, "default")
+	prod := listImagePulls(t, client, "prod")
+	if len(def) != 1 || len(prod) != 1 {
+		t.Fatalf("got %d objects in default and %d in prod, want 1 each", len(def), len(prod))
+	}
+
+	obj := def[0]
+	if obj.GetName() != imagePullName(testPull(0)) {
+		t.Errorf("name = %q", obj.GetName())
+	}
+	owners := obj.GetOwnerReferences()
+	if len(owners) != 1 || owners[0].Name != "web-1" || owners[0].UID != "uid-1" {
+		t.Errorf("owner references = %+v, want web-1/uid-1", owners)
+	}
+	phase, _, _ := unstructured.NestedString(obj.Object, "status", "phase")
+	if phase != "Pulling" {
+		t.Errorf("phase = %q, want Pulling", phase)
+	}
+	pods, _, _ := unstructured.NestedSlice(obj.Object, "status", "pods")
+	if len(pods) != 2 {
+		t.Errorf("status.pods has %d entries, want 2 (one per container)", len(pods))
+	}
+	if got := obj.GetLabels()[nodeLabel]; got != "node1" {
+		t.Errorf("node label = %q", got)
+	}
+}
+
+func TestImagePullSyncer_UpdatesOnlyOnChange(t *testing.T) {
+	s, client := newTestSyncer("")
+	ctx := context.Background()
+
+	pull := testPull(45)
+	pull.Pods = pull.Pods[:1]
+	if err := s.Sync(ctx, []model.PullStatus{*pull}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sync(ctx, []model.PullStatus{*pull}); err != nil {
+		t.Fatal(err)
+	}
+	if n := countWrites(client, "update"); n != 0 {
+		t.Fatalf("unchanged pull caused %d updates, want 0", n)
+	}
+
+	completed := time.Now()
+	pull.Percent = 100
+	pull.CompletedAt = &completed
+	if err := s.Sync(ctx, []model.PullStatus{*pull}); err != nil {
+		t.Fatal(err)
+	}
+	if n := countWrites(client, "update"); n != 1 {
+		t.Fatalf("got %d updates, want 1", n)
+	}
+
+	items := listImagePulls(t, client, "default")
+	phase, _, _ := unstructured.NestedString(items[0].Object, "status", "phase")
+	if phase != "Completed" {
+		t.Errorf("phase = %q, want Completed", phase)
+	}
+}
+
+func TestImagePullSyncer_DeletesGonePulls(t *testing.T) {
+	s, client := newTestSyncer("")
+	ctx := context.Background()
+
+	if err := s.Sync(ctx, []model.PullStatus{*testPull(45)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Sync(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(listImagePulls(t, client, "")); n != 0 {
+		t.Fatalf("%d objects left after the pull went away, want 0", n)
+	}
+}
+
+func TestImagePullSyncer_AdoptsLeftoverObjects(t *testing.T) {
+	_, client := newTestSyncer("")
+	ctx := context.Background()
+
+	stale := imagePullObject(testPull(45), "default", nil)
+	if _, err := client.Resource(ImagePullGVR).Namespace("default").Create(ctx, stale, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewImagePullSyncer(client, "", slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err := s.Sync(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(listImagePulls(t, client, "")); n != 0 {
+		t.Fatalf("leftover object not garbage-collected, %d remain", n)
+	}
+}
+
+func TestImagePullSyncer_FallbackNamespace(t *testing.T) {
+	pull := testPull(10)
+	pull.Pods = nil
+
+	s, client := newTestSyncer("")
+	if err := s.Sync(context.Background(), []model.PullStatus{*pull}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(listImagePulls(t, client, "")); n != 0 {
+		t.Fatalf("uncorrelated pull mirrored without a fallback namespace")
+	}
+
+	s, client = newTestSyncer("pulltrace")
+	if err := s.Sync(context.Background(), []model.PullStatus{*pull}); err != nil {
+		t.Fatal(err)
+	}
+	items := listImagePulls(t, client, "pulltrace")
+	if len(items) != 1 {
+		t.Fatalf("got %d objects in fallback namespace, want 1", len(items))
+	}
+	if owners := items[0].GetOwnerReferences(); len(owners) != 0 {
+		t.Errorf("uncorrelated pull has owner references %+v", owners)
+	}
+}
+
+func TestImagePullName(t *testing.T) {
+	tests := []struct {
+		ref    string
+		prefix string
+	}{
+		{"nginx:1.27", "nginx-"},
+		{"ghcr.io/d44b/Pull_Trace-Server:v1", "pull-trace-server-"},
+		{"registry:5000/app@sha256:abcd", "app-"},
+		{"___:tag", "pull-"},
+	}
+	for _, tt := range tests {
+		got := imagePullName(&model.PullStatus{ID: "id", ImageRef: tt.ref})
+		if want := tt.prefix + pullHash("id"); got != want {
+			t.Errorf("imagePullName(%q) = %q, want %q", tt.ref, got, want)
+		}
+	}
+}
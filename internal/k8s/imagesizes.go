@@ -0,0 +1,237 @@
+package k8s
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/metrics"
+	"github.com/d44b/pulltrace/internal/registry"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// maxConcurrentLookups bounds the manifest requests in flight.
+	maxConcurrentLookups = 4
+	// lookupQueueSize bounds the lookups waiting for a worker. Pulling
+	// events beyond it are dropped.
+	lookupQueueSize = 256
+	lookupTimeout   = 30 * time.Second
+)
+
+// PullingImage describes a kubelet Pulling event.
+type PullingImage struct {
+	NodeName  string
+	Image     string
+	Namespace string
+	PodName   string
+	// PullSecrets are the names of the pod's imagePullSecrets.
+	PullSecrets []string
+}
+
+type imageSize struct {
+	bytes int64
+	at    time.Time
+}
+
+// lookup is a queued manifest lookup.
+type lookup struct {
+	PullingImage
+	sizeKey    string
+	attemptKey string
+}
+
+// ImageSizes looks up the compressed size of images from their registry
+// manifests when the kubelet starts pulling them, using the pod's pull
+// secrets and the node's platform. It is safe for concurrent use.
+type ImageSizes struct {
+	client   kubernetes.Interface
+	registry *registry.Client
+	logger   *slog.Logger
+	queue    chan lookup
+
+	mu sync.Mutex
+	// sizes maps "node:normalizedImage" -> resolved size.
+	sizes map[string]imageSize
+	// attempts maps a lookup, keyed by node, image and the pod's pull
+	// secrets, to when it was queued or failed. A failed lookup is not
+	// retried with the same secrets until it expires, but a pod with other
+	// secrets tries again.
+	attempts  map[string]time.Time
+	platforms map[string]registry.Platform
+}
+
+// NewImageSizes returns an ImageSizes that reads pull secrets and nodes
+// through client and fetches manifests with rc.
+func NewImageSizes(client kubernetes.Interface, rc *registry.Client, logger *slog.Logger) *ImageSizes {
+	return &ImageSizes{
+		client:    client,
+		registry:  rc,
+		logger:    logger,
+		queue:     make(chan lookup, lookupQueueSize),
+		sizes:     make(map[string]imageSize),
+		attempts:  make(map[string]time.Time),
+		platforms: make(map[string]registry.Platform),
+	}
+}
+
+// Run looks up the queued images with maxConcurrentLookups workers until ctx
+// is cancelled.
+func (s *ImageSizes) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for range maxConcurrentLookups {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case l := <-s.queue:
+					s.lookup(ctx, l)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Resolve queues a lookup of the size of an image being pulled, unless it is
+// already known, or queued or failed with the same pull secrets. It does not
+// block; if the queue is full the lookup is dropped.
+func (s *ImageSizes) Resolve(p PullingImage) {
+	l := lookup{PullingImage: p, sizeKey: p.NodeName + ":" + normalizeImageRef(p.Image)}
+	l.attemptKey = l.sizeKey + secretsKey(p)
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire(now)
+	if _, ok := s.sizes[l.sizeKey]; ok {
+		return
+	}
+	if _, ok := s.attempts[l.attemptKey]; ok {
+		return
+	}
+	select {
+	case s.queue <- l:
+		s.attempts[l.attemptKey] = now
+	default:
+		metrics.ManifestLookups.WithLabelValues("dropped").Inc()
+		s.logger.Debug("image size lookup queue full", "node", p.NodeName, "image", p.Image)
+	}
+}
+
+func (s *ImageSizes) lookup(ctx context.Context, l lookup) {
+	ctx, cancel := context.WithTimeout(ctx, lookupTimeout)
+	defer cancel()
+
+	bytes, err := s.registry.ImageSize(ctx, l.Image, s.platform(ctx, l.NodeName), s.credentials(ctx, l.PullingImage))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		metrics.ManifestLookups.WithLabelValues("failed").Inc()
+		s.logger.Warn("image size lookup failed", "node", l.NodeName, "image", l.Image, "error", err)
+		s.attempts[l.attemptKey] = time.Now()
+		return
+	}
+	metrics.ManifestLookups.WithLabelValues("success").Inc()
+	s.logger.Debug("image size resolved", "node", l.NodeName, "image", l.Image, "bytes", bytes)
+	s.sizes[l.sizeKey] = imageSize{bytes: bytes, at: time.Now()}
+	delete(s.attempts, l.attemptKey)
+}
+
+// secretsKey identifies the credentials a lookup for p uses. Pods without
+// pull secrets share anonymous access.
+func secretsKey(p PullingImage) string {
+	if len(p.PullSecrets) == 0 {
+		return ""
+	}
+	secrets := slices.Clone(p.PullSecrets)
+	slices.Sort(secrets)
+	return "|" + p.Namespace + "/" + strings.Join(secrets, ",")
+}
+
+// Size returns the compressed size of imageRef for nodeName's platform, or
+// 0 if it is not known (yet).
+func (s *ImageSizes) Size(nodeName, imageRef string) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sizes[nodeName+":"+normalizeImageRef(imageRef)].bytes
+}
+
+// expire drops sizes looked up and failures recorded more than
+// pullingImageTTL ago, so moved tags and fixed credentials are looked up
+// again. s.mu must be held.
+func (s *ImageSizes) expire(now time.Time) {
+	cutoff := now.Add(-pullingImageTTL)
+	for key, size := range s.sizes {
+		if size.at.Before(cutoff) {
+			delete(s.sizes, key)
+		}
+	}
+	for key, at := range s.attempts {
+		if at.Before(cutoff) {
+			delete(s.attempts, key)
+		}
+	}
+}
+
+// platform returns the OS and architecture of a node. If the node cannot be
+// read, the empty Platform selects the first manifest of an index.
+func (s *ImageSizes) platform(ctx context.Context, nodeName string) registry.Platform {
+	s.mu.Lock()
+	p, ok := s.platforms[nodeName]
+	s.mu.Unlock()
+	if ok {
+		return p
+	}
+
+	node, err := s.client.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		s.logger.Debug("reading node platform", "node", nodeName, "error", err)
+		return registry.Platform{}
+	}
+	p = registry.Platform{OS: node.Status.NodeInfo.OperatingSystem, Architecture: node.Status.NodeInfo.Architecture}
+	s.mu.Lock()
+	s.platforms[nodeName] = p
+	s.mu.Unlock()
+	return p
+}
+
+// credentials reads the pod's image pull secrets. Secrets that cannot be
+// read or parsed are skipped.
+func (s *ImageSizes) credentials(ctx context.Context, p PullingImage) map[string]registry.Credentials {
+	auths := make(map[string]registry.Credentials)
+	for _, name := range p.PullSecrets {
+		secret, err := s.client.CoreV1().Secrets(p.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			s.logger.Debug("reading pull secret", "namespace", p.Namespace, "secret", name, "error", err)
+			continue
+		}
+		var creds map[string]registry.Credentials
+		switch secret.Type {
+		case corev1.SecretTypeDockerConfigJson:
+			creds, err = registry.ParseDockerConfig(secret.Data[corev1.DockerConfigJsonKey], false)
+		case corev1.SecretTypeDockercfg:
+			creds, err = registry.ParseDockerConfig(secret.Data[corev1.DockerConfigKey], true)
+		default:
+			continue
+		}
+		if err != nil {
+			s.logger.Debug("parsing pull secret", "namespace", p.Namespace, "secret", name, "error", err)
+			continue
+		}
+		for host, c := range creds {
+			if _, ok := auths[host]; !ok {
+				auths[host] = c
+			}
+		}
+	}
+	return auths
+}
@@ -0,0 +1,116 @@
+package k8s
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/d44b/pulltrace/internal/registry"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// runQueued performs the queued lookups, if any, as a worker would.
+func runQueued(s *ImageSizes) int {
+	n := 0
+	for len(s.queue) > 0 {
+		s.lookup(context.Background(), <-s.queue)
+		n++
+	}
+	return n
+}
+
+func TestImageSizes_Resolve(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v2/team/app/manifests/{ref}", func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "bot" || pass != "pat" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="stand-in"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		switch r.PathValue("ref") {
+		case "v1":
+			json.NewEncoder(w).Encode(map[string]any{"manifests": []map[string]any{ //nolint:errcheck
+				{"digest": "sha256:amd64", "platform": map[string]string{"os": "linux", "architecture": "amd64"}},
+				{"digest": "sha256:arm64", "platform": map[string]string{"os": "linux", "architecture": "arm64"}},
+			}})
+		case "sha256:arm64":
+			json.NewEncoder(w).Encode(map[string]any{"layers": []map[string]any{{"size": 700}, {"size": 77}}}) //nolint:errcheck
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	srv := httptest.NewTLSServer(mux)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	arm64 := corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{OperatingSystem: "linux", Architecture: "arm64"}}
+	client := fake.NewSimpleClientset(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}, Status: arm64},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}, Status: arm64},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "regcred"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data: map[string][]byte{
+				corev1.DockerConfigJsonKey: []byte(`{"auths": {"` + host + `": {"username": "bot", "password": "pat"}}}`),
+			},
+		},
+	)
+	sizes := NewImageSizes(client, &registry.Client{HTTPClient: srv.Client()}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	image := host + "/team/app:v1"
+
+	withSecret := func(node, pod string) PullingImage {
+		return PullingImage{NodeName: node, Image: image, Namespace: "default", PodName: pod, PullSecrets: []string{"regcred"}}
+	}
+	sizes.Resolve(withSecret("node1", "web"))
+	runQueued(sizes)
+	if got := sizes.Size("node1", image); got != 777 {
+		t.Errorf("Size = %d, want 777 for linux/arm64", got)
+	}
+	sizes.Resolve(withSecret("node1", "web-2"))
+	if n := runQueued(sizes); n != 0 {
+		t.Errorf("%d lookups of a known size", n)
+	}
+
+	// Without the pull secret the lookup fails and is not repeated for other
+	// pods without it.
+	sizes.Resolve(PullingImage{NodeName: "node2", Image: image, Namespace: "default", PodName: "web"})
+	runQueued(sizes)
+	if got := sizes.Size("node2", image); got != 0 {
+		t.Errorf("size without credentials = %d, want 0", got)
+	}
+	sizes.Resolve(PullingImage{NodeName: "node2", Image: image, Namespace: "default", PodName: "other"})
+	if n := runQueued(sizes); n != 0 {
+		t.Errorf("failed lookup repeated %d times with the same secrets", n)
+	}
+
+	// A pod with the secret is not held back by the failure.
+	sizes.Resolve(withSecret("node2", "web-3"))
+	runQueued(sizes)
+	if got := sizes.Size("node2", image); got != 777 {
+		t.Errorf("Size with the secret after a failure = %d, want 777", got)
+	}
+}
+
+func TestImageSizes_QueueFull(t *testing.T) {
+	sizes := NewImageSizes(fake.NewSimpleClientset(), &registry.Client{}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	for i := range lookupQueueSize + 1 {
+		sizes.Resolve(PullingImage{NodeName: "node1", Image: fmt.Sprintf("team/app:%d", i)})
+	}
+	if len(sizes.queue) != lookupQueueSize {
+		t.Errorf("queued %d lookups, want %d", len(sizes.queue), lookupQueueSize)
+	}
+	// The dropped lookup is tried again on the next Pulling event.
+	dropped := fmt.Sprintf("node1:%s", normalizeImageRef(fmt.Sprintf("team/app:%d", lookupQueueSize)))
+	if _, ok := sizes.attempts[dropped]; ok {
+		t.Error("dropped lookup recorded as attempted")
+	}
+}
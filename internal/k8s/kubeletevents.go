@@ -0,0 +1,223 @@
+package k8s
+
+import (
+	"strings"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+)
+
+// kubeletEvent is a kubelet event about a pod, read from either core/v1 or
+// events.k8s.io/v1. The kubelet folds repeats of an event into one object
+// whose count and last time it updates, as a series or, for older
+// recorders, as a deprecated count.
+type kubeletEvent struct {
+	uid     string
+	reason  string
+	message string
+	node    string
+	pod     corev1.ObjectReference
+	// first and last are when the event was first and last seen, and count
+	// how many times.
+	first, last time.Time
+	count       int32
+}
+
+func fromCoreEvent(ev *corev1.Event) kubeletEvent {
+	ke := kubeletEvent{
+		uid:     string(ev.UID),
+		reason:  ev.Reason,
+		message: ev.Message,
+		node:    ev.Source.Host,
+		pod:     ev.InvolvedObject,
+		first:   ev.FirstTimestamp.Time,
+		last:    ev.LastTimestamp.Time,
+		count:   ev.Count,
+	}
+	if ke.node == "" {
+		ke.node = ev.ReportingInstance
+	}
+	if ev.Series != nil {
+		ke.count = ev.Series.Count
+		ke.last = ev.Series.LastObservedTime.Time
+	}
+	ke.fillTimes(ev.EventTime.Time)
+	return ke
+}
+
+func fromEventsV1(ev *eventsv1.Event) kubeletEvent {
+	ke := kubeletEvent{
+		uid:     string(ev.UID),
+		reason:  ev.Reason,
+		message: ev.Note,
+		node:    ev.DeprecatedSource.Host,
+		pod:     ev.Regarding,
+		first:   ev.DeprecatedFirstTimestamp.Time,
+		last:    ev.DeprecatedLastTimestamp.Time,
+		count:   ev.DeprecatedCount,
+	}
+	if ke.node == "" {
+		ke.node = ev.ReportingInstance
+	}
+	if ev.Series != nil {
+		ke.count = ev.Series.Count
+		ke.last = ev.Series.LastObservedTime.Time
+	}
+	ke.fillTimes(ev.EventTime.Time)
+	return ke
+}
+
+// fillTimes falls back to the event time for missing first and last times.
+func (ke *kubeletEvent) fillTimes(eventTime time.Time) {
+	if ke.first.IsZero() {
+		ke.first = eventTime
+	}
+	if ke.last.IsZero() {
+		ke.last = eventTime
+	}
+	if ke.first.IsZero() {
+		ke.first = ke.last
+	}
+	if ke.count < 1 {
+		ke.count = 1
+	}
+}
+
+// pullRetries counts a pod's kubelet attempts to pull one image and the
+// back-offs between them.
+type pullRetries struct {
+	// attempts and backOffs map the UID of each Pulling or BackOff event to
+	// its count, since the kubelet may start a new event object rather than
+	// extend a series.
+	attempts, backOffs map[string]int32
+	lastAttempt        time.Time
+	// interval is the time between the last two attempts.
+	interval time.Duration
+	touched  time.Time
+}
+
+func sumCounts(counts map[string]int32) int {
+	n := 0
+	for _, c := range counts {
+		n += int(c)
+	}
+	return n
+}
+
+// retriesFor returns the retries of a pod's pulls of image. pw.mu must be
+// held.
+func (pw *PodWatcher) retriesFor(pod corev1.ObjectReference, image string) *pullRetries {
+	key := pod.Namespace + "/" + pod.Name + ":" + normalizeImageRef(image)
+	r, ok := pw.retries[key]
+	if !ok {
+		r = &pullRetries{attempts: make(map[string]int32), backOffs: make(map[string]int32)}
+		pw.retries[key] = r
+	}
+	r.touched = time.Now()
+	return r
+}
+
+// recordAttempt counts a kubelet Pulling event, or a new occurrence of one,
+// as an attempt to pull image.
+func (pw *PodWatcher) recordAttempt(ke kubeletEvent, image string) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	r := pw.retriesFor(ke.pod, image)
+	before := sumCounts(r.attempts)
+	if ke.count <= r.attempts[ke.uid] {
+		return
+	}
+	r.attempts[ke.uid] = ke.count
+	added := sumCounts(r.attempts) - before
+
+	switch {
+	case before > 0 && ke.last.After(r.lastAttempt):
+		r.interval = ke.last.Sub(r.lastAttempt) / time.Duration(added)
+	case before == 0 && ke.count > 1 && ke.last.After(ke.first):
+		// First seen as a series already under way: average its intervals.
+		r.interval = ke.last.Sub(ke.first) / time.Duration(ke.count-1)
+	}
+	if ke.last.After(r.lastAttempt) {
+		r.lastAttempt = ke.last
+	}
+}
+
+// recordBackOff counts a kubelet BackOff event for a pull of image.
+func (pw *PodWatcher) recordBackOff(ke kubeletEvent, image string) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	r := pw.retriesFor(ke.pod, image)
+	if ke.count > r.backOffs[ke.uid] {
+		r.backOffs[ke.uid] = ke.count
+	}
+}
+
+// PullRetries returns how often the kubelet tried to pull imageRef for the
+// given pods, from the pod that retried most. It returns nil if none of them
+// retried.
+func (pw *PodWatcher) PullRetries(imageRef string, pods []model.PodCorrelation) *model.PullRetries {
+	pw.mu.RLock()
+	defer pw.mu.RUnlock()
+	var most *model.PullRetries
+	for _, pc := range pods {
+		r, ok := pw.retries[pc.Namespace+"/"+pc.PodName+":"+normalizeImageRef(imageRef)]
+		if !ok {
+			continue
+		}
+		attempts, backOffs := sumCounts(r.attempts), sumCounts(r.backOffs)
+		if attempts <= 1 && backOffs == 0 {
+			continue
+		}
+		if most != nil && attempts <= most.Attempts {
+			continue
+		}
+		most = &model.PullRetries{
+			Attempts:       attempts,
+			BackOffs:       backOffs,
+			LastAttemptAt:  r.lastAttempt,
+			BackoffSeconds: r.interval.Seconds(),
+		}
+	}
+	return most
+}
+
+// removeRetries forgets the pull attempts of a deleted pod. pw.mu must be
+// held.
+func (pw *PodWatcher) removeRetries(namespace, name string) {
+	prefix := namespace + "/" + name + ":"
+	for key := range pw.retries {
+		if strings.HasPrefix(key, prefix) {
+			delete(pw.retries, key)
+		}
+	}
+}
+
+// expireRetries drops the pull attempts of pods not heard of since cutoff.
+// pw.mu must be held.
+func (pw *PodWatcher) expireRetries(cutoff time.Time) {
+	for key, r := range pw.retries {
+		if r.touched.Before(cutoff) {
+			delete(pw.retries, key)
+		}
+	}
+}
+
+// parseImageFromBackOffMessage extracts the image from a kubelet BackOff
+// event about a pull.
+// Message format: Back-off pulling image "nginx:bad"
+// BackOff events about restarting containers carry no image.
+func parseImageFromBackOffMessage(msg string) string {
+	const prefix = "Back-off pulling image \""
+	if !strings.HasPrefix(msg, prefix) {
+		return ""
+	}
+	rest := msg[len(prefix):]
+	end := strings.Index(rest, "\"")
+	if end == -1 {
+		return ""
+	}
+	return rest[:end]
+}
@@ -0,0 +1,138 @@
+package k8s
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+
+	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestKubeletEventConversion(t *testing.T) {
+	first := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	last := first.Add(time.Minute)
+	pod := corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web-0"}
+
+	core := fromCoreEvent(&corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{UID: "c1"},
+		InvolvedObject: pod,
+		Reason:         "Pulling",
+		Message:        `Pulling image "nginx:1.27"`,
+		Source:         corev1.EventSource{Host: "node1"},
+		FirstTimestamp: metav1.NewTime(first),
+		LastTimestamp:  metav1.NewTime(last),
+		Count:          4,
+	})
+	if core.uid != "c1" || core.node != "node1" || core.count != 4 || !core.first.Equal(first) || !core.last.Equal(last) {
+		t.Errorf("core/v1 event = %+v", core)
+	}
+
+	v1 := fromEventsV1(&eventsv1.Event{
+		ObjectMeta:        metav1.ObjectMeta{UID: "e1"},
+		Regarding:         pod,
+		Reason:            "Pulling",
+		Note:              `Pulling image "nginx:1.27"`,
+		ReportingInstance: "node1",
+		EventTime:         metav1.NewMicroTime(first),
+		Series:            &eventsv1.EventSeries{Count: 3, LastObservedTime: metav1.NewMicroTime(last)},
+	})
+	if v1.uid != "e1" || v1.node != "node1" || v1.count != 3 || !v1.first.Equal(first) || !v1.last.Equal(last) || v1.message != `Pulling image "nginx:1.27"` {
+		t.Errorf("events.k8s.io/v1 event = %+v", v1)
+	}
+
+	single := fromEventsV1(&eventsv1.Event{Regarding: pod, EventTime: metav1.NewMicroTime(first)})
+	if single.count != 1 || !single.last.Equal(first) {
+		t.Errorf("event without a series = %+v", single)
+	}
+}
+
+func TestPullRetries(t *testing.T) {
+	pw := NewPodWatcherForClient(fake.NewSimpleClientset(), nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	pod := corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web-0"}
+	event := func(uid, reason string, count int32, sec int) kubeletEvent {
+		return kubeletEvent{uid: uid, reason: reason, pod: pod, node: "node1",
+			first: start, last: start.Add(time.Duration(sec) * time.Second), count: count}
+	}
+	pods := []model.PodCorrelation{{Namespace: "default", PodName: "other"}, {Namespace: "default", PodName: "web-0"}}
+
+	pw.recordAttempt(event("e1", "Pulling", 1, 0), "nginx:bad")
+	if got := pw.PullRetries("nginx:bad", pods); got != nil {
+		t.Errorf("a single attempt reported as %+v", got)
+	}
+
+	// The kubelet extends the series of the Pulling event, backing off in
+	// between.
+	pw.recordAttempt(event("e1", "Pulling", 2, 10), "nginx:bad")
+	pw.recordAttempt(event("e1", "Pulling", 2, 10), "nginx:bad") // resync
+	pw.recordAttempt(event("e1", "Pulling", 3, 30), "nginx:bad")
+	pw.recordBackOff(event("b1", "BackOff", 2, 25), "nginx:bad")
+	// A new Pulling event object, after the series was dropped.
+	pw.recordAttempt(event("e2", "Pulling", 1, 70), "docker.io/library/nginx:bad")
+
+	got := pw.PullRetries("nginx:bad", pods)
+	want := &model.PullRetries{Attempts: 4, BackOffs: 2, LastAttemptAt: start.Add(70 * time.Second), BackoffSeconds: 40}
+	if got == nil || *got != *want {
+		t.Errorf("PullRetries = %+v, want %+v", got, want)
+	}
+	if got := pw.PullRetries("nginx:1.27", pods); got != nil {
+		t.Errorf("another image reported %+v", got)
+	}
+
+	pw.mu.Lock()
+	pw.removeRetries("default", "web-0")
+	pw.mu.Unlock()
+	if got := pw.PullRetries("nginx:bad", pods); got != nil {
+		t.Errorf("retries kept after the pod was deleted: %+v", got)
+	}
+}
+
+func TestPodWatcher_EventsV1Series(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	client.Resources = []*metav1.APIResourceList{{
+		GroupVersion: eventsv1.SchemeGroupVersion.String(),
+		APIResources: []metav1.APIResource{{Name: "events", Namespaced: true, Kind: "Event"}},
+	}}
+	pw := NewPodWatcherForClient(client, nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if !pw.eventsV1Available() {
+		t.Fatal("events.k8s.io/v1 not detected")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go pw.Run(ctx) //nolint:errcheck
+	eventually(t, "caches to sync", pw.HasSynced)
+
+	start := time.Now().Add(-time.Minute)
+	ev := &eventsv1.Event{
+		ObjectMeta:        metav1.ObjectMeta{Namespace: "default", Name: "web-0.pulling", UID: types.UID("e1")},
+		Regarding:         corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web-0"},
+		Reason:            "Pulling",
+		Note:              `Pulling image "nginx:bad"`,
+		ReportingInstance: "node1",
+		EventTime:         metav1.NewMicroTime(start),
+	}
+	events := client.EventsV1().Events("default")
+	if _, err := events.Create(ctx, ev, metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the Pulling event", func() bool { return len(pw.GetPullingImagesForNode("node1")) == 1 })
+
+	ev.Series = &eventsv1.EventSeries{Count: 3, LastObservedTime: metav1.NewMicroTime(start.Add(30 * time.Second))}
+	if _, err := events.Update(ctx, ev, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	pods := []model.PodCorrelation{{Namespace: "default", PodName: "web-0"}}
+	eventually(t, "the series update", func() bool {
+		r := pw.PullRetries("nginx:bad", pods)
+		return r != nil && r.Attempts == 3 && r.BackoffSeconds == 15
+	})
+}
@@ -4,23 +4,41 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/d44b/pulltrace/internal/model"
+	"github.com/d44b/pulltrace/internal/registry"
 
 	corev1 "k8s.io/api/core/v1"
+	eventsv1 "k8s.io/api/events/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
 )
 
 const pullingImageTTL = 10 * time.Minute
 
+type pullFailure struct {
+	message string
+	at      time.Time
+}
+
+// resolvedOwner caches the workload a ReplicaSet or Job belongs to.
+type resolvedOwner struct {
+	workload model.WorkloadRef
+	at       time.Time
+}
+
 // PodWatcher watches pods and kubelet events to correlate image pulls with pods.
 type PodWatcher struct {
+	config     *rest.Config
 	client     kubernetes.Interface
 	namespaces []string
 	mu         sync.RWMutex
@@ -29,214 +47,475 @@ type PodWatcher struct {
 	// pullingByNode tracks images currently being pulled per node,
 	// based on kubelet "Pulling" events. Values are insertion timestamps for TTL.
 	pullingByNode map[string]map[string]time.Time
-	logger        *slog.Logger
-	stopCh        chan struct{}
+	// failedByNode maps node -> normalized image -> the latest kubelet
+	// "Failed to pull image" event, cleared when the image is pulled again.
+	failedByNode map[string]map[string]pullFailure
+	// podNodes maps "namespace/name" -> node for every scheduled pod.
+	podNodes map[string]string
+	// owners maps "namespace/Kind/name" of a ReplicaSet or Job to the
+	// workload that controls it.
+	owners map[string]resolvedOwner
+	// times maps "namespace/name" -> startup timeline of pods that waited
+	// on an image.
+	times   map[string]*podTimes
+	onReady func(model.PodTimeline)
+	// pullSecrets maps "namespace/name" -> imagePullSecrets of scheduled
+	// pods, for onPulling.
+	pullSecrets map[string][]string
+	onPulling   func(PullingImage)
+	onPulled    func(PulledImage)
+	// retries maps "namespace/name:normalizedImage" -> the kubelet's
+	// attempts to pull the image for the pod.
+	retries map[string]*pullRetries
+	// digests maps "node:normalizedImage" -> the manifest digest the image
+	// resolved to on the node, from the imageIDs of started containers.
+	digests map[string]string
+	logger  *slog.Logger
+	stopCh  chan struct{}
+	// synced is set once the informer caches have been filled.
+	synced atomic.Bool
 }
 
-func NewPodWatcher(namespaces []string, logger *slog.Logger) (*PodWatcher, error) {
-	config, err := rest.InClusterConfig()
+// NewPodWatcher returns a PodWatcher for the cluster kube points at.
+func NewPodWatcher(kube ClientConfig, namespaces []string, logger *slog.Logger) (*PodWatcher, error) {
+	clientset, config, err := kube.NewClient()
 	if err != nil {
-		return nil, fmt.Errorf("creating in-cluster config: %w", err)
+		return nil, err
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		return nil, fmt.Errorf("creating kubernetes client: %w", err)
-	}
+	pw := NewPodWatcherForClient(clientset, namespaces, logger)
+	pw.config = config
+	return pw, nil
+}
 
+// NewPodWatcherForClient returns a PodWatcher that uses client, such as a
+// fake clientset. Its RESTConfig is nil.
+func NewPodWatcherForClient(client kubernetes.Interface, namespaces []string, logger *slog.Logger) *PodWatcher {
 	return &PodWatcher{
-		client:        clientset,
+		client:        client,
 		namespaces:    namespaces,
 		podsByImage:   make(map[string][]model.PodCorrelation),
 		pullingByNode: make(map[string]map[string]time.Time),
+		failedByNode:  make(map[string]map[string]pullFailure),
+		podNodes:      make(map[string]string),
+		owners:        make(map[string]resolvedOwner),
+		times:         make(map[string]*podTimes),
+		pullSecrets:   make(map[string][]string),
+		retries:       make(map[string]*pullRetries),
+		digests:       make(map[string]string),
 		logger:        logger,
 		stopCh:        make(chan struct{}),
-	}, nil
+	}
 }
 
+// Run watches pods, kubelet events and nodes through shared informers until
+// ctx is done or Stop is called. The informers list once and then resume
+// their watches from the last resourceVersion they saw, relisting only when
+// it has expired, so no change is replayed or missed across reconnects.
 func (pw *PodWatcher) Run(ctx context.Context) error {
 	pw.logger.Info("starting pod watcher", "namespaces", pw.namespaces)
 
-	ns := ""
-	if len(pw.namespaces) == 1 {
-		ns = pw.namespaces[0]
-	}
-
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-pw.stopCh:
-				return
-			default:
-			}
-			if err := pw.watchEvents(ctx, ns); err != nil {
-				pw.logger.Error("event watch error, retrying", "error", err)
-				time.Sleep(5 * time.Second)
-			}
+	ctx, cancel := context.WithCancel(ctx)
+	var factories []informers.SharedInformerFactory
+	defer func() {
+		cancel()
+		for _, f := range factories {
+			f.Shutdown()
 		}
 	}()
-
 	go func() {
-		ticker := time.NewTicker(5 * time.Minute)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case <-pw.stopCh:
-				return
-			case <-ticker.C:
-				pw.cleanupStalePulling()
-			}
+		select {
+		case <-pw.stopCh:
+			cancel()
+		case <-ctx.Done():
 		}
 	}()
 
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case <-pw.stopCh:
-			return nil
-		default:
+	namespaces := pw.namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+	eventsV1 := pw.eventsV1Available()
+	pw.logger.Info("watching kubelet events", "eventsV1", eventsV1)
+	var synced []cache.InformerSynced
+	for _, ns := range namespaces {
+		// Only scheduled pods can wait on an image pull.
+		pods := informers.NewSharedInformerFactoryWithOptions(pw.client, 0, informers.WithNamespace(ns),
+			informers.WithTweakListOptions(func(o *metav1.ListOptions) { o.FieldSelector = "spec.nodeName!=" }))
+		factories = append(factories, pods)
+		podInformer := pods.Core().V1().Pods().Informer()
+		if _, err := podInformer.AddEventHandler(pw.podHandler(ctx)); err != nil {
+			return fmt.Errorf("adding pod handler: %w", err)
+		}
+
+		var eventInformer cache.SharedIndexInformer
+		var handler cache.ResourceEventHandler
+		if eventsV1 {
+			events := informers.NewSharedInformerFactoryWithOptions(pw.client, 0, informers.WithNamespace(ns))
+			factories = append(factories, events)
+			eventInformer = events.Events().V1().Events().Informer()
+			handler = eventHandler(pw, fromEventsV1)
+		} else {
+			events := informers.NewSharedInformerFactoryWithOptions(pw.client, 0, informers.WithNamespace(ns),
+				informers.WithTweakListOptions(func(o *metav1.ListOptions) { o.FieldSelector = "involvedObject.kind=Pod" }))
+			factories = append(factories, events)
+			eventInformer = events.Core().V1().Events().Informer()
+			handler = eventHandler(pw, fromCoreEvent)
 		}
-		if err := pw.watchLoop(ctx, ns); err != nil {
-			pw.logger.Error("watch error, retrying", "error", err)
-			time.Sleep(5 * time.Second)
+		if _, err := eventInformer.AddEventHandler(handler); err != nil {
+			return fmt.Errorf("adding event handler: %w", err)
 		}
+		synced = append(synced, podInformer.HasSynced, eventInformer.HasSynced)
 	}
-}
-
-func (pw *PodWatcher) watchLoop(ctx context.Context, namespace string) error {
-	watcher, err := pw.client.CoreV1().Pods(namespace).Watch(ctx, metav1.ListOptions{})
-	if err != nil {
-		return fmt.Errorf("watching pods: %w", err)
+	nodes := informers.NewSharedInformerFactory(pw.client, 0)
+	factories = append(factories, nodes)
+	nodeInformer := nodes.Core().V1().Nodes().Informer()
+	if _, err := nodeInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		DeleteFunc: func(obj any) {
+			if node, ok := tombstoneObject[*corev1.Node](obj); ok {
+				pw.removeNode(node.Name)
+			}
+		},
+	}); err != nil {
+		return fmt.Errorf("adding node handler: %w", err)
 	}
-	defer watcher.Stop()
+	synced = append(synced, nodeInformer.HasSynced)
 
-	pods, err := pw.client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
-	if err != nil {
-		return fmt.Errorf("listing pods: %w", err)
+	for _, f := range factories {
+		f.Start(ctx.Done())
 	}
-	for i := range pods.Items {
-		pw.updatePod(&pods.Items[i])
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return nil
 	}
+	pw.synced.Store(true)
+	pw.logger.Info("pod watcher caches synced")
 
+	ticker := time.NewTicker(5 * time.Minute)
+	defer ticker.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return nil
-		case event, ok := <-watcher.ResultChan():
-			if !ok {
-				return fmt.Errorf("watch channel closed")
+		case <-ticker.C:
+			pw.cleanupStalePulling()
+		}
+	}
+}
+
+// eventsV1Available reports whether the API server serves events.k8s.io/v1,
+// which carries event series. Older servers only serve core/v1 events.
+func (pw *PodWatcher) eventsV1Available() bool {
+	resources, err := pw.client.Discovery().ServerResourcesForGroupVersion(eventsv1.SchemeGroupVersion.String())
+	if err != nil {
+		return false
+	}
+	for _, r := range resources.APIResources {
+		if r.Name == "events" {
+			return true
+		}
+	}
+	return false
+}
+
+// HasSynced reports whether the pod, event and node caches have been filled
+// since Run started.
+func (pw *PodWatcher) HasSynced() bool {
+	return pw.synced.Load()
+}
+
+func (pw *PodWatcher) podHandler(ctx context.Context) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj any) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				pw.updatePod(ctx, pod)
 			}
-			pod, ok := event.Object.(*corev1.Pod)
-			if !ok {
-				continue
+		},
+		UpdateFunc: func(_, obj any) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				pw.updatePod(ctx, pod)
 			}
-			switch event.Type {
-			case watch.Added, watch.Modified:
-				pw.updatePod(pod)
-			case watch.Deleted:
+		},
+		DeleteFunc: func(obj any) {
+			if pod, ok := tombstoneObject[*corev1.Pod](obj); ok {
 				pw.removePod(pod)
 			}
-		}
+		},
 	}
 }
 
-func (pw *PodWatcher) watchEvents(ctx context.Context, namespace string) error {
-	watcher, err := pw.client.CoreV1().Events(namespace).Watch(ctx, metav1.ListOptions{})
-	if err != nil {
-		return fmt.Errorf("watching events: %w", err)
+// eventHandler handles kubelet events, converted by convert from the
+// informer's type. Events listed when the watcher starts describe pulls that
+// may be long over, so they update the pod timelines and retry counts but do
+// not mark images as pulling or failed and are not handed to onPulling or
+// onPulled.
+// Updates are new occurrences of an event in its series.
+func eventHandler[T any](pw *PodWatcher, convert func(T) kubeletEvent) cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj any, isInInitialList bool) {
+			if ev, ok := obj.(T); ok {
+				pw.handleEvent(convert(ev), isInInitialList)
+			}
+		},
+		UpdateFunc: func(_, obj any) {
+			if ev, ok := obj.(T); ok {
+				pw.handleEvent(convert(ev), false)
+			}
+		},
 	}
-	defer watcher.Stop()
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return nil
-		case event, ok := <-watcher.ResultChan():
-			if !ok {
-				return fmt.Errorf("event watch channel closed")
-			}
-			ev, ok := event.Object.(*corev1.Event)
-			if !ok {
-				continue
+// tombstoneObject returns the object of a delete notification, which is a
+// cache.DeletedFinalStateUnknown if the watch missed the deletion.
+func tombstoneObject[T any](obj any) (T, bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	o, ok := obj.(T)
+	return o, ok
+}
+
+func (pw *PodWatcher) handleEvent(ke kubeletEvent, replay bool) {
+	if ke.pod.Kind != "Pod" {
+		return
+	}
+	node := ke.node
+	switch ke.reason {
+	case "Pulling":
+		image := parseImageFromPullingMessage(ke.message)
+		if image != "" && node != "" {
+			if !replay {
+				pw.addPullingImage(node, image)
 			}
-			if ev.InvolvedObject.Kind != "Pod" {
-				continue
+			pw.clearPullFailure(node, image)
+			pw.recordPullEvent(ke)
+			pw.recordAttempt(ke, image)
+			if !replay {
+				pw.pulling(node, image, ke.pod)
 			}
-			switch ev.Reason {
-			case "Pulling":
-				image := parseImageFromPullingMessage(ev.Message)
-				node := ev.Source.Host
-				if image != "" && node != "" {
-					pw.addPullingImage(node, image)
-					pw.logger.Debug("pulling event", "node", node, "image", image)
-				}
-			case "Pulled":
-				image := parseImageFromPulledMessage(ev.Message)
-				node := ev.Source.Host
-				if image != "" && node != "" {
-					pw.removePullingImage(node, image)
-					pw.logger.Debug("pulled event", "node", node, "image", image)
-				}
+			pw.logger.Debug("pulling event", "node", node, "image", image, "count", ke.count)
+		}
+	case "Pulled":
+		image := parseImageFromPulledMessage(ke.message)
+		if image != "" && node != "" {
+			pw.removePullingImage(node, image)
+			pw.clearPullFailure(node, image)
+			pw.recordPullEvent(ke)
+			if !replay {
+				pw.pulled(node, image, ke)
 			}
+			pw.logger.Debug("pulled event", "node", node, "image", image)
+		}
+	case "Failed":
+		image := parseImageFromFailedMessage(ke.message)
+		if image != "" && node != "" && !replay {
+			pw.addPullFailure(node, image, ke.message)
+			pw.logger.Debug("failed pull event", "node", node, "image", image)
+		}
+	case "BackOff":
+		if image := parseImageFromBackOffMessage(ke.message); image != "" {
+			pw.recordBackOff(ke, image)
+			pw.logger.Debug("pull back-off event", "node", node, "image", image, "count", ke.count)
 		}
 	}
 }
 
-func (pw *PodWatcher) updatePod(pod *corev1.Pod) {
+func (pw *PodWatcher) updatePod(ctx context.Context, pod *corev1.Pod) {
 	if len(pw.namespaces) > 0 && !pw.inNamespaces(pod.Namespace) {
 		return
 	}
 
+	// The owner is only looked up for pods that wait on an image, and
+	// before taking the lock because it may call the API server.
+	var workload *model.WorkloadRef
+	if pod.Spec.NodeName != "" && waitingOnImage(pod) {
+		workload = pw.resolveWorkload(ctx, pod)
+	}
+
 	pw.mu.Lock()
-	defer pw.mu.Unlock()
+	ready, isReady := pw.correlatePod(pod, workload)
+	pw.mu.Unlock()
+	if isReady && pw.onReady != nil {
+		pw.onReady(ready)
+	}
+}
 
+// correlatePod records the images a scheduled pod waits on and its startup
+// times. It returns the pod's timeline when the pod has just become Ready.
+// pw.mu must be held.
+func (pw *PodWatcher) correlatePod(pod *corev1.Pod, workload *model.WorkloadRef) (model.PodTimeline, bool) {
 	nodeName := pod.Spec.NodeName
 	if nodeName == "" {
-		return
+		return model.PodTimeline{}, false
+	}
+	pw.podNodes[pod.Namespace+"/"+pod.Name] = nodeName
+	if len(pod.Spec.ImagePullSecrets) > 0 {
+		secrets := make([]string, 0, len(pod.Spec.ImagePullSecrets))
+		for _, ref := range pod.Spec.ImagePullSecrets {
+			secrets = append(secrets, ref.Name)
+		}
+		pw.pullSecrets[pod.Namespace+"/"+pod.Name] = secrets
 	}
 
-	for _, cs := range pod.Status.ContainerStatuses {
-		if cs.State.Waiting != nil && cs.State.Waiting.Reason == "ContainerCreating" {
-			for _, c := range pod.Spec.Containers {
-				if c.Name == cs.Name {
-					key := nodeName + ":" + normalizeImageRef(c.Image)
-					pw.addCorrelation(key, model.PodCorrelation{
-						Namespace: pod.Namespace,
-						PodName:   pod.Name,
-						Container: c.Name,
-						Image:     c.Image,
-					})
-				}
+	correlate := func(kind model.CorrelationKind, name, image string, initOrder int) {
+		pw.addCorrelation(nodeName+":"+normalizeImageRef(image), model.PodCorrelation{
+			Namespace: pod.Namespace,
+			PodName:   pod.Name,
+			PodUID:    string(pod.UID),
+			Container: name,
+			Kind:      kind,
+			Image:     image,
+			InitOrder: initOrder,
+			Workload:  workload,
+		})
+	}
+	for _, c := range pod.Spec.Containers {
+		if waitingForPull(pod.Status.ContainerStatuses, c.Name) {
+			correlate(model.CorrelationContainer, c.Name, c.Image, 0)
+		}
+		pw.recordDigest(nodeName, c.Image, pod.Status.ContainerStatuses, c.Name)
+	}
+	for i, c := range pod.Spec.InitContainers {
+		if waitingForPull(pod.Status.InitContainerStatuses, c.Name) {
+			correlate(model.CorrelationInitContainer, c.Name, c.Image, i+1)
+		}
+		pw.recordDigest(nodeName, c.Image, pod.Status.InitContainerStatuses, c.Name)
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		if waitingForPull(pod.Status.EphemeralContainerStatuses, c.Name) {
+			correlate(model.CorrelationEphemeralContainer, c.Name, c.Image, 0)
+		}
+		pw.recordDigest(nodeName, c.Image, pod.Status.EphemeralContainerStatuses, c.Name)
+	}
+	// Image volumes are pulled while the pod's volumes are mounted, before
+	// its first container starts.
+	if waitingOnStart(pod) {
+		for _, v := range pod.Spec.Volumes {
+			if v.Image != nil && v.Image.Reference != "" {
+				correlate(model.CorrelationImageVolume, v.Name, v.Image.Reference, 0)
 			}
 		}
 	}
+	return pw.updateTimes(pod)
+}
+
+// recordDigest remembers the manifest digest image resolved to on the node,
+// from the imageID of the named container once it has started. Runtimes
+// report the digest as "name@sha256:..."; an imageID without one is the
+// local image ID and says nothing about the registry. pw.mu must be held.
+func (pw *PodWatcher) recordDigest(nodeName, image string, statuses []corev1.ContainerStatus, name string) {
+	for _, cs := range statuses {
+		if cs.Name != name {
+			continue
+		}
+		if _, digest, ok := strings.Cut(cs.ImageID, "@"); ok && strings.HasPrefix(digest, "sha256:") {
+			pw.digests[nodeName+":"+normalizeImageRef(image)] = digest
+		}
+		return
+	}
+}
+
+// pullWaitingReasons are the reasons a container waits with while its image
+// may be pulled: before it is created, while init containers run first, and
+// while the kubelet retries a failed pull.
+var pullWaitingReasons = map[string]bool{
+	"ContainerCreating": true,
+	"PodInitializing":   true,
+	"ErrImagePull":      true,
+	"ImagePullBackOff":  true,
+}
+
+// waitingForPull reports whether the named container waits for a reason
+// that may involve pulling its image.
+func waitingForPull(statuses []corev1.ContainerStatus, name string) bool {
+	for _, cs := range statuses {
+		if cs.Name == name {
+			return cs.State.Waiting != nil && pullWaitingReasons[cs.State.Waiting.Reason]
+		}
+	}
+	return false
+}
 
-	for _, cs := range pod.Status.InitContainerStatuses {
-		if cs.State.Waiting != nil && cs.State.Waiting.Reason == "ContainerCreating" {
-			for _, c := range pod.Spec.InitContainers {
-				if c.Name == cs.Name {
-					key := nodeName + ":" + normalizeImageRef(c.Image)
-					pw.addCorrelation(key, model.PodCorrelation{
-						Namespace: pod.Namespace,
-						PodName:   pod.Name,
-						Container: c.Name,
-						Image:     c.Image,
-					})
-				}
+// waitingOnStart reports whether none of the pod's init or app containers
+// has been created yet, so its image volumes may still be pulling.
+func waitingOnStart(pod *corev1.Pod) bool {
+	statuses := append(slices.Clone(pod.Status.InitContainerStatuses), pod.Status.ContainerStatuses...)
+	if len(statuses) == 0 {
+		return false
+	}
+	for _, cs := range statuses {
+		if cs.State.Waiting == nil || !pullWaitingReasons[cs.State.Waiting.Reason] {
+			return false
+		}
+	}
+	return true
+}
+
+// waitingOnImage reports whether any of the pod's containers may be waiting
+// for its image to be pulled.
+func waitingOnImage(pod *corev1.Pod) bool {
+	for _, statuses := range [][]corev1.ContainerStatus{pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses, pod.Status.EphemeralContainerStatuses} {
+		for _, cs := range statuses {
+			if cs.State.Waiting != nil && pullWaitingReasons[cs.State.Waiting.Reason] {
+				return true
 			}
 		}
 	}
+	return false
+}
+
+// resolveWorkload follows the pod's controller ownerReferences up to its
+// Deployment, StatefulSet, DaemonSet, Job or CronJob. A ReplicaSet or Job
+// that has no controller, or cannot be read, is itself the workload. It
+// returns nil for pods no controller owns.
+func (pw *PodWatcher) resolveWorkload(ctx context.Context, pod *corev1.Pod) *model.WorkloadRef {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return nil
+	}
+	workload := model.WorkloadRef{Kind: ref.Kind, Name: ref.Name}
+	if ref.Kind != "ReplicaSet" && ref.Kind != "Job" {
+		return &workload
+	}
+
+	key := pod.Namespace + "/" + ref.Kind + "/" + ref.Name
+	pw.mu.RLock()
+	cached, ok := pw.owners[key]
+	pw.mu.RUnlock()
+	if ok {
+		return &cached.workload
+	}
+
+	var owner metav1.Object
+	var err error
+	if ref.Kind == "ReplicaSet" {
+		owner, err = pw.client.AppsV1().ReplicaSets(pod.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	} else {
+		owner, err = pw.client.BatchV1().Jobs(pod.Namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	}
+	if err != nil {
+		// Not cached, so the lookup is retried on the pod's next update.
+		pw.logger.Debug("resolving workload owner", "kind", ref.Kind, "namespace", pod.Namespace, "name", ref.Name, "error", err)
+		return &workload
+	}
+	if top := metav1.GetControllerOf(owner); top != nil {
+		workload = model.WorkloadRef{Kind: top.Kind, Name: top.Name}
+	}
+
+	pw.mu.Lock()
+	pw.owners[key] = resolvedOwner{workload: workload, at: time.Now()}
+	pw.mu.Unlock()
+	return &workload
 }
 
 func (pw *PodWatcher) removePod(pod *corev1.Pod) {
 	pw.mu.Lock()
 	defer pw.mu.Unlock()
 
+	delete(pw.podNodes, pod.Namespace+"/"+pod.Name)
+	delete(pw.times, pod.Namespace+"/"+pod.Name)
+	delete(pw.pullSecrets, pod.Namespace+"/"+pod.Name)
+	pw.removeRetries(pod.Namespace, pod.Name)
+
 	for key, corrs := range pw.podsByImage {
 		var filtered []model.PodCorrelation
 		for _, c := range corrs {
@@ -252,16 +531,131 @@ func (pw *PodWatcher) removePod(pod *corev1.Pod) {
 	}
 }
 
+// removeNode forgets the pulls in flight and the pull failures of a deleted
+// node.
+func (pw *PodWatcher) removeNode(nodeName string) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	delete(pw.pullingByNode, nodeName)
+	delete(pw.failedByNode, nodeName)
+	for key := range pw.digests {
+		if strings.HasPrefix(key, nodeName+":") {
+			delete(pw.digests, key)
+		}
+	}
+}
+
 func (pw *PodWatcher) addCorrelation(key string, corr model.PodCorrelation) {
 	existing := pw.podsByImage[key]
 	for _, e := range existing {
-		if e.Namespace == corr.Namespace && e.PodName == corr.PodName && e.Container == corr.Container {
+		if e.Namespace == corr.Namespace && e.PodName == corr.PodName && e.Container == corr.Container && e.Kind == corr.Kind {
 			return
 		}
 	}
 	pw.podsByImage[key] = append(existing, corr)
 }
 
+// OnPulling sets a function called for every kubelet Pulling event. It must
+// be set before Run and must not block.
+func (pw *PodWatcher) OnPulling(fn func(PullingImage)) {
+	pw.onPulling = fn
+}
+
+// pulling hands a kubelet Pulling event for pod to onPulling, with the pod's
+// pull secrets if the pod is known.
+func (pw *PodWatcher) pulling(nodeName, image string, pod corev1.ObjectReference) {
+	if pw.onPulling == nil {
+		return
+	}
+	pw.mu.RLock()
+	secrets := pw.pullSecrets[pod.Namespace+"/"+pod.Name]
+	pw.mu.RUnlock()
+	pw.onPulling(PullingImage{
+		NodeName:    nodeName,
+		Image:       image,
+		Namespace:   pod.Namespace,
+		PodName:     pod.Name,
+		PullSecrets: secrets,
+	})
+}
+
+// PulledImage is the kubelet's account of a completed pull, from its Pulled
+// event.
+type PulledImage struct {
+	NodeName  string
+	Image     string
+	Namespace string
+	PodName   string
+	PodUID    string
+	// Container and Kind are the container the event is about, from its
+	// field path. They are empty if the event names no container.
+	Container string
+	Kind      model.CorrelationKind
+	// At is when the kubelet reported the pull.
+	At time.Time
+	// Duration is the time the kubelet spent pulling and Waiting the time
+	// the pull queued behind others before that. SizeBytes is the image size
+	// the runtime reported. Older kubelets report only Duration.
+	Duration  time.Duration
+	Waiting   time.Duration
+	SizeBytes int64
+}
+
+// OnPulled sets a function called for every kubelet event reporting that an
+// image was pulled. It must be set before Run and must not block.
+func (pw *PodWatcher) OnPulled(fn func(PulledImage)) {
+	pw.onPulled = fn
+}
+
+// pulled hands a kubelet Pulled event to onPulled. Events for images that
+// were already present carry no pull and are skipped.
+func (pw *PodWatcher) pulled(nodeName, image string, ke kubeletEvent) {
+	if pw.onPulled == nil {
+		return
+	}
+	duration, waiting, size, ok := parsePulledMetadata(ke.message)
+	if !ok {
+		return
+	}
+	at := ke.last
+	if at.IsZero() {
+		at = time.Now()
+	}
+	container, kind := containerFromFieldPath(ke.pod.FieldPath)
+	pw.onPulled(PulledImage{
+		NodeName:  nodeName,
+		Image:     image,
+		Namespace: ke.pod.Namespace,
+		PodName:   ke.pod.Name,
+		PodUID:    string(ke.pod.UID),
+		Container: container,
+		Kind:      kind,
+		At:        at,
+		Duration:  duration,
+		Waiting:   waiting,
+		SizeBytes: size,
+	})
+}
+
+// containerFromFieldPath returns the container a kubelet event's field path
+// refers to, such as spec.initContainers{migrate}, and its kind.
+func containerFromFieldPath(fieldPath string) (string, model.CorrelationKind) {
+	field, rest, ok := strings.Cut(fieldPath, "{")
+	name, found := strings.CutSuffix(rest, "}")
+	if !ok || !found {
+		return "", ""
+	}
+	switch field {
+	case "spec.containers":
+		return name, model.CorrelationContainer
+	case "spec.initContainers":
+		return name, model.CorrelationInitContainer
+	case "spec.ephemeralContainers":
+		return name, model.CorrelationEphemeralContainer
+	}
+	return "", ""
+}
+
 func (pw *PodWatcher) addPullingImage(nodeName, image string) {
 	pw.mu.Lock()
 	defer pw.mu.Unlock()
@@ -282,9 +676,32 @@ func (pw *PodWatcher) removePullingImage(nodeName, image string) {
 	}
 }
 
+func (pw *PodWatcher) addPullFailure(nodeName, image, message string) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if pw.failedByNode[nodeName] == nil {
+		pw.failedByNode[nodeName] = make(map[string]pullFailure)
+	}
+	pw.failedByNode[nodeName][normalizeImageRef(image)] = pullFailure{message: message, at: time.Now()}
+}
+
+func (pw *PodWatcher) clearPullFailure(nodeName, image string) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if images, ok := pw.failedByNode[nodeName]; ok {
+		delete(images, normalizeImageRef(image))
+		if len(images) == 0 {
+			delete(pw.failedByNode, nodeName)
+		}
+	}
+}
+
 // cleanupStalePulling removes entries from pullingByNode that have not received
 // a "Pulled" event within pullingImageTTL. This prevents unbounded growth when
-// kubelet events are missed (e.g., due to watcher restarts).
+// kubelet events are missed (e.g., due to watcher restarts). Pull failures
+// and resolved workload owners expire after the same TTL, as do the startup
+// timelines of pods that became Ready and the pull attempts of pods no
+// longer retrying.
 func (pw *PodWatcher) cleanupStalePulling() {
 	pw.mu.Lock()
 	defer pw.mu.Unlock()
@@ -299,6 +716,23 @@ func (pw *PodWatcher) cleanupStalePulling() {
 			delete(pw.pullingByNode, node)
 		}
 	}
+	for node, images := range pw.failedByNode {
+		for img, f := range images {
+			if f.at.Before(cutoff) {
+				delete(images, img)
+			}
+		}
+		if len(images) == 0 {
+			delete(pw.failedByNode, node)
+		}
+	}
+	for key, o := range pw.owners {
+		if o.at.Before(cutoff) {
+			delete(pw.owners, key)
+		}
+	}
+	pw.expireTimes(cutoff)
+	pw.expireRetries(cutoff)
 }
 
 func (pw *PodWatcher) inNamespaces(ns string) bool {
@@ -316,6 +750,53 @@ func (pw *PodWatcher) GetPodsForImage(nodeName, imageRef string) []model.PodCorr
 	return pw.podsByImage[nodeName+":"+normalizeImageRef(imageRef)]
 }
 
+// GetPodsForPull returns the pods on nodeName waiting on imageRef and, if
+// digest is set, those waiting on other references to the same image: ones
+// pinned to digest, or tags that resolved to it on the node.
+func (pw *PodWatcher) GetPodsForPull(nodeName, imageRef, digest string) []model.PodCorrelation {
+	pw.mu.RLock()
+	defer pw.mu.RUnlock()
+	own := nodeName + ":" + normalizeImageRef(imageRef)
+	pods := pw.podsByImage[own]
+	if digest == "" {
+		return pods
+	}
+	// Copy so appending does not share the map's slice.
+	pods = slices.Clone(pods)
+	prefix := nodeName + ":"
+	for key, corrs := range pw.podsByImage {
+		if key == own || !strings.HasPrefix(key, prefix) || pw.resolvedDigestLocked(key) != digest {
+			continue
+		}
+		for _, c := range corrs {
+			if !slices.ContainsFunc(pods, func(p model.PodCorrelation) bool {
+				return p.Namespace == c.Namespace && p.PodName == c.PodName && p.Container == c.Container && p.Kind == c.Kind
+			}) {
+				pods = append(pods, c)
+			}
+		}
+	}
+	return pods
+}
+
+// resolvedDigestLocked returns the manifest digest the image of a
+// "node:normalizedImage" key names or, for a tag, last resolved to on the
+// node. It returns "" if the digest is not known. pw.mu must be held.
+func (pw *PodWatcher) resolvedDigestLocked(key string) string {
+	if _, digest, ok := strings.Cut(key, "@"); ok {
+		return digest
+	}
+	return pw.digests[key]
+}
+
+// PullFailure returns the message of the last kubelet event reporting that
+// imageRef failed to pull on nodeName, or "" if there is none.
+func (pw *PodWatcher) PullFailure(nodeName, imageRef string) string {
+	pw.mu.RLock()
+	defer pw.mu.RUnlock()
+	return pw.failedByNode[nodeName][normalizeImageRef(imageRef)].message
+}
+
 func (pw *PodWatcher) GetPullingImagesForNode(nodeName string) []string {
 	pw.mu.RLock()
 	defer pw.mu.RUnlock()
@@ -347,26 +828,46 @@ func (pw *PodWatcher) GetWaitingImagesForNode(nodeName string) []string {
 	return images
 }
 
+// Client returns the Kubernetes client used by the watcher.
+func (pw *PodWatcher) Client() kubernetes.Interface {
+	return pw.client
+}
+
+// RESTConfig returns the configuration used to reach the API server, for
+// building additional clients. It is nil for a fake cluster.
+func (pw *PodWatcher) RESTConfig() *rest.Config {
+	return pw.config
+}
+
+// NodesWithPods returns every node that has at least one scheduled pod in the
+// watched namespaces.
+func (pw *PodWatcher) NodesWithPods() []string {
+	pw.mu.RLock()
+	defer pw.mu.RUnlock()
+	seen := make(map[string]bool)
+	var nodes []string
+	for _, node := range pw.podNodes {
+		if !seen[node] {
+			seen[node] = true
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
 func (pw *PodWatcher) Stop() {
 	close(pw.stopCh)
 }
 
+// SameImage reports whether two image references name the same image.
+func SameImage(a, b string) bool {
+	return normalizeImageRef(a) == normalizeImageRef(b)
+}
+
+// normalizeImageRef returns the canonical form of an image reference, which
+// keys the watcher's maps.
 func normalizeImageRef(ref string) string {
-	if !strings.Contains(ref, "/") {
-		ref = "docker.io/library/" + ref
-	} else {
-		// Check whether the first path segment looks like a registry hostname
-		// (contains "." or ":"). Only inspect the first segment to avoid false
-		// matches on version tags like "1.27" in "library/nginx:1.27".
-		firstSeg := ref[:strings.Index(ref, "/")]
-		if !strings.ContainsAny(firstSeg, ".:") {
-			ref = "docker.io/" + ref
-		}
-	}
-	if !strings.Contains(ref, ":") && !strings.Contains(ref, "@") {
-		ref = ref + ":latest"
-	}
-	return ref
+	return registry.Canonical(ref)
 }
 
 // parseImageFromPullingMessage extracts the image from a kubelet Pulling event.
@@ -400,3 +901,52 @@ func parseImageFromPulledMessage(msg string) string {
 	}
 	return rest[:end]
 }
+
+// parsePulledMetadata extracts the pull duration, the time spent waiting
+// and the image size from a kubelet Pulled event. Depending on the kubelet
+// version the message is one of:
+//
+//	Successfully pulled image "nginx:latest" in 5.2s
+//	Successfully pulled image "nginx:latest" in 5.2s (6.1s including waiting)
+//	Successfully pulled image "nginx:latest" in 5.2s (6.1s including waiting). Image size: 123456 bytes.
+//
+// ok is false if the message reports no duration.
+func parsePulledMetadata(msg string) (duration, waiting time.Duration, size int64, ok bool) {
+	_, rest, found := strings.Cut(msg, "\" in ")
+	if !found || parseImageFromPulledMessage(msg) == "" {
+		return 0, 0, 0, false
+	}
+	field, rest, _ := strings.Cut(rest, " ")
+	duration, err := time.ParseDuration(strings.TrimSuffix(field, "."))
+	if err != nil {
+		return 0, 0, 0, false
+	}
+	if i := strings.Index(rest, " including waiting)"); i >= 0 && strings.HasPrefix(rest, "(") {
+		if total, err := time.ParseDuration(rest[1:i]); err == nil && total > duration {
+			waiting = total - duration
+		}
+	}
+	if _, s, found := strings.Cut(rest, "Image size: "); found {
+		s, _, _ = strings.Cut(s, " ")
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil && n > 0 {
+			size = n
+		}
+	}
+	return duration, waiting, size, true
+}
+
+// parseImageFromFailedMessage extracts the image from a kubelet Failed event.
+// Message format: Failed to pull image "nginx:bad": rpc error: ...
+// Other Failed events, such as "Error: ErrImagePull", carry no image.
+func parseImageFromFailedMessage(msg string) string {
+	const prefix = "Failed to pull image \""
+	if !strings.HasPrefix(msg, prefix) {
+		return ""
+	}
+	rest := msg[len(prefix):]
+	end := strings.Index(rest, "\"")
+	if end == -1 {
+		return ""
+	}
+	return rest[:end]
+}
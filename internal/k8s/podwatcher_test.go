@@ -1,10 +1,25 @@
 package k8s
 
 import (
+	"context"
+	"io"
+	"log/slog"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
+// testDigest is a manifest digest for image references in tests.
+const testDigest = "sha256:0d6b3c6e4f7a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4"
+
 func TestNormalizeImageRef(t *testing.T) {
 	cases := []struct {
 		input  string
@@ -17,7 +32,12 @@ func TestNormalizeImageRef(t *testing.T) {
 		{"library/nginx:1.27", "docker.io/library/nginx:1.27"},
 		{"ghcr.io/foo/bar:v1.0", "ghcr.io/foo/bar:v1.0"},
 		{"ghcr.io/foo/bar", "ghcr.io/foo/bar:latest"},
-		{"nginx@sha256:abc", "docker.io/library/nginx@sha256:abc"},
+		{"nginx@" + testDigest, "docker.io/library/nginx@" + testDigest},
+		{"nginx:1.27@" + testDigest, "docker.io/library/nginx@" + testDigest},
+		{"index.docker.io/library/nginx:1.27", "docker.io/library/nginx:1.27"},
+		{"registry.local:5000/app", "registry.local:5000/app:latest"},
+		{"localhost/app:v1", "localhost/app:v1"},
+		{"__pulling__", "__pulling__"},
 	}
 	for _, c := range cases {
 		got := normalizeImageRef(c.input)
@@ -63,6 +83,63 @@ func TestParseImageFromPulledMessage(t *testing.T) {
 	}
 }
 
+func TestParseImageFromFailedMessage(t *testing.T) {
+	cases := []struct {
+		msg    string
+		expect string
+	}{
+		{`Failed to pull image "nginx:bad": rpc error: code = NotFound desc = not found`, "nginx:bad"},
+		{`Failed to pull image "ghcr.io/foo/bar:v1.0": pull access denied`, "ghcr.io/foo/bar:v1.0"},
+		{"Error: ErrImagePull", ""},
+		{`Back-off pulling image "nginx:bad"`, ""},
+	}
+	for _, c := range cases {
+		got := parseImageFromFailedMessage(c.msg)
+		if got != c.expect {
+			t.Errorf("parseImageFromFailedMessage(%q) = %q, want %q", c.msg, got, c.expect)
+		}
+	}
+}
+
+func TestParsePulledMetadata(t *testing.T) {
+	cases := []struct {
+		msg      string
+		duration time.Duration
+		waiting  time.Duration
+		size     int64
+		ok       bool
+	}{
+		{`Successfully pulled image "nginx:latest" in 5.2s`, 5200 * time.Millisecond, 0, 0, true},
+		{`Successfully pulled image "nginx:latest" in 1m2.5s (1m4.5s including waiting)`, 62500 * time.Millisecond, 2 * time.Second, 0, true},
+		{`Successfully pulled image "ghcr.io/foo/bar:v1" in 845ms (3.845s including waiting). Image size: 123456 bytes.`, 845 * time.Millisecond, 3 * time.Second, 123456, true},
+		{`Container image "nginx:latest" already present on machine`, 0, 0, 0, false},
+		{`Successfully pulled image "nginx:latest" in soon`, 0, 0, 0, false},
+	}
+	for _, c := range cases {
+		duration, waiting, size, ok := parsePulledMetadata(c.msg)
+		if duration != c.duration || waiting != c.waiting || size != c.size || ok != c.ok {
+			t.Errorf("parsePulledMetadata(%q) = %v, %v, %d, %v; want %v, %v, %d, %v",
+				c.msg, duration, waiting, size, ok, c.duration, c.waiting, c.size, c.ok)
+		}
+	}
+}
+
+func TestPullFailure(t *testing.T) {
+	pw := &PodWatcher{failedByNode: make(map[string]map[string]pullFailure)}
+	pw.addPullFailure("node1", "nginx:bad", `Failed to pull image "nginx:bad": not found`)
+
+	if got := pw.PullFailure("node1", "docker.io/library/nginx:bad"); got == "" {
+		t.Error("failure not found under the normalized image ref")
+	}
+	if got := pw.PullFailure("node2", "nginx:bad"); got != "" {
+		t.Errorf("failure leaked to another node: %q", got)
+	}
+	pw.clearPullFailure("node1", "nginx:bad")
+	if got := pw.PullFailure("node1", "nginx:bad"); got != "" {
+		t.Errorf("failure kept after a new pull: %q", got)
+	}
+}
+
 func TestInNamespaces(t *testing.T) {
 	pw := &PodWatcher{namespaces: []string{"default", "kube-system"}}
 	if !pw.inNamespaces("default") {
@@ -119,3 +196,367 @@ func TestCleanupStalePulling_RemovesEmptyNode(t *testing.T) {
 		t.Error("node with no remaining images should be removed")
 	}
 }
+
+func TestNodesWithPods(t *testing.T) {
+	pw := &PodWatcher{
+		podsByImage: make(map[string][]model.PodCorrelation),
+		podNodes:    make(map[string]string),
+	}
+	pod := func(ns, name, node string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: ns, Name: name},
+			Spec:       corev1.PodSpec{NodeName: node},
+		}
+	}
+	pw.updatePod(context.Background(), pod("default", "a", "node1"))
+	pw.updatePod(context.Background(), pod("default", "b", "node1"))
+	pw.updatePod(context.Background(), pod("default", "c", "node2"))
+	pw.updatePod(context.Background(), pod("default", "pending", ""))
+	pw.removePod(pod("default", "c", "node2"))
+
+	nodes := pw.NodesWithPods()
+	if len(nodes) != 1 || nodes[0] != "node1" {
+		t.Errorf("NodesWithPods: want [node1], got %v", nodes)
+	}
+}
+
+func TestUpdatePod_InitContainerOrder(t *testing.T) {
+	pw := &PodWatcher{
+		podsByImage: make(map[string][]model.PodCorrelation),
+		podNodes:    make(map[string]string),
+		times:       make(map[string]*podTimes),
+	}
+	creating := corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}
+	pw.updatePod(context.Background(), &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-0"},
+		Spec: corev1.PodSpec{
+			NodeName:       "node1",
+			InitContainers: []corev1.Container{{Name: "migrate", Image: "migrate:1"}, {Name: "seed", Image: "seed:1"}},
+			Containers:     []corev1.Container{{Name: "app", Image: "app:1"}},
+		},
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{{Name: "seed", State: creating}},
+			ContainerStatuses:     []corev1.ContainerStatus{{Name: "app", State: creating}},
+		},
+	})
+
+	if got := pw.GetPodsForImage("node1", "seed:1"); len(got) != 1 || got[0].InitOrder != 2 {
+		t.Errorf("seed correlations = %+v, want InitOrder 2", got)
+	}
+	if got := pw.GetPodsForImage("node1", "app:1"); len(got) != 1 || got[0].InitOrder != 0 {
+		t.Errorf("app correlations = %+v, want InitOrder 0", got)
+	}
+}
+
+func TestUpdatePod_WaitingStatesAndKinds(t *testing.T) {
+	newWatcher := func() *PodWatcher {
+		return &PodWatcher{
+			podsByImage: make(map[string][]model.PodCorrelation),
+			podNodes:    make(map[string]string),
+			times:       make(map[string]*podTimes),
+		}
+	}
+	waiting := func(reason string) corev1.ContainerState {
+		return corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: reason}}
+	}
+	running := corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+	spec := corev1.PodSpec{
+		NodeName:       "node1",
+		InitContainers: []corev1.Container{{Name: "migrate", Image: "migrate:1"}},
+		Containers:     []corev1.Container{{Name: "app", Image: "app:1"}, {Name: "crashing", Image: "crash:1"}},
+		EphemeralContainers: []corev1.EphemeralContainer{
+			{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debugger", Image: "busybox:1"}},
+		},
+		Volumes: []corev1.Volume{
+			{Name: "models", VolumeSource: corev1.VolumeSource{Image: &corev1.ImageVolumeSource{Reference: "models:v2"}}},
+			{Name: "scratch", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		},
+	}
+	kindOf := func(pw *PodWatcher, image string) model.CorrelationKind {
+		got := pw.GetPodsForImage("node1", image)
+		if len(got) != 1 {
+			return ""
+		}
+		return got[0].Kind
+	}
+
+	// Before any container starts every waiting state that may involve a
+	// pull correlates, as do image volumes.
+	pw := newWatcher()
+	pw.updatePod(context.Background(), &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-0"},
+		Spec:       spec,
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{{Name: "migrate", State: waiting("ImagePullBackOff")}},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "app", State: waiting("PodInitializing")},
+				{Name: "crashing", State: waiting("PodInitializing")},
+			},
+		},
+	})
+	for image, want := range map[string]model.CorrelationKind{
+		"migrate:1": model.CorrelationInitContainer,
+		"app:1":     model.CorrelationContainer,
+		"models:v2": model.CorrelationImageVolume,
+	} {
+		if got := kindOf(pw, image); got != want {
+			t.Errorf("%s: kind = %q, want %q", image, got, want)
+		}
+	}
+	if got := pw.GetPodsForImage("node1", "models:v2"); len(got) != 1 || got[0].Container != "models" {
+		t.Errorf("image volume correlations = %+v", got)
+	}
+
+	// Once the pod runs, only containers still waiting on a pull correlate.
+	pw = newWatcher()
+	pw.updatePod(context.Background(), &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-0"},
+		Spec:       spec,
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{{Name: "migrate", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}}}},
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "app", State: running},
+				{Name: "crashing", State: waiting("CrashLoopBackOff")},
+			},
+			EphemeralContainerStatuses: []corev1.ContainerStatus{{Name: "debugger", State: waiting("ErrImagePull")}},
+		},
+	})
+	if got := kindOf(pw, "busybox:1"); got != model.CorrelationEphemeralContainer {
+		t.Errorf("ephemeral container kind = %q", got)
+	}
+	for _, image := range []string{"migrate:1", "app:1", "crash:1", "models:v2"} {
+		if got := pw.GetPodsForImage("node1", image); len(got) != 0 {
+			t.Errorf("%s: unexpected correlations %+v", image, got)
+		}
+	}
+}
+
+func TestResolvedDigest(t *testing.T) {
+	pw := NewPodWatcherForClient(fake.NewSimpleClientset(), nil, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	started := func(name, image, imageID string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec:       corev1.PodSpec{NodeName: "node1", Containers: []corev1.Container{{Name: "app", Image: image}}},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "app", ImageID: imageID,
+				State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}}},
+		}
+	}
+	pw.updatePod(context.Background(), started("web-0", "nginx:1.27", "docker.io/library/nginx@"+testDigest))
+	// A local image ID says nothing about the registry digest.
+	pw.updatePod(context.Background(), started("dev-0", "dev:1", "sha256:0123"))
+
+	resolved := func(node, image string) string {
+		pw.mu.RLock()
+		defer pw.mu.RUnlock()
+		return pw.resolvedDigestLocked(node + ":" + normalizeImageRef(image))
+	}
+	if got := resolved("node1", "docker.io/library/nginx:1.27"); got != testDigest {
+		t.Errorf("resolved tag = %q, want %q", got, testDigest)
+	}
+	if got := resolved("node2", "nginx:1.27"); got != "" {
+		t.Errorf("resolved on another node = %q", got)
+	}
+	if got := resolved("node2", "ghcr.io/app:v1@"+testDigest); got != testDigest {
+		t.Errorf("pinned reference = %q, want its digest", got)
+	}
+	if got := resolved("node1", "dev:1"); got != "" {
+		t.Errorf("local image = %q, want none", got)
+	}
+	pw.removeNode("node1")
+	if got := resolved("node1", "nginx:1.27"); got != "" {
+		t.Errorf("digest kept after node removal: %q", got)
+	}
+}
+
+func TestContainerFromFieldPath(t *testing.T) {
+	cases := []struct {
+		fieldPath string
+		name      string
+		kind      model.CorrelationKind
+	}{
+		{"spec.containers{app}", "app", model.CorrelationContainer},
+		{"spec.initContainers{migrate}", "migrate", model.CorrelationInitContainer},
+		{"spec.ephemeralContainers{debugger-x7k}", "debugger-x7k", model.CorrelationEphemeralContainer},
+		{"", "", ""},
+		{"spec.volumes{models}", "", ""},
+		{"spec.containers{app", "", ""},
+	}
+	for _, c := range cases {
+		name, kind := containerFromFieldPath(c.fieldPath)
+		if name != c.name || kind != c.kind {
+			t.Errorf("containerFromFieldPath(%q) = %q, %q, want %q, %q", c.fieldPath, name, kind, c.name, c.kind)
+		}
+	}
+}
+
+func TestResolveWorkload(t *testing.T) {
+	controller := func(kind, name string) []metav1.OwnerReference {
+		yes := true
+		return []metav1.OwnerReference{{Kind: kind, Name: name, Controller: &yes}}
+	}
+	client := fake.NewSimpleClientset(
+		&appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-5d8f",
+			OwnerReferences: controller("Deployment", "web")}},
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "backup-2890",
+			OwnerReferences: controller("CronJob", "backup")}},
+		&batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "migrate"}},
+	)
+	pw := &PodWatcher{
+		client: client,
+		owners: make(map[string]resolvedOwner),
+		logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	tests := []struct {
+		owners []metav1.OwnerReference
+		want   *model.WorkloadRef
+	}{
+		{controller("ReplicaSet", "web-5d8f"), &model.WorkloadRef{Kind: "Deployment", Name: "web"}},
+		{controller("Job", "backup-2890"), &model.WorkloadRef{Kind: "CronJob", Name: "backup"}},
+		{controller("Job", "migrate"), &model.WorkloadRef{Kind: "Job", Name: "migrate"}},
+		{controller("StatefulSet", "db"), &model.WorkloadRef{Kind: "StatefulSet", Name: "db"}},
+		// A ReplicaSet that cannot be read stands for itself.
+		{controller("ReplicaSet", "gone"), &model.WorkloadRef{Kind: "ReplicaSet", Name: "gone"}},
+		{nil, nil},
+	}
+	for _, tt := range tests {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "p", OwnerReferences: tt.owners}}
+		got := pw.resolveWorkload(context.Background(), pod)
+		if (got == nil) != (tt.want == nil) || (got != nil && *got != *tt.want) {
+			t.Errorf("resolveWorkload(%v) = %v, want %v", tt.owners, got, tt.want)
+		}
+	}
+
+	if _, ok := pw.owners["default/ReplicaSet/web-5d8f"]; !ok {
+		t.Error("resolved ReplicaSet owner should be cached")
+	}
+	if _, ok := pw.owners["default/ReplicaSet/gone"]; ok {
+		t.Error("failed lookup should not be cached")
+	}
+}
+
+// eventually polls cond until it holds or a deadline passes.
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestPodWatcher_Informers(t *testing.T) {
+	creating := corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}
+	waitingPod := func(namespace, name, image string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+			Spec:       corev1.PodSpec{NodeName: "node1", Containers: []corev1.Container{{Name: "app", Image: image}}},
+			Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "app", State: creating}}},
+		}
+	}
+	kubeletEvent := func(name, podName, reason, message string) *corev1.Event {
+		return &corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Namespace: "default", Name: name},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: podName},
+			Reason:         reason,
+			Message:        message,
+			Source:         corev1.EventSource{Host: "node1"},
+			LastTimestamp:  metav1.Now(),
+		}
+	}
+	pulledEvent := func(name, podName, image string) *corev1.Event {
+		return kubeletEvent(name, podName, "Pulled", `Successfully pulled image "`+image+`" in 2s`)
+	}
+	pullingEvent := func(name, podName, image string) *corev1.Event {
+		return kubeletEvent(name, podName, "Pulling", `Pulling image "`+image+`"`)
+	}
+	client := fake.NewSimpleClientset(
+		waitingPod("default", "web-0", "nginx:1.27"),
+		pullingEvent("old-pulling", "web-9", "nginx:1.26"),
+		pulledEvent("old", "web-9", "nginx:1.26"),
+		pullingEvent("stuck", "web-8", "redis:7"),
+		kubeletEvent("old-failed", "web-7", "Failed", `Failed to pull image "nginx:bad": not found`),
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+	)
+	pw := NewPodWatcherForClient(client, []string{"default", "jobs"}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	var mu sync.Mutex
+	var pulling, pulled []string
+	pw.OnPulling(func(p PullingImage) {
+		mu.Lock()
+		defer mu.Unlock()
+		pulling = append(pulling, p.Image)
+	})
+	pw.OnPulled(func(p PulledImage) {
+		mu.Lock()
+		defer mu.Unlock()
+		pulled = append(pulled, p.Image)
+	})
+
+	done := make(chan error)
+	go func() { done <- pw.Run(context.Background()) }()
+	eventually(t, "caches to sync", pw.HasSynced)
+
+	if got := pw.GetPodsForImage("node1", "nginx:1.27"); len(got) != 1 || got[0].PodName != "web-0" {
+		t.Errorf("listed pod correlations = %+v", got)
+	}
+
+	ctx := context.Background()
+	if _, err := client.CoreV1().Pods("jobs").Create(ctx, waitingPod("jobs", "batch-0", "worker:1"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CoreV1().Pods("other").Create(ctx, waitingPod("other", "x-0", "worker:1"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the jobs pod", func() bool { return len(pw.GetPodsForImage("node1", "worker:1")) == 1 })
+
+	// Pulling events in the initial list neither mark images as pulling nor
+	// reach onPulling, and Failed events do not mark them as failed.
+	if got := pw.GetPullingImagesForNode("node1"); len(got) != 0 {
+		t.Errorf("pulling images after the initial list = %v", got)
+	}
+	if got := pw.PullFailure("node1", "nginx:bad"); got != "" {
+		t.Errorf("pull failure after the initial list = %q", got)
+	}
+	if _, err := client.CoreV1().Events("default").Create(ctx, pullingEvent("new-pulling", "web-0", "nginx:1.27"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CoreV1().Events("default").Create(ctx, pulledEvent("new", "web-0", "nginx:1.27"), metav1.CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the Pulled event", func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(pulled) > 0
+	})
+	mu.Lock()
+	if len(pulling) != 1 || pulling[0] != "nginx:1.27" {
+		t.Errorf("onPulling images = %v, want only the event created after the initial list", pulling)
+	}
+	if len(pulled) != 1 || pulled[0] != "nginx:1.27" {
+		t.Errorf("onPulled images = %v, want only the event created after the initial list", pulled)
+	}
+	mu.Unlock()
+
+	if err := client.CoreV1().Pods("default").Delete(ctx, "web-0", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the pod deletion", func() bool { return len(pw.GetPodsForImage("node1", "nginx:1.27")) == 0 })
+
+	pw.addPullFailure("node1", "nginx:bad", "Failed to pull image")
+	if err := client.CoreV1().Nodes().Delete(ctx, "node1", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "the node deletion", func() bool { return pw.PullFailure("node1", "nginx:bad") == "" })
+
+	pw.Stop()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Run = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after Stop")
+	}
+}
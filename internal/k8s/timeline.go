@@ -0,0 +1,159 @@
+package k8s
+
+import (
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// podTimes is the Kubernetes side of a pod's startup timeline: condition
+// transitions and kubelet pull events.
+type podTimes struct {
+	uid, node                                        string
+	created, scheduled, initialized, containersReady time.Time
+	ready                                            time.Time
+	// pulling is the first kubelet Pulling event, pulled the last Pulled.
+	pulling, pulled time.Time
+	// waited is set once the pod was seen waiting on an image, and
+	// reported once it then became Ready and was handed to onReady. Pods
+	// that were already running when the watcher started are not reported.
+	waited, reported bool
+	touched          time.Time
+}
+
+// OnPodReady sets a function called once for each tracked pod when it
+// becomes Ready. It must be set before Run and must not block.
+func (pw *PodWatcher) OnPodReady(fn func(model.PodTimeline)) {
+	pw.onReady = fn
+}
+
+// PodTimeline returns the Kubernetes side of a pod's startup timeline: its
+// condition transitions and kubelet pull events. Pods are tracked from the
+// time they wait on an image until they are deleted, or pullingImageTTL
+// after they become Ready.
+func (pw *PodWatcher) PodTimeline(namespace, name string) (model.PodTimeline, bool) {
+	pw.mu.RLock()
+	defer pw.mu.RUnlock()
+	t, ok := pw.times[namespace+"/"+name]
+	if !ok {
+		return model.PodTimeline{}, false
+	}
+	return t.timeline(namespace, name), true
+}
+
+// podTimesFor returns the times of a pod, starting afresh if the pod was
+// recreated under the same name. pw.mu must be held.
+func (pw *PodWatcher) podTimesFor(namespace, name, uid string) *podTimes {
+	key := namespace + "/" + name
+	t, ok := pw.times[key]
+	if !ok || (uid != "" && t.uid != "" && t.uid != uid) {
+		t = &podTimes{}
+		pw.times[key] = t
+	}
+	if uid != "" {
+		t.uid = uid
+	}
+	t.touched = time.Now()
+	return t
+}
+
+// updateTimes records the condition transitions of a pod that waits or
+// waited on an image. It returns the pod's timeline when the pod has just
+// become Ready. pw.mu must be held.
+func (pw *PodWatcher) updateTimes(pod *corev1.Pod) (model.PodTimeline, bool) {
+	if _, ok := pw.times[pod.Namespace+"/"+pod.Name]; !ok && !waitingOnImage(pod) {
+		return model.PodTimeline{}, false
+	}
+	t := pw.podTimesFor(pod.Namespace, pod.Name, string(pod.UID))
+	t.waited = t.waited || waitingOnImage(pod)
+	t.node = pod.Spec.NodeName
+	t.created = pod.CreationTimestamp.Time
+	for _, c := range pod.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case corev1.PodScheduled:
+			t.scheduled = c.LastTransitionTime.Time
+		case corev1.PodInitialized:
+			t.initialized = c.LastTransitionTime.Time
+		case corev1.ContainersReady:
+			t.containersReady = c.LastTransitionTime.Time
+		case corev1.PodReady:
+			t.ready = c.LastTransitionTime.Time
+		}
+	}
+	if t.ready.IsZero() || !t.waited || t.reported {
+		return model.PodTimeline{}, false
+	}
+	t.reported = true
+	return t.timeline(pod.Namespace, pod.Name), true
+}
+
+// recordPullEvent records the time of a kubelet Pulling or Pulled event for
+// a pod.
+func (pw *PodWatcher) recordPullEvent(ke kubeletEvent) {
+	at := ke.last
+	if ke.reason == "Pulling" {
+		at = ke.first
+	}
+	if at.IsZero() {
+		return
+	}
+
+	obj := ke.pod
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if t, ok := pw.times[obj.Namespace+"/"+obj.Name]; ok && t.uid != "" && obj.UID != "" && t.uid != string(obj.UID) && t.reported {
+		// A late event for a pod that has since been replaced.
+		return
+	}
+	t := pw.podTimesFor(obj.Namespace, obj.Name, string(obj.UID))
+	switch ke.reason {
+	case "Pulling":
+		if t.pulling.IsZero() || at.Before(t.pulling) {
+			t.pulling = at
+		}
+	case "Pulled":
+		if at.After(t.pulled) {
+			t.pulled = at
+		}
+	}
+}
+
+// expireTimes drops pods that became Ready before cutoff, and pods only
+// known from kubelet events that have not been heard of since. pw.mu must be
+// held.
+func (pw *PodWatcher) expireTimes(cutoff time.Time) {
+	for key, t := range pw.times {
+		if (!t.ready.IsZero() && t.ready.Before(cutoff)) || (t.created.IsZero() && t.touched.Before(cutoff)) {
+			delete(pw.times, key)
+		}
+	}
+}
+
+func (t *podTimes) timeline(namespace, name string) model.PodTimeline {
+	return model.PodTimeline{
+		Namespace:         namespace,
+		Name:              name,
+		UID:               t.uid,
+		NodeName:          t.node,
+		CreatedAt:         t.created,
+		ScheduledAt:       timePtr(t.scheduled),
+		PullingAt:         timePtr(t.pulling),
+		PulledAt:          timePtr(t.pulled),
+		InitializedAt:     timePtr(t.initialized),
+		ContainersReadyAt: timePtr(t.containersReady),
+		ReadyAt:           timePtr(t.ready),
+	}
+}
+
+// timePtr returns nil for the zero time.
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
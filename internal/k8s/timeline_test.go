@@ -0,0 +1,106 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodTimeline(t *testing.T) {
+	var reported []model.PodTimeline
+	pw := &PodWatcher{
+		podsByImage: make(map[string][]model.PodCorrelation),
+		podNodes:    make(map[string]string),
+		times:       make(map[string]*podTimes),
+	}
+	pw.OnPodReady(func(tl model.PodTimeline) { reported = append(reported, tl) })
+
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(s int) metav1.Time { return metav1.NewTime(created.Add(time.Duration(s) * time.Second)) }
+	condition := func(typ corev1.PodConditionType, s int) corev1.PodCondition {
+		return corev1.PodCondition{Type: typ, Status: corev1.ConditionTrue, LastTransitionTime: at(s)}
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-0", UID: "u1", CreationTimestamp: at(0)},
+		Spec:       corev1.PodSpec{NodeName: "node1", Containers: []corev1.Container{{Name: "app", Image: "app:1"}}},
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{condition(corev1.PodScheduled, 2)},
+			ContainerStatuses: []corev1.ContainerStatus{{Name: "app", State: corev1.ContainerState{
+				Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}}},
+		},
+	}
+	pw.updatePod(context.Background(), pod)
+
+	event := func(reason string, s int) kubeletEvent {
+		return fromCoreEvent(&corev1.Event{
+			Reason:         reason,
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web-0", UID: "u1"},
+			FirstTimestamp: at(s),
+			LastTimestamp:  at(s),
+		})
+	}
+	pw.recordPullEvent(event("Pulling", 5))
+	pw.recordPullEvent(event("Pulling", 4))
+	pw.recordPullEvent(event("Pulled", 30))
+	pw.recordPullEvent(event("Pulled", 20))
+
+	pod = pod.DeepCopy()
+	pod.Status.ContainerStatuses[0].State = corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+	pod.Status.Conditions = append(pod.Status.Conditions,
+		condition(corev1.PodInitialized, 3), condition(corev1.ContainersReady, 33), condition(corev1.PodReady, 33))
+	pw.updatePod(context.Background(), pod)
+	pw.updatePod(context.Background(), pod) // reported once
+
+	if len(reported) != 1 {
+		t.Fatalf("onReady called %d times, want 1", len(reported))
+	}
+	tl := reported[0]
+	if tl.UID != "u1" || tl.NodeName != "node1" || !tl.CreatedAt.Equal(created) {
+		t.Errorf("timeline = %+v", tl)
+	}
+	for name, got := range map[string]*time.Time{
+		"scheduledAt": tl.ScheduledAt, "pullingAt": tl.PullingAt, "pulledAt": tl.PulledAt,
+		"initializedAt": tl.InitializedAt, "containersReadyAt": tl.ContainersReadyAt, "readyAt": tl.ReadyAt,
+	} {
+		want := map[string]int{"scheduledAt": 2, "pullingAt": 4, "pulledAt": 30, "initializedAt": 3, "containersReadyAt": 33, "readyAt": 33}[name]
+		if got == nil || !got.Equal(at(want).Time) {
+			t.Errorf("%s = %v, want +%ds", name, got, want)
+		}
+	}
+
+	if _, ok := pw.PodTimeline("default", "web-0"); !ok {
+		t.Error("PodTimeline should find the pod")
+	}
+	pw.removePod(pod)
+	if _, ok := pw.PodTimeline("default", "web-0"); ok {
+		t.Error("PodTimeline should forget deleted pods")
+	}
+}
+
+func TestPodTimeline_RunningBeforeWatch(t *testing.T) {
+	called := false
+	pw := &PodWatcher{
+		podsByImage: make(map[string][]model.PodCorrelation),
+		podNodes:    make(map[string]string),
+		times:       make(map[string]*podTimes),
+	}
+	pw.OnPodReady(func(model.PodTimeline) { called = true })
+
+	// A replayed Pulling event for a pod that was already Ready.
+	pw.recordPullEvent(fromCoreEvent(&corev1.Event{Reason: "Pulling", LastTimestamp: metav1.Now(),
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "old"}}))
+	pw.updatePod(context.Background(), &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "old"},
+		Spec:       corev1.PodSpec{NodeName: "node1"},
+		Status: corev1.PodStatus{Conditions: []corev1.PodCondition{
+			{Type: corev1.PodReady, Status: corev1.ConditionTrue, LastTransitionTime: metav1.Now()}}},
+	})
+	if called {
+		t.Error("a pod never seen waiting on an image should not be reported")
+	}
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// AgentRegistry holds the agent's node-local metrics. It is kept separate from
+// the default registry so the server's /metrics output is not polluted with
+// agent series (and vice versa).
+var AgentRegistry = prometheus.NewRegistry()
+
+var agentFactory = promauto.With(AgentRegistry)
+
+var (
+	AgentPollDurationSeconds = agentFactory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "pulltrace",
+		Subsystem: "agent",
+		Name:      "poll_duration_seconds",
+		Help:      "Latency of containerd content status polls in seconds.",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1},
+	})
+
+	AgentIngestsActive = agentFactory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pulltrace",
+		Subsystem: "agent",
+		Name:      "ingests_active",
+		Help:      "Number of containerd content ingests seen by the last poll.",
+	})
+
+	AgentReportsSent = agentFactory.NewCounter(prometheus.CounterOpts{
+		Namespace: "pulltrace",
+		Subsystem: "agent",
+		Name:      "reports_sent_total",
+		Help:      "Total reports successfully delivered to the server.",
+	})
+
+	AgentReportFailures = agentFactory.NewCounter(prometheus.CounterOpts{
+		Namespace: "pulltrace",
+		Subsystem: "agent",
+		Name:      "report_failures_total",
+		Help:      "Total reports that failed to reach the server.",
+	})
+
+	AgentContainerdUp = agentFactory.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pulltrace",
+		Subsystem: "agent",
+		Name:      "containerd_up",
+		Help:      "Whether the last containerd poll succeeded (1) or failed (0).",
+	})
+
+	AgentContainerdReconnects = agentFactory.NewCounter(prometheus.CounterOpts{
+		Namespace: "pulltrace",
+		Subsystem: "agent",
+		Name:      "containerd_reconnects_total",
+		Help:      "Total successful reconnections to containerd after a lost connection.",
+	})
+
+	AgentContainerdReconnectFailures = agentFactory.NewCounter(prometheus.CounterOpts{
+		Namespace: "pulltrace",
+		Subsystem: "agent",
+		Name:      "containerd_reconnect_failures_total",
+		Help:      "Total failed containerd reconnect attempts.",
+	})
+)
@@ -38,12 +38,115 @@ var (
 		Help:      "Total number of pull errors.",
 	})
 
+	PullsStalled = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pulltrace",
+		Name:      "pulls_stalled",
+		Help:      "Number of active image pulls that have stopped making progress.",
+	})
+
+	PullStalls = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "pulltrace",
+		Name:      "pull_stalls_total",
+		Help:      "Total number of times an active pull became stalled.",
+	})
+
+	PodStartupSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "pulltrace",
+		Name:      "pod_startup_seconds",
+		Help:      "Startup time of pods that pulled an image, by timeline segment; the total segment runs from creation to Ready.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300, 600},
+	}, []string{"segment"})
+
+	ManifestLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pulltrace",
+		Name:      "manifest_lookups_total",
+		Help:      "Registry manifest lookups of image sizes, by result (success, failed or dropped).",
+	}, []string{"result"})
+
+	PullDiscrepancies = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pulltrace",
+		Name:      "pull_discrepancies_total",
+		Help:      "Pulls whose kubelet-reported duration or size disagrees with the agent's, or that no agent reported, by kind.",
+	}, []string{"kind"})
+
 	AgentReports = promauto.NewCounter(prometheus.CounterOpts{
 		Namespace: "pulltrace",
 		Name:      "agent_reports_total",
 		Help:      "Total agent reports received.",
 	})
 
+	AgentsConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pulltrace",
+		Name:      "agents_connected",
+		Help:      "Number of agents that reported within their heartbeat deadline.",
+	})
+
+	AgentInfo = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pulltrace",
+		Name:      "agent_info",
+		Help:      "Reporting agents by node and agent version (always 1).",
+	}, []string{"node", "version"})
+
+	AgentLastReport = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pulltrace",
+		Name:      "agent_last_report_timestamp_seconds",
+		Help:      "Unix time of the last report received from each agent.",
+	}, []string{"node"})
+
+	AgentReportLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pulltrace",
+		Name:      "agent_report_lag_seconds",
+		Help:      "Delay between an agent taking a snapshot and the server receiving it.",
+	}, []string{"node"})
+
+	NodesWithoutAgent = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pulltrace",
+		Name:      "nodes_without_agent",
+		Help:      "Nodes running pods that have no live reporting agent.",
+	})
+
+	ServerLeader = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "pulltrace",
+		Name:      "server_leader",
+		Help:      "Whether this server replica holds the leader Lease (1) or not (0).",
+	})
+
+	FederationClusterUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pulltrace",
+		Name:      "federation_cluster_up",
+		Help:      "Whether the event stream from each federated downstream cluster is connected (1) or down (0).",
+	}, []string{"cluster"})
+
+	FederationEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pulltrace",
+		Name:      "federation_events_total",
+		Help:      "Pull events received from each federated downstream cluster.",
+	}, []string{"cluster"})
+
+	Notifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pulltrace",
+		Name:      "notifications_total",
+		Help:      "Webhook notifications by rule, webhook and result (sent, failed or dropped).",
+	}, []string{"rule", "webhook", "result"})
+
+	NotificationsRateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pulltrace",
+		Name:      "notifications_rate_limited_total",
+		Help:      "Notifications suppressed by each rule's rate limit.",
+	}, []string{"rule"})
+
+	Alerts = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "pulltrace",
+		Name:      "alerts",
+		Help:      "Pending and firing alerts by rule, severity and state.",
+	}, []string{"rule", "severity", "state"})
+
+	AlertTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "pulltrace",
+		Name:      "alert_transitions_total",
+		Help:      "Alerts that started firing or resolved, by rule, severity and new state.",
+	}, []string{"rule", "severity", "state"})
+
 	SSEClients = promauto.NewGauge(prometheus.GaugeOpts{
 		Namespace: "pulltrace",
 		Name:      "sse_clients_active",
@@ -4,6 +4,29 @@ import "time"
 
 const SchemaVersion = "v1"
 
+// ProtocolVersion is the agent/server report protocol implemented by this
+// build. MinProtocolVersion is the oldest one the server still accepts.
+// Reports that omit protocolVersion predate versioning and count as version 1.
+const (
+	ProtocolVersion    = 2
+	MinProtocolVersion = 1
+)
+
+// Capability names an optional agent behaviour the server may rely on.
+type Capability string
+
+const (
+	// CapabilityHeartbeat means the agent reports at least every
+	// HeartbeatSeconds even when idle, so silence means the agent is gone.
+	CapabilityHeartbeat Capability = "heartbeat"
+	// CapabilityAgentStats means reports carry cumulative PollErrors and
+	// ReportErrors counters.
+	CapabilityAgentStats Capability = "agent-stats"
+)
+
+// AgentCapabilities lists the capabilities implemented by this build's agent.
+var AgentCapabilities = []Capability{CapabilityHeartbeat, CapabilityAgentStats}
+
 // PullEvent is the top-level event sent over SSE and emitted as a structured log line.
 type PullEvent struct {
 	SchemaVersion string      `json:"schemaVersion"`
@@ -11,6 +34,12 @@ type PullEvent struct {
 	Type          EventType   `json:"type"`
 	NodeName      string      `json:"nodeName"`
 	Pull          *PullStatus `json:"pull,omitempty"`
+	// Alert is set instead of Pull on alert events.
+	Alert *Alert `json:"alert,omitempty"`
+	// Pod is set instead of Pull on pod events.
+	Pod *PodStatus `json:"pod,omitempty"`
+	// Workload is set instead of Pull on workload events.
+	Workload *WorkloadStatus `json:"workload,omitempty"`
 }
 
 type EventType string
@@ -18,11 +47,25 @@ type EventType string
 const (
 	EventPullProgress  EventType = "pull.progress"
 	EventPullCompleted EventType = "pull.completed"
+	// EventPullStalled is sent instead of EventPullProgress when a pull
+	// becomes stalled.
+	EventPullStalled   EventType = "pull.stalled"
+	EventPodProgress   EventType = "pod.progress"
+	EventPodCompleted  EventType = "pod.completed"
+	EventAlertFiring   EventType = "alert.firing"
+	EventAlertResolved EventType = "alert.resolved"
+	// Workload events follow the pulls of a Deployment, StatefulSet,
+	// DaemonSet, Job or CronJob across nodes.
+	EventWorkloadProgress  EventType = "workload.progress"
+	EventWorkloadCompleted EventType = "workload.completed"
 )
 
 // PullStatus describes the current state of an image pull.
 type PullStatus struct {
-	ID              string           `json:"id"`
+	ID string `json:"id"`
+	// Cluster names the cluster the pull runs in. It is set by federating
+	// servers and by servers configured with a cluster name.
+	Cluster         string           `json:"cluster,omitempty"`
 	NodeName        string           `json:"nodeName,omitempty"`
 	ImageRef        string           `json:"imageRef"`
 	TotalBytes      int64            `json:"totalBytes"`
@@ -38,8 +81,60 @@ type PullStatus struct {
 	Pods            []PodCorrelation `json:"pods,omitempty"`
 	Layers          []LayerStatus    `json:"layers,omitempty"`
 	TotalKnown      bool             `json:"totalKnown"`
+	// Digest is the manifest digest the image reference resolved to, once
+	// known from containerd or from a reference pinned to a digest.
+	Digest string `json:"digest,omitempty"`
+	// ManifestBytes is the compressed image size from the registry
+	// manifest, when the server looked it up.
+	ManifestBytes int64 `json:"manifestBytes,omitempty"`
+	// Kubelet is the kubelet's own account of the pull, from its Pulled
+	// event. Discrepancies lists where the two disagree.
+	Kubelet       *KubeletPull  `json:"kubelet,omitempty"`
+	Discrepancies []Discrepancy `json:"discrepancies,omitempty"`
+	// Retries is set once the kubelet has retried the pull for one of its
+	// pods.
+	Retries *PullRetries `json:"retries,omitempty"`
+	// Stalled is set while an active pull has downloaded nothing for the
+	// server's stall timeout. LastProgressAt is when its downloaded bytes
+	// last changed.
+	Stalled        bool       `json:"stalled,omitempty"`
+	LastProgressAt *time.Time `json:"lastProgressAt,omitempty"`
 }
 
+// KubeletPull is what the kubelet reported about a completed pull.
+type KubeletPull struct {
+	PulledAt time.Time `json:"pulledAt"`
+	// DurationSeconds is the time the kubelet spent pulling, and
+	// WaitingSeconds the time the pull queued behind others before that.
+	DurationSeconds float64 `json:"durationSeconds"`
+	WaitingSeconds  float64 `json:"waitingSeconds,omitempty"`
+	// SizeBytes is the image size reported by the runtime. Older kubelets
+	// do not report it.
+	SizeBytes int64 `json:"sizeBytes,omitempty"`
+}
+
+// PullRetries counts the kubelet's attempts to pull an image for a pod, from
+// its Pulling and BackOff events and their series.
+type PullRetries struct {
+	// Attempts includes the first attempt.
+	Attempts      int       `json:"attempts"`
+	BackOffs      int       `json:"backOffs"`
+	LastAttemptAt time.Time `json:"lastAttemptAt"`
+	// BackoffSeconds is the time between the last two attempts.
+	BackoffSeconds float64 `json:"backoffSeconds,omitempty"`
+}
+
+// Discrepancy is a way in which the kubelet's and the agent's accounts of a
+// pull disagree.
+type Discrepancy string
+
+const (
+	// DiscrepancyMissed means the kubelet pulled an image no agent reported.
+	DiscrepancyMissed   Discrepancy = "missed"
+	DiscrepancySize     Discrepancy = "size"
+	DiscrepancyDuration Discrepancy = "duration"
+)
+
 // LayerStatus describes a single layer download.
 type LayerStatus struct {
 	PullID          string     `json:"pullId"`
@@ -52,21 +147,211 @@ type LayerStatus struct {
 	StartedAt       time.Time  `json:"startedAt"`
 	CompletedAt     *time.Time `json:"completedAt,omitempty"`
 	TotalKnown      bool       `json:"totalKnown"`
+	Stalled         bool       `json:"stalled,omitempty"`
+	LastProgressAt  *time.Time `json:"lastProgressAt,omitempty"`
 }
 
+// CorrelationKind is the part of a pod that waits on an image.
+type CorrelationKind string
+
+const (
+	CorrelationContainer          CorrelationKind = "container"
+	CorrelationInitContainer      CorrelationKind = "initContainer"
+	CorrelationEphemeralContainer CorrelationKind = "ephemeralContainer"
+	// CorrelationImageVolume is a volume mounted from an OCI image, pulled
+	// before any of the pod's containers start.
+	CorrelationImageVolume CorrelationKind = "imageVolume"
+)
+
 // PodCorrelation maps an image pull to a waiting pod.
 type PodCorrelation struct {
 	Namespace string `json:"namespace"`
 	PodName   string `json:"podName"`
-	Container string `json:"container"`
-	Image     string `json:"image,omitempty"`
+	PodUID    string `json:"podUID,omitempty"`
+	// Container is the name of the container, or of the volume for an image
+	// volume.
+	Container string          `json:"container"`
+	Kind      CorrelationKind `json:"kind"`
+	Image     string          `json:"image,omitempty"`
+	// InitOrder is the position of an init container in the pod spec,
+	// starting at 1. It is 0 for other containers.
+	InitOrder int `json:"initOrder,omitempty"`
+	// Workload is the top-level controller of the pod, resolved through
+	// ownerReferences. It is nil for pods no controller owns.
+	Workload *WorkloadRef `json:"workload,omitempty"`
+}
+
+// Pod startup timeline segments, in order.
+const (
+	SegmentScheduled  = "scheduled"  // created to PodScheduled
+	SegmentPullQueued = "pullQueued" // first kubelet Pulling event to first ingest
+	SegmentPulling    = "pulling"    // first ingest to the last pull finishing
+	SegmentUnpacking  = "unpacking"  // last pull finishing to the last Pulled event
+	SegmentStarted    = "started"    // last Pulled event to ContainersReady
+)
+
+// PodTimeline breaks a pod's startup into segments. Timestamps come from pod
+// conditions, kubelet Pulling and Pulled events, and the agents' ingests of
+// the pod's images; any of them may be missing.
+type PodTimeline struct {
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	UID       string    `json:"uid,omitempty"`
+	NodeName  string    `json:"nodeName,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+	// ScheduledAt, InitializedAt, ContainersReadyAt and ReadyAt are the
+	// transition times of the matching pod conditions.
+	ScheduledAt *time.Time `json:"scheduledAt,omitempty"`
+	// PullingAt is the first kubelet Pulling event and PulledAt the last
+	// Pulled event for the pod.
+	PullingAt *time.Time `json:"pullingAt,omitempty"`
+	// FirstIngestAt is when the first of the pod's pulls started writing
+	// content on the node, and IngestedAt when the last one finished.
+	FirstIngestAt     *time.Time `json:"firstIngestAt,omitempty"`
+	IngestedAt        *time.Time `json:"ingestedAt,omitempty"`
+	PulledAt          *time.Time `json:"pulledAt,omitempty"`
+	InitializedAt     *time.Time `json:"initializedAt,omitempty"`
+	ContainersReadyAt *time.Time `json:"containersReadyAt,omitempty"`
+	ReadyAt           *time.Time `json:"readyAt,omitempty"`
+	// Segments lists the segments whose start and end are both known.
+	Segments []TimelineSegment `json:"segments"`
+	// ImageSeconds runs from PullingAt to PulledAt and TotalSeconds from
+	// CreatedAt to ReadyAt.
+	ImageSeconds float64 `json:"imageSeconds,omitempty"`
+	TotalSeconds float64 `json:"totalSeconds,omitempty"`
+}
+
+// TimelineSegment is one step of a pod's startup.
+type TimelineSegment struct {
+	Name    string    `json:"name"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Seconds float64   `json:"seconds"`
+}
+
+// WorkloadRef names the workload a pod belongs to, in the pod's namespace.
+type WorkloadRef struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// PodStatus combines every image pull a pod is waiting on.
+type PodStatus struct {
+	Namespace       string  `json:"namespace"`
+	Name            string  `json:"name"`
+	UID             string  `json:"uid,omitempty"`
+	Cluster         string  `json:"cluster,omitempty"`
+	NodeName        string  `json:"nodeName,omitempty"`
+	TotalBytes      int64   `json:"totalBytes"`
+	DownloadedBytes int64   `json:"downloadedBytes"`
+	BytesPerSec     float64 `json:"bytesPerSec"`
+	// ETASeconds adds up the init containers' pulls, which the kubelet runs
+	// one after another, and then the longest of the other containers'
+	// pulls. It is omitted while any active pull has no ETA.
+	ETASeconds float64   `json:"etaSeconds,omitempty"`
+	Percent    float64   `json:"percent"`
+	PullCount  int       `json:"pullCount"`
+	PullsDone  int       `json:"pullsDone"`
+	StartedAt  time.Time `json:"startedAt"`
+	// CompletedAt is set once every pull seen for the pod has completed.
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+	TotalKnown  bool       `json:"totalKnown"`
+	Containers  []PodPull  `json:"containers"`
+}
+
+// PodPull is the pull behind one of a pod's containers. Pull carries no
+// layers.
+type PodPull struct {
+	Container string          `json:"container"`
+	Kind      CorrelationKind `json:"kind"`
+	InitOrder int             `json:"initOrder,omitempty"`
+	Pull      PullStatus      `json:"pull"`
+}
+
+// WorkloadStatus combines the image pulls of a workload's pods across nodes,
+// such as those started by a rollout.
+type WorkloadStatus struct {
+	Namespace       string  `json:"namespace"`
+	Kind            string  `json:"kind"`
+	Name            string  `json:"name"`
+	Cluster         string  `json:"cluster,omitempty"`
+	NodesPulling    int     `json:"nodesPulling"`
+	NodesDone       int     `json:"nodesDone"`
+	PullCount       int     `json:"pullCount"`
+	PullsDone       int     `json:"pullsDone"`
+	TotalBytes      int64   `json:"totalBytes"`
+	DownloadedBytes int64   `json:"downloadedBytes"`
+	BytesPerSec     float64 `json:"bytesPerSec"`
+	Percent         float64 `json:"percent"`
+	// SlowestNode is the pulling node furthest behind, by percent.
+	SlowestNode string    `json:"slowestNode,omitempty"`
+	StartedAt   time.Time `json:"startedAt"`
+	// CompletedAt is set once every node has finished its pulls.
+	CompletedAt *time.Time     `json:"completedAt,omitempty"`
+	Nodes       []WorkloadNode `json:"nodes"`
+}
+
+// WorkloadNode is one node's share of a workload's pulls.
+type WorkloadNode struct {
+	NodeName        string  `json:"nodeName"`
+	PullCount       int     `json:"pullCount"`
+	PullsDone       int     `json:"pullsDone"`
+	TotalBytes      int64   `json:"totalBytes"`
+	DownloadedBytes int64   `json:"downloadedBytes"`
+	BytesPerSec     float64 `json:"bytesPerSec"`
+	Percent         float64 `json:"percent"`
+	// ETASeconds is the longest ETA of the node's active pulls. It is
+	// omitted while any of them has none.
+	ETASeconds  float64    `json:"etaSeconds,omitempty"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+// WorkloadsResponse wraps the workloads list endpoint response.
+type WorkloadsResponse struct {
+	Workloads []WorkloadStatus `json:"workloads"`
 }
 
 // AgentReport is the payload sent by an agent to the server.
 type AgentReport struct {
-	NodeName  string      `json:"nodeName"`
-	Timestamp time.Time   `json:"timestamp"`
-	Pulls     []PullState `json:"pulls"`
+	ProtocolVersion int          `json:"protocolVersion,omitempty"`
+	Capabilities    []Capability `json:"capabilities,omitempty"`
+	NodeName        string       `json:"nodeName"`
+	Timestamp       time.Time    `json:"timestamp"`
+	// HeartbeatSeconds is the longest the agent waits between reports. The
+	// server uses it to decide when a silent agent is no longer live.
+	HeartbeatSeconds float64 `json:"heartbeatSeconds,omitempty"`
+	AgentVersion     string  `json:"agentVersion,omitempty"`
+	// PollErrors and ReportErrors are cumulative since the agent started.
+	PollErrors   int64       `json:"pollErrors,omitempty"`
+	ReportErrors int64       `json:"reportErrors,omitempty"`
+	Pulls        []PullState `json:"pulls"`
+}
+
+// Version returns the report's protocol version, treating unversioned
+// reports as version 1.
+func (r AgentReport) Version() int {
+	if r.ProtocolVersion == 0 {
+		return 1
+	}
+	return r.ProtocolVersion
+}
+
+// HasCapability reports whether the sending agent declared c.
+func (r AgentReport) HasCapability(c Capability) bool {
+	for _, have := range r.Capabilities {
+		if have == c {
+			return true
+		}
+	}
+	return false
+}
+
+// ReportRejection is the body returned with 409 Conflict when the server
+// cannot accept a report's protocol version.
+type ReportRejection struct {
+	Error              string `json:"error"`
+	ProtocolVersion    int    `json:"protocolVersion"`
+	MinProtocolVersion int    `json:"minProtocolVersion"`
 }
 
 // PullState is the agent-side snapshot of a single image pull.
@@ -86,6 +371,86 @@ type LayerState struct {
 	TotalKnown      bool   `json:"totalKnown"`
 }
 
+// AgentStatus describes a reporting agent as seen by the server.
+type AgentStatus struct {
+	NodeName         string       `json:"nodeName"`
+	AgentVersion     string       `json:"agentVersion,omitempty"`
+	ProtocolVersion  int          `json:"protocolVersion"`
+	Capabilities     []Capability `json:"capabilities,omitempty"`
+	LastReport       time.Time    `json:"lastReport"`
+	ReportLagSeconds float64      `json:"reportLagSeconds"`
+	HeartbeatSeconds float64      `json:"heartbeatSeconds"`
+	Connected        bool         `json:"connected"`
+	Reports          int64        `json:"reports"`
+	PollErrors       int64        `json:"pollErrors"`
+	ReportErrors     int64        `json:"reportErrors"`
+}
+
+// AgentsResponse wraps the agents list endpoint response.
+type AgentsResponse struct {
+	Agents []AgentStatus `json:"agents"`
+	// NodesWithoutAgent lists nodes that run pods but have no live agent.
+	NodesWithoutAgent []string `json:"nodesWithoutAgent"`
+}
+
+// ClusterStatus describes a cluster whose pulls a server serves: its own,
+// or a downstream server it federates.
+type ClusterStatus struct {
+	Name string `json:"name"`
+	// Local is true for the cluster whose agents report to this server.
+	Local bool   `json:"local,omitempty"`
+	URL   string `json:"url,omitempty"`
+	// Connected is false while the downstream event stream is down. Pulls
+	// from a disconnected cluster are kept but may be out of date.
+	Connected bool `json:"connected"`
+	// Since is when Connected last changed.
+	Since     *time.Time `json:"since,omitempty"`
+	LastEvent *time.Time `json:"lastEvent,omitempty"`
+	// Error is the reason the last connection attempt failed or dropped.
+	Error       string `json:"error,omitempty"`
+	ActivePulls int    `json:"activePulls"`
+}
+
+// ClustersResponse wraps the clusters list endpoint response.
+type ClustersResponse struct {
+	Clusters []ClusterStatus `json:"clusters"`
+}
+
+// AlertState is the lifecycle state of an alert.
+type AlertState string
+
+const (
+	// AlertPending means the rule's condition holds but not yet for the
+	// rule's for-duration.
+	AlertPending  AlertState = "pending"
+	AlertFiring   AlertState = "firing"
+	AlertResolved AlertState = "resolved"
+)
+
+// Alert is the state of one alerting rule for one labelled series, such as
+// a pull, a pod or a node.
+type Alert struct {
+	Rule     string     `json:"rule"`
+	Severity string     `json:"severity,omitempty"`
+	State    AlertState `json:"state"`
+	// Labels identify the series, e.g. node, image, namespace and pod.
+	Labels map[string]string `json:"labels"`
+	// Annotations describe the series without identifying it, e.g. the
+	// pods waiting on a pull.
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Value       float64           `json:"value"`
+	Summary     string            `json:"summary,omitempty"`
+	// ActiveAt is when the condition started to hold.
+	ActiveAt   time.Time  `json:"activeAt"`
+	FiredAt    *time.Time `json:"firedAt,omitempty"`
+	ResolvedAt *time.Time `json:"resolvedAt,omitempty"`
+}
+
+// AlertsResponse wraps the alerts list endpoint response.
+type AlertsResponse struct {
+	Alerts []Alert `json:"alerts"`
+}
+
 // APIResponse wraps the pulls list endpoint response.
 type APIResponse struct {
 	Pulls []PullStatus `json:"pulls"`
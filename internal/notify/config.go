@@ -0,0 +1,142 @@
+package notify
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	defaultMaxRetries  = 3
+	defaultTimeout     = 10 * time.Second
+	defaultDedupWindow = time.Hour
+	defaultDedupKey    = "{{ .Pull.ID }}"
+)
+
+// Trigger is the pull condition a rule notifies about.
+type Trigger string
+
+const (
+	// TriggerFailed fires when a pull completes with an error.
+	TriggerFailed Trigger = "failed"
+	// TriggerStalled fires when the server marks a pull stalled and it has
+	// downloaded nothing for at least the rule's After duration.
+	TriggerStalled Trigger = "stalled"
+	// TriggerSlow fires when a pull has been running for longer than the
+	// rule's After duration.
+	TriggerSlow Trigger = "slow"
+)
+
+// Config is the notifier configuration file.
+type Config struct {
+	Webhooks []Webhook `json:"webhooks"`
+	Rules    []Rule    `json:"rules"`
+}
+
+// Webhook is an HTTP endpoint notifications are POSTed to.
+type Webhook struct {
+	// Name is referenced by rules and labels metrics.
+	Name string `json:"name"`
+	// URL is the endpoint. Set URLFile instead to keep a URL that embeds a
+	// token, as chat webhooks do, in a Secret; it is re-read for every
+	// request.
+	URL     string `json:"url,omitempty"`
+	URLFile string `json:"urlFile,omitempty"`
+	// Template is a Go text/template executed with a Notification to render
+	// the JSON request body. Without one, the Notification itself is sent.
+	Template string            `json:"template,omitempty"`
+	Headers  map[string]string `json:"headers,omitempty"`
+	// SecretFile holds the key the body is signed with (see Sign). It is
+	// re-read for every request so rotated secrets are picked up.
+	SecretFile string `json:"secretFile,omitempty"`
+	// MaxRetries is how often a failed delivery is retried with backoff.
+	// Unset means 3.
+	MaxRetries *int            `json:"maxRetries,omitempty"`
+	Timeout    metav1.Duration `json:"timeout,omitempty"`
+}
+
+// Rule sends a notification to its webhooks when a pull meets its trigger.
+type Rule struct {
+	Name    string  `json:"name"`
+	Trigger Trigger `json:"trigger"`
+	// After is how long a pull must have run before a slow rule fires, or
+	// made no progress before a stalled rule fires.
+	After    metav1.Duration `json:"after,omitempty"`
+	Webhooks []string        `json:"webhooks"`
+	// DedupKey is a template executed with the Notification. The rule
+	// notifies at most once per key within DedupWindow, one hour by default.
+	// The default key is the pull ID.
+	DedupKey    string          `json:"dedupKey,omitempty"`
+	DedupWindow metav1.Duration `json:"dedupWindow,omitempty"`
+	// RateLimit caps the notifications the rule sends; the rest are dropped.
+	RateLimit *RateLimit `json:"rateLimit,omitempty"`
+}
+
+// RateLimit allows at most Count notifications per Interval.
+type RateLimit struct {
+	Count    int             `json:"count"`
+	Interval metav1.Duration `json:"interval"`
+}
+
+// LoadConfig reads a notifier configuration from a YAML or JSON file.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if err := cfg.validate(); err != nil {
+		return Config{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+func (c Config) validate() error {
+	if len(c.Rules) == 0 {
+		return fmt.Errorf("no rules configured")
+	}
+	webhooks := make(map[string]bool)
+	for i, w := range c.Webhooks {
+		switch {
+		case w.Name == "":
+			return fmt.Errorf("webhooks[%d]: name is required", i)
+		case webhooks[w.Name]:
+			return fmt.Errorf("webhook %q is listed twice", w.Name)
+		case (w.URL == "") == (w.URLFile == ""):
+			return fmt.Errorf("webhook %q: set exactly one of url and urlFile", w.Name)
+		case w.MaxRetries != nil && *w.MaxRetries < 0:
+			return fmt.Errorf("webhook %q: maxRetries must not be negative", w.Name)
+		}
+		webhooks[w.Name] = true
+	}
+	rules := make(map[string]bool)
+	for i, r := range c.Rules {
+		switch {
+		case r.Name == "":
+			return fmt.Errorf("rules[%d]: name is required", i)
+		case rules[r.Name]:
+			return fmt.Errorf("rule %q is listed twice", r.Name)
+		case r.Trigger != TriggerFailed && r.Trigger != TriggerStalled && r.Trigger != TriggerSlow:
+			return fmt.Errorf("rule %q: trigger must be failed, stalled or slow", r.Name)
+		case r.Trigger == TriggerSlow && r.After.Duration <= 0:
+			return fmt.Errorf("rule %q: after is required for %s rules", r.Name, r.Trigger)
+		case len(r.Webhooks) == 0:
+			return fmt.Errorf("rule %q: no webhooks", r.Name)
+		case r.RateLimit != nil && (r.RateLimit.Count <= 0 || r.RateLimit.Interval.Duration <= 0):
+			return fmt.Errorf("rule %q: rateLimit needs a positive count and interval", r.Name)
+		}
+		for _, name := range r.Webhooks {
+			if !webhooks[name] {
+				return fmt.Errorf("rule %q: unknown webhook %q", r.Name, name)
+			}
+		}
+		rules[r.Name] = true
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package notify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := LoadConfig(writeConfig(t, `
+webhooks:
+  - name: chat
+    urlFile: /etc/pulltrace/notify-secrets/chat-url
+    template: '{"text": {{ json .Message }}}'
+  - name: incidents
+    url: https://events.example.com/v2/enqueue
+    secretFile: /etc/pulltrace/notify-secrets/incidents
+    maxRetries: 5
+    timeout: 5s
+rules:
+  - name: pull-failed
+    trigger: failed
+    webhooks: [chat, incidents]
+  - name: pull-slow
+    trigger: slow
+    after: 5m
+    webhooks: [chat]
+    dedupKey: '{{ .Pull.ImageRef }}'
+    dedupWindow: 30m
+    rateLimit: {count: 10, interval: 1h}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Webhooks) != 2 || *cfg.Webhooks[1].MaxRetries != 5 || cfg.Webhooks[1].Timeout.Duration != 5*time.Second {
+		t.Errorf("webhooks = %+v", cfg.Webhooks)
+	}
+	if r := cfg.Rules[1]; r.After.Duration != 5*time.Minute || r.DedupWindow.Duration != 30*time.Minute || r.RateLimit.Count != 10 {
+		t.Errorf("rules = %+v", cfg.Rules)
+	}
+
+	hook := "webhooks: [{name: a, url: http://a}]\n"
+	for name, content := range map[string]string{
+		"no rules":            hook + `rules: []`,
+		"unknown trigger":     hook + `rules: [{name: r, trigger: crashed, webhooks: [a]}]`,
+		"slow without after":  hook + `rules: [{name: r, trigger: slow, webhooks: [a]}]`,
+		"unknown webhook":     hook + `rules: [{name: r, trigger: failed, webhooks: [b]}]`,
+		"no webhooks":         hook + `rules: [{name: r, trigger: failed}]`,
+		"duplicate rule":      hook + `rules: [{name: r, trigger: failed, webhooks: [a]}, {name: r, trigger: failed, webhooks: [a]}]`,
+		"bad rate limit":      hook + `rules: [{name: r, trigger: failed, webhooks: [a], rateLimit: {count: 0, interval: 1m}}]`,
+		"webhook without url": "webhooks: [{name: a}]\n" + `rules: [{name: r, trigger: failed, webhooks: [a]}]`,
+		"url and urlFile":     "webhooks: [{name: a, url: http://a, urlFile: /u}]\n" + `rules: [{name: r, trigger: failed, webhooks: [a]}]`,
+		"unknown keys":        hook + `rules: [{name: r, trigger: failed, webhooks: [a], severity: high}]`,
+	} {
+		if _, err := LoadConfig(writeConfig(t, content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,302 @@
+// Package notify posts webhook notifications when image pulls fail, stall
+// or take longer than expected.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/metrics"
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+const (
+	// checkInterval is how often active pulls are checked against stalled
+	// and slow rules between events.
+	checkInterval = 5 * time.Second
+
+	// deliveryQueueSize bounds pending deliveries; notifications are dropped
+	// beyond it.
+	deliveryQueueSize = 256
+
+	// deliveryWorkers is how many deliveries, retries included, run at once.
+	deliveryWorkers = 4
+)
+
+// Notification is the data webhook templates and dedup keys are executed
+// with. Without a template it is also the request body.
+type Notification struct {
+	model.PullEvent
+	Rule    string  `json:"rule"`
+	Trigger Trigger `json:"trigger"`
+	// Message is a one-line summary, e.g. "nginx:1.27 on node1 has been
+	// pulling for 5m0s".
+	Message string `json:"message"`
+	// ElapsedSeconds is how long the pull has run.
+	ElapsedSeconds float64 `json:"elapsedSeconds"`
+}
+
+// Notifier evaluates rules against pull events and delivers the resulting
+// notifications to webhooks in the background.
+type Notifier struct {
+	logger   *slog.Logger
+	webhooks map[string]*webhook
+	rules    []*rule
+	queue    chan delivery
+	// retryDelay is the wait before the first retry; it doubles for each
+	// further one.
+	retryDelay time.Duration
+	now        func() time.Time
+
+	mu sync.Mutex
+	// pulls maps pull ID -> the active pulls checked against stalled and
+	// slow rules, as of their latest event.
+	pulls map[string]model.PullStatus
+}
+
+type rule struct {
+	Rule
+	dedupKey    *template.Template
+	dedupWindow time.Duration
+	// sent maps dedup key -> when the rule last notified for it.
+	sent map[string]time.Time
+	// recent holds the times the rule notified within its rate limit interval.
+	recent []time.Time
+}
+
+// New validates cfg and returns a notifier for it. Call Run to start it.
+func New(cfg Config, logger *slog.Logger) (*Notifier, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	n := &Notifier{
+		logger:     logger,
+		webhooks:   make(map[string]*webhook),
+		queue:      make(chan delivery, deliveryQueueSize),
+		retryDelay: time.Second,
+		now:        time.Now,
+		pulls:      make(map[string]model.PullStatus),
+	}
+	for _, w := range cfg.Webhooks {
+		wh, err := newWebhook(w)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %q: %w", w.Name, err)
+		}
+		n.webhooks[w.Name] = wh
+	}
+	for _, r := range cfg.Rules {
+		key := r.DedupKey
+		if key == "" {
+			key = defaultDedupKey
+		}
+		tmpl, err := template.New(r.Name).Funcs(templateFuncs).Option("missingkey=error").Parse(key)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: dedupKey: %w", r.Name, err)
+		}
+		window := r.DedupWindow.Duration
+		if window <= 0 {
+			window = defaultDedupWindow
+		}
+		n.rules = append(n.rules, &rule{Rule: r, dedupKey: tmpl, dedupWindow: window, sent: make(map[string]time.Time)})
+	}
+	return n, nil
+}
+
+// Run delivers notifications and checks active pulls until ctx is cancelled.
+func (n *Notifier) Run(ctx context.Context) {
+	for i := 0; i < deliveryWorkers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case d := <-n.queue:
+					n.deliver(ctx, d)
+				}
+			}
+		}()
+	}
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n.check(n.now())
+		}
+	}
+}
+
+// Observe evaluates the rules against a pull event. It never blocks on
+// delivery.
+func (n *Notifier) Observe(ev model.PullEvent) {
+	if ev.Pull == nil {
+		return
+	}
+	pull := *ev.Pull
+	ev.Pull = &pull
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := n.now()
+	switch ev.Type {
+	case model.EventPullProgress, model.EventPullStalled:
+		n.pulls[pull.ID] = pull
+		n.evaluate(ev, now)
+	case model.EventPullCompleted:
+		delete(n.pulls, pull.ID)
+		n.evaluate(ev, now)
+	}
+}
+
+// Forget stops checking a pull, e.g. one that was force-completed because
+// its agent went silent.
+func (n *Notifier) Forget(pullID string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.pulls, pullID)
+}
+
+// Reset stops checking every pull, for a server replica that no longer
+// holds pull state.
+func (n *Notifier) Reset() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.pulls = make(map[string]model.PullStatus)
+}
+
+// check evaluates stalled and slow rules for pulls that have had no event
+// recently, and forgets dedup keys past their window.
+func (n *Notifier) check(now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, pull := range n.pulls {
+		n.evaluate(model.PullEvent{
+			SchemaVersion: model.SchemaVersion,
+			Timestamp:     now,
+			Type:          model.EventPullProgress,
+			NodeName:      pull.NodeName,
+			Pull:          &pull,
+		}, now)
+	}
+	for _, r := range n.rules {
+		for key, at := range r.sent {
+			if now.Sub(at) >= r.dedupWindow {
+				delete(r.sent, key)
+			}
+		}
+	}
+}
+
+// evaluate notifies every rule that ev's pull meets. n.mu must be held.
+func (n *Notifier) evaluate(ev model.PullEvent, now time.Time) {
+	pull := ev.Pull
+	end := now
+	if pull.CompletedAt != nil {
+		end = *pull.CompletedAt
+	}
+	elapsed := end.Sub(pull.StartedAt)
+
+	for _, r := range n.rules {
+		var msg string
+		switch r.Trigger {
+		case TriggerFailed:
+			if pull.CompletedAt == nil || pull.Error == "" {
+				continue
+			}
+			msg = fmt.Sprintf("%s on %s failed: %s", pull.ImageRef, pull.NodeName, pull.Error)
+		case TriggerStalled:
+			// The server decides when a pull is stalled; after only
+			// delays the notification further.
+			progressAt := pull.StartedAt
+			if pull.LastProgressAt != nil {
+				progressAt = *pull.LastProgressAt
+			}
+			idle := now.Sub(progressAt)
+			if !pull.Stalled || idle < r.After.Duration {
+				continue
+			}
+			msg = fmt.Sprintf("%s on %s has made no progress for %s", pull.ImageRef, pull.NodeName, idle.Round(time.Second))
+		case TriggerSlow:
+			if elapsed <= r.After.Duration {
+				continue
+			}
+			if pull.CompletedAt != nil {
+				msg = fmt.Sprintf("%s on %s took %s", pull.ImageRef, pull.NodeName, elapsed.Round(time.Second))
+			} else {
+				msg = fmt.Sprintf("%s on %s has been pulling for %s", pull.ImageRef, pull.NodeName, elapsed.Round(time.Second))
+			}
+		}
+		n.notify(r, Notification{
+			PullEvent:      ev,
+			Rule:           r.Name,
+			Trigger:        r.Trigger,
+			Message:        msg,
+			ElapsedSeconds: elapsed.Seconds(),
+		}, now)
+	}
+}
+
+// notify queues nt for the rule's webhooks unless it is a duplicate or the
+// rule is over its rate limit. n.mu must be held.
+func (n *Notifier) notify(r *rule, nt Notification, now time.Time) {
+	var buf bytes.Buffer
+	if err := r.dedupKey.Execute(&buf, nt); err != nil {
+		n.logger.Warn("rendering dedup key failed", "rule", r.Name, "error", err)
+		return
+	}
+	key := buf.String()
+	if last, ok := r.sent[key]; ok && now.Sub(last) < r.dedupWindow {
+		return
+	}
+	if !r.allow(now) {
+		metrics.NotificationsRateLimited.WithLabelValues(r.Name).Inc()
+		n.logger.Debug("notification rate limited", "rule", r.Name, "key", key)
+		return
+	}
+	r.sent[key] = now
+
+	n.logger.Info("notifying", "rule", r.Name, "trigger", r.Trigger, "message", nt.Message)
+	for _, name := range r.Webhooks {
+		wh := n.webhooks[name]
+		body, err := wh.render(nt)
+		if err != nil {
+			metrics.Notifications.WithLabelValues(r.Name, name, "failed").Inc()
+			n.logger.Warn("rendering webhook body failed", "rule", r.Name, "webhook", name, "error", err)
+			continue
+		}
+		select {
+		case n.queue <- delivery{webhook: wh, rule: r.Name, id: newDeliveryID(), body: body}:
+		default:
+			metrics.Notifications.WithLabelValues(r.Name, name, "dropped").Inc()
+			n.logger.Warn("notification queue full, dropping notification", "rule", r.Name, "webhook", name)
+		}
+	}
+}
+
+// allow reports whether the rule's rate limit admits one more notification
+// at now, and counts it if so.
+func (r *rule) allow(now time.Time) bool {
+	if r.RateLimit == nil {
+		return true
+	}
+	cutoff := now.Add(-r.RateLimit.Interval.Duration)
+	kept := r.recent[:0]
+	for _, at := range r.recent {
+		if at.After(cutoff) {
+			kept = append(kept, at)
+		}
+	}
+	r.recent = kept
+	if len(r.recent) >= r.RateLimit.Count {
+		return false
+	}
+	r.recent = append(r.recent, now)
+	return true
+}
@@ -0,0 +1,323 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+// receiver is a webhook endpoint that answers with the queued status codes,
+// then 200, and records every request.
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	statuses []int
+	requests []received
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	rcv := &receiver{statuses: statuses}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		rcv.mu.Lock()
+		defer rcv.mu.Unlock()
+		rcv.requests = append(rcv.requests, received{header: r.Header.Clone(), body: body})
+		if len(rcv.statuses) > 0 {
+			w.WriteHeader(rcv.statuses[0])
+			rcv.statuses = rcv.statuses[1:]
+		}
+	}))
+	t.Cleanup(rcv.Close)
+	return rcv
+}
+
+func (rcv *receiver) received() []received {
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	return append([]received(nil), rcv.requests...)
+}
+
+// waitFor waits until the receiver has seen n requests.
+func (rcv *receiver) waitFor(t *testing.T, n int) []received {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := rcv.received()
+		if len(got) >= n {
+			return got
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d webhook requests, want %d", len(got), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// fakeClock is a settable clock for n.now.
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func startNotifier(t *testing.T, cfg Config) (*Notifier, *fakeClock) {
+	t.Helper()
+	n, err := New(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{t: time.Now()}
+	n.now = clock.now
+	n.retryDelay = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go n.Run(ctx)
+	return n, clock
+}
+
+func pullEvent(typ model.EventType, pull model.PullStatus) model.PullEvent {
+	return model.PullEvent{
+		SchemaVersion: model.SchemaVersion,
+		Timestamp:     time.Now(),
+		Type:          typ,
+		NodeName:      pull.NodeName,
+		Pull:          &pull,
+	}
+}
+
+func duration(d time.Duration) metav1.Duration { return metav1.Duration{Duration: d} }
+
+func TestNotifier_FailedPull(t *testing.T) {
+	rcv := newReceiver(t)
+	secret := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	urlFile := filepath.Join(t.TempDir(), "url")
+	if err := os.WriteFile(urlFile, []byte(rcv.URL+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	n, clock := startNotifier(t, Config{
+		Webhooks: []Webhook{{
+			Name:       "chat",
+			URLFile:    urlFile,
+			SecretFile: secret,
+			Headers:    map[string]string{"X-Api-Key": "abc"},
+			Template:   `{"text": {{ printf "[%s] %s" .Rule .Message | json }}, "image": {{ json .Pull.ImageRef }}}`,
+		}},
+		Rules: []Rule{{Name: "pull-failed", Trigger: TriggerFailed, Webhooks: []string{"chat"}}},
+	})
+
+	pull := model.PullStatus{ID: "node1:nginx:bad@1", NodeName: "node1", ImageRef: "nginx:bad", StartedAt: clock.now()}
+	n.Observe(pullEvent(model.EventPullProgress, pull))
+	completed := clock.now()
+	pull.CompletedAt = &completed
+	pull.Error = "manifest unknown"
+	n.Observe(pullEvent(model.EventPullCompleted, pull))
+	// A duplicate completion is not notified twice.
+	n.Observe(pullEvent(model.EventPullCompleted, pull))
+
+	got := rcv.waitFor(t, 1)
+	var body struct{ Text, Image string }
+	if err := json.Unmarshal(got[0].body, &body); err != nil {
+		t.Fatalf("body %s: %v", got[0].body, err)
+	}
+	if body.Text != "[pull-failed] nginx:bad on node1 failed: manifest unknown" || body.Image != "nginx:bad" {
+		t.Errorf("body = %+v", body)
+	}
+	h := got[0].header
+	if h.Get("Content-Type") != "application/json" || h.Get("X-Api-Key") != "abc" || h.Get(DeliveryHeader) == "" {
+		t.Errorf("headers = %v", h)
+	}
+	if want := Sign([]byte("s3cret"), h.Get(TimestampHeader), got[0].body); h.Get(SignatureHeader) != want {
+		t.Errorf("signature = %q, want %q", h.Get(SignatureHeader), want)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if len(rcv.received()) != 1 {
+		t.Errorf("got %d requests, want 1", len(rcv.received()))
+	}
+}
+
+func TestNotifier_StalledAndSlow(t *testing.T) {
+	rcv := newReceiver(t)
+	n, clock := startNotifier(t, Config{
+		Webhooks: []Webhook{{Name: "incidents", URL: rcv.URL}},
+		Rules: []Rule{
+			{Name: "stalled", Trigger: TriggerStalled, After: duration(time.Minute), Webhooks: []string{"incidents"}},
+			{Name: "slow", Trigger: TriggerSlow, After: duration(5 * time.Minute), Webhooks: []string{"incidents"}},
+		},
+	})
+
+	start := clock.now()
+	pull := model.PullStatus{ID: "node1:big:1@1", NodeName: "node1", ImageRef: "big:1", StartedAt: start, DownloadedBytes: 10, LastProgressAt: &start}
+	n.Observe(pullEvent(model.EventPullProgress, pull))
+
+	// Only the server decides that a pull is stalled.
+	clock.advance(75 * time.Second)
+	n.check(clock.now())
+	time.Sleep(50 * time.Millisecond)
+	if len(rcv.received()) != 0 {
+		t.Fatalf("got %d requests for a pull the server has not marked stalled", len(rcv.received()))
+	}
+	pull.Stalled = true
+	n.Observe(pullEvent(model.EventPullStalled, pull))
+
+	got := rcv.waitFor(t, 1)
+	var nt Notification
+	if err := json.Unmarshal(got[0].body, &nt); err != nil {
+		t.Fatal(err)
+	}
+	if nt.Rule != "stalled" || nt.Trigger != TriggerStalled || nt.Pull.ID != pull.ID || nt.Message != "big:1 on node1 has made no progress for 1m15s" {
+		t.Errorf("stalled notification = %+v", nt)
+	}
+
+	// The pull recovers and then runs past the slow threshold.
+	progressAt := clock.now()
+	pull.DownloadedBytes, pull.Stalled, pull.LastProgressAt = 20, false, &progressAt
+	n.Observe(pullEvent(model.EventPullProgress, pull))
+	clock.advance(4 * time.Minute)
+	n.check(clock.now())
+	clock.advance(time.Minute)
+	pull.DownloadedBytes = 30
+	n.Observe(pullEvent(model.EventPullProgress, pull))
+
+	got = rcv.waitFor(t, 2)
+	if err := json.Unmarshal(got[1].body, &nt); err != nil {
+		t.Fatal(err)
+	}
+	if nt.Rule != "slow" || nt.ElapsedSeconds < 300 {
+		t.Errorf("slow notification = %+v", nt)
+	}
+
+	// Completed pulls are no longer checked.
+	completed := clock.now()
+	pull.CompletedAt = &completed
+	n.Observe(pullEvent(model.EventPullCompleted, pull))
+	clock.advance(10 * time.Minute)
+	n.check(clock.now())
+	time.Sleep(50 * time.Millisecond)
+	if len(rcv.received()) != 2 {
+		t.Errorf("got %d requests, want 2", len(rcv.received()))
+	}
+}
+
+func TestNotifier_Retries(t *testing.T) {
+	flaky := newReceiver(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	rejecting := newReceiver(t, http.StatusBadRequest)
+	retries := 1
+	n, clock := startNotifier(t, Config{
+		Webhooks: []Webhook{
+			{Name: "flaky", URL: flaky.URL},
+			{Name: "rejecting", URL: rejecting.URL, MaxRetries: &retries},
+		},
+		Rules: []Rule{{Name: "failed", Trigger: TriggerFailed, Webhooks: []string{"flaky", "rejecting"}}},
+	})
+
+	now := clock.now()
+	n.Observe(pullEvent(model.EventPullCompleted, model.PullStatus{ID: "a", StartedAt: now, CompletedAt: &now, Error: "boom"}))
+
+	got := flaky.waitFor(t, 3)
+	for _, r := range got[1:] {
+		if r.header.Get(DeliveryHeader) != got[0].header.Get(DeliveryHeader) {
+			t.Error("retries changed the delivery ID")
+		}
+	}
+	rejecting.waitFor(t, 1)
+	time.Sleep(50 * time.Millisecond)
+	if len(flaky.received()) != 3 || len(rejecting.received()) != 1 {
+		t.Errorf("got %d and %d requests, want 3 and 1", len(flaky.received()), len(rejecting.received()))
+	}
+}
+
+func TestNotifier_DedupKeyAndRateLimit(t *testing.T) {
+	rcv := newReceiver(t)
+	n, clock := startNotifier(t, Config{
+		Webhooks: []Webhook{{Name: "chat", URL: rcv.URL}},
+		Rules: []Rule{{
+			Name:      "failed",
+			Trigger:   TriggerFailed,
+			Webhooks:  []string{"chat"},
+			DedupKey:  "{{ .Pull.ImageRef }}",
+			RateLimit: &RateLimit{Count: 2, Interval: duration(time.Hour)},
+		}},
+	})
+
+	fail := func(id, node, image string) {
+		now := clock.now()
+		n.Observe(pullEvent(model.EventPullCompleted, model.PullStatus{
+			ID: id, NodeName: node, ImageRef: image, StartedAt: now, CompletedAt: &now, Error: "denied",
+		}))
+	}
+	fail("1", "node1", "nginx:bad")
+	fail("2", "node2", "nginx:bad") // same dedup key
+	fail("3", "node1", "redis:bad")
+	fail("4", "node1", "etcd:bad") // over the rate limit
+	rcv.waitFor(t, 2)
+
+	// Once the dedup window has passed, the image is notified again.
+	clock.advance(2 * time.Hour)
+	n.check(clock.now())
+	fail("5", "node3", "nginx:bad")
+	got := rcv.waitFor(t, 3)
+	time.Sleep(50 * time.Millisecond)
+	if len(rcv.received()) != 3 {
+		t.Fatalf("got %d requests, want 3", len(rcv.received()))
+	}
+	var images []string
+	for _, r := range got {
+		var nt Notification
+		json.Unmarshal(r.body, &nt) //nolint:errcheck
+		images = append(images, nt.Pull.ImageRef)
+	}
+	if images[2] != "nginx:bad" {
+		t.Errorf("notified images %v", images)
+	}
+}
+
+func TestNew_InvalidTemplate(t *testing.T) {
+	_, err := New(Config{
+		Webhooks: []Webhook{{Name: "chat", URL: "http://x", Template: "{{ .Pull.ImageRef"}},
+		Rules:    []Rule{{Name: "failed", Trigger: TriggerFailed, Webhooks: []string{"chat"}}},
+	}, slog.Default())
+	if err == nil {
+		t.Error("expected a template parse error")
+	}
+}
+
+func TestWebhookRender_InvalidJSON(t *testing.T) {
+	wh, err := newWebhook(Webhook{Name: "chat", URL: "http://x", Template: `{"text": {{ .Message }}}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wh.render(Notification{Message: "not quoted"}); err == nil {
+		t.Error("expected an error for a body that is not JSON")
+	}
+}
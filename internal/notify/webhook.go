@@ -0,0 +1,182 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/metrics"
+	"github.com/d44b/pulltrace/internal/version"
+)
+
+const (
+	// DeliveryHeader carries an ID that stays the same across retries of
+	// one notification, so receivers can drop duplicates.
+	DeliveryHeader = "X-Pulltrace-Delivery"
+	// TimestampHeader and SignatureHeader are set on webhooks with a
+	// secret; see Sign.
+	TimestampHeader = "X-Pulltrace-Timestamp"
+	SignatureHeader = "X-Pulltrace-Signature"
+
+	// maxRetryDelay caps the exponential backoff between retries.
+	maxRetryDelay = 30 * time.Second
+)
+
+var templateFuncs = template.FuncMap{
+	// json renders a value as JSON, e.g. a quoted and escaped string.
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// Sign returns the SignatureHeader value for a body sent at timestamp (Unix
+// seconds, as in TimestampHeader): "sha256=" followed by the hex
+// HMAC-SHA256 of "<timestamp>.<body>" keyed with secret. Surrounding
+// whitespace in a secret file is ignored.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, bytes.TrimSpace(secret))
+	mac.Write([]byte(timestamp + ".")) //nolint:errcheck
+	mac.Write(body)                    //nolint:errcheck
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type webhook struct {
+	Webhook
+	template   *template.Template
+	maxRetries int
+	client     *http.Client
+}
+
+type delivery struct {
+	webhook *webhook
+	rule    string
+	id      string
+	body    []byte
+}
+
+func newWebhook(w Webhook) (*webhook, error) {
+	wh := &webhook{Webhook: w, maxRetries: defaultMaxRetries}
+	if w.MaxRetries != nil {
+		wh.maxRetries = *w.MaxRetries
+	}
+	timeout := w.Timeout.Duration
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	wh.client = &http.Client{Timeout: timeout}
+	if w.Template != "" {
+		tmpl, err := template.New(w.Name).Funcs(templateFuncs).Option("missingkey=error").Parse(w.Template)
+		if err != nil {
+			return nil, fmt.Errorf("template: %w", err)
+		}
+		wh.template = tmpl
+	}
+	return wh, nil
+}
+
+// render returns the request body for nt.
+func (w *webhook) render(nt Notification) ([]byte, error) {
+	if w.template == nil {
+		return json.Marshal(nt)
+	}
+	var buf bytes.Buffer
+	if err := w.template.Execute(&buf, nt); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("template did not render valid JSON: %s", strings.TrimSpace(buf.String()))
+	}
+	return buf.Bytes(), nil
+}
+
+// deliver posts d, retrying network errors, 429 and 5xx responses with
+// exponential backoff.
+func (n *Notifier) deliver(ctx context.Context, d delivery) {
+	wait := n.retryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := d.webhook.post(ctx, d)
+		if err == nil {
+			metrics.Notifications.WithLabelValues(d.rule, d.webhook.Name, "sent").Inc()
+			return
+		}
+		if !retry || attempt >= d.webhook.maxRetries {
+			metrics.Notifications.WithLabelValues(d.rule, d.webhook.Name, "failed").Inc()
+			n.logger.Warn("webhook delivery failed",
+				"rule", d.rule,
+				"webhook", d.webhook.Name,
+				"attempts", attempt+1,
+				"error", err,
+			)
+			return
+		}
+		n.logger.Debug("retrying webhook delivery", "webhook", d.webhook.Name, "in", wait, "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait = min(2*wait, maxRetryDelay)
+	}
+}
+
+// post sends d once and reports whether a failure is worth retrying.
+func (w *webhook) post(ctx context.Context, d delivery) (retry bool, err error) {
+	url := w.URL
+	if w.URLFile != "" {
+		data, err := os.ReadFile(w.URLFile)
+		if err != nil {
+			return true, fmt.Errorf("reading url: %w", err)
+		}
+		url = strings.TrimSpace(string(data))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(d.body))
+	if err != nil {
+		return false, err
+	}
+	for k, v := range w.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pulltrace/"+version.Version)
+	req.Header.Set(DeliveryHeader, d.id)
+	if w.SecretFile != "" {
+		secret, err := os.ReadFile(w.SecretFile)
+		if err != nil {
+			return true, fmt.Errorf("reading secret: %w", err)
+		}
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, ts)
+		req.Header.Set(SignatureHeader, Sign(secret, ts, d.body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10)) //nolint:errcheck
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500,
+		fmt.Errorf("unexpected status %s", resp.Status)
+}
+
+func newDeliveryID() string {
+	b := make([]byte, 16)
+	rand.Read(b) //nolint:errcheck
+	return hex.EncodeToString(b)
+}
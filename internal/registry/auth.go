@@ -0,0 +1,74 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// dockerAuth is one entry of a Docker config file's auths.
+type dockerAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// ParseDockerConfig reads the credentials of a kubernetes.io/dockerconfigjson
+// secret (a Docker config.json) or, if legacy is set, of a
+// kubernetes.io/dockercfg secret. Hosts are keyed as written in the file.
+func ParseDockerConfig(data []byte, legacy bool) (map[string]Credentials, error) {
+	var auths map[string]dockerAuth
+	if legacy {
+		if err := json.Unmarshal(data, &auths); err != nil {
+			return nil, fmt.Errorf("parsing .dockercfg: %w", err)
+		}
+	} else {
+		var cfg struct {
+			Auths map[string]dockerAuth `json:"auths"`
+		}
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, fmt.Errorf("parsing .dockerconfigjson: %w", err)
+		}
+		auths = cfg.Auths
+	}
+
+	creds := make(map[string]Credentials, len(auths))
+	for host, a := range auths {
+		c := Credentials{Username: a.Username, Password: a.Password, IdentityToken: a.IdentityToken}
+		if a.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("decoding auth for %s: %w", host, err)
+			}
+			user, pass, ok := strings.Cut(string(decoded), ":")
+			if !ok {
+				return nil, fmt.Errorf("auth for %s is not user:password", host)
+			}
+			c.Username, c.Password = user, pass
+		}
+		creds[host] = c
+	}
+	return creds, nil
+}
+
+// lookupCredentials finds the credentials for registry, matching keys
+// written as bare hosts or as URLs, and Docker Hub under any of its names.
+func lookupCredentials(auths map[string]Credentials, registry string) (Credentials, bool) {
+	for key, c := range auths {
+		host := key
+		if i := strings.Index(host, "://"); i >= 0 {
+			host = host[i+3:]
+		}
+		host, _, _ = strings.Cut(host, "/")
+		switch host {
+		case "index.docker.io", "registry-1.docker.io":
+			host = "docker.io"
+		}
+		if host == registry {
+			return c, true
+		}
+	}
+	return Credentials{}, false
+}
@@ -0,0 +1,365 @@
+// Package registry parses image references and looks up image sizes from the
+// manifests served by OCI distribution registries, so a pull's total is known
+// before its layers start downloading.
+package registry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/distribution/reference"
+)
+
+// Manifest media types accepted from registries.
+const (
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+)
+
+// maxManifestSize bounds manifest and token responses.
+const maxManifestSize = 4 << 20
+
+// Platform selects a manifest from a multi-arch index. An empty Platform
+// selects the first one.
+type Platform struct {
+	OS           string
+	Architecture string
+	Variant      string
+}
+
+// Credentials authenticate to one registry.
+type Credentials struct {
+	Username string
+	Password string
+	// IdentityToken is an OAuth2 refresh token, used instead of a password
+	// by some registries.
+	IdentityToken string
+}
+
+// Reference is a parsed image reference.
+type Reference struct {
+	// Registry is the registry host as written in image references, with
+	// docker.io for Docker Hub.
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference parses an image reference such as "nginx:1.27",
+// "ghcr.io/org/app@sha256:..." or "localhost:5000/app" with the OCI
+// distribution grammar. References without a tag or digest use "latest".
+func ParseReference(ref string) (Reference, error) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return Reference{}, fmt.Errorf("invalid image reference %q: %w", ref, err)
+	}
+	r := Reference{Registry: reference.Domain(named), Repository: reference.Path(named)}
+	if tagged, ok := named.(reference.Tagged); ok {
+		r.Tag = tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		r.Digest = digested.Digest().String()
+	}
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+	return r, nil
+}
+
+// Name returns the full repository name, such as docker.io/library/nginx.
+func (r Reference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+// String returns the canonical form of the reference: the full name with
+// the digest if it is pinned to one, and with the tag otherwise. A digest
+// identifies the image whatever the tag says.
+func (r Reference) String() string {
+	if r.Digest != "" {
+		return r.Name() + "@" + r.Digest
+	}
+	return r.Name() + ":" + r.Tag
+}
+
+// Canonical returns the canonical form of the image reference ref, so that
+// "nginx", "docker.io/library/nginx:latest" and "index.docker.io/nginx" are
+// equal. Strings that are not references, such as content digests, are
+// returned as they are.
+func Canonical(ref string) string {
+	if strings.HasPrefix(ref, "sha256:") {
+		return ref
+	}
+	r, err := ParseReference(ref)
+	if err != nil {
+		return ref
+	}
+	return r.String()
+}
+
+// apiHost is the host serving the registry API.
+func (r Reference) apiHost() string {
+	if r.Registry == "docker.io" {
+		return "registry-1.docker.io"
+	}
+	return r.Registry
+}
+
+// Client fetches manifests from registries.
+type Client struct {
+	// HTTPClient is used for every request. Registries are reached over
+	// HTTPS.
+	HTTPClient *http.Client
+}
+
+// ImageSize returns the compressed size of the layers of the image ref for
+// platform, following a multi-arch index to the platform's manifest. auths
+// maps registry hosts, as found in Docker config files, to credentials.
+func (c *Client) ImageSize(ctx context.Context, ref string, platform Platform, auths map[string]Credentials) (int64, error) {
+	r, err := ParseReference(ref)
+	if err != nil {
+		return 0, err
+	}
+	creds, _ := lookupCredentials(auths, r.Registry)
+	s := &session{client: c.httpClient(), ref: r, creds: creds}
+
+	tagOrDigest := r.Digest
+	if tagOrDigest == "" {
+		tagOrDigest = r.Tag
+	}
+	m, err := s.manifest(ctx, tagOrDigest)
+	if err != nil {
+		return 0, err
+	}
+	if len(m.Manifests) > 0 {
+		digest, err := selectPlatform(m.Manifests, platform)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", ref, err)
+		}
+		if m, err = s.manifest(ctx, digest); err != nil {
+			return 0, err
+		}
+	}
+	if len(m.Layers) == 0 {
+		return 0, fmt.Errorf("%s: manifest lists no layers", ref)
+	}
+	var size int64
+	for _, l := range m.Layers {
+		size += l.Size
+	}
+	return size, nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// manifest is the part of an image manifest or index needed for sizes.
+type manifest struct {
+	MediaType string       `json:"mediaType"`
+	Manifests []descriptor `json:"manifests"`
+	Layers    []descriptor `json:"layers"`
+}
+
+type descriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+	Platform  *struct {
+		OS           string `json:"os"`
+		Architecture string `json:"architecture"`
+		Variant      string `json:"variant"`
+	} `json:"platform"`
+}
+
+// selectPlatform returns the digest of the index entry for platform,
+// preferring an exact variant match.
+func selectPlatform(entries []descriptor, platform Platform) (string, error) {
+	if platform.OS == "" && platform.Architecture == "" {
+		return entries[0].Digest, nil
+	}
+	var match string
+	for _, e := range entries {
+		p := e.Platform
+		if p == nil || p.OS != platform.OS || p.Architecture != platform.Architecture {
+			continue
+		}
+		if platform.Variant == "" || p.Variant == platform.Variant {
+			return e.Digest, nil
+		}
+		if match == "" {
+			match = e.Digest
+		}
+	}
+	if match == "" {
+		return "", fmt.Errorf("no manifest for platform %s/%s", platform.OS, platform.Architecture)
+	}
+	return match, nil
+}
+
+// session fetches manifests of one repository, authenticating once.
+type session struct {
+	client *http.Client
+	ref    Reference
+	creds  Credentials
+	// auth is the Authorization header to send, once a challenge was met.
+	auth string
+}
+
+func (s *session) manifest(ctx context.Context, tagOrDigest string) (*manifest, error) {
+	u := (&url.URL{Scheme: "https", Host: s.ref.apiHost(), Path: "/v2/" + s.ref.Repository + "/manifests/" + tagOrDigest}).String()
+	resp, err := s.get(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var m manifest
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&m); err != nil {
+		return nil, fmt.Errorf("decoding manifest %s: %w", u, err)
+	}
+	return &m, nil
+}
+
+// get fetches u, answering one authentication challenge.
+func (s *session) get(ctx context.Context, u string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", strings.Join([]string{mediaTypeOCIIndex, mediaTypeDockerManifestList, mediaTypeOCIManifest, mediaTypeDockerManifest}, ", "))
+		if s.auth != "" {
+			req.Header.Set("Authorization", s.auth)
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusOK {
+			return resp, nil
+		}
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized || attempt > 0 || challenge == "" {
+			return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+		}
+		if err := s.authenticate(ctx, challenge); err != nil {
+			return nil, fmt.Errorf("GET %s: %w", u, err)
+		}
+	}
+}
+
+// isLoopback reports whether host names the local machine.
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// authenticate answers a WWW-Authenticate challenge, fetching a bearer token
+// from the registry's token service if asked to.
+func (s *session) authenticate(ctx context.Context, challenge string) error {
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if s.creds.Username == "" {
+			return errors.New("registry requires credentials")
+		}
+		s.auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(s.creds.Username+":"+s.creds.Password))
+		return nil
+	case "bearer":
+	default:
+		return fmt.Errorf("unsupported authentication scheme %q", scheme)
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || realm.Scheme == "" {
+		return fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+	// The token request carries the pull secret, so it must not leave the
+	// node in clear text.
+	if realm.Scheme != "https" && !isLoopback(realm.Hostname()) {
+		return fmt.Errorf("token realm %q is not https", params["realm"])
+	}
+	q := realm.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	scope := params["scope"]
+	if scope == "" {
+		scope = "repository:" + s.ref.Repository + ":pull"
+	}
+	q.Set("scope", scope)
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	switch {
+	case s.creds.IdentityToken != "":
+		req.SetBasicAuth("<token>", s.creds.IdentityToken)
+	case s.creds.Username != "":
+		req.SetBasicAuth(s.creds.Username, s.creds.Password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("token request: %s", resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxManifestSize)).Decode(&token); err != nil {
+		return fmt.Errorf("decoding token: %w", err)
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return errors.New("token service returned no token")
+	}
+	s.auth = "Bearer " + token.Token
+	return nil
+}
+
+// parseChallenge splits a WWW-Authenticate header such as
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`
+// into its scheme and parameters.
+func parseChallenge(h string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(h), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var key, value string
+		key, rest, _ = strings.Cut(strings.TrimLeft(rest, " ,"), "=")
+		if strings.HasPrefix(rest, `"`) {
+			value, rest, _ = strings.Cut(rest[1:], `"`)
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		if key = strings.TrimSpace(key); key != "" {
+			params[strings.ToLower(key)] = value
+		}
+	}
+	return scheme, params
+}
@@ -0,0 +1,223 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want Reference
+	}{
+		{"nginx", Reference{Registry: "docker.io", Repository: "library/nginx", Tag: "latest"}},
+		{"nginx:1.27", Reference{Registry: "docker.io", Repository: "library/nginx", Tag: "1.27"}},
+		{"bitnami/redis:7", Reference{Registry: "docker.io", Repository: "bitnami/redis", Tag: "7"}},
+		{"index.docker.io/library/nginx:1.27", Reference{Registry: "docker.io", Repository: "library/nginx", Tag: "1.27"}},
+		{"ghcr.io/d44b/app@" + arm64Digest, Reference{Registry: "ghcr.io", Repository: "d44b/app", Digest: arm64Digest}},
+		{"ghcr.io/d44b/app:v1@" + arm64Digest, Reference{Registry: "ghcr.io", Repository: "d44b/app", Tag: "v1", Digest: arm64Digest}},
+		{"localhost:5000/app", Reference{Registry: "localhost:5000", Repository: "app", Tag: "latest"}},
+		{"localhost/app:v2", Reference{Registry: "localhost", Repository: "app", Tag: "v2"}},
+		{"registry.example:5000/team/app:v3", Reference{Registry: "registry.example:5000", Repository: "team/app", Tag: "v3"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseReference(tt.ref)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseReference(%q) = %+v, %v; want %+v", tt.ref, got, err, tt.want)
+		}
+	}
+	for _, ref := range []string{"@" + arm64Digest, "app@sha256:abc", "Nginx:1.27", ""} {
+		if _, err := ParseReference(ref); err == nil {
+			t.Errorf("ParseReference(%q) should fail", ref)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{"nginx", "docker.io/library/nginx:latest"},
+		{"index.docker.io/nginx:1.27", "docker.io/library/nginx:1.27"},
+		{"localhost/app", "localhost/app:latest"},
+		{"registry.example:5000/app", "registry.example:5000/app:latest"},
+		// A digest names the image, so the tag beside it is dropped.
+		{"ghcr.io/d44b/app:v1@" + arm64Digest, "ghcr.io/d44b/app@" + arm64Digest},
+		{arm64Digest, arm64Digest},
+		{"__pulling__", "__pulling__"},
+	}
+	for _, tt := range tests {
+		if got := Canonical(tt.ref); got != tt.want {
+			t.Errorf("Canonical(%q) = %q, want %q", tt.ref, got, tt.want)
+		}
+	}
+}
+
+// arm64Digest is the digest of the stand-in registry's linux/arm64 manifest.
+const arm64Digest = "sha256:4b1e7a2c9f0d3e6a8b5c7d9e1f2a3b4c5d6e7f8091a2b3c4d5e6f708192a3b4c"
+
+// newRegistry starts a stand-in OCI registry serving a two-platform index
+// for app:v1 behind bearer token auth for user:secret.
+func newRegistry(t *testing.T) *httptest.Server {
+	t.Helper()
+	var srv *httptest.Server
+	manifests := map[string]any{
+		"v1": map[string]any{
+			"mediaType": mediaTypeOCIIndex,
+			"manifests": []map[string]any{
+				{"digest": "sha256:amd64", "platform": map[string]string{"os": "linux", "architecture": "amd64"}},
+				{"digest": "sha256:armv7", "platform": map[string]string{"os": "linux", "architecture": "arm", "variant": "v7"}},
+				{"digest": arm64Digest, "platform": map[string]string{"os": "linux", "architecture": "arm64", "variant": "v8"}},
+			},
+		},
+		"sha256:amd64": map[string]any{"mediaType": mediaTypeOCIManifest, "layers": []map[string]any{{"size": 1000}, {"size": 234}}},
+		arm64Digest:    map[string]any{"mediaType": mediaTypeOCIManifest, "layers": []map[string]any{{"size": 900}}},
+		"v2":           map[string]any{"mediaType": mediaTypeDockerManifest, "layers": []map[string]any{{"size": 42}}},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "user" || pass != "secret" || r.URL.Query().Get("scope") != "repository:team/app:pull" {
+			http.Error(w, "denied", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": "t0k3n"}) //nolint:errcheck
+	})
+	mux.HandleFunc("/v2/team/app/manifests/{ref}", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0k3n" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+srv.URL+`/token",service="stand-in",scope="repository:team/app:pull"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if !strings.Contains(r.Header.Get("Accept"), mediaTypeOCIIndex) {
+			http.Error(w, "index not accepted", http.StatusNotAcceptable)
+			return
+		}
+		m, ok := manifests[r.PathValue("ref")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(m) //nolint:errcheck
+	})
+	srv = httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestImageSize(t *testing.T) {
+	srv := newRegistry(t)
+	host := strings.TrimPrefix(srv.URL, "https://")
+	c := &Client{HTTPClient: srv.Client()}
+	ctx := context.Background()
+	auths := map[string]Credentials{"https://" + host + "/v1/": {Username: "user", Password: "secret"}}
+
+	tests := []struct {
+		ref      string
+		platform Platform
+		want     int64
+	}{
+		{host + "/team/app:v1", Platform{OS: "linux", Architecture: "amd64"}, 1234},
+		{host + "/team/app:v1", Platform{OS: "linux", Architecture: "arm64"}, 900},
+		{host + "/team/app:v1", Platform{}, 1234},
+		{host + "/team/app:v2", Platform{OS: "linux", Architecture: "amd64"}, 42},
+		{host + "/team/app@" + arm64Digest, Platform{}, 900},
+	}
+	for _, tt := range tests {
+		got, err := c.ImageSize(ctx, tt.ref, tt.platform, auths)
+		if err != nil || got != tt.want {
+			t.Errorf("ImageSize(%s, %+v) = %d, %v; want %d", tt.ref, tt.platform, got, err, tt.want)
+		}
+	}
+
+	if _, err := c.ImageSize(ctx, host+"/team/app:v1", Platform{OS: "windows", Architecture: "amd64"}, auths); err == nil {
+		t.Error("ImageSize for a missing platform should fail")
+	}
+	if _, err := c.ImageSize(ctx, host+"/team/app:v1", Platform{}, nil); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("ImageSize without credentials error = %v, want a 401", err)
+	}
+	if _, err := c.ImageSize(ctx, host+"/team/app:v3", Platform{}, auths); err == nil {
+		t.Error("ImageSize of an unknown tag should fail")
+	}
+}
+
+func TestImageSize_PlainHTTPRealm(t *testing.T) {
+	var tokenRequests int
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tokenRequests++
+		json.NewEncoder(w).Encode(map[string]string{"token": "t0k3n"}) //nolint:errcheck
+	}))
+	t.Cleanup(tokens.Close)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://tokens.example.com/token"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	}))
+	t.Cleanup(srv.Close)
+	// Route every host to the token server, so a token request would reach it.
+	client := srv.Client()
+	client.Transport.(*http.Transport).Proxy = func(r *http.Request) (*url.URL, error) {
+		if r.URL.Scheme == "http" {
+			return url.Parse(tokens.URL)
+		}
+		return nil, nil
+	}
+	host := strings.TrimPrefix(srv.URL, "https://")
+	c := &Client{HTTPClient: client}
+	auths := map[string]Credentials{host: {Username: "user", Password: "secret"}}
+
+	_, err := c.ImageSize(context.Background(), host+"/team/app:v1", Platform{}, auths)
+	if err == nil || !strings.Contains(err.Error(), "not https") {
+		t.Errorf("ImageSize error = %v, want a rejected realm", err)
+	}
+	if tokenRequests != 0 {
+		t.Errorf("credentials were sent to a plain-HTTP realm %d times", tokenRequests)
+	}
+}
+
+func TestSelectPlatform_PrefersVariant(t *testing.T) {
+	var entries []descriptor
+	json.Unmarshal([]byte(`[
+		{"digest": "sha256:v6", "platform": {"os": "linux", "architecture": "arm", "variant": "v6"}},
+		{"digest": "sha256:v7", "platform": {"os": "linux", "architecture": "arm", "variant": "v7"}}
+	]`), &entries) //nolint:errcheck
+
+	if got, _ := selectPlatform(entries, Platform{OS: "linux", Architecture: "arm", Variant: "v7"}); got != "sha256:v7" {
+		t.Errorf("arm/v7 selected %s", got)
+	}
+	if got, _ := selectPlatform(entries, Platform{OS: "linux", Architecture: "arm"}); got != "sha256:v6" {
+		t.Errorf("arm selected %s", got)
+	}
+}
+
+func TestParseDockerConfig(t *testing.T) {
+	creds, err := ParseDockerConfig([]byte(`{"auths": {
+		"https://index.docker.io/v1/": {"auth": "dXNlcjpwYXNzOndvcmQ="},
+		"ghcr.io": {"username": "bot", "password": "pat"}
+	}}`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := lookupCredentials(creds, "docker.io"); !ok || c.Username != "user" || c.Password != "pass:word" {
+		t.Errorf("docker.io credentials = %+v, %v", c, ok)
+	}
+	if c, ok := lookupCredentials(creds, "ghcr.io"); !ok || c.Username != "bot" {
+		t.Errorf("ghcr.io credentials = %+v, %v", c, ok)
+	}
+	if _, ok := lookupCredentials(creds, "quay.io"); ok {
+		t.Error("quay.io should have no credentials")
+	}
+
+	legacy, err := ParseDockerConfig([]byte(`{"quay.io": {"auth": "YTpi"}}`), true)
+	if err != nil || legacy["quay.io"].Username != "a" {
+		t.Errorf("legacy config = %+v, %v", legacy, err)
+	}
+	if _, err := ParseDockerConfig([]byte(`{"auths": {"x": {"auth": "bm9jb2xvbg=="}}}`), false); err == nil {
+		t.Error("auth without a colon should fail")
+	}
+}
@@ -0,0 +1,216 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/metrics"
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+const (
+	// defaultAgentHeartbeat is assumed for agents that do not announce one.
+	defaultAgentHeartbeat = 10 * time.Second
+
+	// agentLivenessFactor is how many heartbeats an agent may miss before it
+	// is considered disconnected.
+	agentLivenessFactor = 3
+)
+
+// agentRecord is the server's view of a single reporting agent.
+type agentRecord struct {
+	nodeName     string
+	version      string
+	protocol     int
+	capabilities []model.Capability
+	lastReport   time.Time
+	lag          time.Duration
+	heartbeat    time.Duration
+	reports      int64
+	pollErrors   int64
+	reportErrors int64
+}
+
+// live reports whether the agent has reported within its liveness deadline.
+func (a *agentRecord) live(now time.Time) bool {
+	return now.Sub(a.lastReport) <= agentLivenessFactor*a.heartbeat
+}
+
+func (a *agentRecord) hasCapability(c model.Capability) bool {
+	for _, have := range a.capabilities {
+		if have == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *agentRecord) status(now time.Time) model.AgentStatus {
+	return model.AgentStatus{
+		NodeName:         a.nodeName,
+		AgentVersion:     a.version,
+		ProtocolVersion:  a.protocol,
+		Capabilities:     a.capabilities,
+		LastReport:       a.lastReport,
+		ReportLagSeconds: a.lag.Seconds(),
+		HeartbeatSeconds: a.heartbeat.Seconds(),
+		Connected:        a.live(now),
+		Reports:          a.reports,
+		PollErrors:       a.pollErrors,
+		ReportErrors:     a.reportErrors,
+	}
+}
+
+// agentRegistry tracks every agent that has reported to this server. Agent
+// reports double as heartbeats, so an idle node that sends empty reports is
+// still considered live.
+type agentRegistry struct {
+	mu     sync.RWMutex
+	agents map[string]*agentRecord
+}
+
+func newAgentRegistry() *agentRegistry {
+	return &agentRegistry{agents: make(map[string]*agentRecord)}
+}
+
+// observe records a report received at now. Optional report fields are only
+// trusted when the agent declared the matching capability.
+func (r *agentRegistry) observe(report model.AgentReport, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec, ok := r.agents[report.NodeName]
+	if !ok {
+		rec = &agentRecord{nodeName: report.NodeName}
+		r.agents[report.NodeName] = rec
+	}
+	if rec.version != report.AgentVersion {
+		if rec.version != "" {
+			metrics.AgentInfo.DeleteLabelValues(rec.nodeName, rec.version)
+		}
+		rec.version = report.AgentVersion
+	}
+	rec.protocol = report.Version()
+	rec.capabilities = report.Capabilities
+	rec.lastReport = now
+	rec.lag = 0
+	if !report.Timestamp.IsZero() {
+		rec.lag = now.Sub(report.Timestamp)
+	}
+	rec.heartbeat = defaultAgentHeartbeat
+	if report.HasCapability(model.CapabilityHeartbeat) && report.HeartbeatSeconds > 0 {
+		rec.heartbeat = time.Duration(report.HeartbeatSeconds * float64(time.Second))
+	}
+	rec.reports++
+	if report.HasCapability(model.CapabilityAgentStats) {
+		rec.pollErrors = report.PollErrors
+		rec.reportErrors = report.ReportErrors
+	}
+
+	metrics.AgentInfo.WithLabelValues(rec.nodeName, rec.version).Set(1)
+	metrics.AgentLastReport.WithLabelValues(rec.nodeName).Set(float64(now.Unix()))
+	metrics.AgentReportLag.WithLabelValues(rec.nodeName).Set(rec.lag.Seconds())
+}
+
+// liveness returns when the agent on node last reported and whether it
+// declared the heartbeat capability.
+func (r *agentRegistry) liveness(node string) (last time.Time, heartbeats bool, ok bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	rec, ok := r.agents[node]
+	if !ok {
+		return time.Time{}, false, false
+	}
+	return rec.lastReport, rec.hasCapability(model.CapabilityHeartbeat), true
+}
+
+// connected counts agents that are within their liveness deadline.
+func (r *agentRegistry) connected(now time.Time) int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	n := 0
+	for _, rec := range r.agents {
+		if rec.live(now) {
+			n++
+		}
+	}
+	return n
+}
+
+// list returns the status of every known agent, sorted by node name.
+func (r *agentRegistry) list(now time.Time) []model.AgentStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]model.AgentStatus, 0, len(r.agents))
+	for _, rec := range r.agents {
+		out = append(out, rec.status(now))
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].NodeName < out[j].NodeName })
+	return out
+}
+
+// missing returns the nodes from podNodes that have no live agent.
+func (r *agentRegistry) missing(podNodes []string, now time.Time) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var out []string
+	for _, node := range podNodes {
+		if rec, ok := r.agents[node]; !ok || !rec.live(now) {
+			out = append(out, node)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// prune drops agents that have been silent for longer than maxAge.
+func (r *agentRegistry) prune(now time.Time, maxAge time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for node, rec := range r.agents {
+		if now.Sub(rec.lastReport) > maxAge {
+			delete(r.agents, node)
+			metrics.AgentInfo.DeleteLabelValues(node, rec.version)
+			metrics.AgentLastReport.DeleteLabelValues(node)
+			metrics.AgentReportLag.DeleteLabelValues(node)
+		}
+	}
+}
+
+// nodesWithoutAgent returns nodes that run pods but have no live agent. It
+// requires the pod watcher; without it the list is always empty.
+func (s *Server) nodesWithoutAgent(now time.Time) []string {
+	if s.podWatcher == nil {
+		return nil
+	}
+	return s.agents.missing(s.podWatcher.NodesWithPods(), now)
+}
+
+// updateAgentMetrics refreshes the liveness gauges.
+func (s *Server) updateAgentMetrics() {
+	now := time.Now()
+	metrics.AgentsConnected.Set(float64(s.agents.connected(now)))
+	metrics.NodesWithoutAgent.Set(float64(len(s.nodesWithoutAgent(now))))
+}
+
+func (s *Server) handleAgents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	now := time.Now()
+	resp := model.AgentsResponse{
+		Agents:            s.agents.list(now),
+		NodesWithoutAgent: s.nodesWithoutAgent(now),
+	}
+	if resp.NodesWithoutAgent == nil {
+		resp.NodesWithoutAgent = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp) //nolint:errcheck
+}
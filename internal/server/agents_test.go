@@ -0,0 +1,205 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+func heartbeat(node string, seconds float64) model.AgentReport {
+	return model.AgentReport{
+		ProtocolVersion:  model.ProtocolVersion,
+		Capabilities:     model.AgentCapabilities,
+		NodeName:         node,
+		HeartbeatSeconds: seconds,
+	}
+}
+
+func TestAgentRegistry_Connected(t *testing.T) {
+	r := newAgentRegistry()
+	now := time.Now()
+	r.observe(heartbeat("fast", 1), now.Add(-2*time.Second))
+	r.observe(heartbeat("idle", 30), now.Add(-60*time.Second))
+	r.observe(heartbeat("gone", 1), now.Add(-10*time.Second))
+
+	if got := r.connected(now); got != 2 {
+		t.Errorf("connected: want 2 (fast, idle), got %d", got)
+	}
+}
+
+func TestAgentRegistry_DefaultHeartbeat(t *testing.T) {
+	r := newAgentRegistry()
+	now := time.Now()
+	r.observe(heartbeat("legacy", 0), now.Add(-agentLivenessFactor*defaultAgentHeartbeat+time.Second))
+	if got := r.connected(now); got != 1 {
+		t.Errorf("agent without announced heartbeat should use default, got %d connected", got)
+	}
+}
+
+func TestAgentRegistry_Prune(t *testing.T) {
+	r := newAgentRegistry()
+	now := time.Now()
+	r.observe(heartbeat("old", 1), now.Add(-2*time.Hour))
+	r.observe(heartbeat("new", 1), now)
+	r.prune(now, time.Hour)
+	if _, _, ok := r.liveness("old"); ok {
+		t.Error("old agent should have been pruned")
+	}
+	if _, _, ok := r.liveness("new"); !ok {
+		t.Error("new agent should remain")
+	}
+}
+
+func TestCleanup_HeartbeatKeepsPullAlive(t *testing.T) {
+	s := newTestServer()
+	s.processReport(model.AgentReport{
+		NodeName: "node1",
+		Pulls:    []model.PullState{{ImageRef: "nginx:latest", StartedAt: time.Now()}},
+	})
+	key := "node1:nginx:latest"
+
+	// The pull itself has not been updated in a long time, but the agent is
+	// still heartbeating, so the pull must not be force-completed.
+	s.mu.Lock()
+	s.lastSeen[key] = time.Now().Add(-2 * stalePullTimeout)
+	s.mu.Unlock()
+	s.agents.observe(heartbeat("node1", 5), time.Now())
+	s.cleanup()
+
+	s.mu.RLock()
+	completed := s.pulls[key].CompletedAt != nil
+	s.mu.RUnlock()
+	if completed {
+		t.Fatal("pull on a heartbeating node should stay active")
+	}
+
+	s.agents.observe(heartbeat("node1", 5), time.Now().Add(-2*stalePullTimeout))
+	s.cleanup()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.pulls[key].CompletedAt == nil {
+		t.Error("pull on a silent node should be force-completed")
+	}
+}
+
+func TestAgentRegistry_RecordsReportDetails(t *testing.T) {
+	r := newAgentRegistry()
+	now := time.Now()
+	r.observe(model.AgentReport{
+		Capabilities:     []model.Capability{model.CapabilityAgentStats},
+		NodeName:         "node1",
+		Timestamp:        now.Add(-300 * time.Millisecond),
+		HeartbeatSeconds: 5,
+		AgentVersion:     "0.2.0",
+		PollErrors:       2,
+		ReportErrors:     1,
+	}, now)
+	r.observe(model.AgentReport{
+		Capabilities: []model.Capability{model.CapabilityAgentStats},
+		NodeName:     "node1",
+		Timestamp:    now,
+		AgentVersion: "0.2.0",
+		PollErrors:   3,
+	}, now)
+
+	agents := r.list(now)
+	if len(agents) != 1 {
+		t.Fatalf("expected 1 agent, got %d", len(agents))
+	}
+	a := agents[0]
+	if a.AgentVersion != "0.2.0" {
+		t.Errorf("AgentVersion: want 0.2.0, got %q", a.AgentVersion)
+	}
+	if a.Reports != 2 {
+		t.Errorf("Reports: want 2, got %d", a.Reports)
+	}
+	if a.PollErrors != 3 || a.ReportErrors != 0 {
+		t.Errorf("errors: want poll=3 report=0, got poll=%d report=%d", a.PollErrors, a.ReportErrors)
+	}
+	if a.ReportLagSeconds != 0 {
+		t.Errorf("ReportLagSeconds: want 0 for latest report, got %f", a.ReportLagSeconds)
+	}
+	if !a.Connected {
+		t.Error("agent should be connected")
+	}
+}
+
+func TestAgentRegistry_Missing(t *testing.T) {
+	r := newAgentRegistry()
+	now := time.Now()
+	r.observe(heartbeat("node1", 5), now)
+	r.observe(heartbeat("node2", 5), now.Add(-time.Hour))
+
+	got := r.missing([]string{"node3", "node1", "node2"}, now)
+	if len(got) != 2 || got[0] != "node2" || got[1] != "node3" {
+		t.Errorf("missing: want [node2 node3], got %v", got)
+	}
+}
+
+func TestHandleAgents(t *testing.T) {
+	s := newTestServer()
+	if w := postReport(t, s, model.AgentReport{NodeName: "node1", Timestamp: time.Now(), AgentVersion: "0.2.0"}, ""); w.Code != http.StatusOK {
+		t.Fatalf("report: expected 200, got %d", w.Code)
+	}
+
+	w := httptest.NewRecorder()
+	s.handleAgents(w, httptest.NewRequest(http.MethodGet, "/api/v1/agents", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	var resp model.AgentsResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if len(resp.Agents) != 1 || resp.Agents[0].NodeName != "node1" {
+		t.Fatalf("expected node1 agent, got %+v", resp.Agents)
+	}
+	if resp.NodesWithoutAgent == nil {
+		t.Error("nodesWithoutAgent should be an empty array, not null")
+	}
+}
+
+func TestAgentRegistry_IgnoresUndeclaredCapabilities(t *testing.T) {
+	r := newAgentRegistry()
+	now := time.Now()
+	r.observe(model.AgentReport{NodeName: "legacy", HeartbeatSeconds: 600, PollErrors: 7}, now)
+
+	a := r.list(now)[0]
+	if a.ProtocolVersion != 1 {
+		t.Errorf("unversioned report should count as protocol 1, got %d", a.ProtocolVersion)
+	}
+	if a.HeartbeatSeconds != defaultAgentHeartbeat.Seconds() {
+		t.Errorf("heartbeat without capability should use default, got %fs", a.HeartbeatSeconds)
+	}
+	if a.PollErrors != 0 {
+		t.Errorf("error counters without capability should be ignored, got %d", a.PollErrors)
+	}
+}
+
+func TestCleanup_LegacyAgentUsesPullLastSeen(t *testing.T) {
+	s := newTestServer()
+	s.processReport(model.AgentReport{
+		NodeName: "node1",
+		Pulls:    []model.PullState{{ImageRef: "nginx:latest", StartedAt: time.Now()}},
+	})
+	key := "node1:nginx:latest"
+
+	// A legacy agent reporting recently does not vouch for pulls it no
+	// longer updates; the per-pull timestamp decides.
+	s.agents.observe(model.AgentReport{NodeName: "node1"}, time.Now())
+	s.mu.Lock()
+	s.lastSeen[key] = time.Now().Add(-2 * stalePullTimeout)
+	s.mu.Unlock()
+	s.cleanup()
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.pulls[key].CompletedAt == nil {
+		t.Error("stale pull from a non-heartbeating agent should be force-completed")
+	}
+}
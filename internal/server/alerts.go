@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+// alertEvalInterval is how often alerting rules are evaluated.
+const alertEvalInterval = 5 * time.Second
+
+// alertLoop evaluates the alerting rules against every pull the server
+// serves and broadcasts alerts that fire or resolve.
+func (s *Server) alertLoop(ctx context.Context) {
+	ticker := time.NewTicker(alertEvalInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Followers hold no pulls; evaluating would resolve every alert.
+			if s.replica != nil && !s.replica.isLeading() {
+				continue
+			}
+			s.evaluateAlerts(time.Now())
+		}
+	}
+}
+
+func (s *Server) evaluateAlerts(now time.Time) {
+	pulls := append(s.snapshotPulls(), s.remotePulls()...)
+	for _, a := range s.alerting.Evaluate(pulls, now) {
+		typ := model.EventAlertFiring
+		if a.State == model.AlertResolved {
+			typ = model.EventAlertResolved
+		}
+		event := model.PullEvent{
+			SchemaVersion: model.SchemaVersion,
+			Timestamp:     now,
+			Type:          typ,
+			NodeName:      a.Labels["node"],
+			Alert:         &a,
+		}
+		if data, err := json.Marshal(event); err == nil {
+			s.logger.Info(string(typ),
+				"rule", a.Rule,
+				"severity", a.Severity,
+				"labels", a.Labels,
+				"summary", a.Summary,
+			)
+			s.broadcastSSE(data)
+		}
+	}
+}
+
+func (s *Server) handleAlerts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	alerts := []model.Alert{}
+	if s.alerting != nil {
+		alerts = s.alerting.Alerts()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model.AlertsResponse{Alerts: alerts}) //nolint:errcheck
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/alerts"
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+func TestEvaluateAlerts_BroadcastsTransitions(t *testing.T) {
+	s := newTestServer()
+	engine, err := alerts.NewEngine([]alerts.Rule{{
+		Name:     "SlowPull",
+		Expr:     "pull_duration_seconds > 300",
+		Severity: "warning",
+	}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.alerting = engine
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	s.processReport(model.AgentReport{
+		NodeName:  "node1",
+		Timestamp: time.Now(),
+		Pulls:     []model.PullState{{ImageRef: "nginx:1.27", StartedAt: time.Now().Add(-10 * time.Minute)}},
+	})
+
+	resp, r := openSSE(t, srv.URL, "")
+	defer resp.Body.Close()
+	readSSE(t, r, 1) // snapshot
+
+	s.evaluateAlerts(time.Now())
+	_, events := readSSE(t, r, 1)
+	ev := events[0]
+	if ev.Type != model.EventAlertFiring || ev.Pull != nil || ev.Alert == nil || ev.NodeName != "node1" {
+		t.Fatalf("event = %+v", ev)
+	}
+	if ev.Alert.Rule != "SlowPull" || ev.Alert.Labels["image"] != "nginx:1.27" {
+		t.Errorf("alert = %+v", ev.Alert)
+	}
+
+	var list model.AlertsResponse
+	if code := getJSON(t, s.Handler(), "/api/v1/alerts", &list); code != http.StatusOK {
+		t.Fatalf("GET /api/v1/alerts: %d", code)
+	}
+	if len(list.Alerts) != 1 || list.Alerts[0].State != model.AlertFiring {
+		t.Errorf("alerts = %+v", list.Alerts)
+	}
+
+	// The pull completes, so the alert resolves.
+	s.processReport(model.AgentReport{NodeName: "node1", Timestamp: time.Now()})
+	readSSE(t, r, 1) // pull.completed
+	s.evaluateAlerts(time.Now())
+	_, events = readSSE(t, r, 1)
+	if ev := events[0]; ev.Type != model.EventAlertResolved || ev.Alert == nil || ev.Alert.State != model.AlertResolved {
+		t.Errorf("event = %+v", ev)
+	}
+}
+
+func TestHandleAlerts_Disabled(t *testing.T) {
+	s := newTestServer()
+	var list model.AlertsResponse
+	if code := getJSON(t, s.Handler(), "/api/v1/alerts", &list); code != http.StatusOK {
+		t.Fatalf("GET /api/v1/alerts: %d", code)
+	}
+	if list.Alerts == nil || len(list.Alerts) != 0 {
+		t.Errorf("alerts = %+v, want an empty list", list.Alerts)
+	}
+}
@@ -0,0 +1,527 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/metrics"
+	"github.com/d44b/pulltrace/internal/model"
+	"github.com/d44b/pulltrace/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// federationRetryInterval is how long to wait before retrying a downstream
+// whose first connection failed. Later reconnects use the client's backoff.
+const federationRetryInterval = 5 * time.Second
+
+// Downstream is a pulltrace server whose pulls a federating server merges
+// into its own view.
+type Downstream struct {
+	// Name tags the downstream's pulls and prefixes their IDs. It must be
+	// unique and must not contain '/'.
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// BearerTokenFile is re-read for every request so rotated tokens are
+	// picked up.
+	BearerTokenFile    string `json:"bearerTokenFile,omitempty"`
+	CAFile             string `json:"caFile,omitempty"`
+	CertFile           string `json:"certFile,omitempty"`
+	KeyFile            string `json:"keyFile,omitempty"`
+	InsecureSkipVerify bool   `json:"insecureSkipVerify,omitempty"`
+}
+
+// LoadFederationConfig reads the downstream list from a YAML or JSON file of
+// the form {"clusters": [Downstream, ...]}.
+func LoadFederationConfig(path string) ([]Downstream, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Clusters []Downstream `json:"clusters"`
+	}
+	if err := yaml.UnmarshalStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if len(file.Clusters) == 0 {
+		return nil, fmt.Errorf("%s: no clusters configured", path)
+	}
+	seen := make(map[string]bool)
+	for i, d := range file.Clusters {
+		switch {
+		case d.Name == "":
+			return nil, fmt.Errorf("%s: clusters[%d]: name is required", path, i)
+		case strings.Contains(d.Name, "/"):
+			return nil, fmt.Errorf("%s: cluster %q: name must not contain '/'", path, d.Name)
+		case seen[d.Name]:
+			return nil, fmt.Errorf("%s: cluster %q is listed twice", path, d.Name)
+		case d.URL == "":
+			return nil, fmt.Errorf("%s: cluster %q: url is required", path, d.Name)
+		case (d.CertFile == "") != (d.KeyFile == ""):
+			return nil, fmt.Errorf("%s: cluster %q: certFile and keyFile must be set together", path, d.Name)
+		}
+		seen[d.Name] = true
+	}
+	return file.Clusters, nil
+}
+
+// httpClient returns an HTTP client that authenticates to the downstream.
+func (d Downstream) httpClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: d.InsecureSkipVerify} //nolint:gosec // explicit opt-in
+	if d.CAFile != "" {
+		pem, err := os.ReadFile(d.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", d.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if d.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(d.CertFile, d.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	var rt http.RoundTripper = transport
+	if d.BearerTokenFile != "" {
+		rt = &bearerTokenTransport{file: d.BearerTokenFile, next: transport}
+	}
+	return &http.Client{Transport: rt}, nil
+}
+
+type bearerTokenTransport struct {
+	file string
+	next http.RoundTripper
+}
+
+func (t *bearerTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := os.ReadFile(t.file)
+	if err != nil {
+		return nil, fmt.Errorf("reading bearer token: %w", err)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	return t.next.RoundTrip(req)
+}
+
+// remoteCluster is the federating server's view of one downstream.
+type remoteCluster struct {
+	downstream Downstream
+	connected  bool
+	since      time.Time
+	lastEvent  time.Time
+	err        string
+	// pulls is keyed by federated ID, "<cluster>/<downstream ID>".
+	pulls map[string]*remotePull
+}
+
+type remotePull struct {
+	status   model.PullStatus
+	lastSeen time.Time
+}
+
+func (rc *remoteCluster) status() model.ClusterStatus {
+	cs := model.ClusterStatus{
+		Name:      rc.downstream.Name,
+		URL:       redactURL(rc.downstream.URL),
+		Connected: rc.connected,
+		Error:     rc.err,
+	}
+	if !rc.since.IsZero() {
+		since := rc.since
+		cs.Since = &since
+	}
+	if !rc.lastEvent.IsZero() {
+		last := rc.lastEvent
+		cs.LastEvent = &last
+	}
+	for _, rp := range rc.pulls {
+		if rp.status.CompletedAt == nil {
+			cs.ActivePulls++
+		}
+	}
+	return cs
+}
+
+// redactURL hides credentials embedded in a downstream URL.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Redacted()
+}
+
+// federation holds the pulls received from downstream servers. They are
+// kept apart from the local pull map so agent liveness, pod correlation and
+// the Kubernetes side effects only ever apply to local pulls.
+type federation struct {
+	mu       sync.RWMutex
+	clusters []*remoteCluster
+	clients  []*client.Client
+}
+
+// configureFederation sets up the downstream clients without connecting.
+// With leader election, only the leader subscribes, from OnStartedLeading.
+func (s *Server) configureFederation(downstreams []Downstream) error {
+	f := &federation{}
+	for _, d := range downstreams {
+		hc, err := d.httpClient()
+		if err != nil {
+			return fmt.Errorf("cluster %q: %w", d.Name, err)
+		}
+		rc := &remoteCluster{downstream: d, pulls: make(map[string]*remotePull)}
+		c, err := client.New(d.URL,
+			client.WithHTTPClient(hc),
+			client.WithLogger(s.logger.With("cluster", d.Name)),
+			client.WithConnectionStateHandler(func(connected bool, err error) {
+				s.setClusterState(rc, connected, err)
+			}),
+		)
+		if err != nil {
+			return fmt.Errorf("cluster %q: %w", d.Name, err)
+		}
+		f.clusters = append(f.clusters, rc)
+		f.clients = append(f.clients, c)
+		metrics.FederationClusterUp.WithLabelValues(d.Name).Set(0)
+	}
+	s.federation = f
+	return nil
+}
+
+// runFederation merges every downstream's events until ctx is cancelled.
+func (s *Server) runFederation(ctx context.Context) {
+	f := s.federation
+	if f == nil {
+		return
+	}
+	for i, rc := range f.clusters {
+		go s.federate(ctx, rc, f.clients[i])
+	}
+}
+
+// resetFederation drops every downstream pull and marks the clusters
+// disconnected, for a replica that lost the Lease.
+func (s *Server) resetFederation() {
+	f := s.federation
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, rc := range f.clusters {
+		for _, rp := range rc.pulls {
+			if rp.status.CompletedAt == nil {
+				metrics.PullsActive.Dec()
+			}
+			trackStalled(rp.status.Stalled, false)
+		}
+		rc.pulls = make(map[string]*remotePull)
+		rc.connected = false
+		rc.since = time.Time{}
+		rc.lastEvent = time.Time{}
+		rc.err = ""
+		metrics.FederationClusterUp.WithLabelValues(rc.downstream.Name).Set(0)
+	}
+}
+
+// federate merges one downstream's events until ctx is cancelled.
+func (s *Server) federate(ctx context.Context, rc *remoteCluster, c *client.Client) {
+	for {
+		events, err := c.Watch(ctx, client.WatchFilter{Aggregates: true})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			s.logger.Warn("federated cluster unreachable", "cluster", rc.downstream.Name, "error", err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(federationRetryInterval):
+			}
+			continue
+		}
+		for ev := range events {
+			s.applyRemoteEvent(rc, ev)
+		}
+		return
+	}
+}
+
+func (s *Server) setClusterState(rc *remoteCluster, connected bool, err error) {
+	f := s.federation
+	f.mu.Lock()
+	if s.replica != nil && !s.replica.isLeading() {
+		f.mu.Unlock()
+		return
+	}
+	changed := rc.connected != connected || rc.since.IsZero()
+	if changed {
+		rc.since = time.Now()
+	}
+	rc.connected = connected
+	rc.err = ""
+	if err != nil {
+		rc.err = err.Error()
+	}
+	f.mu.Unlock()
+
+	up := 0.0
+	if connected {
+		up = 1
+	}
+	metrics.FederationClusterUp.WithLabelValues(rc.downstream.Name).Set(up)
+	if changed && connected {
+		s.logger.Info("federated cluster connected", "cluster", rc.downstream.Name)
+	} else if changed {
+		s.logger.Warn("federated cluster disconnected", "cluster", rc.downstream.Name, "error", err)
+	}
+}
+
+// applyRemoteEvent records a downstream event and rebroadcasts it, tagged
+// with the cluster name, to this server's own event stream clients.
+func (s *Server) applyRemoteEvent(rc *remoteCluster, ev model.PullEvent) {
+	if ev.Pull == nil {
+		s.forwardRemoteEvent(rc, ev)
+		return
+	}
+	now := time.Now()
+	pull := *ev.Pull
+	pull.ID = rc.downstream.Name + "/" + pull.ID
+	pull.Cluster = rc.downstream.Name
+
+	f := s.federation
+	f.mu.Lock()
+	// A subscription still winding down after leadership was lost must not
+	// count the pull again; the new leader does.
+	if s.replica != nil && !s.replica.isLeading() {
+		f.mu.Unlock()
+		return
+	}
+	metrics.FederationEvents.WithLabelValues(rc.downstream.Name).Inc()
+	rc.lastEvent = now
+	rp, ok := rc.pulls[pull.ID]
+	if !ok {
+		metrics.PullsTotal.Inc()
+		if pull.CompletedAt == nil {
+			metrics.PullsActive.Inc()
+		} else {
+			observeCompletion(&pull)
+		}
+		rp = &remotePull{}
+		rc.pulls[pull.ID] = rp
+	} else if rp.status.CompletedAt == nil && pull.CompletedAt != nil {
+		metrics.PullsActive.Dec()
+		observeCompletion(&pull)
+	} else if rp.status.CompletedAt != nil && pull.CompletedAt == nil {
+		// Force-completed by cleanupRemote, but the downstream still reports
+		// it active.
+		metrics.PullsActive.Inc()
+	}
+	trackStalled(rp.status.Stalled, pull.Stalled)
+	rp.status = pull
+	rp.lastSeen = now
+	f.mu.Unlock()
+
+	ev.Pull = &pull
+	if data, err := json.Marshal(ev); err == nil {
+		s.broadcastSSE(data)
+	}
+}
+
+// forwardRemoteEvent rebroadcasts a downstream pod or workload event tagged
+// with the cluster name, with the IDs of a pod's pulls prefixed like those of
+// remote pulls. Other events without a pull are dropped.
+func (s *Server) forwardRemoteEvent(rc *remoteCluster, ev model.PullEvent) {
+	name := rc.downstream.Name
+	switch {
+	case ev.Pod != nil:
+		ev.Pod.Cluster = name
+		for i := range ev.Pod.Containers {
+			pull := &ev.Pod.Containers[i].Pull
+			pull.ID = name + "/" + pull.ID
+			pull.Cluster = name
+		}
+	case ev.Workload != nil:
+		ev.Workload.Cluster = name
+	default:
+		return
+	}
+
+	f := s.federation
+	f.mu.Lock()
+	if s.replica != nil && !s.replica.isLeading() {
+		f.mu.Unlock()
+		return
+	}
+	metrics.FederationEvents.WithLabelValues(name).Inc()
+	rc.lastEvent = time.Now()
+	f.mu.Unlock()
+
+	if data, err := json.Marshal(ev); err == nil {
+		s.broadcastSSE(data)
+	}
+}
+
+// remoteClusterNames returns the names of the downstreams in order.
+func (s *Server) remoteClusterNames() []string {
+	f := s.federation
+	if f == nil {
+		return nil
+	}
+	names := make([]string, 0, len(f.clusters))
+	for _, rc := range f.clusters {
+		names = append(names, rc.downstream.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// clusterPulls returns copies of the pulls received from the named
+// downstream, and false if there is no such downstream.
+func (s *Server) clusterPulls(cluster string) ([]*model.PullStatus, bool) {
+	f := s.federation
+	if f == nil {
+		return nil, false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, rc := range f.clusters {
+		if rc.downstream.Name != cluster {
+			continue
+		}
+		pulls := make([]*model.PullStatus, 0, len(rc.pulls))
+		for _, rp := range rc.pulls {
+			p := rp.status
+			pulls = append(pulls, &p)
+		}
+		return pulls, true
+	}
+	return nil, false
+}
+
+// observeCompletion records a finished remote pull in the pull metrics.
+func observeCompletion(p *model.PullStatus) {
+	metrics.PullDurationSeconds.Observe(p.CompletedAt.Sub(p.StartedAt).Seconds())
+	metrics.PullBytesTotal.Add(float64(p.TotalBytes))
+	if p.Error != "" {
+		metrics.PullErrors.Inc()
+	}
+}
+
+// remotePulls returns a copy of every pull received from downstreams.
+func (s *Server) remotePulls() []model.PullStatus {
+	f := s.federation
+	if f == nil {
+		return nil
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	var pulls []model.PullStatus
+	for _, rc := range f.clusters {
+		for _, rp := range rc.pulls {
+			pulls = append(pulls, rp.status)
+		}
+	}
+	return pulls
+}
+
+// remotePull returns the downstream pull with the given federated ID.
+func (s *Server) remotePull(id string) (model.PullStatus, bool) {
+	f := s.federation
+	if f == nil {
+		return model.PullStatus{}, false
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	for _, rc := range f.clusters {
+		if rp, ok := rc.pulls[id]; ok {
+			return rp.status, true
+		}
+	}
+	return model.PullStatus{}, false
+}
+
+// cleanupRemote drops downstream pulls that left the history and
+// force-completes active ones the downstream stopped updating. Pulls of a
+// disconnected cluster are kept as they were last seen.
+func (s *Server) cleanupRemote(now time.Time) {
+	f := s.federation
+	if f == nil {
+		return
+	}
+	ttlCutoff := now.Add(-s.config.HistoryTTL)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, rc := range f.clusters {
+		for id, rp := range rc.pulls {
+			p := &rp.status
+			if p.CompletedAt != nil && p.CompletedAt.Before(ttlCutoff) {
+				trackStalled(p.Stalled, false)
+				delete(rc.pulls, id)
+				continue
+			}
+			if p.CompletedAt == nil && rc.connected && now.Sub(rp.lastSeen) > stalePullTimeout {
+				completedAt := rp.lastSeen
+				p.CompletedAt = &completedAt
+				clearStalled(p)
+				metrics.PullsActive.Dec()
+				s.logger.Warn("force-completing stale federated pull", "cluster", rc.downstream.Name, "id", id)
+			}
+		}
+	}
+}
+
+// clusterStatuses lists the local cluster, if named or federating, followed
+// by every downstream in name order.
+func (s *Server) clusterStatuses() []model.ClusterStatus {
+	clusters := []model.ClusterStatus{}
+	if s.config.ClusterName != "" || s.federation != nil {
+		local := model.ClusterStatus{Name: s.config.ClusterName, Local: true, Connected: true}
+		s.mu.RLock()
+		for _, p := range s.pulls {
+			if p.CompletedAt == nil {
+				local.ActivePulls++
+			}
+		}
+		s.mu.RUnlock()
+		clusters = append(clusters, local)
+	}
+	if f := s.federation; f != nil {
+		f.mu.RLock()
+		remote := make([]model.ClusterStatus, 0, len(f.clusters))
+		for _, rc := range f.clusters {
+			remote = append(remote, rc.status())
+		}
+		f.mu.RUnlock()
+		sort.Slice(remote, func(i, j int) bool { return remote[i].Name < remote[j].Name })
+		clusters = append(clusters, remote...)
+	}
+	return clusters
+}
+
+func (s *Server) handleClusters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model.ClustersResponse{Clusters: s.clusterStatuses()}) //nolint:errcheck
+}
@@ -0,0 +1,415 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/metrics"
+	"github.com/d44b/pulltrace/internal/model"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func getJSON(t *testing.T, h http.Handler, path string, v any) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+	return w.Code
+}
+
+func TestLoadFederationConfig(t *testing.T) {
+	path := writeFile(t, "federation.yaml", `
+clusters:
+  - name: eu-west
+    url: https://pulltrace.eu-west.example.com
+    bearerTokenFile: /var/run/secrets/eu-west/token
+  - name: us-east
+    url: http://pulltrace.us-east.internal:8080
+    insecureSkipVerify: true
+`)
+	got, err := LoadFederationConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Name != "eu-west" || got[0].BearerTokenFile == "" || !got[1].InsecureSkipVerify {
+		t.Errorf("got %+v", got)
+	}
+
+	for name, content := range map[string]string{
+		"empty":        `clusters: []`,
+		"no name":      `clusters: [{url: "http://a"}]`,
+		"slash":        `clusters: [{name: "a/b", url: "http://a"}]`,
+		"duplicate":    `clusters: [{name: a, url: "http://a"}, {name: a, url: "http://b"}]`,
+		"no url":       `clusters: [{name: a}]`,
+		"cert only":    `clusters: [{name: a, url: "http://a", certFile: /c}]`,
+		"unknown keys": `clusters: [{name: a, url: "http://a", token: x}]`,
+	} {
+		if _, err := LoadFederationConfig(writeFile(t, "f.yaml", content)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestFederation_MergesDownstream(t *testing.T) {
+	downstream := newTestServer()
+	downstream.processReport(model.AgentReport{
+		NodeName:  "node1",
+		Timestamp: time.Now(),
+		Pulls: []model.PullState{{
+			ImageRef:   "nginx:1.27",
+			StartedAt:  time.Now(),
+			TotalKnown: true,
+			Layers:     []model.LayerState{{Digest: "sha256:aaa", TotalBytes: 100, DownloadedBytes: 40, TotalKnown: true}},
+		}},
+	})
+	dh := downstream.Handler()
+	var outage atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if outage.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		dh.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	hub := newTestServer()
+	hub.config.ClusterName = "hub"
+	hub.processReport(model.AgentReport{
+		NodeName:  "hub-node",
+		Timestamp: time.Now(),
+		Pulls:     []model.PullState{{ImageRef: "redis:7", StartedAt: time.Now()}},
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := hub.configureFederation([]Downstream{{
+		Name:            "east",
+		URL:             srv.URL,
+		BearerTokenFile: writeFile(t, "token", "s3cret\n"),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hub.runFederation(ctx)
+	h := hub.Handler()
+
+	var resp model.APIResponse
+	eventually(t, "the downstream pull", func() bool {
+		getJSON(t, h, "/api/v1/pulls", &resp)
+		return len(resp.Pulls) == 2
+	})
+	byCluster := make(map[string]model.PullStatus)
+	for _, p := range resp.Pulls {
+		byCluster[p.Cluster] = p
+	}
+	remote, local := byCluster["east"], byCluster["hub"]
+	if remote.ImageRef != "nginx:1.27" || !strings.HasPrefix(remote.ID, "east/node1:") || remote.Percent != 40 {
+		t.Errorf("remote pull = %+v", remote)
+	}
+	if local.ImageRef != "redis:7" {
+		t.Errorf("local pull = %+v", local)
+	}
+
+	var one model.PullStatus
+	if code := getJSON(t, h, "/api/v1/pulls/"+url.PathEscape(remote.ID), &one); code != http.StatusOK || one.ID != remote.ID {
+		t.Errorf("GET remote pull by ID: %d %+v", code, one)
+	}
+
+	var clusters model.ClustersResponse
+	getJSON(t, h, "/api/v1/clusters", &clusters)
+	if len(clusters.Clusters) != 2 {
+		t.Fatalf("clusters = %+v", clusters.Clusters)
+	}
+	if c := clusters.Clusters[0]; c.Name != "hub" || !c.Local || !c.Connected || c.ActivePulls != 1 {
+		t.Errorf("local cluster = %+v", c)
+	}
+	if c := clusters.Clusters[1]; c.Name != "east" || !c.Connected || c.ActivePulls != 1 || c.LastEvent == nil {
+		t.Errorf("downstream cluster = %+v", c)
+	}
+
+	// An outage is reported, and the last known pulls stay visible.
+	outage.Store(true)
+	srv.CloseClientConnections()
+	eventually(t, "the outage", func() bool {
+		getJSON(t, h, "/api/v1/clusters", &clusters)
+		return !clusters.Clusters[1].Connected
+	})
+	if clusters.Clusters[1].Error == "" {
+		t.Error("disconnected cluster has no error")
+	}
+	getJSON(t, h, "/api/v1/pulls", &resp)
+	if len(resp.Pulls) != 2 {
+		t.Errorf("got %d pulls during the outage, want 2", len(resp.Pulls))
+	}
+}
+
+func TestFederation_UnreachableDownstream(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	s := newTestServer()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.configureFederation([]Downstream{{Name: "gone", URL: "http://" + addr}}); err != nil {
+		t.Fatal(err)
+	}
+	s.runFederation(ctx)
+
+	var clusters model.ClustersResponse
+	eventually(t, "the connection error", func() bool {
+		getJSON(t, s.Handler(), "/api/v1/clusters", &clusters)
+		return len(clusters.Clusters) == 2 && clusters.Clusters[1].Error != ""
+	})
+	if c := clusters.Clusters[1]; c.Connected || c.Since == nil {
+		t.Errorf("unreachable cluster = %+v", c)
+	}
+}
+
+func TestCleanupRemote(t *testing.T) {
+	s := newTestServer()
+	now := time.Now()
+	old := now.Add(-time.Hour)
+	up := &remoteCluster{downstream: Downstream{Name: "up"}, connected: true, pulls: map[string]*remotePull{
+		"up/done":  {status: model.PullStatus{ID: "up/done", CompletedAt: &old}},
+		"up/stale": {status: model.PullStatus{ID: "up/stale"}, lastSeen: now.Add(-2 * stalePullTimeout)},
+		"up/live":  {status: model.PullStatus{ID: "up/live"}, lastSeen: now},
+	}}
+	down := &remoteCluster{downstream: Downstream{Name: "down"}, pulls: map[string]*remotePull{
+		"down/quiet": {status: model.PullStatus{ID: "down/quiet"}, lastSeen: now.Add(-2 * stalePullTimeout)},
+	}}
+	s.federation = &federation{clusters: []*remoteCluster{up, down}}
+
+	s.cleanupRemote(now)
+
+	if _, ok := up.pulls["up/done"]; ok {
+		t.Error("pull past the history TTL was kept")
+	}
+	if up.pulls["up/stale"].status.CompletedAt == nil {
+		t.Error("stale pull of a connected cluster was not completed")
+	}
+	if up.pulls["up/live"].status.CompletedAt != nil {
+		t.Error("live pull was completed")
+	}
+	if down.pulls["down/quiet"].status.CompletedAt != nil {
+		t.Error("pull of a disconnected cluster was completed")
+	}
+}
+
+func TestFederation_ResumedPullCountsActive(t *testing.T) {
+	s := newTestServer()
+	rc := &remoteCluster{downstream: Downstream{Name: "east"}, connected: true, pulls: map[string]*remotePull{}}
+	s.federation = &federation{clusters: []*remoteCluster{rc}}
+	active := func() float64 {
+		var m dto.Metric
+		if err := metrics.PullsActive.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m.GetGauge().GetValue()
+	}
+	before := active()
+
+	pull := model.PullStatus{ID: "p1", ImageRef: "nginx:1.27", StartedAt: time.Now()}
+	s.applyRemoteEvent(rc, model.PullEvent{Type: model.EventPullProgress, Pull: &pull})
+	if got := active() - before; got != 1 {
+		t.Fatalf("after start: active delta = %v, want 1", got)
+	}
+
+	rc.pulls["east/p1"].lastSeen = time.Now().Add(-2 * stalePullTimeout)
+	s.cleanupRemote(time.Now())
+	if got := active() - before; got != 0 {
+		t.Fatalf("after force-complete: active delta = %v, want 0", got)
+	}
+
+	s.applyRemoteEvent(rc, model.PullEvent{Type: model.EventPullProgress, Pull: &pull})
+	if got := active() - before; got != 1 {
+		t.Fatalf("after resume: active delta = %v, want 1", got)
+	}
+
+	completed := time.Now()
+	pull.CompletedAt = &completed
+	s.applyRemoteEvent(rc, model.PullEvent{Type: model.EventPullCompleted, Pull: &pull})
+	if got := active() - before; got != 0 {
+		t.Errorf("after completion: active delta = %v, want 0", got)
+	}
+}
+
+// startFederatedPair runs a downstream with one pull of pod default/web-0 of
+// Deployment web, and a hub named "hub" federating it as "east". It returns
+// the hub, its URL, and a function that makes the downstream report progress
+// on the pull, which sends pod and workload events.
+func startFederatedPair(t *testing.T) (hub *Server, hubURL string, progress func()) {
+	t.Helper()
+	downstream := newTestServer()
+	started := time.Now()
+	report := func(downloaded int64) model.AgentReport {
+		return model.AgentReport{
+			NodeName:  "node1",
+			Timestamp: time.Now(),
+			Pulls: []model.PullState{{
+				ImageRef:   "nginx:1.27",
+				StartedAt:  started,
+				TotalKnown: true,
+				Layers:     []model.LayerState{{Digest: "sha256:aaa", TotalBytes: 100, DownloadedBytes: downloaded, TotalKnown: true}},
+			}},
+		}
+	}
+	downstream.processReport(report(10))
+	downstream.mu.Lock()
+	for _, p := range downstream.pulls {
+		p.Pods = []model.PodCorrelation{{
+			Namespace: "default", PodName: "web-0", Container: "app", Kind: model.CorrelationContainer,
+			Workload: &model.WorkloadRef{Kind: "Deployment", Name: "web"},
+		}}
+	}
+	downstream.mu.Unlock()
+	srv := httptest.NewServer(downstream.Handler())
+
+	hub = newTestServer()
+	hub.config.ClusterName = "hub"
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := hub.configureFederation([]Downstream{{Name: "east", URL: srv.URL}}); err != nil {
+		t.Fatal(err)
+	}
+	hub.runFederation(ctx)
+	hubSrv := httptest.NewServer(hub.Handler())
+	t.Cleanup(func() {
+		cancel()
+		hubSrv.CloseClientConnections()
+		hubSrv.Close()
+		srv.CloseClientConnections()
+		srv.Close()
+	})
+	eventually(t, "the downstream pull", func() bool { return len(hub.remotePulls()) == 1 })
+	return hub, hubSrv.URL, func() { downstream.processReport(report(60)) }
+}
+
+// readUntil reads events from an open stream until one satisfies match.
+func readUntil(t *testing.T, resp *http.Response, r *bufio.Reader, match func(model.PullEvent) bool) model.PullEvent {
+	t.Helper()
+	timer := time.AfterFunc(5*time.Second, func() { resp.Body.Close() })
+	defer timer.Stop()
+	for {
+		_, events := readSSE(t, r, 1)
+		if match(events[0]) {
+			return events[0]
+		}
+	}
+}
+
+func TestFederation_Pods(t *testing.T) {
+	hub, hubURL, progress := startFederatedPair(t)
+	h := hub.Handler()
+
+	var pod model.PodStatus
+	if code := getJSON(t, h, "/api/v1/pods/default/web-0?cluster=east", &pod); code != http.StatusOK {
+		t.Fatalf("GET remote pod: %d", code)
+	}
+	if pod.Cluster != "east" || len(pod.Containers) != 1 || !strings.HasPrefix(pod.Containers[0].Pull.ID, "east/") {
+		t.Errorf("remote pod = %+v", pod)
+	}
+	for _, path := range []string{"/api/v1/pods/default/web-0", "/api/v1/pods/default/web-0?cluster=hub", "/api/v1/pods/default/web-0?cluster=west"} {
+		if code := getJSON(t, h, path, &pod); code != http.StatusNotFound {
+			t.Errorf("GET %s: %d, want 404", path, code)
+		}
+	}
+
+	resp, r := openSSE(t, hubURL, "")
+	defer resp.Body.Close()
+	progress()
+	ev := readUntil(t, resp, r, func(ev model.PullEvent) bool { return ev.Pod != nil })
+	if ev.Pod.Cluster != "east" || ev.Pod.Percent != 60 || !strings.HasPrefix(ev.Pod.Containers[0].Pull.ID, "east/") {
+		t.Errorf("forwarded pod event = %+v", ev.Pod)
+	}
+}
+
+func TestFederation_Workloads(t *testing.T) {
+	hub, hubURL, progress := startFederatedPair(t)
+	hub.processReport(model.AgentReport{
+		NodeName:  "hub-node",
+		Timestamp: time.Now(),
+		Pulls:     []model.PullState{{ImageRef: "redis:7", StartedAt: time.Now()}},
+	})
+	hub.mu.Lock()
+	for _, p := range hub.pulls {
+		p.Pods = []model.PodCorrelation{{Namespace: "default", PodName: "cache-0", Workload: &model.WorkloadRef{Kind: "StatefulSet", Name: "cache"}}}
+	}
+	hub.mu.Unlock()
+	h := hub.Handler()
+
+	var list model.WorkloadsResponse
+	getJSON(t, h, "/api/v1/workloads", &list)
+	if len(list.Workloads) != 2 || list.Workloads[0].Cluster != "hub" || list.Workloads[1].Cluster != "east" || list.Workloads[1].Name != "web" {
+		t.Fatalf("workloads = %+v", list.Workloads)
+	}
+	getJSON(t, h, "/api/v1/workloads?cluster=east", &list)
+	if len(list.Workloads) != 1 || list.Workloads[0].Name != "web" {
+		t.Errorf("east workloads = %+v", list.Workloads)
+	}
+	getJSON(t, h, "/api/v1/workloads?cluster=hub", &list)
+	if len(list.Workloads) != 1 || list.Workloads[0].Name != "cache" {
+		t.Errorf("hub workloads = %+v", list.Workloads)
+	}
+	if code := getJSON(t, h, "/api/v1/workloads?cluster=west", &list); code != http.StatusNotFound {
+		t.Errorf("unknown cluster: %d, want 404", code)
+	}
+
+	var wl model.WorkloadStatus
+	if code := getJSON(t, h, "/api/v1/workloads/default/deployment/web?cluster=east", &wl); code != http.StatusOK || wl.Cluster != "east" || wl.NodesPulling != 1 {
+		t.Errorf("GET remote workload: %d %+v", code, wl)
+	}
+	if code := getJSON(t, h, "/api/v1/workloads/default/deployment/web", &wl); code != http.StatusNotFound {
+		t.Errorf("GET remote workload without cluster: %d, want 404", code)
+	}
+
+	resp, r := openSSE(t, hubURL, "")
+	defer resp.Body.Close()
+	progress()
+	ev := readUntil(t, resp, r, func(ev model.PullEvent) bool { return ev.Workload != nil })
+	if ev.Workload.Cluster != "east" || ev.Workload.Name != "web" {
+		t.Errorf("forwarded workload event = %+v", ev.Workload)
+	}
+}
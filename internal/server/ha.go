@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/metrics"
+	"github.com/d44b/pulltrace/internal/model"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// forwardedByHeader marks a request a follower proxied to the leader, so a
+// replica with an outdated view of the leader answers 503 instead of
+// forwarding it again.
+const forwardedByHeader = "X-Pulltrace-Forwarded-By"
+
+// electionTimings are the Lease parameters; the defaults match
+// kube-controller-manager.
+type electionTimings struct {
+	leaseDuration time.Duration
+	renewDeadline time.Duration
+	retryPeriod   time.Duration
+}
+
+var defaultElectionTimings = electionTimings{
+	leaseDuration: 15 * time.Second,
+	renewDeadline: 10 * time.Second,
+	retryPeriod:   2 * time.Second,
+}
+
+// replica tracks this server's role when several replicas share one Lease.
+// Only the leader receives agent reports and holds pull state; followers
+// proxy every API request, event streams included, to it.
+type replica struct {
+	identity string // this replica's advertise URL
+
+	mu      sync.RWMutex
+	leading bool
+	leader  string
+	proxy   *httputil.ReverseProxy
+	// term is cancelled when the leader changes, ending proxied streams to
+	// the previous one.
+	term       context.Context
+	cancelTerm context.CancelFunc
+}
+
+func newReplica(identity string) *replica {
+	term, cancel := context.WithCancel(context.Background())
+	return &replica{identity: identity, term: term, cancelTerm: cancel}
+}
+
+// setLeader records the identity of the observed leader, which is its
+// advertise URL.
+func (r *replica) setLeader(identity string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if identity == r.leader {
+		return
+	}
+	r.leader = identity
+	r.cancelTerm()
+	r.term, r.cancelTerm = context.WithCancel(context.Background())
+	r.proxy = nil
+	if identity == "" || identity == r.identity {
+		return
+	}
+	if target, err := url.Parse(identity); err == nil {
+		r.proxy = &httputil.ReverseProxy{
+			Rewrite: func(pr *httputil.ProxyRequest) {
+				pr.SetURL(target)
+				pr.SetXForwarded()
+			},
+			// Flush immediately so event streams are not buffered.
+			FlushInterval: -1,
+			ErrorHandler: func(w http.ResponseWriter, _ *http.Request, err error) {
+				http.Error(w, "leader unreachable: "+err.Error(), http.StatusBadGateway)
+			},
+		}
+	}
+}
+
+func (r *replica) setLeading(leading bool) {
+	r.mu.Lock()
+	r.leading = leading
+	r.mu.Unlock()
+	v := 0.0
+	if leading {
+		v = 1
+	}
+	metrics.ServerLeader.Set(v)
+}
+
+func (r *replica) isLeading() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.leading
+}
+
+func (r *replica) current() (leading bool, leader string, proxy *httputil.ReverseProxy, term context.Context) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.leading, r.leader, r.proxy, r.term
+}
+
+// leaderOnly serves h on the leader and proxies the request to the leader
+// on followers. Without leader election it returns h unchanged.
+func (s *Server) leaderOnly(h http.HandlerFunc) http.HandlerFunc {
+	if s.replica == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		leading, leader, proxy, term := s.replica.current()
+		switch {
+		case leading:
+			h(w, r)
+		case proxy == nil:
+			http.Error(w, "no leader elected", http.StatusServiceUnavailable)
+		case r.Header.Get(forwardedByHeader) != "":
+			http.Error(w, "not the leader", http.StatusServiceUnavailable)
+		default:
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+			stop := context.AfterFunc(term, cancel)
+			defer stop()
+
+			// The leader sets its own security headers.
+			for k := range w.Header() {
+				delete(w.Header(), k)
+			}
+			r = r.WithContext(ctx)
+			r.Header.Set(forwardedByHeader, s.replica.identity)
+			s.logger.Debug("proxying to leader", "path", r.URL.Path, "leader", leader)
+			proxy.ServeHTTP(w, r)
+		}
+	}
+}
+
+// runLeaderElection campaigns for the Lease until ctx is cancelled,
+// rejoining as a candidate whenever leadership is lost.
+func (s *Server) runLeaderElection(ctx context.Context, cs kubernetes.Interface) {
+	for ctx.Err() == nil {
+		var le *leaderelection.LeaderElector
+		var err error
+		le, err = leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+			Lock: &resourcelock.LeaseLock{
+				LeaseMeta:  metav1.ObjectMeta{Name: s.config.LeaseName, Namespace: s.config.Namespace},
+				Client:     cs.CoordinationV1(),
+				LockConfig: resourcelock.ResourceLockConfig{Identity: s.replica.identity},
+			},
+			LeaseDuration:   s.election.leaseDuration,
+			RenewDeadline:   s.election.renewDeadline,
+			RetryPeriod:     s.election.retryPeriod,
+			ReleaseOnCancel: true,
+			Name:            s.config.LeaseName,
+			Callbacks: leaderelection.LeaderCallbacks{
+				// ctx is cancelled when leadership is lost, which ends the
+				// downstream subscriptions.
+				OnStartedLeading: func(ctx context.Context) {
+					s.logger.Info("started leading", "lease", s.config.LeaseName)
+					s.replica.setLeading(true)
+					s.runFederation(ctx)
+				},
+				OnStoppedLeading: s.stopLeading,
+				// Callbacks run asynchronously, so read the latest
+				// observation rather than trusting the argument's order.
+				OnNewLeader: func(string) {
+					leader := le.GetLeader()
+					s.logger.Info("observed leader", "leader", leader)
+					s.replica.setLeader(leader)
+				},
+			},
+		})
+		if err != nil {
+			s.logger.Error("leader election misconfigured", "error", err)
+			return
+		}
+		le.Run(ctx)
+	}
+}
+
+// stopLeading drops the local and federated pull state of a replica that
+// lost the Lease and ends its event streams, so clients reconnect and reach
+// the new leader.
+func (s *Server) stopLeading() {
+	if !s.replica.isLeading() {
+		return
+	}
+	s.logger.Warn("stopped leading", "lease", s.config.LeaseName)
+	s.replica.setLeading(false)
+
+	s.mu.Lock()
+	for _, p := range s.pulls {
+		if p.CompletedAt == nil {
+			metrics.PullsActive.Dec()
+		}
+		trackStalled(p.Stalled, false)
+	}
+	s.pulls = make(map[string]*model.PullStatus)
+	s.rates = make(map[string]*model.RateCalculator)
+	s.lastSeen = make(map[string]time.Time)
+	s.lastBytes = make(map[string]int64)
+	s.progress = make(map[string]progressMark)
+	s.mu.Unlock()
+	s.resetFederation()
+
+	s.agents.prune(time.Now(), -1) // drops every agent and its gauges
+	if s.notifier != nil {
+		s.notifier.Reset()
+	}
+	if s.alerting != nil {
+		s.alerting.Reset()
+	}
+
+	s.sseMu.Lock()
+	for ch := range s.sseClients {
+		delete(s.sseClients, ch)
+		close(ch)
+	}
+	s.sseMu.Unlock()
+}
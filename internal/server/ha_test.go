@@ -0,0 +1,253 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+type testReplica struct {
+	s      *Server
+	srv    *httptest.Server
+	cancel context.CancelFunc
+}
+
+// startReplicas runs n in-process servers that elect a leader through a
+// Lease in a shared fake clientset, each federating the given downstreams.
+func startReplicas(t *testing.T, n int, downstreams ...Downstream) []*testReplica {
+	t.Helper()
+	cs := fake.NewSimpleClientset()
+	replicas := make([]*testReplica, n)
+	for i := range replicas {
+		srv := httptest.NewUnstartedServer(nil)
+		s := New(Config{
+			LogLevel:       "error",
+			HistoryTTL:     30 * time.Minute,
+			Namespace:      "pulltrace",
+			LeaseName:      "pulltrace-server",
+			LeaderElection: true,
+			AdvertiseURL:   "http://" + srv.Listener.Addr().String(),
+		}, nil)
+		// Lease durations are stored in whole seconds.
+		s.election = electionTimings{
+			leaseDuration: 2 * time.Second,
+			renewDeadline: time.Second,
+			retryPeriod:   100 * time.Millisecond,
+		}
+		if len(downstreams) > 0 {
+			if err := s.configureFederation(downstreams); err != nil {
+				t.Fatal(err)
+			}
+		}
+		srv.Config.Handler = s.Handler()
+		srv.Start()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		go s.runLeaderElection(ctx, kubernetes.Interface(cs))
+		replicas[i] = &testReplica{s: s, srv: srv, cancel: cancel}
+		t.Cleanup(func() {
+			cancel()
+			srv.CloseClientConnections()
+			srv.Close()
+		})
+	}
+	return replicas
+}
+
+// stableLeader waits until every replica agrees on one leader for longer
+// than a renew deadline and returns its index.
+func stableLeader(t *testing.T, replicas []*testReplica) int {
+	t.Helper()
+	agreed := func() int {
+		leader := -1
+		for i, r := range replicas {
+			leading, observed, _, _ := r.s.replica.current()
+			if observed == "" {
+				return -1
+			}
+			if leading {
+				if leader != -1 {
+					return -1
+				}
+				leader = i
+			}
+			if leader != -1 && observed != replicas[leader].s.replica.identity {
+				return -1
+			}
+		}
+		return leader
+	}
+	var leader int
+	eventually(t, "a single leader", func() bool {
+		if leader = agreed(); leader == -1 {
+			return false
+		}
+		time.Sleep(500 * time.Millisecond)
+		return agreed() == leader
+	})
+	return leader
+}
+
+func postHTTPReport(t *testing.T, url string, node, image string) {
+	t.Helper()
+	body, _ := json.Marshal(model.AgentReport{
+		ProtocolVersion: model.ProtocolVersion,
+		NodeName:        node,
+		Timestamp:       time.Now(),
+		Pulls: []model.PullState{{
+			ImageRef:   image,
+			StartedAt:  time.Now(),
+			TotalKnown: true,
+			Layers:     []model.LayerState{{Digest: "sha256:aaa", TotalBytes: 100, DownloadedBytes: 10, TotalKnown: true}},
+		}},
+	})
+	resp, err := http.Post(url+"/api/v1/report", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("report via %s: status %d", url, resp.StatusCode)
+	}
+}
+
+func listPullIDs(t *testing.T, url string) []string {
+	t.Helper()
+	resp, err := http.Get(url + "/api/v1/pulls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var body model.APIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("GET %s/api/v1/pulls: status %d: %v", url, resp.StatusCode, err)
+	}
+	ids := make([]string, 0, len(body.Pulls))
+	for _, p := range body.Pulls {
+		ids = append(ids, p.ID)
+	}
+	return ids
+}
+
+func others(replicas []*testReplica, skip int) []*testReplica {
+	var out []*testReplica
+	for i, r := range replicas {
+		if i != skip {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+func TestReplicas_ShareStateThroughLeader(t *testing.T) {
+	replicas := startReplicas(t, 3)
+	leader := stableLeader(t, replicas)
+	followers := others(replicas, leader)
+
+	// A follower keeps an event stream open through the leader.
+	resp, r := openSSE(t, followers[1].srv.URL, "")
+	defer resp.Body.Close()
+
+	// An agent reports to the other follower.
+	postHTTPReport(t, followers[0].srv.URL, "node1", "nginx:1.27")
+
+	want := listPullIDs(t, replicas[leader].srv.URL)
+	if len(want) != 1 {
+		t.Fatalf("leader has pulls %v, want 1", want)
+	}
+	for i, rep := range replicas {
+		if got := listPullIDs(t, rep.srv.URL); len(got) != 1 || got[0] != want[0] {
+			t.Errorf("replica %d serves pulls %v, want %v", i, got, want)
+		}
+	}
+	if _, events := readSSE(t, r, 1); events[0].Pull.ID != want[0] {
+		t.Errorf("event stream on a follower got %+v", events[0].Pull)
+	}
+	if followers[0].s.replica.isLeading() || len(followers[0].s.snapshotPulls()) != 0 {
+		t.Error("follower holds pull state")
+	}
+}
+
+func TestReplicas_Failover(t *testing.T) {
+	replicas := startReplicas(t, 3)
+	leader := stableLeader(t, replicas)
+	resp, r := openSSE(t, others(replicas, leader)[0].srv.URL, "")
+	defer resp.Body.Close()
+
+	// The leader steps down and releases the Lease.
+	replicas[leader].cancel()
+	closed := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, r) //nolint:errcheck
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event stream through the old leader stayed open")
+	}
+
+	remaining := others(replicas, leader)
+	next := stableLeader(t, remaining)
+	postHTTPReport(t, remaining[1-next].srv.URL, "node2", "redis:7")
+	want := listPullIDs(t, remaining[next].srv.URL)
+	if len(want) != 1 {
+		t.Fatalf("new leader has pulls %v, want 1", want)
+	}
+	if got := listPullIDs(t, remaining[1-next].srv.URL); len(got) != 1 || got[0] != want[0] {
+		t.Errorf("follower serves %v, want %v", got, want)
+	}
+	if replicas[leader].s.replica.isLeading() {
+		t.Error("old leader still leading")
+	}
+}
+
+func TestReplicas_FederateOnLeaderOnly(t *testing.T) {
+	downstream := newTestServer()
+	downstream.processReport(model.AgentReport{
+		NodeName:  "node1",
+		Timestamp: time.Now(),
+		Pulls:     []model.PullState{{ImageRef: "nginx:1.27", StartedAt: time.Now()}},
+	})
+	srv := httptest.NewServer(downstream.Handler())
+	// Registered first so it runs after the replicas stop.
+	t.Cleanup(func() {
+		srv.CloseClientConnections()
+		srv.Close()
+	})
+
+	replicas := startReplicas(t, 2, Downstream{Name: "east", URL: srv.URL})
+	leader := stableLeader(t, replicas)
+	eventually(t, "the leader to federate", func() bool {
+		return len(replicas[leader].s.remotePulls()) == 1
+	})
+	follower := replicas[1-leader]
+	if got := follower.s.remotePulls(); len(got) != 0 {
+		t.Errorf("follower holds federated pulls %+v", got)
+	}
+	if got := listPullIDs(t, follower.srv.URL); len(got) != 1 {
+		t.Errorf("follower serves %v, want the leader's federated pull", got)
+	}
+
+	// The old leader drops the federated pulls and the new one subscribes.
+	replicas[leader].cancel()
+	eventually(t, "the new leader to federate", func() bool {
+		return follower.s.replica.isLeading() && len(follower.s.remotePulls()) == 1
+	})
+	old := replicas[leader].s
+	if got := old.remotePulls(); len(got) != 0 {
+		t.Errorf("old leader holds federated pulls %+v", got)
+	}
+	if c := old.federation.clusters[0].status(); c.Connected || c.LastEvent != nil {
+		t.Errorf("old leader's downstream = %+v", c)
+	}
+}
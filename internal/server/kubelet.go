@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/k8s"
+	"github.com/d44b/pulltrace/internal/metrics"
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+// A kubelet and an agent figure disagree once they differ by more than
+// discrepancyRatio of the larger one and by more than the minimum below.
+const (
+	discrepancyRatio       = 0.25
+	minSizeDiscrepancy     = 1 << 20
+	minDurationDiscrepancy = 10 * time.Second
+	// kubeletClockSkew widens the window in which an agent pull must have
+	// been active to match a kubelet event.
+	kubeletClockSkew = 30 * time.Second
+)
+
+// observeKubeletPull reconciles a kubelet Pulled event with the agent's
+// account of the same pull, or records a pull no agent reported. Only the
+// leader tracks pulls, so followers ignore the event.
+func (s *Server) observeKubeletPull(kp k8s.PulledImage) {
+	if s.replica != nil && !s.replica.isLeading() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kubelet := &model.KubeletPull{
+		PulledAt:        kp.At,
+		DurationSeconds: kp.Duration.Seconds(),
+		WaitingSeconds:  kp.Waiting.Seconds(),
+		SizeBytes:       kp.SizeBytes,
+	}
+	pull := s.kubeletPullOf(kp)
+	switch {
+	case pull == nil:
+		pull = s.recordMissedPull(kp, kubelet)
+		if pull == nil {
+			return
+		}
+	case pull.Kubelet != nil:
+		// Another pod's event for the same pull; the first one counts.
+		return
+	default:
+		pull.Kubelet = kubelet
+		pull.Discrepancies = discrepancies(pull)
+	}
+
+	for _, d := range pull.Discrepancies {
+		metrics.PullDiscrepancies.WithLabelValues(string(d)).Inc()
+	}
+	if len(pull.Discrepancies) > 0 {
+		s.logger.Warn("pull.discrepancy",
+			"node", pull.NodeName,
+			"image", pull.ImageRef,
+			"discrepancies", pull.Discrepancies,
+			"kubeletSeconds", kubelet.DurationSeconds,
+			"kubeletBytes", kubelet.SizeBytes,
+		)
+	}
+}
+
+// kubeletPullOf finds the pull a kubelet Pulled event reports: the latest
+// pull of the image on the node that was active while the kubelet pulled,
+// preferring one the event's pod waited on. A pull whose image the agent
+// could not name matches if the pod waited on it. s.mu must be held.
+func (s *Server) kubeletPullOf(kp k8s.PulledImage) *model.PullStatus {
+	start := kp.At.Add(-kp.Duration - kp.Waiting - kubeletClockSkew)
+	var byPod, byImage *model.PullStatus
+	for _, p := range s.pulls {
+		if p.NodeName != kp.NodeName || p.Cluster != s.config.ClusterName {
+			continue
+		}
+		if p.CompletedAt != nil && p.CompletedAt.Before(start) {
+			continue
+		}
+		sameImage := k8s.SameImage(p.ImageRef, kp.Image)
+		unnamed := p.ImageRef == "__pulling__" || isContentDigest(p.ImageRef)
+		switch {
+		case waitedOnBy(p, kp) && (sameImage || unnamed):
+			if byPod == nil || p.StartedAt.After(byPod.StartedAt) {
+				byPod = p
+			}
+		case sameImage:
+			if byImage == nil || p.StartedAt.After(byImage.StartedAt) {
+				byImage = p
+			}
+		}
+	}
+	if byPod != nil {
+		return byPod
+	}
+	return byImage
+}
+
+// waitedOnBy reports whether the pod of a kubelet event waited on a pull.
+func waitedOnBy(p *model.PullStatus, kp k8s.PulledImage) bool {
+	for _, pc := range p.Pods {
+		if pc.Namespace == kp.Namespace && pc.PodName == kp.PodName && (kp.PodUID == "" || pc.PodUID == "" || pc.PodUID == kp.PodUID) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordMissedPull adds a completed pull for a kubelet event no agent pull
+// matches, typically a pull that finished between two agent polls. s.mu
+// must be held.
+func (s *Server) recordMissedPull(kp k8s.PulledImage, kubelet *model.KubeletPull) *model.PullStatus {
+	key := kp.NodeName + ":" + kp.Image
+	if _, ok := s.pulls[key]; !ok && len(s.pulls) >= maxActivePulls {
+		s.logger.Warn("pulls map at capacity, dropping missed pull",
+			"node", kp.NodeName,
+			"image", kp.Image,
+			"limit", maxActivePulls,
+		)
+		return nil
+	}
+
+	startedAt := kp.At.Add(-kp.Duration)
+	completedAt := kp.At
+	pull := &model.PullStatus{
+		ID:              fmt.Sprintf("%s@%d", key, startedAt.UnixNano()),
+		Cluster:         s.config.ClusterName,
+		NodeName:        kp.NodeName,
+		ImageRef:        kp.Image,
+		TotalBytes:      kp.SizeBytes,
+		DownloadedBytes: kp.SizeBytes,
+		TotalKnown:      kp.SizeBytes > 0,
+		Percent:         100,
+		StartedAt:       startedAt,
+		CompletedAt:     &completedAt,
+		Pods:            []model.PodCorrelation{{Namespace: kp.Namespace, PodName: kp.PodName, PodUID: kp.PodUID, Container: kp.Container, Kind: kp.Kind}},
+		Kubelet:         kubelet,
+		Discrepancies:   []model.Discrepancy{model.DiscrepancyMissed},
+	}
+	s.pulls[key] = pull
+	s.forgetProgress(key)
+	metrics.PullsTotal.Inc()
+
+	event := model.PullEvent{
+		SchemaVersion: model.SchemaVersion,
+		Timestamp:     time.Now(),
+		Type:          model.EventPullCompleted,
+		NodeName:      kp.NodeName,
+		Pull:          pull,
+	}
+	if data, err := json.Marshal(event); err == nil {
+		s.broadcastSSE(data)
+	}
+	return pull
+}
+
+// discrepancies compares the kubelet's account of a pull with the agent's.
+// Agents do not see layers already present on the node, so their total is
+// only compared when it is known from the manifest or exceeds the kubelet's.
+func discrepancies(p *model.PullStatus) []model.Discrepancy {
+	var found []model.Discrepancy
+	k := p.Kubelet
+	if k.SizeBytes > 0 {
+		agentBytes := p.ManifestBytes
+		if agentBytes == 0 && p.TotalBytes > k.SizeBytes {
+			agentBytes = p.TotalBytes
+		}
+		if agentBytes > 0 && differ(float64(agentBytes), float64(k.SizeBytes), minSizeDiscrepancy) {
+			found = append(found, model.DiscrepancySize)
+		}
+	}
+	end := k.PulledAt
+	if p.CompletedAt != nil {
+		end = *p.CompletedAt
+	}
+	if differ(end.Sub(p.StartedAt).Seconds(), k.DurationSeconds, minDurationDiscrepancy.Seconds()) {
+		found = append(found, model.DiscrepancyDuration)
+	}
+	return found
+}
+
+// differ reports whether a and b differ by more than minDiff and by more
+// than discrepancyRatio of the larger one.
+func differ(a, b, minDiff float64) bool {
+	d := math.Abs(a - b)
+	return d > minDiff && d > discrepancyRatio*math.Max(a, b)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/k8s"
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+func TestObserveKubeletPull_Reconciles(t *testing.T) {
+	s := newTestServer()
+	pulledAt := time.Date(2026, 3, 1, 12, 1, 0, 0, time.UTC)
+	at := func(sec int) *time.Time {
+		t := pulledAt.Add(time.Duration(sec) * time.Second)
+		return &t
+	}
+	pod := []model.PodCorrelation{{Namespace: "default", PodName: "web-0", Container: "app"}}
+	// The agent saw nginx for 20s, the kubelet reports 60s.
+	s.pulls["node1:nginx:1.27"] = &model.PullStatus{ID: "a", NodeName: "node1", ImageRef: "nginx:1.27",
+		TotalBytes: 30 << 20, StartedAt: *at(-20), CompletedAt: at(0), Pods: pod}
+	// An agent pull of another image of the same pod.
+	s.pulls["node1:sidecar:1"] = &model.PullStatus{ID: "b", NodeName: "node1", ImageRef: "sidecar:1",
+		StartedAt: *at(-5), CompletedAt: at(0), Pods: pod}
+
+	s.observeKubeletPull(k8s.PulledImage{
+		NodeName: "node1", Image: "docker.io/library/nginx:1.27", Namespace: "default", PodName: "web-0",
+		At: pulledAt, Duration: 60 * time.Second, Waiting: 2 * time.Second, SizeBytes: 40 << 20,
+	})
+
+	pull := s.pulls["node1:nginx:1.27"]
+	if pull.Kubelet == nil || pull.Kubelet.DurationSeconds != 60 || pull.Kubelet.WaitingSeconds != 2 || pull.Kubelet.SizeBytes != 40<<20 {
+		t.Fatalf("kubelet = %+v", pull.Kubelet)
+	}
+	// The agent's total is below the kubelet's size, as it is when layers
+	// were already present, so only the duration is flagged.
+	if !slices.Equal(pull.Discrepancies, []model.Discrepancy{model.DiscrepancyDuration}) {
+		t.Errorf("discrepancies = %v, want [duration]", pull.Discrepancies)
+	}
+	if s.pulls["node1:sidecar:1"].Kubelet != nil {
+		t.Error("the pod's other pull was reconciled too")
+	}
+
+	// Another pod's event for the same pull changes nothing.
+	s.observeKubeletPull(k8s.PulledImage{
+		NodeName: "node1", Image: "nginx:1.27", Namespace: "default", PodName: "web-1",
+		At: pulledAt.Add(time.Second), Duration: 5 * time.Second,
+	})
+	if pull.Kubelet.DurationSeconds != 60 || len(s.pulls) != 2 {
+		t.Errorf("duplicate event: kubelet = %+v, %d pulls", pull.Kubelet, len(s.pulls))
+	}
+}
+
+func TestObserveKubeletPull_Missed(t *testing.T) {
+	s := newTestServer()
+	pulledAt := time.Now()
+	// A pull of the same image that completed long before the kubelet's.
+	old := pulledAt.Add(-time.Hour)
+	s.pulls["node1:redis:7"] = &model.PullStatus{ID: "a", NodeName: "node1", ImageRef: "redis:7",
+		StartedAt: old.Add(-time.Minute), CompletedAt: &old}
+
+	s.observeKubeletPull(k8s.PulledImage{
+		NodeName: "node1", Image: "redis:7", Namespace: "default", PodName: "cache-0", PodUID: "u1",
+		Container: "redis", Kind: model.CorrelationContainer,
+		At: pulledAt, Duration: 200 * time.Millisecond, SizeBytes: 5000,
+	})
+
+	pull := s.pulls["node1:redis:7"]
+	if pull.ID == "a" {
+		t.Fatal("kubelet pull matched an old agent pull")
+	}
+	if !slices.Equal(pull.Discrepancies, []model.Discrepancy{model.DiscrepancyMissed}) {
+		t.Errorf("discrepancies = %v, want [missed]", pull.Discrepancies)
+	}
+	if pull.CompletedAt == nil || !pull.CompletedAt.Equal(pulledAt) || !pull.StartedAt.Equal(pulledAt.Add(-200*time.Millisecond)) {
+		t.Errorf("started %v, completed %v", pull.StartedAt, pull.CompletedAt)
+	}
+	if pull.TotalBytes != 5000 || pull.DownloadedBytes != 5000 || !pull.TotalKnown || pull.Percent != 100 {
+		t.Errorf("bytes %d/%d, known %v, percent %v", pull.DownloadedBytes, pull.TotalBytes, pull.TotalKnown, pull.Percent)
+	}
+	if len(pull.Pods) != 1 || pull.Pods[0].PodName != "cache-0" || pull.Pods[0].PodUID != "u1" ||
+		pull.Pods[0].Container != "redis" || pull.Pods[0].Kind != model.CorrelationContainer {
+		t.Errorf("pods = %+v", pull.Pods)
+	}
+}
+
+func TestDiscrepancies(t *testing.T) {
+	start := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	end := start.Add(30 * time.Second)
+	tests := []struct {
+		name string
+		pull model.PullStatus
+		want []model.Discrepancy
+	}{
+		{"agreeing", model.PullStatus{ManifestBytes: 100 << 20, CompletedAt: &end,
+			Kubelet: &model.KubeletPull{DurationSeconds: 33, SizeBytes: 105 << 20}}, nil},
+		{"manifest size", model.PullStatus{ManifestBytes: 50 << 20, CompletedAt: &end,
+			Kubelet: &model.KubeletPull{DurationSeconds: 30, SizeBytes: 100 << 20}}, []model.Discrepancy{model.DiscrepancySize}},
+		{"agent total above kubelet size", model.PullStatus{TotalBytes: 300 << 20, CompletedAt: &end,
+			Kubelet: &model.KubeletPull{DurationSeconds: 30, SizeBytes: 100 << 20}}, []model.Discrepancy{model.DiscrepancySize}},
+		{"small absolute difference", model.PullStatus{ManifestBytes: 1000, CompletedAt: &end,
+			Kubelet: &model.KubeletPull{DurationSeconds: 25, SizeBytes: 3000}}, nil},
+		{"active pull", model.PullStatus{
+			Kubelet: &model.KubeletPull{PulledAt: start.Add(2 * time.Minute), DurationSeconds: 30}}, []model.Discrepancy{model.DiscrepancyDuration}},
+	}
+	for _, tt := range tests {
+		tt.pull.StartedAt = start
+		if got := discrepancies(&tt.pull); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: discrepancies = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec documents the HTTP API. openapi_test.go fails when it drifts
+// from the registered routes or from the JSON shape of the model types.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec) //nolint:errcheck
+}
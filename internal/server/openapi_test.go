@@ -0,0 +1,259 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+type openAPIDoc struct {
+	OpenAPI string                                `json:"openapi"`
+	Paths   map[string]map[string]json.RawMessage `json:"paths"`
+	Comps   struct {
+		Schemas map[string]openAPISchema `json:"schemas"`
+	} `json:"components"`
+}
+
+type openAPISchema struct {
+	Ref        string                   `json:"$ref"`
+	Type       string                   `json:"type"`
+	Format     string                   `json:"format"`
+	Required   []string                 `json:"required"`
+	Properties map[string]openAPISchema `json:"properties"`
+	Items      *openAPISchema           `json:"items"`
+	Enum       []string                 `json:"enum"`
+}
+
+func loadSpec(t *testing.T) openAPIDoc {
+	t.Helper()
+	var doc openAPIDoc
+	if err := json.Unmarshal(openAPISpec, &doc); err != nil {
+		t.Fatalf("openapi.json is not valid JSON: %v", err)
+	}
+	if !strings.HasPrefix(doc.OpenAPI, "3.") {
+		t.Fatalf("openapi = %q, want 3.x", doc.OpenAPI)
+	}
+	return doc
+}
+
+// specPath converts a ServeMux pattern to its OpenAPI path template.
+func specPath(pattern string) string {
+	return strings.ReplaceAll(pattern, "...}", "}")
+}
+
+func TestOpenAPI_Served(t *testing.T) {
+	srv := httptest.NewServer(newTestServer().Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/v1/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	var doc map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenAPI_PathsMatchRoutes(t *testing.T) {
+	doc := loadSpec(t)
+
+	var routes, documented []string
+	for pattern := range newTestServer().apiRoutes() {
+		routes = append(routes, specPath(pattern))
+	}
+	for path := range doc.Paths {
+		documented = append(documented, path)
+	}
+	sort.Strings(routes)
+	sort.Strings(documented)
+	if !reflect.DeepEqual(routes, documented) {
+		t.Errorf("registered routes %v != documented paths %v", routes, documented)
+	}
+}
+
+// TestOpenAPI_MethodsMatchHandlers checks that every documented operation is
+// handled and that undocumented methods are rejected.
+func TestOpenAPI_MethodsMatchHandlers(t *testing.T) {
+	doc := loadSpec(t)
+	h := newTestServer().Handler()
+
+	methods := []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch}
+	for path, ops := range doc.Paths {
+		url := strings.ReplaceAll(path, "{id}", "node1:missing@1")
+		for _, method := range methods {
+			_, documented := ops[strings.ToLower(method)]
+
+			// Cancelled so the event stream returns immediately.
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			req := httptest.NewRequest(method, url, strings.NewReader("{}")).WithContext(ctx)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code == http.StatusNotFound && strings.Contains(w.Body.String(), "404 page not found") {
+				t.Errorf("%s %s: no handler registered", method, path)
+				continue
+			}
+			if documented && w.Code == http.StatusMethodNotAllowed {
+				t.Errorf("%s %s is documented but the handler rejects it", method, path)
+			}
+			if !documented && w.Code != http.StatusMethodNotAllowed {
+				t.Errorf("%s %s is not documented but the handler answered %d", method, path, w.Code)
+			}
+		}
+	}
+}
+
+// TestOpenAPI_SchemasMatchModel compares each component schema with the JSON
+// encoding of the corresponding model type.
+func TestOpenAPI_SchemasMatchModel(t *testing.T) {
+	doc := loadSpec(t)
+	types := []any{
+		model.APIResponse{},
+		model.PullEvent{},
+		model.PullStatus{},
+		model.LayerStatus{},
+		model.KubeletPull{},
+		model.PullRetries{},
+		model.PodCorrelation{},
+		model.PodStatus{},
+		model.PodPull{},
+		model.PodTimeline{},
+		model.TimelineSegment{},
+		model.WorkloadRef{},
+		model.WorkloadStatus{},
+		model.WorkloadNode{},
+		model.WorkloadsResponse{},
+		model.AgentReport{},
+		model.PullState{},
+		model.LayerState{},
+		model.ReportRejection{},
+		model.AgentsResponse{},
+		model.AgentStatus{},
+		model.ClustersResponse{},
+		model.ClusterStatus{},
+		model.AlertsResponse{},
+		model.Alert{},
+	}
+	for _, v := range types {
+		typ := reflect.TypeOf(v)
+		schema, ok := doc.Comps.Schemas[typ.Name()]
+		if !ok {
+			t.Errorf("components.schemas.%s is missing", typ.Name())
+			continue
+		}
+		checkStruct(t, doc, typ, schema)
+	}
+
+	enums := map[string][]string{
+		"EventType":       {string(model.EventPullProgress), string(model.EventPullCompleted), string(model.EventPullStalled), string(model.EventPodProgress), string(model.EventPodCompleted), string(model.EventAlertFiring), string(model.EventAlertResolved), string(model.EventWorkloadProgress), string(model.EventWorkloadCompleted)},
+		"Capability":      {string(model.CapabilityHeartbeat), string(model.CapabilityAgentStats)},
+		"AlertState":      {string(model.AlertPending), string(model.AlertFiring), string(model.AlertResolved)},
+		"Discrepancy":     {string(model.DiscrepancyMissed), string(model.DiscrepancySize), string(model.DiscrepancyDuration)},
+		"CorrelationKind": {string(model.CorrelationContainer), string(model.CorrelationInitContainer), string(model.CorrelationEphemeralContainer), string(model.CorrelationImageVolume)},
+	}
+	for name, want := range enums {
+		got := doc.Comps.Schemas[name].Enum
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("components.schemas.%s enum = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func checkStruct(t *testing.T, doc openAPIDoc, typ reflect.Type, schema openAPISchema) {
+	t.Helper()
+	var required []string
+	seen := make(map[string]bool)
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		tag := f.Tag.Get("json")
+		if !f.IsExported() || tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		seen[name] = true
+		if opts != "omitempty" {
+			required = append(required, name)
+		}
+
+		prop, ok := schema.Properties[name]
+		if !ok {
+			t.Errorf("%s.%s is not documented", typ.Name(), name)
+			continue
+		}
+		checkType(t, doc, typ.Name()+"."+name, f.Type, prop)
+	}
+	for name := range schema.Properties {
+		if !seen[name] {
+			t.Errorf("%s.%s is documented but not in the Go type", typ.Name(), name)
+		}
+	}
+
+	sort.Strings(required)
+	documented := append([]string(nil), schema.Required...)
+	sort.Strings(documented)
+	if !reflect.DeepEqual(required, documented) {
+		t.Errorf("%s required = %v, want %v (fields without omitempty)", typ.Name(), documented, required)
+	}
+}
+
+func checkType(t *testing.T, doc openAPIDoc, where string, typ reflect.Type, s openAPISchema) {
+	t.Helper()
+	if typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if s.Ref != "" {
+		name := strings.TrimPrefix(s.Ref, "#/components/schemas/")
+		if _, ok := doc.Comps.Schemas[name]; !ok {
+			t.Errorf("%s: dangling $ref %s", where, s.Ref)
+		}
+		if name != typ.Name() {
+			t.Errorf("%s: $ref %s, want %s", where, name, typ.Name())
+		}
+		return
+	}
+
+	var want, format string
+	switch {
+	case typ == reflect.TypeOf(time.Time{}):
+		want, format = "string", "date-time"
+	case typ.Kind() == reflect.String:
+		want = "string"
+	case typ.Kind() == reflect.Bool:
+		want = "boolean"
+	case typ.Kind() == reflect.Float32 || typ.Kind() == reflect.Float64:
+		want = "number"
+	case typ.Kind() >= reflect.Int && typ.Kind() <= reflect.Uint64:
+		want = "integer"
+	case typ.Kind() == reflect.Slice:
+		want = "array"
+	default:
+		want = "object"
+	}
+	if s.Type != want {
+		t.Errorf("%s: type %q, want %q", where, s.Type, want)
+	}
+	if format != "" && s.Format != format {
+		t.Errorf("%s: format %q, want %q", where, s.Format, format)
+	}
+	if want == "array" {
+		if s.Items == nil {
+			t.Errorf("%s: array without items", where)
+			return
+		}
+		checkType(t, doc, where+"[]", typ.Elem(), *s.Items)
+	}
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+// podRef identifies a pod by namespace and name.
+type podRef struct{ namespace, name string }
+
+// podPulls returns the local pulls correlated with a pod. s.mu must be held.
+func (s *Server) podPulls(ref podRef) []*model.PullStatus {
+	var pulls []*model.PullStatus
+	for _, p := range s.pulls {
+		for _, pc := range p.Pods {
+			if pc.Namespace == ref.namespace && pc.PodName == ref.name {
+				pulls = append(pulls, p)
+				break
+			}
+		}
+	}
+	return pulls
+}
+
+// broadcastPods sends a pod event for each pod in refs. s.mu must be held.
+func (s *Server) broadcastPods(refs map[podRef]bool, now time.Time) {
+	sorted := make([]podRef, 0, len(refs))
+	for ref := range refs {
+		sorted = append(sorted, ref)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].namespace != sorted[j].namespace {
+			return sorted[i].namespace < sorted[j].namespace
+		}
+		return sorted[i].name < sorted[j].name
+	})
+
+	for _, ref := range sorted {
+		pod, ok := aggregatePod(ref, s.podPulls(ref))
+		if !ok {
+			continue
+		}
+		typ := model.EventPodProgress
+		if pod.CompletedAt != nil {
+			typ = model.EventPodCompleted
+		}
+		event := model.PullEvent{
+			SchemaVersion: model.SchemaVersion,
+			Timestamp:     now,
+			Type:          typ,
+			NodeName:      pod.NodeName,
+			Pod:           &pod,
+		}
+		if data, err := json.Marshal(event); err == nil {
+			if typ == model.EventPodCompleted {
+				s.logger.Info("pod.completed",
+					"namespace", pod.Namespace,
+					"pod", pod.Name,
+					"pulls", pod.PullCount,
+					"duration", pod.CompletedAt.Sub(pod.StartedAt).String(),
+				)
+			} else {
+				s.logger.Debug("pod.progress",
+					"namespace", pod.Namespace,
+					"pod", pod.Name,
+					"percent", pod.Percent,
+				)
+			}
+			s.broadcastSSE(data)
+		}
+	}
+}
+
+func (s *Server) handlePod(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ref := podRef{namespace: r.PathValue("namespace"), name: r.PathValue("name")}
+	var pod model.PodStatus
+	var ok bool
+	if cluster := r.URL.Query().Get("cluster"); cluster == "" || cluster == s.config.ClusterName {
+		s.mu.RLock()
+		pod, ok = aggregatePod(ref, s.podPulls(ref))
+		s.mu.RUnlock()
+	} else if pulls, known := s.clusterPulls(cluster); !known {
+		http.Error(w, "cluster not found", http.StatusNotFound)
+		return
+	} else {
+		pod, ok = aggregatePod(ref, pulls)
+	}
+	if !ok {
+		http.Error(w, "pod not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pod) //nolint:errcheck
+}
+
+// aggregatePod combines the pulls correlated with a pod into a PodStatus.
+// Pulls left over from an earlier pod of the same name, such as a
+// StatefulSet replica that was recreated, are skipped. It returns false if
+// no pull belongs to the pod.
+func aggregatePod(ref podRef, pulls []*model.PullStatus) (model.PodStatus, bool) {
+	// The newest pull decides which incarnation of the pod is shown.
+	var uid string
+	var newest time.Time
+	for _, p := range pulls {
+		for _, pc := range p.Pods {
+			if pc.Namespace == ref.namespace && pc.PodName == ref.name && pc.PodUID != "" && !p.StartedAt.Before(newest) {
+				uid, newest = pc.PodUID, p.StartedAt
+			}
+		}
+	}
+
+	pod := model.PodStatus{Namespace: ref.namespace, Name: ref.name, UID: uid, TotalKnown: true}
+	var volumeETA, initETA, appETA float64
+	etaKnown, active := true, false
+	var completedAt time.Time
+	for _, p := range pulls {
+		// initOrder is the earliest init container waiting on the pull, or
+		// 0 if only app containers are. volume is set if an image volume
+		// waits on it.
+		initOrder, volume, matched := 0, false, false
+		for _, pc := range p.Pods {
+			if pc.Namespace != ref.namespace || pc.PodName != ref.name || (uid != "" && pc.PodUID != "" && pc.PodUID != uid) {
+				continue
+			}
+			matched = true
+			if pc.InitOrder > 0 && (initOrder == 0 || pc.InitOrder < initOrder) {
+				initOrder = pc.InitOrder
+			}
+			if pc.Kind == model.CorrelationImageVolume {
+				volume = true
+			}
+			pull := *p
+			pull.Layers = nil
+			pod.Containers = append(pod.Containers, model.PodPull{Container: pc.Container, Kind: pc.Kind, InitOrder: pc.InitOrder, Pull: pull})
+		}
+		if !matched {
+			continue
+		}
+
+		pod.PullCount++
+		if pod.NodeName == "" {
+			pod.NodeName, pod.Cluster = p.NodeName, p.Cluster
+		}
+		if pod.StartedAt.IsZero() || p.StartedAt.Before(pod.StartedAt) {
+			pod.StartedAt = p.StartedAt
+		}
+		pod.TotalBytes += p.TotalBytes
+		if p.CompletedAt != nil {
+			pod.PullsDone++
+			pod.DownloadedBytes += p.TotalBytes
+			if p.CompletedAt.After(completedAt) {
+				completedAt = *p.CompletedAt
+			}
+			continue
+		}
+
+		active = true
+		pod.DownloadedBytes += p.DownloadedBytes
+		pod.BytesPerSec += p.BytesPerSec
+		if !p.TotalKnown {
+			pod.TotalKnown = false
+		}
+		eta := p.ETASeconds
+		if eta <= 0 && !(p.TotalKnown && p.DownloadedBytes >= p.TotalBytes) {
+			etaKnown = false
+		}
+		// Image volumes are pulled together before any container starts.
+		// Init containers start one at a time, so their pulls run in
+		// sequence; the other containers' pulls start together after them.
+		switch {
+		case volume:
+			volumeETA = max(volumeETA, eta)
+		case initOrder > 0:
+			initETA += eta
+		default:
+			appETA = max(appETA, eta)
+		}
+	}
+	if pod.PullCount == 0 {
+		return model.PodStatus{}, false
+	}
+
+	if active && etaKnown {
+		pod.ETASeconds = volumeETA + initETA + appETA
+	}
+	if !active {
+		pod.CompletedAt = &completedAt
+		pod.Percent = 100
+	} else if pod.TotalBytes > 0 {
+		pod.Percent = float64(pod.DownloadedBytes) / float64(pod.TotalBytes) * 100
+	}
+	sort.SliceStable(pod.Containers, func(i, j int) bool {
+		a, b := pod.Containers[i], pod.Containers[j]
+		if av, bv := a.Kind == model.CorrelationImageVolume, b.Kind == model.CorrelationImageVolume; av != bv {
+			return av
+		}
+		if (a.InitOrder > 0) != (b.InitOrder > 0) {
+			return a.InitOrder > 0
+		}
+		if a.InitOrder != b.InitOrder {
+			return a.InitOrder < b.InitOrder
+		}
+		return a.Container < b.Container
+	})
+	return pod, true
+}
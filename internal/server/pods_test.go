@@ -0,0 +1,172 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+func TestAggregatePod_InitContainersAddUp(t *testing.T) {
+	now := time.Now()
+	done := now.Add(-time.Minute)
+	pod := func(container string, initOrder int) []model.PodCorrelation {
+		return []model.PodCorrelation{{Namespace: "default", PodName: "web-0", PodUID: "u1", Container: container, InitOrder: initOrder}}
+	}
+	pulls := []*model.PullStatus{
+		{ID: "migrate", NodeName: "node1", ImageRef: "migrate:1", StartedAt: now.Add(-3 * time.Minute), CompletedAt: &done,
+			TotalBytes: 100, DownloadedBytes: 90, TotalKnown: true, Pods: pod("migrate", 1)},
+		{ID: "seed", NodeName: "node1", ImageRef: "seed:1", StartedAt: now.Add(-time.Minute),
+			TotalBytes: 200, DownloadedBytes: 100, BytesPerSec: 10, ETASeconds: 10, TotalKnown: true, Pods: pod("seed", 2)},
+		{ID: "app", NodeName: "node1", ImageRef: "app:1", StartedAt: now,
+			TotalBytes: 400, DownloadedBytes: 100, BytesPerSec: 10, ETASeconds: 30, TotalKnown: true, Pods: pod("app", 0)},
+		{ID: "sidecar", NodeName: "node1", ImageRef: "sidecar:1", StartedAt: now,
+			TotalBytes: 300, DownloadedBytes: 100, BytesPerSec: 20, ETASeconds: 20, TotalKnown: true, Pods: pod("sidecar", 0)},
+		{ID: "other", NodeName: "node1", ImageRef: "other:1", StartedAt: now, TotalBytes: 999,
+			Pods: []model.PodCorrelation{{Namespace: "default", PodName: "db-0"}}},
+	}
+
+	p, ok := aggregatePod(podRef{"default", "web-0"}, pulls)
+	if !ok {
+		t.Fatal("pod not found")
+	}
+	if p.PullCount != 4 || p.PullsDone != 1 || p.CompletedAt != nil || p.UID != "u1" || p.NodeName != "node1" {
+		t.Errorf("pod = %+v", p)
+	}
+	// The completed pull counts as fully downloaded.
+	if p.TotalBytes != 1000 || p.DownloadedBytes != 400 || p.Percent != 40 || p.BytesPerSec != 40 {
+		t.Errorf("totals = %d/%d %.0f%% %.0fB/s", p.DownloadedBytes, p.TotalBytes, p.Percent, p.BytesPerSec)
+	}
+	// seed (10s) runs before the app containers, whose pulls overlap (30s).
+	if p.ETASeconds != 40 {
+		t.Errorf("ETASeconds = %v, want 40", p.ETASeconds)
+	}
+	var order []string
+	for _, c := range p.Containers {
+		order = append(order, c.Container)
+		if c.Pull.Layers != nil {
+			t.Errorf("%s: pull layers should be dropped", c.Container)
+		}
+	}
+	if got, want := order, []string{"migrate", "seed", "app", "sidecar"}; !slices.Equal(got, want) {
+		t.Errorf("containers = %v, want %v", got, want)
+	}
+
+	if _, ok := aggregatePod(podRef{"default", "missing"}, pulls); ok {
+		t.Error("unknown pod should not be found")
+	}
+}
+
+func TestAggregatePod_ImageVolumesFirst(t *testing.T) {
+	now := time.Now()
+	pull := func(id string, eta float64, kind model.CorrelationKind, initOrder int) *model.PullStatus {
+		return &model.PullStatus{ID: id, NodeName: "node1", ImageRef: id + ":1", StartedAt: now,
+			TotalBytes: 100, DownloadedBytes: 50, ETASeconds: eta, TotalKnown: true,
+			Pods: []model.PodCorrelation{{Namespace: "default", PodName: "web-0", Container: id, Kind: kind, InitOrder: initOrder}}}
+	}
+	pulls := []*model.PullStatus{
+		pull("app", 30, model.CorrelationContainer, 0),
+		pull("debug", 40, model.CorrelationEphemeralContainer, 0),
+		pull("migrate", 10, model.CorrelationInitContainer, 1),
+		pull("models", 15, model.CorrelationImageVolume, 0),
+		pull("weights", 5, model.CorrelationImageVolume, 0),
+	}
+
+	p, ok := aggregatePod(podRef{"default", "web-0"}, pulls)
+	if !ok {
+		t.Fatal("pod not found")
+	}
+	// The volumes (15s) are pulled together, then migrate (10s), then the
+	// other containers together (40s).
+	if p.ETASeconds != 65 {
+		t.Errorf("ETASeconds = %v, want 65", p.ETASeconds)
+	}
+	var order []string
+	for _, c := range p.Containers {
+		order = append(order, string(c.Kind)+"/"+c.Container)
+	}
+	want := []string{"imageVolume/models", "imageVolume/weights", "initContainer/migrate", "container/app", "ephemeralContainer/debug"}
+	if !slices.Equal(order, want) {
+		t.Errorf("containers = %v, want %v", order, want)
+	}
+}
+
+func TestAggregatePod_UnknownETAAndCompletion(t *testing.T) {
+	now := time.Now()
+	pc := []model.PodCorrelation{{Namespace: "default", PodName: "web-0", Container: "app"}}
+	pull := &model.PullStatus{ID: "app", StartedAt: now, TotalBytes: 100, DownloadedBytes: 10, Pods: pc}
+
+	p, _ := aggregatePod(podRef{"default", "web-0"}, []*model.PullStatus{pull})
+	if p.ETASeconds != 0 || p.TotalKnown {
+		t.Errorf("pod with an unsized pull = %+v", p)
+	}
+
+	completed := now.Add(time.Minute)
+	pull.CompletedAt = &completed
+	p, _ = aggregatePod(podRef{"default", "web-0"}, []*model.PullStatus{pull})
+	if p.CompletedAt == nil || !p.CompletedAt.Equal(completed) || p.Percent != 100 {
+		t.Errorf("completed pod = %+v", p)
+	}
+}
+
+func TestAggregatePod_SkipsEarlierIncarnation(t *testing.T) {
+	now := time.Now()
+	old := now.Add(-10 * time.Minute)
+	pulls := []*model.PullStatus{
+		{ID: "old", StartedAt: old, CompletedAt: &old, TotalBytes: 100,
+			Pods: []model.PodCorrelation{{Namespace: "default", PodName: "web-0", PodUID: "u1", Container: "app"}}},
+		{ID: "new", StartedAt: now, TotalBytes: 100,
+			Pods: []model.PodCorrelation{{Namespace: "default", PodName: "web-0", PodUID: "u2", Container: "app"}}},
+	}
+	p, _ := aggregatePod(podRef{"default", "web-0"}, pulls)
+	if p.UID != "u2" || p.PullCount != 1 || p.Containers[0].Pull.ID != "new" {
+		t.Errorf("pod = %+v", p)
+	}
+}
+
+func TestHandlePod_AndPodEvents(t *testing.T) {
+	s := newTestServer()
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	report := func(pulls ...model.PullState) {
+		s.processReport(model.AgentReport{NodeName: "node1", Timestamp: time.Now(), Pulls: pulls})
+	}
+	app := model.PullState{ImageRef: "app:1", StartedAt: time.Now(), TotalKnown: true,
+		Layers: []model.LayerState{{Digest: "sha256:a", TotalBytes: 1000, DownloadedBytes: 250, TotalKnown: true}}}
+	report(app)
+	// Correlate as the pod watcher would.
+	s.mu.Lock()
+	s.pulls["node1:app:1"].Pods = []model.PodCorrelation{{Namespace: "default", PodName: "web-0", Container: "app"}}
+	s.mu.Unlock()
+
+	resp, r := openSSE(t, srv.URL, "")
+	defer resp.Body.Close()
+	readSSE(t, r, 1) // snapshot
+
+	report(app)
+	_, events := readSSE(t, r, 2)
+	if ev := events[1]; ev.Type != model.EventPodProgress || ev.Pod == nil || ev.Pod.Name != "web-0" || ev.Pod.Percent != 25 {
+		t.Fatalf("pod event = %+v", ev)
+	}
+
+	var pod model.PodStatus
+	if code := getJSON(t, s.Handler(), "/api/v1/pods/default/web-0", &pod); code != http.StatusOK {
+		t.Fatalf("GET pod: %d", code)
+	}
+	if pod.PullCount != 1 || pod.Containers[0].Container != "app" || pod.NodeName != "node1" {
+		t.Errorf("pod = %+v", pod)
+	}
+	if code := getJSON(t, s.Handler(), "/api/v1/pods/default/db-0", &pod); code != http.StatusNotFound {
+		t.Errorf("GET unknown pod: %d, want 404", code)
+	}
+
+	report() // the pull completes
+	_, events = readSSE(t, r, 2)
+	if ev := events[1]; ev.Type != model.EventPodCompleted || ev.Pod.CompletedAt == nil {
+		t.Errorf("pod event = %+v", ev)
+	}
+}
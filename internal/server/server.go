@@ -5,6 +5,7 @@ import (
 	"crypto/subtle"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/fs"
 	"log/slog"
 	"net/http"
@@ -13,16 +14,23 @@ import (
 	"sync"
 	"time"
 
+	"github.com/d44b/pulltrace/internal/alerts"
 	"github.com/d44b/pulltrace/internal/k8s"
 	"github.com/d44b/pulltrace/internal/metrics"
 	"github.com/d44b/pulltrace/internal/model"
+	"github.com/d44b/pulltrace/internal/notify"
+	"github.com/d44b/pulltrace/internal/registry"
+	"github.com/d44b/pulltrace/internal/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/dynamic"
 )
 
 const (
 	maxReportBodyBytes = 1 << 20 // 1 MiB
 
-	rateLimitWindow = 500 * time.Millisecond
+	// rateLimitWindow is shorter than the agent's fastest default poll
+	// interval (250ms) so adaptive polling is not throttled.
+	rateLimitWindow = 200 * time.Millisecond
 
 	// maxRateLimitEntries prevents memory exhaustion from reports with arbitrary node names.
 	maxRateLimitEntries = 1024
@@ -33,9 +41,19 @@ const (
 	// maxSSEClients prevents resource exhaustion from SSE connections.
 	maxSSEClients = 256
 
-	// stalePullTimeout force-completes pulls that stop sending updates.
+	// stalePullTimeout force-completes pulls whose node agent has stopped
+	// reporting, including idle heartbeats.
 	stalePullTimeout = 10 * time.Minute
 
+	// agentRetention is how long a silent agent stays in the registry.
+	agentRetention = 1 * time.Hour
+
+	// imagePullSyncInterval is how often ImagePull resources are reconciled.
+	imagePullSyncInterval = 5 * time.Second
+
+	// livenessInterval is how often the agent liveness gauges are refreshed.
+	livenessInterval = 10 * time.Second
+
 	mergedPullSuffix = ":__merged__"
 )
 
@@ -46,6 +64,37 @@ type Config struct {
 	WatchNamespaces []string
 	HistoryTTL      time.Duration
 	AgentToken      string // if non-empty, agents must present a matching Bearer token
+	// PodEvents enables writing pull progress as Kubernetes Events on waiting
+	// pods, at most once per PodEventsInterval per pull.
+	PodEvents         bool
+	PodEventsInterval time.Duration
+	// ImagePullResources mirrors pulls into ImagePull custom resources.
+	// Uncorrelated pulls are written to Namespace, if set.
+	ImagePullResources bool
+	Namespace          string
+	// ClusterName tags this server's own pulls. FederationConfig is the path
+	// of a file listing downstream servers whose pulls are merged in.
+	ClusterName      string
+	FederationConfig string
+	// LeaderElection lets several replicas share state: the holder of the
+	// LeaseName Lease in Namespace serves the API and the others proxy to
+	// it at the AdvertiseURL it publishes as its identity.
+	LeaderElection bool
+	LeaseName      string
+	AdvertiseURL   string
+	// NotifyConfig is the path of a file of webhook notification rules.
+	NotifyConfig string
+	// AlertRules is the path of a file of alerting rules.
+	AlertRules string
+	// StallTimeout marks active pulls and layers stalled once their
+	// downloaded bytes stop changing for this long. Zero disables it.
+	StallTimeout time.Duration
+	// RegistryLookup looks up the size of images from their registry
+	// manifests when the kubelet starts pulling them.
+	RegistryLookup bool
+	// Kube says how to reach the API server: in-cluster, through a
+	// kubeconfig, or a recorded fake cluster.
+	Kube k8s.ClientConfig
 }
 
 func ConfigFromEnv() Config {
@@ -69,6 +118,35 @@ func ConfigFromEnv() Config {
 		c.HistoryTTL = 30 * time.Minute
 	}
 
+	c.PodEvents = os.Getenv("PULLTRACE_POD_EVENTS") == "true"
+	c.ImagePullResources = os.Getenv("PULLTRACE_IMAGEPULL_RESOURCES") == "true"
+	c.Namespace = os.Getenv("PULLTRACE_NAMESPACE")
+	c.ClusterName = os.Getenv("PULLTRACE_CLUSTER_NAME")
+	c.FederationConfig = os.Getenv("PULLTRACE_FEDERATION_CONFIG")
+	c.LeaderElection = os.Getenv("PULLTRACE_LEADER_ELECTION") == "true"
+	c.LeaseName = envOrDefault("PULLTRACE_LEASE_NAME", "pulltrace-server")
+	c.AdvertiseURL = os.Getenv("PULLTRACE_ADVERTISE_URL")
+	c.NotifyConfig = os.Getenv("PULLTRACE_NOTIFY_CONFIG")
+	c.AlertRules = os.Getenv("PULLTRACE_ALERT_RULES")
+	c.RegistryLookup = os.Getenv("PULLTRACE_REGISTRY_LOOKUP") == "true"
+	c.Kube.Kubeconfig = os.Getenv("PULLTRACE_KUBECONFIG")
+	c.Kube.Overrides.CurrentContext = os.Getenv("PULLTRACE_KUBE_CONTEXT")
+	c.Kube.FakeCluster = os.Getenv("PULLTRACE_FAKE_CLUSTER")
+	if interval := os.Getenv("PULLTRACE_POD_EVENTS_INTERVAL"); interval != "" {
+		if d, err := time.ParseDuration(interval); err == nil {
+			c.PodEventsInterval = d
+		}
+	}
+	if c.PodEventsInterval == 0 {
+		c.PodEventsInterval = 15 * time.Second
+	}
+	c.StallTimeout = time.Minute
+	if timeout := os.Getenv("PULLTRACE_STALL_TIMEOUT"); timeout != "" {
+		if d, err := time.ParseDuration(timeout); err == nil {
+			c.StallTimeout = d
+		}
+	}
+
 	return c
 }
 
@@ -119,6 +197,8 @@ type Server struct {
 	config      Config
 	logger      *slog.Logger
 	podWatcher  *k8s.PodWatcher
+	sizes       *k8s.ImageSizes
+	events      *k8s.EventEmitter
 	mu          sync.RWMutex
 	pulls       map[string]*model.PullStatus
 	rates       map[string]*model.RateCalculator
@@ -128,10 +208,20 @@ type Server struct {
 	// non-decreasing value so that Rate() never goes negative when a concurrent
 	// pull finishes and the merged byte total drops.
 	lastBytes   map[string]int64
-	sseClients  map[chan []byte]struct{}
+	// progress tracks when each pull's and layer's downloaded bytes last
+	// changed, for stall detection.
+	progress    map[string]progressMark
+	sseClients  map[chan sseMessage]struct{}
 	sseMu       sync.Mutex
+	sseLog      *sseLog
 	webFS       fs.FS
 	rateLimiter *rateLimiter
+	agents      *agentRegistry
+	federation  *federation
+	notifier    *notify.Notifier
+	alerting    *alerts.Engine
+	replica     *replica
+	election    electionTimings
 }
 
 func New(cfg Config, webFS fs.FS) *Server {
@@ -145,17 +235,25 @@ func New(cfg Config, webFS fs.FS) *Server {
 		level = slog.LevelError
 	}
 
-	return &Server{
+	s := &Server{
 		config:      cfg,
 		logger:      slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})),
 		pulls:       make(map[string]*model.PullStatus),
 		rates:       make(map[string]*model.RateCalculator),
 		lastSeen:    make(map[string]time.Time),
 		lastBytes:   make(map[string]int64),
-		sseClients:  make(map[chan []byte]struct{}),
+		progress:    make(map[string]progressMark),
+		sseClients:  make(map[chan sseMessage]struct{}),
+		sseLog:      newSSELog(),
 		webFS:       webFS,
 		rateLimiter: newRateLimiter(),
+		agents:      newAgentRegistry(),
+		election:    defaultElectionTimings,
 	}
+	if cfg.LeaderElection {
+		s.replica = newReplica(cfg.AdvertiseURL)
+	}
+	return s
 }
 
 func securityHeaders(next http.Handler) http.Handler {
@@ -176,41 +274,140 @@ func securityHeaders(next http.Handler) http.Handler {
 	})
 }
 
+// apiRoutes maps each API pattern to its handler. Every entry must be
+// described in openapi.json. With leader election, every route but the
+// OpenAPI document is served by the leader.
+func (s *Server) apiRoutes() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"/api/v1/report":                              s.handleReport,
+		"/api/v1/pulls":                               s.handlePulls,
+		"/api/v1/pulls/{id...}":                       s.handlePull,
+		"/api/v1/pods/{namespace}/{name}":             s.handlePod,
+		"/api/v1/pods/{namespace}/{name}/timeline":    s.handlePodTimeline,
+		"/api/v1/workloads":                           s.handleWorkloads,
+		"/api/v1/workloads/{namespace}/{kind}/{name}": s.handleWorkload,
+		"/api/v1/events":                              s.handleSSE,
+		"/api/v1/agents":                              s.handleAgents,
+		"/api/v1/clusters":                            s.handleClusters,
+		"/api/v1/alerts":                              s.handleAlerts,
+		"/api/v1/openapi.json":                        s.handleOpenAPI,
+	}
+}
+
+// Handler returns the server's HTTP API and web UI handler.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	for pattern, h := range s.apiRoutes() {
+		if pattern != "/api/v1/openapi.json" {
+			h = s.leaderOnly(h)
+		}
+		mux.HandleFunc(pattern, h)
+	}
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+
+	if s.webFS != nil {
+		mux.Handle("/", http.FileServer(http.FS(s.webFS)))
+	}
+	return securityHeaders(mux)
+}
+
 func (s *Server) Run(ctx context.Context) error {
 	s.logger.Info("starting pulltrace server",
+		"version", version.Version,
 		"httpAddr", s.config.HTTPAddr,
 		"metricsAddr", s.config.MetricsAddr,
 		"tokenAuth", s.config.AgentToken != "",
+		"podEvents", s.config.PodEvents,
+		"cluster", s.config.ClusterName,
+		"leaderElection", s.config.LeaderElection,
 	)
 
-	pw, err := k8s.NewPodWatcher(s.config.WatchNamespaces, s.logger)
+	if s.config.FederationConfig != "" {
+		downstreams, err := LoadFederationConfig(s.config.FederationConfig)
+		if err != nil {
+			return fmt.Errorf("federation: %w", err)
+		}
+		if err := s.configureFederation(downstreams); err != nil {
+			return fmt.Errorf("federation: %w", err)
+		}
+		if s.replica == nil {
+			s.runFederation(ctx)
+		}
+		s.logger.Info("federation enabled", "clusters", len(downstreams))
+	}
+
+	if s.config.NotifyConfig != "" {
+		cfg, err := notify.LoadConfig(s.config.NotifyConfig)
+		if err != nil {
+			return fmt.Errorf("notifications: %w", err)
+		}
+		if s.notifier, err = notify.New(cfg, s.logger); err != nil {
+			return fmt.Errorf("notifications: %s: %w", s.config.NotifyConfig, err)
+		}
+		go s.notifier.Run(ctx)
+		s.logger.Info("notifications enabled", "rules", len(cfg.Rules), "webhooks", len(cfg.Webhooks))
+	}
+
+	if s.config.AlertRules != "" {
+		rules, err := alerts.LoadRules(s.config.AlertRules)
+		if err != nil {
+			return fmt.Errorf("alert rules: %w", err)
+		}
+		if s.alerting, err = alerts.NewEngine(rules, s.config.HistoryTTL); err != nil {
+			return fmt.Errorf("alert rules: %s: %w", s.config.AlertRules, err)
+		}
+		go s.alertLoop(ctx)
+		s.logger.Info("alerting enabled", "rules", len(rules))
+	}
+
+	if s.replica != nil && (s.config.AdvertiseURL == "" || s.config.Namespace == "") {
+		return fmt.Errorf("leader election requires PULLTRACE_ADVERTISE_URL and PULLTRACE_NAMESPACE")
+	}
+
+	pw, err := k8s.NewPodWatcher(s.config.Kube, s.config.WatchNamespaces, s.logger)
+	if err != nil && s.replica != nil {
+		return fmt.Errorf("leader election requires the Kubernetes API: %w", err)
+	}
 	if err != nil {
 		s.logger.Warn("pod watcher unavailable, running without pod correlation", "error", err)
 	} else {
 		s.podWatcher = pw
+		pw.OnPodReady(s.observeTimeline)
+		pw.OnPulled(s.observeKubeletPull)
+		if s.config.RegistryLookup {
+			s.sizes = k8s.NewImageSizes(pw.Client(), &registry.Client{}, s.logger)
+			pw.OnPulling(s.sizes.Resolve)
+			go s.sizes.Run(ctx)
+		}
 		go func() {
 			if err := pw.Run(ctx); err != nil {
 				s.logger.Error("pod watcher failed", "error", err)
 			}
 		}()
+		if s.replica != nil {
+			go s.runLeaderElection(ctx, pw.Client())
+		}
+		if s.config.PodEvents {
+			s.events = k8s.NewEventEmitter(pw.Client(), s.config.PodEventsInterval, s.logger)
+			go s.events.Run(ctx)
+		}
+		if s.config.ImagePullResources {
+			if pw.RESTConfig() == nil {
+				s.logger.Warn("imagepull resources disabled on a fake cluster")
+			} else if dc, err := dynamic.NewForConfig(pw.RESTConfig()); err != nil {
+				s.logger.Warn("imagepull resources disabled", "error", err)
+			} else {
+				go s.imagePullLoop(ctx, k8s.NewImagePullSyncer(dc, s.config.Namespace, s.logger))
+			}
+		}
 	}
 
 	go s.cleanupLoop(ctx)
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/api/v1/report", s.handleReport)
-	mux.HandleFunc("/api/v1/pulls", s.handlePulls)
-	mux.HandleFunc("/api/v1/events", s.handleSSE)
-	mux.HandleFunc("/healthz", s.handleHealthz)
-	mux.HandleFunc("/readyz", s.handleReadyz)
-
-	if s.webFS != nil {
-		mux.Handle("/", http.FileServer(http.FS(s.webFS)))
-	}
-
 	httpServer := &http.Server{
 		Addr:              s.config.HTTPAddr,
-		Handler:           securityHeaders(mux),
+		Handler:           s.Handler(),
 		ReadHeaderTimeout: 10 * time.Second,
 		ReadTimeout:       30 * time.Second,
 		// WriteTimeout is 0 because SSE connections are long-lived.
@@ -276,16 +473,42 @@ func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if v := report.Version(); v < model.MinProtocolVersion || v > model.ProtocolVersion {
+		s.rejectReport(w, report)
+		return
+	}
+
 	if !s.rateLimiter.allow(report.NodeName) {
 		http.Error(w, "rate limited", http.StatusTooManyRequests)
 		return
 	}
 
 	metrics.AgentReports.Inc()
+	s.agents.observe(report, time.Now())
 	s.processReport(report)
 	w.WriteHeader(http.StatusOK)
 }
 
+// rejectReport answers a report whose protocol version this server cannot
+// process. The body tells the agent which versions are supported so the
+// mismatch shows up in its logs instead of silently misbehaving.
+func (s *Server) rejectReport(w http.ResponseWriter, report model.AgentReport) {
+	msg := fmt.Sprintf("agent protocol version %d is not supported; server accepts %d-%d",
+		report.Version(), model.MinProtocolVersion, model.ProtocolVersion)
+	s.logger.Warn("rejecting agent report",
+		"node", report.NodeName,
+		"agentVersion", report.AgentVersion,
+		"protocolVersion", report.Version(),
+	)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(model.ReportRejection{ //nolint:errcheck
+		Error:              msg,
+		ProtocolVersion:    model.ProtocolVersion,
+		MinProtocolVersion: model.MinProtocolVersion,
+	})
+}
+
 // isContentDigest reports whether ref is a raw containerd content digest rather
 // than a human-readable image name. These refs are merged server-side into a
 // single logical pull keyed by "__pulling__" until the image name is resolved.
@@ -335,6 +558,48 @@ func mergeDigestPulls(pulls []model.PullState) []model.PullState {
 	return append(normal, merged)
 }
 
+// resolvedDigest returns the manifest digest a pull's image reference
+// resolved to, from the containerd ingest of its index or, for a
+// single-platform image, of its only manifest. It returns "" if neither was
+// seen, or if the ingests of several images were merged into the pull.
+func resolvedDigest(layers []model.LayerState) string {
+	var indexes, manifests []string
+	for _, l := range layers {
+		// Ingest refs are "<kind>-<digest>" or "<kind>-<name>@<digest>".
+		kind, rest, _ := strings.Cut(l.Digest, "-")
+		if i := strings.LastIndex(rest, "@"); i >= 0 {
+			rest = rest[i+1:]
+		}
+		if !strings.HasPrefix(rest, "sha256:") {
+			continue
+		}
+		switch kind {
+		case "index":
+			indexes = append(indexes, rest)
+		case "manifest":
+			manifests = append(manifests, rest)
+		}
+	}
+	switch {
+	case len(indexes) == 1:
+		return indexes[0]
+	case len(indexes) == 0 && len(manifests) == 1:
+		return manifests[0]
+	}
+	return ""
+}
+
+// pinnedDigest returns the digest ref is pinned to, or "" for a tag. The
+// digest a tag resolved to earlier on a node is not used: the tag may have
+// moved since, and containerd names the digest of this pull.
+func pinnedDigest(ref string) string {
+	r, err := registry.ParseReference(ref)
+	if err != nil {
+		return ""
+	}
+	return r.Digest
+}
+
 func (s *Server) processReport(report model.AgentReport) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -342,6 +607,9 @@ func (s *Server) processReport(report model.AgentReport) {
 	now := time.Now()
 	mergedPulls := mergeDigestPulls(report.Pulls)
 	updatedKeys := make(map[string]bool)
+	// pods collects the pods whose pulls changed, for pod events.
+	pods := make(map[podRef]bool)
+	workloads := make(map[workloadRef]bool)
 
 	for _, pull := range mergedPulls {
 		key := report.NodeName + ":" + pull.ImageRef
@@ -365,11 +633,13 @@ func (s *Server) processReport(report model.AgentReport) {
 			uid := fmt.Sprintf("%s@%d", key, now.UnixNano())
 			existing = &model.PullStatus{
 				ID:        uid,
+				Cluster:   s.config.ClusterName,
 				NodeName:  report.NodeName,
 				ImageRef:  pull.ImageRef,
 				StartedAt: pull.StartedAt,
 			}
 			s.pulls[key] = existing
+			s.forgetProgress(key)
 			metrics.PullsTotal.Inc()
 			metrics.PullsActive.Inc()
 		}
@@ -425,6 +695,9 @@ func (s *Server) processReport(report model.AgentReport) {
 				completedAt := now
 				ls.CompletedAt = &completedAt
 			}
+			layerProgressAt := s.advance(layerKey, layer.DownloadedBytes, now)
+			ls.LastProgressAt = &layerProgressAt
+			ls.Stalled = ls.CompletedAt == nil && s.isStalled(layerProgressAt, now)
 			layerStatuses = append(layerStatuses, ls)
 		}
 
@@ -434,6 +707,16 @@ func (s *Server) processReport(report model.AgentReport) {
 		existing.LayersDone = layersDone
 		existing.TotalKnown = pull.TotalKnown
 		existing.Layers = layerStatuses
+		// Agents only report layers once they start, so the manifest size
+		// is the better total until all of them have.
+		if s.sizes != nil && existing.ManifestBytes == 0 {
+			existing.ManifestBytes = s.sizes.Size(report.NodeName, existing.ImageRef)
+		}
+		if existing.ManifestBytes > 0 {
+			existing.TotalBytes = max(existing.TotalBytes, existing.ManifestBytes)
+			existing.TotalKnown = true
+		}
+		totalBytes = existing.TotalBytes
 
 		if totalBytes > 0 {
 			existing.Percent = float64(downloadedBytes) / float64(totalBytes) * 100
@@ -456,23 +739,67 @@ func (s *Server) processReport(report model.AgentReport) {
 			existing.ETASeconds = rc.ETA(existing.TotalBytes - existing.DownloadedBytes)
 		}
 
+		// A pull is stalled once none of its layers has moved for the stall
+		// timeout, and active again as soon as one does.
+		progressAt := s.advance(key, downloadedBytes, now)
+		existing.LastProgressAt = &progressAt
+		wasStalled := existing.Stalled
+		setStalled(existing, s.isStalled(progressAt, now))
+		eventType := model.EventPullProgress
+		if existing.Stalled && !wasStalled {
+			eventType = model.EventPullStalled
+			metrics.PullStalls.Inc()
+		}
+
+		if digest := resolvedDigest(pull.Layers); digest != "" {
+			existing.Digest = digest
+		} else if existing.Digest == "" {
+			existing.Digest = pinnedDigest(existing.ImageRef)
+		}
 		if s.podWatcher != nil {
-			existing.Pods = s.podWatcher.GetPodsForImage(report.NodeName, existing.ImageRef)
+			existing.Pods = s.podWatcher.GetPodsForPull(report.NodeName, existing.ImageRef, existing.Digest)
+			existing.Retries = s.podWatcher.PullRetries(existing.ImageRef, existing.Pods)
+		}
+		for _, pc := range existing.Pods {
+			pods[podRef{pc.Namespace, pc.PodName}] = true
+		}
+		addWorkloads(workloads, existing)
+		if s.events != nil {
+			s.events.Progress(existing)
 		}
 
 		event := model.PullEvent{
 			SchemaVersion: model.SchemaVersion,
 			Timestamp:     report.Timestamp,
-			Type:          model.EventPullProgress,
+			Type:          eventType,
 			NodeName:      report.NodeName,
 			Pull:          existing,
 		}
+		if s.notifier != nil {
+			s.notifier.Observe(event)
+		}
 		if data, err := json.Marshal(event); err == nil {
-			s.logger.Debug("pull.progress",
-				"node", report.NodeName,
-				"image", existing.ImageRef,
-				"percent", existing.Percent,
-			)
+			switch {
+			case eventType == model.EventPullStalled:
+				s.logger.Warn("pull.stalled",
+					"node", report.NodeName,
+					"image", existing.ImageRef,
+					"percent", existing.Percent,
+					"lastProgressAt", progressAt,
+				)
+			case wasStalled && !existing.Stalled:
+				s.logger.Info("stalled pull resumed",
+					"node", report.NodeName,
+					"image", existing.ImageRef,
+					"stalledFor", now.Sub(progressAt).String(),
+				)
+			default:
+				s.logger.Debug("pull.progress",
+					"node", report.NodeName,
+					"image", existing.ImageRef,
+					"percent", existing.Percent,
+				)
+			}
 			s.broadcastSSE(data)
 		}
 	}
@@ -489,12 +816,28 @@ func (s *Server) processReport(report model.AgentReport) {
 
 		pull.CompletedAt = &now
 		pull.Percent = 100
+		clearStalled(pull)
+		if s.podWatcher != nil {
+			if pull.Error == "" {
+				pull.Error = s.podWatcher.PullFailure(report.NodeName, pull.ImageRef)
+			}
+			if retries := s.podWatcher.PullRetries(pull.ImageRef, pull.Pods); retries != nil {
+				pull.Retries = retries
+			}
+		}
 		metrics.PullsActive.Dec()
 		metrics.PullDurationSeconds.Observe(now.Sub(pull.StartedAt).Seconds())
 		metrics.PullBytesTotal.Add(float64(pull.TotalBytes))
 		if pull.Error != "" {
 			metrics.PullErrors.Inc()
 		}
+		if s.events != nil {
+			s.events.Completed(pull)
+		}
+		for _, pc := range pull.Pods {
+			pods[podRef{pc.Namespace, pc.PodName}] = true
+		}
+		addWorkloads(workloads, pull)
 
 		event := model.PullEvent{
 			SchemaVersion: model.SchemaVersion,
@@ -503,6 +846,9 @@ func (s *Server) processReport(report model.AgentReport) {
 			NodeName:      report.NodeName,
 			Pull:          pull,
 		}
+		if s.notifier != nil {
+			s.notifier.Observe(event)
+		}
 		if data, err := json.Marshal(event); err == nil {
 			s.logger.Info("pull.completed",
 				"node", report.NodeName,
@@ -512,6 +858,9 @@ func (s *Server) processReport(report model.AgentReport) {
 			s.broadcastSSE(data)
 		}
 	}
+
+	s.broadcastPods(pods, now)
+	s.broadcastWorkloads(workloads, now)
 }
 
 func (s *Server) handlePulls(w http.ResponseWriter, r *http.Request) {
@@ -520,26 +869,28 @@ func (s *Server) handlePulls(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.mu.RLock()
-	pulls := make([]model.PullStatus, 0, len(s.pulls))
-	for _, p := range s.pulls {
-		pulls = append(pulls, *p)
-	}
-	s.mu.RUnlock()
+	pulls := append(s.snapshotPulls(), s.remotePulls()...)
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(model.APIResponse{Pulls: pulls}) //nolint:errcheck
 }
 
 func (s *Server) handleSSE(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
 		return
 	}
 
-	// Register client atomically to avoid TOCTOU between capacity check and insertion.
-	ch := make(chan []byte, 64)
+	// Register client atomically to avoid TOCTOU between capacity check and
+	// insertion. Capturing the replay window under the same lock guarantees
+	// no event falls between the replay and the live channel.
+	ch := make(chan sseMessage, 64)
 	s.sseMu.Lock()
 	if len(s.sseClients) >= maxSSEClients {
 		s.sseMu.Unlock()
@@ -547,6 +898,8 @@ func (s *Server) handleSSE(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	s.sseClients[ch] = struct{}{}
+	replay, resumed := s.sseLog.since(r.Header.Get("Last-Event-ID"))
+	lastID := s.sseLog.lastID()
 	s.sseMu.Unlock()
 	metrics.SSEClients.Inc()
 
@@ -565,47 +918,74 @@ func (s *Server) handleSSE(w http.ResponseWriter, r *http.Request) {
 	// SSE comment flushes headers through buffering proxies.
 	w.Write([]byte(": connected\n\n")) //nolint:errcheck
 
-	s.mu.RLock()
-	now := time.Now()
-	for _, p := range s.pulls {
-		event := model.PullEvent{
-			SchemaVersion: model.SchemaVersion,
-			Timestamp:     now,
-			Type:          model.EventPullProgress,
-			NodeName:      p.NodeName,
-			Pull:          p,
+	if resumed {
+		// The client missed only the events still in the replay window.
+		for _, msg := range replay {
+			writeSSE(w, msg)
 		}
-		if data, err := json.Marshal(event); err == nil {
-			w.Write([]byte("data: ")) //nolint:errcheck
-			w.Write(data)             //nolint:errcheck
-			w.Write([]byte("\n\n"))   //nolint:errcheck
+	} else {
+		// Snapshot events carry the latest ID so a later reconnect resumes
+		// from this point.
+		s.mu.RLock()
+		now := time.Now()
+		for _, p := range s.pulls {
+			event := model.PullEvent{
+				SchemaVersion: model.SchemaVersion,
+				Timestamp:     now,
+				Type:          model.EventPullProgress,
+				NodeName:      p.NodeName,
+				Pull:          p,
+			}
+			if data, err := json.Marshal(event); err == nil {
+				writeSSE(w, sseMessage{id: lastID, data: data})
+			}
+		}
+		s.mu.RUnlock()
+		for _, p := range s.remotePulls() {
+			event := model.PullEvent{
+				SchemaVersion: model.SchemaVersion,
+				Timestamp:     now,
+				Type:          model.EventPullProgress,
+				NodeName:      p.NodeName,
+				Pull:          &p,
+			}
+			if data, err := json.Marshal(event); err == nil {
+				writeSSE(w, sseMessage{id: lastID, data: data})
+			}
 		}
 	}
-	s.mu.RUnlock()
 	flusher.Flush()
 
 	for {
 		select {
 		case <-r.Context().Done():
 			return
-		case data, ok := <-ch:
+		case msg, ok := <-ch:
 			if !ok {
 				return
 			}
-			w.Write([]byte("data: ")) //nolint:errcheck
-			w.Write(data)             //nolint:errcheck
-			w.Write([]byte("\n\n"))   //nolint:errcheck
+			writeSSE(w, msg)
 			flusher.Flush()
 		}
 	}
 }
 
+func writeSSE(w io.Writer, msg sseMessage) {
+	if msg.id != "" {
+		fmt.Fprintf(w, "id: %s\n", msg.id)
+	}
+	w.Write([]byte("data: ")) //nolint:errcheck
+	w.Write(msg.data)         //nolint:errcheck
+	w.Write([]byte("\n\n"))   //nolint:errcheck
+}
+
 func (s *Server) broadcastSSE(data []byte) {
 	s.sseMu.Lock()
 	defer s.sseMu.Unlock()
+	msg := s.sseLog.append(data)
 	for ch := range s.sseClients {
 		select {
-		case ch <- data:
+		case ch <- msg:
 		default:
 			// Drop if client is slow.
 		}
@@ -618,6 +998,10 @@ func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
 }
 
 func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if s.podWatcher != nil && !s.podWatcher.HasSynced() {
+		http.Error(w, "pod watcher caches not synced", http.StatusServiceUnavailable)
+		return
+	}
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte("ok")) //nolint:errcheck
 }
@@ -625,17 +1009,97 @@ func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
 func (s *Server) cleanupLoop(ctx context.Context) {
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
+	liveness := time.NewTicker(livenessInterval)
+	defer liveness.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
 			s.cleanup()
+			s.cleanupRemote(time.Now())
 			s.rateLimiter.cleanup()
+			s.agents.prune(time.Now(), agentRetention)
+		case <-liveness.C:
+			s.updateAgentMetrics()
 		}
 	}
 }
 
+// isStale reports whether an active pull should be force-completed. For agents
+// that heartbeat, only a silent agent makes its pulls stale; for older agents
+// the pull's own last update is used instead.
+func (s *Server) isStale(key, node string, now time.Time) bool {
+	if last, heartbeats, ok := s.agents.liveness(node); ok && heartbeats {
+		return now.Sub(last) > stalePullTimeout
+	}
+	lastSeen, ok := s.lastSeen[key]
+	return !ok || now.Sub(lastSeen) > stalePullTimeout
+}
+
+func (s *Server) handlePull(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	var pull *model.PullStatus
+	s.mu.RLock()
+	for _, p := range s.pulls {
+		if p.ID == id {
+			cp := *p
+			pull = &cp
+			break
+		}
+	}
+	s.mu.RUnlock()
+	if pull == nil {
+		if p, ok := s.remotePull(id); ok {
+			pull = &p
+		}
+	}
+
+	if pull == nil {
+		http.Error(w, "pull not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pull) //nolint:errcheck
+}
+
+// imagePullLoop periodically mirrors the pull map into ImagePull resources.
+func (s *Server) imagePullLoop(ctx context.Context, syncer *k8s.ImagePullSyncer) {
+	ticker := time.NewTicker(imagePullSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// Followers hold no pulls; syncing would delete the leader's objects.
+			if s.replica != nil && !s.replica.isLeading() {
+				continue
+			}
+			if err := syncer.Sync(ctx, s.snapshotPulls()); err != nil {
+				s.logger.Warn("imagepull sync failed", "error", err)
+			}
+		}
+	}
+}
+
+// snapshotPulls returns a copy of every pull reported by this server's own
+// agents.
+func (s *Server) snapshotPulls() []model.PullStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pulls := make([]model.PullStatus, 0, len(s.pulls))
+	for _, p := range s.pulls {
+		pulls = append(pulls, *p)
+	}
+	return pulls
+}
+
 func (s *Server) cleanup() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -656,14 +1120,23 @@ func (s *Server) cleanup() {
 					delete(s.lastBytes, rateKey)
 				}
 			}
+			s.forgetProgress(key)
 			continue
 		}
-		if pull.CompletedAt == nil {
-			if lastSeen, ok := s.lastSeen[key]; ok && now.Sub(lastSeen) > stalePullTimeout {
-				completedAt := lastSeen
-				pull.CompletedAt = &completedAt
-				metrics.PullsActive.Dec()
-				s.logger.Warn("force-completing stale pull", "key", key, "lastSeen", lastSeen)
+		if pull.CompletedAt == nil && s.isStale(key, pull.NodeName, now) {
+			completedAt := s.lastSeen[key]
+			if completedAt.IsZero() {
+				completedAt = now
+			}
+			pull.CompletedAt = &completedAt
+			clearStalled(pull)
+			metrics.PullsActive.Dec()
+			s.logger.Warn("force-completing stale pull", "key", key, "lastSeen", completedAt)
+			if s.events != nil {
+				s.events.Discard(pull.ID)
+			}
+			if s.notifier != nil {
+				s.notifier.Forget(pull.ID)
 			}
 		}
 	}
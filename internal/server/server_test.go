@@ -1,15 +1,26 @@
 package server
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/d44b/pulltrace/internal/k8s"
 	"github.com/d44b/pulltrace/internal/model"
+	"github.com/d44b/pulltrace/internal/notify"
+	"github.com/d44b/pulltrace/internal/registry"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func newTestServer() *Server {
@@ -118,6 +129,39 @@ func TestHandleReport_TokenDisabled(t *testing.T) {
 	}
 }
 
+func TestHandleReport_ProtocolVersion(t *testing.T) {
+	cases := []struct {
+		version int
+		expect  int
+	}{
+		{0, http.StatusOK}, // unversioned agents predate the handshake
+		{model.MinProtocolVersion, http.StatusOK},
+		{model.ProtocolVersion, http.StatusOK},
+		{model.ProtocolVersion + 1, http.StatusConflict},
+	}
+	for i, c := range cases {
+		s := newTestServer()
+		w := postReport(t, s, model.AgentReport{
+			ProtocolVersion: c.version,
+			NodeName:        fmt.Sprintf("node-%d", i),
+			Timestamp:       time.Now(),
+		}, "")
+		if w.Code != c.expect {
+			t.Errorf("protocol %d: expected %d, got %d", c.version, c.expect, w.Code)
+		}
+		if c.expect != http.StatusConflict {
+			continue
+		}
+		var rej model.ReportRejection
+		if err := json.NewDecoder(w.Body).Decode(&rej); err != nil {
+			t.Fatalf("decoding rejection: %v", err)
+		}
+		if rej.ProtocolVersion != model.ProtocolVersion || rej.Error == "" {
+			t.Errorf("unexpected rejection body: %+v", rej)
+		}
+	}
+}
+
 // ── handlePulls ───────────────────────────────────────────────────────────────
 
 func TestHandlePulls_MethodNotAllowed(t *testing.T) {
@@ -147,6 +191,145 @@ func TestHandlePulls_Empty(t *testing.T) {
 	}
 }
 
+func TestHandlePull_ByID(t *testing.T) {
+	s := newTestServer()
+	s.processReport(model.AgentReport{
+		NodeName: "node1",
+		Pulls:    []model.PullState{{ImageRef: "ghcr.io/d44b/app:v1", StartedAt: time.Now()}},
+	})
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	s.mu.RLock()
+	id := s.pulls["node1:ghcr.io/d44b/app:v1"].ID
+	s.mu.RUnlock()
+
+	resp, err := http.Get(srv.URL + "/api/v1/pulls/" + url.PathEscape(id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var pull model.PullStatus
+	if err := json.NewDecoder(resp.Body).Decode(&pull); err != nil {
+		t.Fatal(err)
+	}
+	if pull.ID != id || pull.ImageRef != "ghcr.io/d44b/app:v1" {
+		t.Errorf("got %+v", pull)
+	}
+
+	resp, err = http.Get(srv.URL + "/api/v1/pulls/nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown pull, got %d", resp.StatusCode)
+	}
+}
+
+// ── handleSSE ─────────────────────────────────────────────────────────────────
+
+// readSSE reads events from an open stream until n data lines have been seen,
+// returning their IDs and payloads.
+func readSSE(t *testing.T, r *bufio.Reader, n int) (ids []string, events []model.PullEvent) {
+	t.Helper()
+	for len(events) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading stream: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			ids = append(ids, strings.TrimPrefix(line, "id: "))
+		case strings.HasPrefix(line, "data: "):
+			var ev model.PullEvent
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &ev); err != nil {
+				t.Fatal(err)
+			}
+			events = append(events, ev)
+		}
+	}
+	return ids, events
+}
+
+func openSSE(t *testing.T, url, lastEventID string) (*http.Response, *bufio.Reader) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url+"/api/v1/events", nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, bufio.NewReader(resp.Body)
+}
+
+func TestHandleSSE_ResumesFromLastEventID(t *testing.T) {
+	s := newTestServer()
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	s.processReport(model.AgentReport{
+		NodeName: "node1",
+		Pulls:    []model.PullState{{ImageRef: "nginx:1", StartedAt: time.Now()}},
+	})
+
+	// A fresh client gets a snapshot tagged with the latest event ID.
+	resp, r := openSSE(t, srv.URL, "")
+	ids, events := readSSE(t, r, 1)
+	resp.Body.Close()
+	if len(ids) != 1 || events[0].Pull.ImageRef != "nginx:1" {
+		t.Fatalf("snapshot: ids=%v events=%+v", ids, events)
+	}
+
+	// Two events happen while the client is disconnected.
+	s.processReport(model.AgentReport{
+		NodeName: "node1",
+		Pulls: []model.PullState{
+			{ImageRef: "nginx:1", StartedAt: time.Now()},
+			{ImageRef: "redis:7", StartedAt: time.Now()},
+		},
+	})
+	s.processReport(model.AgentReport{
+		NodeName: "node2",
+		Pulls:    []model.PullState{{ImageRef: "busybox", StartedAt: time.Now()}},
+	})
+
+	resp, r = openSSE(t, srv.URL, ids[0])
+	defer resp.Body.Close()
+	_, events = readSSE(t, r, 3)
+	var got []string
+	for _, ev := range events {
+		got = append(got, ev.Pull.ImageRef)
+	}
+	if strings.Join(got, ",") != "nginx:1,redis:7,busybox" {
+		t.Errorf("replayed %v, want the three missed events in order", got)
+	}
+}
+
+func TestHandleSSE_UnknownLastEventIDGetsSnapshot(t *testing.T) {
+	s := newTestServer()
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	s.processReport(model.AgentReport{
+		NodeName: "node1",
+		Pulls:    []model.PullState{{ImageRef: "nginx:1", StartedAt: time.Now()}},
+	})
+
+	resp, r := openSSE(t, srv.URL, "otherepoch-7")
+	defer resp.Body.Close()
+	_, events := readSSE(t, r, 1)
+	if events[0].Pull.ImageRef != "nginx:1" {
+		t.Errorf("expected a snapshot, got %+v", events[0])
+	}
+}
+
 // ── processReport ─────────────────────────────────────────────────────────────
 
 func TestProcessReport_TracksNewPull(t *testing.T) {
@@ -208,6 +391,49 @@ func TestProcessReport_CompletesAbsentPull(t *testing.T) {
 	}
 }
 
+func TestProcessReport_NotifiesSlowPull(t *testing.T) {
+	bodies := make(chan notify.Notification, 4)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var nt notify.Notification
+		json.NewDecoder(r.Body).Decode(&nt) //nolint:errcheck
+		bodies <- nt
+	}))
+	defer receiver.Close()
+
+	s := newTestServer()
+	n, err := notify.New(notify.Config{
+		Webhooks: []notify.Webhook{{Name: "chat", URL: receiver.URL}},
+		Rules: []notify.Rule{{
+			Name:     "slow",
+			Trigger:  notify.TriggerSlow,
+			After:    metav1.Duration{Duration: 5 * time.Minute},
+			Webhooks: []string{"chat"},
+		}},
+	}, s.logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Run(ctx)
+	s.notifier = n
+
+	s.processReport(model.AgentReport{
+		NodeName:  "node1",
+		Timestamp: time.Now(),
+		Pulls:     []model.PullState{{ImageRef: "nginx:1.27", StartedAt: time.Now().Add(-10 * time.Minute)}},
+	})
+
+	select {
+	case nt := <-bodies:
+		if nt.Rule != "slow" || nt.Pull == nil || nt.Pull.ImageRef != "nginx:1.27" || nt.NodeName != "node1" {
+			t.Errorf("notification = %+v", nt)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification for a slow pull")
+	}
+}
+
 func TestProcessReport_MergedDigestPull(t *testing.T) {
 	s := newTestServer()
 	s.processReport(model.AgentReport{
@@ -234,6 +460,238 @@ func TestProcessReport_MergedDigestPull(t *testing.T) {
 	}
 }
 
+func TestProcessReport_ManifestSize(t *testing.T) {
+	registrySrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"layers": [{"size": 3000}, {"size": 1000}]}`)
+	}))
+	defer registrySrv.Close()
+	image := strings.TrimPrefix(registrySrv.URL, "https://") + "/team/app:v1"
+
+	s := newTestServer()
+	s.sizes = k8s.NewImageSizes(fake.NewSimpleClientset(), &registry.Client{HTTPClient: registrySrv.Client()}, s.logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.sizes.Run(ctx)
+	s.sizes.Resolve(k8s.PullingImage{NodeName: "node1", Image: image})
+	deadline := time.Now().Add(5 * time.Second)
+	for s.sizes.Size("node1", image) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("manifest size not resolved")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Only the first layer has started: the total comes from the manifest.
+	s.processReport(model.AgentReport{
+		NodeName: "node1",
+		Pulls: []model.PullState{{
+			ImageRef:  image,
+			StartedAt: time.Now(),
+			Layers:    []model.LayerState{{Digest: "sha256:layer1", TotalBytes: 3000, DownloadedBytes: 1000, TotalKnown: true}},
+		}},
+	})
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pull := s.pulls["node1:"+image]
+	if pull == nil {
+		t.Fatal("pull not found")
+	}
+	if pull.ManifestBytes != 4000 || pull.TotalBytes != 4000 || !pull.TotalKnown {
+		t.Errorf("manifestBytes=%d totalBytes=%d totalKnown=%v, want 4000, 4000, true", pull.ManifestBytes, pull.TotalBytes, pull.TotalKnown)
+	}
+	if pull.Percent != 25 {
+		t.Errorf("Percent = %v, want 25", pull.Percent)
+	}
+}
+
+func TestProcessReport_KubeletRetries(t *testing.T) {
+	start := time.Now().Add(-time.Minute)
+	client := fake.NewSimpleClientset(
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-0"},
+			Spec:       corev1.PodSpec{NodeName: "node1", Containers: []corev1.Container{{Name: "app", Image: "nginx:1.27"}}},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "app", State: corev1.ContainerState{
+				Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}}}},
+		},
+		&corev1.Event{
+			ObjectMeta:     metav1.ObjectMeta{Namespace: "default", Name: "web-0.pulling"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Namespace: "default", Name: "web-0"},
+			Reason:         "Pulling",
+			Message:        `Pulling image "nginx:1.27"`,
+			Source:         corev1.EventSource{Host: "node1"},
+			FirstTimestamp: metav1.NewTime(start),
+			LastTimestamp:  metav1.NewTime(start.Add(40 * time.Second)),
+			Count:          3,
+		},
+	)
+	s := newTestServer()
+	s.podWatcher = k8s.NewPodWatcherForClient(client, nil, s.logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.podWatcher.Run(ctx) //nolint:errcheck
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.podWatcher.HasSynced() {
+		if time.Now().After(deadline) {
+			t.Fatal("pod watcher caches not synced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.processReport(model.AgentReport{
+		NodeName: "node1",
+		Pulls: []model.PullState{{
+			ImageRef:  "nginx:1.27",
+			StartedAt: time.Now(),
+			Layers:    []model.LayerState{{Digest: "sha256:layer1", TotalBytes: 1000, DownloadedBytes: 10, TotalKnown: true}},
+		}},
+	})
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r := s.pulls["node1:nginx:1.27"].Retries
+	if r == nil || r.Attempts != 3 || r.BackoffSeconds != 20 {
+		t.Errorf("retries = %+v, want 3 attempts 20s apart", r)
+	}
+}
+
+func TestProcessReport_PodsByDigest(t *testing.T) {
+	const digest = "sha256:5f1c0a8b2e9d4c3b7a6f5e4d3c2b1a0f9e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4b"
+	creating := corev1.ContainerStatus{Name: "app", State: corev1.ContainerState{
+		Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}}}
+	pod := func(name, node, image string, status corev1.ContainerStatus) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec:       corev1.PodSpec{NodeName: node, Containers: []corev1.Container{{Name: "app", Image: image}}},
+			Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{status}},
+		}
+	}
+	running := corev1.ContainerStatus{Name: "app", ImageID: "docker.io/library/nginx@" + digest,
+		State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}
+	client := fake.NewSimpleClientset(
+		pod("web-0", "node1", "nginx:1.27", creating),
+		pod("pinned-0", "node1", "nginx@"+digest, creating),
+		pod("pinned-1", "node2", "nginx@"+digest, creating),
+		// stable resolved to the same digest when stable-0 started.
+		pod("stable-0", "node1", "nginx:stable", running),
+		pod("stable-1", "node1", "nginx:stable", creating),
+		pod("old-0", "node1", "nginx:1.26", creating),
+	)
+	s := newTestServer()
+	s.podWatcher = k8s.NewPodWatcherForClient(client, nil, s.logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.podWatcher.Run(ctx) //nolint:errcheck
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.podWatcher.HasSynced() {
+		if time.Now().After(deadline) {
+			t.Fatal("pod watcher caches not synced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.processReport(model.AgentReport{
+		NodeName: "node1",
+		Pulls: []model.PullState{{
+			ImageRef:  "nginx:1.27",
+			StartedAt: time.Now(),
+			Layers: []model.LayerState{
+				{Digest: "index-" + digest, TotalBytes: 500, DownloadedBytes: 500, TotalKnown: true},
+				{Digest: "layer-sha256:layer1", TotalBytes: 1000, DownloadedBytes: 10, TotalKnown: true},
+			},
+		}},
+	})
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pull := s.pulls["node1:nginx:1.27"]
+	if pull.Digest != digest {
+		t.Errorf("digest = %q, want %q", pull.Digest, digest)
+	}
+	var names []string
+	for _, pc := range pull.Pods {
+		names = append(names, pc.PodName)
+	}
+	slices.Sort(names)
+	if want := []string{"pinned-0", "stable-1", "web-0"}; !slices.Equal(names, want) {
+		t.Errorf("pods = %v, want %v", names, want)
+	}
+}
+
+func TestProcessReport_DigestOfMovedTag(t *testing.T) {
+	const digest = "sha256:5f1c0a8b2e9d4c3b7a6f5e4d3c2b1a0f9e8d7c6b5a4f3e2d1c0b9a8f7e6d5c4b"
+	// stable resolved to digest when stable-0 started, before the tag moved.
+	client := fake.NewSimpleClientset(&corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "stable-0"},
+		Spec:       corev1.PodSpec{NodeName: "node1", Containers: []corev1.Container{{Name: "app", Image: "nginx:stable"}}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{Name: "app", ImageID: "docker.io/library/nginx@" + digest,
+			State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}}}},
+	})
+	s := newTestServer()
+	s.podWatcher = k8s.NewPodWatcherForClient(client, nil, s.logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.podWatcher.Run(ctx) //nolint:errcheck
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.podWatcher.HasSynced() {
+		if time.Now().After(deadline) {
+			t.Fatal("pod watcher caches not synced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	layer := model.LayerState{Digest: "layer-sha256:layer1", TotalBytes: 1000, DownloadedBytes: 10, TotalKnown: true}
+	s.processReport(model.AgentReport{
+		NodeName: "node1",
+		Pulls: []model.PullState{
+			{ImageRef: "nginx:stable", StartedAt: time.Now(), Layers: []model.LayerState{layer}},
+			{ImageRef: "nginx@" + digest, StartedAt: time.Now(), Layers: []model.LayerState{layer}},
+		},
+	})
+	s.processReport(model.AgentReport{NodeName: "node1"})
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if got := s.pulls["node1:nginx:stable"].Digest; got != "" {
+		t.Errorf("tag digest = %q, want none before containerd resolves it", got)
+	}
+	if got := s.pulls["node1:nginx@"+digest].Digest; got != digest {
+		t.Errorf("pinned digest = %q, want %q", got, digest)
+	}
+}
+
+func TestResolvedDigest(t *testing.T) {
+	const (
+		index    = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		manifest = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+		other    = "sha256:3333333333333333333333333333333333333333333333333333333333333333"
+	)
+	layers := func(digests ...string) []model.LayerState {
+		var ls []model.LayerState
+		for _, d := range digests {
+			ls = append(ls, model.LayerState{Digest: d})
+		}
+		return ls
+	}
+	tests := []struct {
+		name   string
+		layers []model.LayerState
+		want   string
+	}{
+		{"index", layers("index-"+index, "manifest-"+manifest, "layer-sha256:aaa"), index},
+		{"single manifest", layers("manifest-"+manifest, "config-sha256:bbb", "layer-sha256:aaa"), manifest},
+		{"named ingest", layers("index-docker.io/library/nginx:1.27@" + index), index},
+		{"layers only", layers("layer-sha256:aaa"), ""},
+		{"merged images", layers("index-"+index, "index-"+other), ""},
+		{"several manifests", layers("manifest-"+manifest, "manifest-"+other), ""},
+	}
+	for _, tt := range tests {
+		if got := resolvedDigest(tt.layers); got != tt.want {
+			t.Errorf("%s: resolvedDigest = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
 // ── mergeDigestPulls ──────────────────────────────────────────────────────────
 
 func TestMergeDigestPulls_Empty(t *testing.T) {
@@ -349,3 +807,33 @@ func TestRateLimiter_Cleanup(t *testing.T) {
 		t.Error("stale entry should have been cleaned up")
 	}
 }
+
+// ── handleReadyz ─────────────────────────────────────────────────────────────
+
+func TestHandleReadyz_WaitsForPodWatcher(t *testing.T) {
+	s := newTestServer()
+	ready := func() int {
+		w := httptest.NewRecorder()
+		s.handleReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code
+	}
+	if code := ready(); code != http.StatusOK {
+		t.Errorf("without a pod watcher: %d, want 200", code)
+	}
+
+	s.podWatcher = k8s.NewPodWatcherForClient(fake.NewSimpleClientset(), nil, s.logger)
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Errorf("before the caches sync: %d, want 503", code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.podWatcher.Run(ctx) //nolint:errcheck
+	deadline := time.Now().Add(5 * time.Second)
+	for ready() != http.StatusOK {
+		if time.Now().After(deadline) {
+			t.Fatal("readyz did not turn ready after the caches synced")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sseReplaySize is how many recent events are kept so a reconnecting client
+// that sends Last-Event-ID can resume without a full snapshot.
+const sseReplaySize = 1024
+
+// sseMessage is one framed server-sent event.
+type sseMessage struct {
+	id   string
+	data []byte
+}
+
+// sseLog numbers broadcast events and remembers the most recent ones. IDs
+// have the form "<epoch>-<seq>"; the epoch changes on every server start so a
+// client resuming against a restarted server falls back to a snapshot instead
+// of being matched against unrelated sequence numbers. It is guarded by
+// Server.sseMu.
+type sseLog struct {
+	epoch  string
+	seq    uint64
+	recent []sseMessage
+}
+
+func newSSELog() *sseLog {
+	return &sseLog{epoch: strconv.FormatInt(time.Now().UnixNano(), 36)}
+}
+
+// append assigns the next ID to data and records it.
+func (l *sseLog) append(data []byte) sseMessage {
+	l.seq++
+	msg := sseMessage{id: l.id(l.seq), data: data}
+	if len(l.recent) == sseReplaySize {
+		copy(l.recent, l.recent[1:])
+		l.recent = l.recent[:sseReplaySize-1]
+	}
+	l.recent = append(l.recent, msg)
+	return msg
+}
+
+// lastID is the ID of the most recent event, or "" before the first one.
+func (l *sseLog) lastID() string {
+	if l.seq == 0 {
+		return ""
+	}
+	return l.id(l.seq)
+}
+
+// since returns the events after lastEventID. ok is false when the ID is from
+// another epoch or too old to replay, in which case the client needs a
+// snapshot.
+func (l *sseLog) since(lastEventID string) (msgs []sseMessage, ok bool) {
+	epoch, seqStr, found := strings.Cut(lastEventID, "-")
+	if !found || epoch != l.epoch {
+		return nil, false
+	}
+	seq, err := strconv.ParseUint(seqStr, 10, 64)
+	if err != nil || seq > l.seq {
+		return nil, false
+	}
+	if seq == l.seq {
+		return nil, true
+	}
+	if len(l.recent) == 0 || l.seq-uint64(len(l.recent)) > seq {
+		return nil, false
+	}
+	start := len(l.recent) - int(l.seq-seq)
+	return append([]sseMessage(nil), l.recent[start:]...), true
+}
+
+func (l *sseLog) id(seq uint64) string {
+	return fmt.Sprintf("%s-%d", l.epoch, seq)
+}
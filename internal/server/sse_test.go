@@ -0,0 +1,47 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSSELog_Since(t *testing.T) {
+	l := newSSELog()
+	if l.lastID() != "" {
+		t.Fatalf("lastID before any event = %q", l.lastID())
+	}
+	for i := 1; i <= 3; i++ {
+		l.append([]byte(fmt.Sprint(i)))
+	}
+
+	msgs, ok := l.since(l.id(1))
+	if !ok || len(msgs) != 2 || string(msgs[0].data) != "2" || string(msgs[1].data) != "3" {
+		t.Errorf("since(1) = %v, %v", msgs, ok)
+	}
+	if msgs, ok := l.since(l.lastID()); !ok || len(msgs) != 0 {
+		t.Errorf("since(latest) = %v, %v; want nothing to replay", msgs, ok)
+	}
+
+	for _, id := range []string{"", "garbage", "other-1", l.id(4), l.epoch + "-x"} {
+		if _, ok := l.since(id); ok {
+			t.Errorf("since(%q) resumed, want snapshot", id)
+		}
+	}
+}
+
+func TestSSELog_WindowIsBounded(t *testing.T) {
+	l := newSSELog()
+	for i := 0; i < sseReplaySize+10; i++ {
+		l.append([]byte("x"))
+	}
+	if len(l.recent) != sseReplaySize {
+		t.Fatalf("kept %d events, want %d", len(l.recent), sseReplaySize)
+	}
+	if _, ok := l.since(l.id(5)); ok {
+		t.Error("resumed from an event that fell out of the window")
+	}
+	msgs, ok := l.since(l.id(10))
+	if !ok || len(msgs) != sseReplaySize {
+		t.Errorf("since(oldest-1) = %d events, %v; want the full window", len(msgs), ok)
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"strings"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/metrics"
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+// progressMark is the downloaded byte count last reported for a pull or
+// layer and when it last changed.
+type progressMark struct {
+	bytes int64
+	at    time.Time
+}
+
+// advance records bytes as the downloaded total for a pull or layer key and
+// returns when that total last changed. Any change counts, so a layer that
+// restarts from zero or leaves the report is not mistaken for a stall.
+// s.mu must be held.
+func (s *Server) advance(key string, bytes int64, now time.Time) time.Time {
+	m, ok := s.progress[key]
+	if !ok || m.bytes != bytes {
+		m = progressMark{bytes: bytes, at: now}
+		s.progress[key] = m
+	}
+	return m.at
+}
+
+// forgetProgress drops the progress marks of a pull key and its layers.
+// s.mu must be held.
+func (s *Server) forgetProgress(key string) {
+	delete(s.progress, key)
+	layerPrefix := key + ":layer:"
+	for k := range s.progress {
+		if strings.HasPrefix(k, layerPrefix) {
+			delete(s.progress, k)
+		}
+	}
+}
+
+// isStalled reports whether a pull or layer whose bytes last changed at
+// progressAt is stalled at now. A zero StallTimeout disables detection.
+func (s *Server) isStalled(progressAt, now time.Time) bool {
+	return s.config.StallTimeout > 0 && now.Sub(progressAt) >= s.config.StallTimeout
+}
+
+// setStalled sets p.Stalled and keeps the stalled pulls gauge in step.
+func setStalled(p *model.PullStatus, stalled bool) {
+	trackStalled(p.Stalled, stalled)
+	p.Stalled = stalled
+}
+
+// trackStalled adjusts the stalled pulls gauge for a pull going from was to
+// is.
+func trackStalled(was, is bool) {
+	switch {
+	case is && !was:
+		metrics.PullsStalled.Inc()
+	case was && !is:
+		metrics.PullsStalled.Dec()
+	}
+}
+
+// clearStalled unmarks a pull that has completed, and its layers. Stalled
+// layers are copied because snapshots may still share the old slice.
+func clearStalled(p *model.PullStatus) {
+	setStalled(p, false)
+	for i, l := range p.Layers {
+		if !l.Stalled {
+			continue
+		}
+		layers := make([]model.LayerStatus, len(p.Layers))
+		copy(layers, p.Layers)
+		for j := i; j < len(layers); j++ {
+			layers[j].Stalled = false
+		}
+		p.Layers = layers
+		return
+	}
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+// ageProgress makes every recorded progress mark d older, as if the
+// server had seen no change in bytes for that long.
+func ageProgress(s *Server, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, m := range s.progress {
+		m.at = m.at.Add(-d)
+		s.progress[k] = m
+	}
+}
+
+func TestProcessReport_StalledPullRecovers(t *testing.T) {
+	s := newTestServer()
+	s.config.StallTimeout = time.Minute
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+	resp, r := openSSE(t, srv.URL, "")
+	defer resp.Body.Close()
+
+	report := func(hung int64) {
+		s.processReport(model.AgentReport{
+			NodeName:  "node1",
+			Timestamp: time.Now(),
+			Pulls: []model.PullState{{
+				ImageRef:   "app:1",
+				StartedAt:  time.Now(),
+				TotalKnown: true,
+				Layers: []model.LayerState{
+					{Digest: "sha256:hung", TotalBytes: 1000, DownloadedBytes: hung, TotalKnown: true},
+					{Digest: "sha256:done", TotalBytes: 500, DownloadedBytes: 500, TotalKnown: true},
+				},
+			}},
+		})
+	}
+	pull := func() model.PullStatus {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return *s.pulls["node1:app:1"]
+	}
+
+	report(100)
+	ageProgress(s, 2*time.Minute)
+	report(100)
+	p := pull()
+	if !p.Stalled || !p.Layers[0].Stalled || p.Layers[1].Stalled || p.LastProgressAt == nil {
+		t.Fatalf("pull after the stall timeout = %+v", p)
+	}
+	report(100) // still stalled, but only announced once
+
+	report(200)
+	if p := pull(); p.Stalled || p.Layers[0].Stalled || p.CompletedAt != nil {
+		t.Fatalf("pull after progress = %+v", p)
+	}
+
+	_, events := readSSE(t, r, 4)
+	var types []model.EventType
+	for _, ev := range events {
+		types = append(types, ev.Type)
+	}
+	want := []model.EventType{model.EventPullProgress, model.EventPullStalled, model.EventPullProgress, model.EventPullProgress}
+	for i := range want {
+		if types[i] != want[i] {
+			t.Fatalf("event types = %v, want %v", types, want)
+		}
+	}
+	if !events[2].Pull.Stalled || events[3].Pull.Stalled {
+		t.Errorf("stalled flags = %v, %v", events[2].Pull.Stalled, events[3].Pull.Stalled)
+	}
+}
+
+func TestProcessReport_CompletedPullIsNotStalled(t *testing.T) {
+	s := newTestServer()
+	s.config.StallTimeout = time.Minute
+	pulls := []model.PullState{{
+		ImageRef:  "app:1",
+		StartedAt: time.Now(),
+		Layers:    []model.LayerState{{Digest: "sha256:hung", TotalBytes: 1000, DownloadedBytes: 100, TotalKnown: true}},
+	}}
+	s.processReport(model.AgentReport{NodeName: "node1", Pulls: pulls})
+	ageProgress(s, 2*time.Minute)
+	s.processReport(model.AgentReport{NodeName: "node1", Pulls: pulls})
+	s.processReport(model.AgentReport{NodeName: "node1"})
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p := s.pulls["node1:app:1"]
+	if p.CompletedAt == nil || p.Stalled || p.Layers[0].Stalled {
+		t.Errorf("completed pull = %+v", p)
+	}
+}
+
+func TestProcessReport_StallDetectionDisabled(t *testing.T) {
+	s := newTestServer()
+	pulls := []model.PullState{{ImageRef: "app:1", StartedAt: time.Now()}}
+	s.processReport(model.AgentReport{NodeName: "node1", Pulls: pulls})
+	ageProgress(s, time.Hour)
+	s.processReport(model.AgentReport{NodeName: "node1", Pulls: pulls})
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if p := s.pulls["node1:app:1"]; p.Stalled {
+		t.Errorf("pull stalled with StallTimeout 0: %+v", p)
+	}
+}
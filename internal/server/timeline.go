@@ -0,0 +1,135 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/metrics"
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+func (s *Server) handlePodTimeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if s.podWatcher == nil {
+		http.Error(w, "pod not found", http.StatusNotFound)
+		return
+	}
+	tl, ok := s.podWatcher.PodTimeline(r.PathValue("namespace"), r.PathValue("name"))
+	if !ok {
+		http.Error(w, "pod not found", http.StatusNotFound)
+		return
+	}
+	s.mu.RLock()
+	s.completeTimeline(&tl)
+	s.mu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tl) //nolint:errcheck
+}
+
+// observeTimeline records the startup of a pod that has just become Ready.
+// Only pods that pulled an image are counted, and only by the leader so
+// replicas do not count a pod twice.
+func (s *Server) observeTimeline(tl model.PodTimeline) {
+	if tl.PullingAt == nil || (s.replica != nil && !s.replica.isLeading()) {
+		return
+	}
+	s.mu.RLock()
+	s.completeTimeline(&tl)
+	s.mu.RUnlock()
+
+	attrs := []any{"namespace", tl.Namespace, "pod", tl.Name, "node", tl.NodeName}
+	for _, seg := range tl.Segments {
+		metrics.PodStartupSeconds.WithLabelValues(seg.Name).Observe(seg.Seconds)
+		attrs = append(attrs, seg.Name, seg.Seconds)
+	}
+	if tl.ReadyAt != nil {
+		metrics.PodStartupSeconds.WithLabelValues("total").Observe(tl.TotalSeconds)
+	}
+	attrs = append(attrs, "imageSeconds", tl.ImageSeconds, "totalSeconds", tl.TotalSeconds)
+	s.logger.Info("pod.ready", attrs...)
+}
+
+// completeTimeline adds the agents' ingest times of the pod's pulls to a
+// timeline and computes its segments. s.mu must be held.
+func (s *Server) completeTimeline(tl *model.PodTimeline) {
+	var first, last time.Time
+	done := true
+	for _, p := range s.podPulls(podRef{tl.Namespace, tl.Name}) {
+		if !pullOfPod(p, tl) {
+			continue
+		}
+		if first.IsZero() || p.StartedAt.Before(first) {
+			first = p.StartedAt
+		}
+		if p.CompletedAt == nil {
+			done = false
+		} else if p.CompletedAt.After(last) {
+			last = *p.CompletedAt
+		}
+	}
+	if !first.IsZero() {
+		tl.FirstIngestAt = &first
+		if done {
+			tl.IngestedAt = &last
+		}
+	}
+	timelineSegments(tl)
+}
+
+// pullOfPod reports whether a pull was waited on by the pod the timeline
+// describes rather than an earlier pod of the same name.
+func pullOfPod(p *model.PullStatus, tl *model.PodTimeline) bool {
+	for _, pc := range p.Pods {
+		if pc.Namespace == tl.Namespace && pc.PodName == tl.Name && (tl.UID == "" || pc.PodUID == "" || pc.PodUID == tl.UID) {
+			return true
+		}
+	}
+	return false
+}
+
+// timelineSegments sets the segments of a timeline whose start and end are
+// both known, and its image and total times. Timestamps come from different
+// clocks, so a segment that would end before it starts counts as zero.
+func timelineSegments(tl *model.PodTimeline) {
+	created := &tl.CreatedAt
+	if tl.CreatedAt.IsZero() {
+		created = nil
+	}
+	bounds := []struct {
+		name       string
+		start, end *time.Time
+	}{
+		{model.SegmentScheduled, created, tl.ScheduledAt},
+		{model.SegmentPullQueued, tl.PullingAt, tl.FirstIngestAt},
+		{model.SegmentPulling, tl.FirstIngestAt, tl.IngestedAt},
+		{model.SegmentUnpacking, tl.IngestedAt, tl.PulledAt},
+		{model.SegmentStarted, tl.PulledAt, tl.ContainersReadyAt},
+	}
+	tl.Segments = []model.TimelineSegment{}
+	for _, b := range bounds {
+		if b.start == nil || b.end == nil {
+			continue
+		}
+		tl.Segments = append(tl.Segments, model.TimelineSegment{
+			Name:    b.name,
+			Start:   *b.start,
+			End:     *b.end,
+			Seconds: seconds(*b.start, *b.end),
+		})
+	}
+	if tl.PullingAt != nil && tl.PulledAt != nil {
+		tl.ImageSeconds = seconds(*tl.PullingAt, *tl.PulledAt)
+	}
+	if created != nil && tl.ReadyAt != nil {
+		tl.TotalSeconds = seconds(*created, *tl.ReadyAt)
+	}
+}
+
+func seconds(start, end time.Time) float64 {
+	return max(end.Sub(start).Seconds(), 0)
+}
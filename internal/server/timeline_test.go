@@ -0,0 +1,76 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+func TestCompleteTimeline(t *testing.T) {
+	s := newTestServer()
+	created := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	at := func(sec int) *time.Time {
+		t := created.Add(time.Duration(sec) * time.Second)
+		return &t
+	}
+	pod := func(uid string) []model.PodCorrelation {
+		return []model.PodCorrelation{{Namespace: "default", PodName: "web-0", PodUID: uid, Container: "app"}}
+	}
+	s.pulls["node1:app:1"] = &model.PullStatus{ID: "a", StartedAt: *at(10), CompletedAt: at(20), Pods: pod("u1")}
+	s.pulls["node1:sidecar:1"] = &model.PullStatus{ID: "b", StartedAt: *at(12), CompletedAt: at(25), Pods: pod("u1")}
+	s.pulls["node1:old:1"] = &model.PullStatus{ID: "c", StartedAt: *at(-60), CompletedAt: at(-50), Pods: pod("u0")}
+
+	tl := model.PodTimeline{
+		Namespace: "default", Name: "web-0", UID: "u1", CreatedAt: created,
+		ScheduledAt: at(2), PullingAt: at(4), PulledAt: at(24), ContainersReadyAt: at(30), ReadyAt: at(31),
+	}
+	s.completeTimeline(&tl)
+
+	if tl.FirstIngestAt == nil || !tl.FirstIngestAt.Equal(*at(10)) || tl.IngestedAt == nil || !tl.IngestedAt.Equal(*at(25)) {
+		t.Errorf("ingest = %v .. %v", tl.FirstIngestAt, tl.IngestedAt)
+	}
+	// The Pulled event's clock is a second behind the server's, so
+	// unpacking counts as zero rather than negative.
+	want := map[string]float64{
+		model.SegmentScheduled:  2,
+		model.SegmentPullQueued: 6,
+		model.SegmentPulling:    15,
+		model.SegmentUnpacking:  0,
+		model.SegmentStarted:    6,
+	}
+	if len(tl.Segments) != len(want) {
+		t.Fatalf("segments = %+v", tl.Segments)
+	}
+	for _, seg := range tl.Segments {
+		if seg.Seconds != want[seg.Name] {
+			t.Errorf("%s = %vs, want %vs", seg.Name, seg.Seconds, want[seg.Name])
+		}
+	}
+	if tl.ImageSeconds != 20 || tl.TotalSeconds != 31 {
+		t.Errorf("image = %vs, total = %vs", tl.ImageSeconds, tl.TotalSeconds)
+	}
+}
+
+func TestCompleteTimeline_PullInProgress(t *testing.T) {
+	s := newTestServer()
+	now := time.Now()
+	s.pulls["node1:app:1"] = &model.PullStatus{ID: "a", StartedAt: now,
+		Pods: []model.PodCorrelation{{Namespace: "default", PodName: "web-0", Container: "app"}}}
+	pulling := now.Add(-time.Second)
+	tl := model.PodTimeline{Namespace: "default", Name: "web-0", PullingAt: &pulling}
+	s.completeTimeline(&tl)
+
+	if tl.IngestedAt != nil || len(tl.Segments) != 1 || tl.Segments[0].Name != model.SegmentPullQueued {
+		t.Errorf("timeline = %+v", tl)
+	}
+}
+
+func TestHandlePodTimeline_NoPodWatcher(t *testing.T) {
+	s := newTestServer()
+	var tl model.PodTimeline
+	if code := getJSON(t, s.Handler(), "/api/v1/pods/default/web-0/timeline", &tl); code != http.StatusNotFound {
+		t.Errorf("GET timeline: %d, want 404", code)
+	}
+}
@@ -0,0 +1,294 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+// workloadRef identifies a workload by namespace, kind and name. The kind
+// is matched case-insensitively so API paths may use "deployment".
+type workloadRef struct{ namespace, kind, name string }
+
+func (r workloadRef) matches(pc model.PodCorrelation) bool {
+	return pc.Workload != nil && pc.Namespace == r.namespace &&
+		strings.EqualFold(pc.Workload.Kind, r.kind) && pc.Workload.Name == r.name
+}
+
+func (r workloadRef) less(o workloadRef) bool {
+	if r.namespace != o.namespace {
+		return r.namespace < o.namespace
+	}
+	if r.kind != o.kind {
+		return r.kind < o.kind
+	}
+	return r.name < o.name
+}
+
+// addWorkloads adds the workloads of a pull's pods to refs.
+func addWorkloads(refs map[workloadRef]bool, p *model.PullStatus) {
+	for _, pc := range p.Pods {
+		if pc.Workload != nil {
+			refs[workloadRef{pc.Namespace, pc.Workload.Kind, pc.Workload.Name}] = true
+		}
+	}
+}
+
+// workloadPulls returns the local pulls of a workload's pods. s.mu must be
+// held.
+func (s *Server) workloadPulls(ref workloadRef) []*model.PullStatus {
+	var pulls []*model.PullStatus
+	for _, p := range s.pulls {
+		if ref.belongsTo(p) {
+			pulls = append(pulls, p)
+		}
+	}
+	return pulls
+}
+
+// broadcastWorkloads sends a workload event for each workload in refs.
+// s.mu must be held.
+func (s *Server) broadcastWorkloads(refs map[workloadRef]bool, now time.Time) {
+	sorted := make([]workloadRef, 0, len(refs))
+	for ref := range refs {
+		sorted = append(sorted, ref)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].less(sorted[j]) })
+
+	for _, ref := range sorted {
+		w, ok := aggregateWorkload(ref, s.workloadPulls(ref))
+		if !ok {
+			continue
+		}
+		typ := model.EventWorkloadProgress
+		if w.CompletedAt != nil {
+			typ = model.EventWorkloadCompleted
+		}
+		event := model.PullEvent{
+			SchemaVersion: model.SchemaVersion,
+			Timestamp:     now,
+			Type:          typ,
+			Workload:      &w,
+		}
+		if data, err := json.Marshal(event); err == nil {
+			if typ == model.EventWorkloadCompleted {
+				s.logger.Info("workload.completed",
+					"namespace", w.Namespace,
+					"kind", w.Kind,
+					"name", w.Name,
+					"nodes", w.NodesDone,
+					"duration", w.CompletedAt.Sub(w.StartedAt).String(),
+				)
+			} else {
+				s.logger.Debug("workload.progress",
+					"namespace", w.Namespace,
+					"kind", w.Kind,
+					"name", w.Name,
+					"nodesPulling", w.NodesPulling,
+					"nodesDone", w.NodesDone,
+					"slowestNode", w.SlowestNode,
+				)
+			}
+			s.broadcastSSE(data)
+		}
+	}
+}
+
+func (s *Server) handleWorkloads(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Without a cluster, the server's own workloads come first, followed by
+	// those of every downstream in name order.
+	cluster := r.URL.Query().Get("cluster")
+	workloads := []model.WorkloadStatus{}
+	if cluster == "" || cluster == s.config.ClusterName {
+		s.mu.RLock()
+		pulls := make([]*model.PullStatus, 0, len(s.pulls))
+		for _, p := range s.pulls {
+			pulls = append(pulls, p)
+		}
+		workloads = append(workloads, aggregateWorkloads(pulls)...)
+		s.mu.RUnlock()
+	}
+	if cluster == "" {
+		for _, name := range s.remoteClusterNames() {
+			pulls, _ := s.clusterPulls(name)
+			workloads = append(workloads, aggregateWorkloads(pulls)...)
+		}
+	} else if cluster != s.config.ClusterName {
+		pulls, ok := s.clusterPulls(cluster)
+		if !ok {
+			http.Error(w, "cluster not found", http.StatusNotFound)
+			return
+		}
+		workloads = append(workloads, aggregateWorkloads(pulls)...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model.WorkloadsResponse{Workloads: workloads}) //nolint:errcheck
+}
+
+func (s *Server) handleWorkload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ref := workloadRef{namespace: r.PathValue("namespace"), kind: r.PathValue("kind"), name: r.PathValue("name")}
+	var wl model.WorkloadStatus
+	var ok bool
+	if cluster := r.URL.Query().Get("cluster"); cluster == "" || cluster == s.config.ClusterName {
+		s.mu.RLock()
+		wl, ok = aggregateWorkload(ref, s.workloadPulls(ref))
+		s.mu.RUnlock()
+	} else if pulls, known := s.clusterPulls(cluster); !known {
+		http.Error(w, "cluster not found", http.StatusNotFound)
+		return
+	} else {
+		wl, ok = aggregateWorkload(ref, pulls)
+	}
+	if !ok {
+		http.Error(w, "workload not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(wl) //nolint:errcheck
+}
+
+// aggregateWorkloads combines pulls, all from one cluster, into a status for
+// each of their pods' workloads, sorted by namespace, kind and name.
+func aggregateWorkloads(pulls []*model.PullStatus) []model.WorkloadStatus {
+	refs := make(map[workloadRef]bool)
+	for _, p := range pulls {
+		addWorkloads(refs, p)
+	}
+	sorted := make([]workloadRef, 0, len(refs))
+	for ref := range refs {
+		sorted = append(sorted, ref)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].less(sorted[j]) })
+	var workloads []model.WorkloadStatus
+	for _, ref := range sorted {
+		if wl, ok := aggregateWorkload(ref, pulls); ok {
+			workloads = append(workloads, wl)
+		}
+	}
+	return workloads
+}
+
+// belongsTo reports whether any of the pull's pods is part of the workload.
+func (r workloadRef) belongsTo(p *model.PullStatus) bool {
+	for _, pc := range p.Pods {
+		if r.matches(pc) {
+			return true
+		}
+	}
+	return false
+}
+
+// aggregateWorkload combines the pulls of a workload's pods per node. While
+// any pull is active, pulls that completed before the earliest active one
+// started are left out as belonging to an earlier rollout. It returns false
+// if no pull belongs to the workload.
+func aggregateWorkload(ref workloadRef, pulls []*model.PullStatus) (model.WorkloadStatus, bool) {
+	var rolloutStart time.Time
+	for _, p := range pulls {
+		if ref.belongsTo(p) && p.CompletedAt == nil && (rolloutStart.IsZero() || p.StartedAt.Before(rolloutStart)) {
+			rolloutStart = p.StartedAt
+		}
+	}
+
+	wl := model.WorkloadStatus{Namespace: ref.namespace, Kind: ref.kind, Name: ref.name}
+	nodes := make(map[string]*model.WorkloadNode)
+	etaKnown := make(map[string]bool)
+	var completedAt time.Time
+	for _, p := range pulls {
+		if !ref.belongsTo(p) || (p.CompletedAt != nil && !rolloutStart.IsZero() && p.CompletedAt.Before(rolloutStart)) {
+			continue
+		}
+		if wl.PullCount == 0 {
+			wl.Cluster = p.Cluster
+			for _, pc := range p.Pods {
+				if ref.matches(pc) {
+					wl.Kind = pc.Workload.Kind
+					break
+				}
+			}
+		}
+		wl.PullCount++
+		if wl.StartedAt.IsZero() || p.StartedAt.Before(wl.StartedAt) {
+			wl.StartedAt = p.StartedAt
+		}
+
+		n, ok := nodes[p.NodeName]
+		if !ok {
+			n = &model.WorkloadNode{NodeName: p.NodeName}
+			nodes[p.NodeName] = n
+			etaKnown[p.NodeName] = true
+		}
+		n.PullCount++
+		n.TotalBytes += p.TotalBytes
+		if p.CompletedAt != nil {
+			n.PullsDone++
+			n.DownloadedBytes += p.TotalBytes
+			if n.CompletedAt == nil || p.CompletedAt.After(*n.CompletedAt) {
+				n.CompletedAt = p.CompletedAt
+			}
+			if p.CompletedAt.After(completedAt) {
+				completedAt = *p.CompletedAt
+			}
+			continue
+		}
+		n.DownloadedBytes += p.DownloadedBytes
+		n.BytesPerSec += p.BytesPerSec
+		if p.ETASeconds <= 0 && !(p.TotalKnown && p.DownloadedBytes >= p.TotalBytes) {
+			etaKnown[p.NodeName] = false
+		}
+		n.ETASeconds = max(n.ETASeconds, p.ETASeconds)
+	}
+	if wl.PullCount == 0 {
+		return model.WorkloadStatus{}, false
+	}
+
+	slowest := -1.0
+	for name, n := range nodes {
+		if n.TotalBytes > 0 {
+			n.Percent = float64(n.DownloadedBytes) / float64(n.TotalBytes) * 100
+		}
+		wl.PullsDone += n.PullsDone
+		wl.TotalBytes += n.TotalBytes
+		wl.DownloadedBytes += n.DownloadedBytes
+		wl.BytesPerSec += n.BytesPerSec
+		if n.PullsDone == n.PullCount {
+			n.Percent = 100
+			wl.NodesDone++
+		} else {
+			// A node is done only when all of its pulls are.
+			n.CompletedAt = nil
+			if !etaKnown[name] {
+				n.ETASeconds = 0
+			}
+			wl.NodesPulling++
+			if slowest < 0 || n.Percent < slowest || (n.Percent == slowest && name < wl.SlowestNode) {
+				slowest, wl.SlowestNode = n.Percent, name
+			}
+		}
+		wl.Nodes = append(wl.Nodes, *n)
+	}
+	sort.Slice(wl.Nodes, func(i, j int) bool { return wl.Nodes[i].NodeName < wl.Nodes[j].NodeName })
+
+	if wl.NodesPulling == 0 {
+		wl.CompletedAt = &completedAt
+		wl.Percent = 100
+	} else if wl.TotalBytes > 0 {
+		wl.Percent = float64(wl.DownloadedBytes) / float64(wl.TotalBytes) * 100
+	}
+	return wl, true
+}
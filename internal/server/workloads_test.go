@@ -0,0 +1,113 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+func webPods(pod string) []model.PodCorrelation {
+	return []model.PodCorrelation{{Namespace: "default", PodName: pod, Container: "app",
+		Workload: &model.WorkloadRef{Kind: "Deployment", Name: "web"}}}
+}
+
+func TestAggregateWorkload_PerNode(t *testing.T) {
+	now := time.Now()
+	rollout := now.Add(-2 * time.Minute)
+	done := now.Add(-time.Second)
+	previous := now.Add(-time.Hour)
+	pulls := []*model.PullStatus{
+		{ID: "n1", NodeName: "node1", StartedAt: rollout, CompletedAt: &done, TotalBytes: 100, DownloadedBytes: 90, Pods: webPods("web-a")},
+		{ID: "n2", NodeName: "node2", StartedAt: rollout, TotalBytes: 100, DownloadedBytes: 60, BytesPerSec: 5, ETASeconds: 8, TotalKnown: true, Pods: webPods("web-b")},
+		{ID: "n3", NodeName: "node3", StartedAt: rollout, TotalBytes: 100, DownloadedBytes: 20, BytesPerSec: 5, ETASeconds: 16, TotalKnown: true, Pods: webPods("web-c")},
+		// Completed before the rollout's first active pull started.
+		{ID: "old", NodeName: "node4", StartedAt: previous, CompletedAt: &previous, TotalBytes: 100, Pods: webPods("web-old")},
+		{ID: "other", NodeName: "node1", StartedAt: now, TotalBytes: 999, Pods: []model.PodCorrelation{{Namespace: "default", PodName: "db-0"}}},
+	}
+
+	wl, ok := aggregateWorkload(workloadRef{"default", "deployment", "web"}, pulls)
+	if !ok {
+		t.Fatal("workload not found")
+	}
+	if wl.Kind != "Deployment" || wl.NodesDone != 1 || wl.NodesPulling != 2 || wl.PullCount != 3 || wl.PullsDone != 1 {
+		t.Errorf("workload = %+v", wl)
+	}
+	if wl.TotalBytes != 300 || wl.DownloadedBytes != 180 || wl.Percent != 60 || wl.BytesPerSec != 10 {
+		t.Errorf("totals = %d/%d %.0f%% %.0fB/s", wl.DownloadedBytes, wl.TotalBytes, wl.Percent, wl.BytesPerSec)
+	}
+	if wl.SlowestNode != "node3" || wl.CompletedAt != nil {
+		t.Errorf("slowest = %q, completedAt = %v", wl.SlowestNode, wl.CompletedAt)
+	}
+	if len(wl.Nodes) != 3 || wl.Nodes[0].NodeName != "node1" || wl.Nodes[0].Percent != 100 || wl.Nodes[2].ETASeconds != 16 {
+		t.Errorf("nodes = %+v", wl.Nodes)
+	}
+
+	if _, ok := aggregateWorkload(workloadRef{"default", "StatefulSet", "web"}, pulls); ok {
+		t.Error("workload of another kind should not be found")
+	}
+}
+
+func TestAggregateWorkload_Completed(t *testing.T) {
+	now := time.Now()
+	first, last := now.Add(-time.Minute), now
+	pulls := []*model.PullStatus{
+		{ID: "n1", NodeName: "node1", StartedAt: now.Add(-2 * time.Minute), CompletedAt: &first, TotalBytes: 100, Pods: webPods("web-a")},
+		{ID: "n2", NodeName: "node2", StartedAt: now.Add(-2 * time.Minute), CompletedAt: &last, TotalBytes: 100, Pods: webPods("web-b")},
+	}
+	wl, _ := aggregateWorkload(workloadRef{"default", "Deployment", "web"}, pulls)
+	if wl.CompletedAt == nil || !wl.CompletedAt.Equal(last) || wl.NodesDone != 2 || wl.SlowestNode != "" || wl.Percent != 100 {
+		t.Errorf("completed workload = %+v", wl)
+	}
+}
+
+func TestHandleWorkloads_AndWorkloadEvents(t *testing.T) {
+	s := newTestServer()
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	app := model.PullState{ImageRef: "web:2", StartedAt: time.Now(), TotalKnown: true,
+		Layers: []model.LayerState{{Digest: "sha256:a", TotalBytes: 1000, DownloadedBytes: 500, TotalKnown: true}}}
+	s.processReport(model.AgentReport{NodeName: "node1", Timestamp: time.Now(), Pulls: []model.PullState{app}})
+	// Correlate as the pod watcher would.
+	s.mu.Lock()
+	s.pulls["node1:web:2"].Pods = webPods("web-a")
+	s.mu.Unlock()
+
+	resp, r := openSSE(t, srv.URL, "")
+	defer resp.Body.Close()
+	readSSE(t, r, 1) // snapshot
+
+	s.processReport(model.AgentReport{NodeName: "node1", Timestamp: time.Now(), Pulls: []model.PullState{app}})
+	// The pull event is followed by the pod's and then the workload's.
+	_, events := readSSE(t, r, 3)
+	if ev := events[2]; ev.Type != model.EventWorkloadProgress || ev.Workload == nil || ev.Workload.SlowestNode != "node1" {
+		t.Fatalf("workload event = %+v", ev)
+	}
+
+	var list model.WorkloadsResponse
+	if code := getJSON(t, s.Handler(), "/api/v1/workloads", &list); code != http.StatusOK {
+		t.Fatalf("GET workloads: %d", code)
+	}
+	if len(list.Workloads) != 1 || list.Workloads[0].Name != "web" || list.Workloads[0].Percent != 50 {
+		t.Errorf("workloads = %+v", list.Workloads)
+	}
+	var wl model.WorkloadStatus
+	if code := getJSON(t, s.Handler(), "/api/v1/workloads/default/deployment/web", &wl); code != http.StatusOK {
+		t.Fatalf("GET workload: %d", code)
+	}
+	if wl.Kind != "Deployment" || wl.NodesPulling != 1 {
+		t.Errorf("workload = %+v", wl)
+	}
+	if code := getJSON(t, s.Handler(), "/api/v1/workloads/default/deployment/api", &wl); code != http.StatusNotFound {
+		t.Errorf("GET unknown workload: %d, want 404", code)
+	}
+
+	s.processReport(model.AgentReport{NodeName: "node1", Timestamp: time.Now()})
+	_, events = readSSE(t, r, 3)
+	if ev := events[2]; ev.Type != model.EventWorkloadCompleted || ev.Workload.NodesDone != 1 {
+		t.Errorf("workload event = %+v", ev)
+	}
+}
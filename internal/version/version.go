@@ -0,0 +1,6 @@
+// Package version holds the build version of the Pulltrace binaries.
+package version
+
+// Version is set at build time via
+// -ldflags "-X github.com/d44b/pulltrace/internal/version.Version=<version>".
+var Version = "dev"
@@ -0,0 +1,272 @@
+// Package client is a Go client for the pulltrace server API.
+//
+//	c, err := client.New("http://pulltrace-server.pulltrace:8080")
+//	pulls, err := c.ListPulls(ctx)
+//	events, err := c.Watch(ctx, client.WatchFilter{Namespace: "prod"})
+//	for ev := range events {
+//		fmt.Println(ev.Type, ev.Pull.ImageRef, ev.Pull.Percent)
+//	}
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+)
+
+// API types, shared with the server.
+type (
+	PullStatus      = model.PullStatus
+	LayerStatus     = model.LayerStatus
+	KubeletPull     = model.KubeletPull
+	PullRetries     = model.PullRetries
+	Discrepancy     = model.Discrepancy
+	PodCorrelation  = model.PodCorrelation
+	CorrelationKind = model.CorrelationKind
+	PodStatus       = model.PodStatus
+	PodPull         = model.PodPull
+	PodTimeline     = model.PodTimeline
+	WorkloadRef     = model.WorkloadRef
+	WorkloadStatus  = model.WorkloadStatus
+	WorkloadNode    = model.WorkloadNode
+	PullEvent       = model.PullEvent
+	EventType       = model.EventType
+)
+
+// Event types on the server's event stream. Watch delivers the pod and
+// workload events, which carry no pull, only when WatchFilter.Aggregates is
+// set.
+const (
+	EventPullProgress      = model.EventPullProgress
+	EventPullCompleted     = model.EventPullCompleted
+	EventPullStalled       = model.EventPullStalled
+	EventPodProgress       = model.EventPodProgress
+	EventPodCompleted      = model.EventPodCompleted
+	EventWorkloadProgress  = model.EventWorkloadProgress
+	EventWorkloadCompleted = model.EventWorkloadCompleted
+)
+
+// Discrepancies between the kubelet's and the agent's accounts of a pull.
+const (
+	DiscrepancyMissed   = model.DiscrepancyMissed
+	DiscrepancySize     = model.DiscrepancySize
+	DiscrepancyDuration = model.DiscrepancyDuration
+)
+
+// Kinds of pod containers and volumes that wait on a pull.
+const (
+	CorrelationContainer          = model.CorrelationContainer
+	CorrelationInitContainer      = model.CorrelationInitContainer
+	CorrelationEphemeralContainer = model.CorrelationEphemeralContainer
+	CorrelationImageVolume        = model.CorrelationImageVolume
+)
+
+// ErrNotFound is returned by the Get methods when the server does not know the
+// pull, pod or workload, either because it is wrong or because it aged out of
+// the history.
+var ErrNotFound = errors.New("pulltrace: not found")
+
+// StatusError is returned when the server answers with a non-200 status.
+type StatusError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("pulltrace: %s %s: %d %s", e.Method, e.Path, e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// Is makes errors.Is(err, ErrNotFound) true for 404 responses.
+func (e *StatusError) Is(target error) bool {
+	return target == ErrNotFound && e.StatusCode == http.StatusNotFound
+}
+
+// Client talks to a pulltrace server. It is safe for concurrent use.
+type Client struct {
+	base       *url.URL
+	httpClient *http.Client
+	logger     *slog.Logger
+	backoff    backoff
+	onState    func(connected bool, err error)
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithHTTPClient sets the HTTP client used for all requests, e.g. one that
+// authenticates to the Kubernetes API server's service proxy. The client
+// must not set an overall Timeout, which would cut off Watch streams.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithLogger sets the logger used to report Watch reconnects.
+func WithLogger(logger *slog.Logger) Option {
+	return func(c *Client) { c.logger = logger }
+}
+
+// WithReconnectBackoff sets the first and the longest delay between Watch
+// reconnect attempts. Delays double in between and are jittered.
+func WithReconnectBackoff(initial, max time.Duration) Option {
+	return func(c *Client) {
+		c.backoff.initial = initial
+		c.backoff.max = max
+	}
+}
+
+// WithConnectionStateHandler sets a function Watch calls whenever its event
+// stream connects or drops. err explains a drop or a failed (re)connect.
+// fn runs on the Watch goroutine and must not block.
+func WithConnectionStateHandler(fn func(connected bool, err error)) Option {
+	return func(c *Client) { c.onState = fn }
+}
+
+// New returns a client for the server at baseURL. baseURL may include a path
+// prefix, such as a Kubernetes service proxy path.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("pulltrace: invalid base URL: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("pulltrace: base URL %q must be http or https", baseURL)
+	}
+	u.Path = strings.TrimRight(u.Path, "/")
+
+	c := &Client{
+		base:       u,
+		httpClient: http.DefaultClient,
+		logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		backoff:    defaultBackoff,
+		onState:    func(bool, error) {},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// ListPulls returns every active and recently completed pull.
+func (c *Client) ListPulls(ctx context.Context) ([]PullStatus, error) {
+	var resp model.APIResponse
+	if err := c.getJSON(ctx, "/api/v1/pulls", &resp); err != nil {
+		return nil, err
+	}
+	return resp.Pulls, nil
+}
+
+// GetPull returns the pull with the given ID, or an error wrapping
+// ErrNotFound.
+func (c *Client) GetPull(ctx context.Context, id string) (*PullStatus, error) {
+	var pull PullStatus
+	if err := c.getJSON(ctx, "/api/v1/pulls/"+url.PathEscape(id), &pull); err != nil {
+		return nil, err
+	}
+	return &pull, nil
+}
+
+// GetPod returns the combined progress of the pulls a pod is waiting on, or
+// an error wrapping ErrNotFound if no pull is correlated with the pod.
+func (c *Client) GetPod(ctx context.Context, namespace, name string) (*PodStatus, error) {
+	var pod PodStatus
+	if err := c.getJSON(ctx, "/api/v1/pods/"+url.PathEscape(namespace)+"/"+url.PathEscape(name), &pod); err != nil {
+		return nil, err
+	}
+	return &pod, nil
+}
+
+// GetPodTimeline returns the startup timeline of a pod that waited on an
+// image, or an error wrapping ErrNotFound if the server does not track it.
+func (c *Client) GetPodTimeline(ctx context.Context, namespace, name string) (*PodTimeline, error) {
+	var tl PodTimeline
+	if err := c.getJSON(ctx, "/api/v1/pods/"+url.PathEscape(namespace)+"/"+url.PathEscape(name)+"/timeline", &tl); err != nil {
+		return nil, err
+	}
+	return &tl, nil
+}
+
+// ListWorkloads returns the pull progress of every workload whose pods have
+// active or recently completed pulls.
+func (c *Client) ListWorkloads(ctx context.Context) ([]WorkloadStatus, error) {
+	var resp model.WorkloadsResponse
+	if err := c.getJSON(ctx, "/api/v1/workloads", &resp); err != nil {
+		return nil, err
+	}
+	return resp.Workloads, nil
+}
+
+// GetWorkload returns the pull progress of a workload's pods across nodes.
+// kind is matched case-insensitively, so "deployment" finds a Deployment.
+func (c *Client) GetWorkload(ctx context.Context, namespace, kind, name string) (*WorkloadStatus, error) {
+	var wl WorkloadStatus
+	path := "/api/v1/workloads/" + url.PathEscape(namespace) + "/" + url.PathEscape(kind) + "/" + url.PathEscape(name)
+	if err := c.getJSON(ctx, path, &wl); err != nil {
+		return nil, err
+	}
+	return &wl, nil
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, v any) error {
+	body, err := c.get(ctx, path, nil)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(body).Decode(v); err != nil {
+		return fmt.Errorf("pulltrace: decoding %s: %w", path, err)
+	}
+	return nil
+}
+
+// get issues a GET for path and returns the body of a 200 response.
+func (c *Client) get(ctx context.Context, path string, header http.Header) (io.ReadCloser, error) {
+	// path is already escaped; keep IDs containing "/" as a single segment.
+	u := *c.base
+	u.Path = c.base.Path + unescape(path)
+	u.RawPath = c.base.EscapedPath() + path
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("pulltrace: GET %s: %w", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, &StatusError{
+			Method:     http.MethodGet,
+			Path:       path,
+			StatusCode: resp.StatusCode,
+			Message:    strings.TrimSpace(string(msg)),
+		}
+	}
+	return resp.Body, nil
+}
+
+func unescape(path string) string {
+	if p, err := url.PathUnescape(path); err == nil {
+		return p
+	}
+	return path
+}
@@ -0,0 +1,129 @@
+package client_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/internal/model"
+	"github.com/d44b/pulltrace/internal/server"
+	"github.com/d44b/pulltrace/pkg/client"
+)
+
+func newServer() *server.Server {
+	return server.New(server.Config{LogLevel: "error", HistoryTTL: 30 * time.Minute}, nil)
+}
+
+// report posts an agent report for node through the server's HTTP handler.
+func report(t *testing.T, h http.Handler, node string, images ...string) {
+	t.Helper()
+	r := model.AgentReport{
+		ProtocolVersion: model.ProtocolVersion,
+		NodeName:        node,
+		Timestamp:       time.Now(),
+	}
+	for _, img := range images {
+		r.Pulls = append(r.Pulls, model.PullState{
+			ImageRef:   img,
+			StartedAt:  time.Now(),
+			TotalKnown: true,
+			Layers: []model.LayerState{
+				{Digest: "sha256:aaa", TotalBytes: 1000, DownloadedBytes: 250, TotalKnown: true},
+			},
+		})
+	}
+	body, _ := json.Marshal(r)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v1/report", bytes.NewReader(body)))
+	if w.Code != http.StatusAccepted && w.Code != http.StatusOK {
+		t.Fatalf("report for %s: status %d: %s", node, w.Code, w.Body.String())
+	}
+}
+
+func TestNew_RejectsBadURL(t *testing.T) {
+	for _, u := range []string{"localhost:8080", "ftp://x", "://"} {
+		if _, err := client.New(u); err == nil {
+			t.Errorf("client.New(%q) succeeded", u)
+		}
+	}
+}
+
+func TestListPullsAndGetPull(t *testing.T) {
+	h := newServer().Handler()
+	report(t, h, "node1", "ghcr.io/d44b/app:v1", "nginx:1.27")
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	c, err := client.New(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	pulls, err := c.ListPulls(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pulls) != 2 {
+		t.Fatalf("ListPulls returned %d pulls, want 2", len(pulls))
+	}
+
+	for _, want := range pulls {
+		got, err := c.GetPull(ctx, want.ID)
+		if err != nil {
+			t.Fatalf("GetPull(%q): %v", want.ID, err)
+		}
+		if got.ID != want.ID || got.ImageRef != want.ImageRef || got.Percent != 25 {
+			t.Errorf("GetPull(%q) = %+v", want.ID, got)
+		}
+	}
+
+	_, err = c.GetPull(ctx, "node1:missing@1")
+	if !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("GetPull(unknown) error = %v, want client.ErrNotFound", err)
+	}
+	var se *client.StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusNotFound {
+		t.Errorf("error %v is not a 404 client.StatusError", err)
+	}
+
+	// Without a pod watcher no pull is correlated with a pod.
+	if _, err := c.GetPod(ctx, "default", "web-0"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("GetPod(uncorrelated) error = %v, want client.ErrNotFound", err)
+	}
+	if workloads, err := c.ListWorkloads(ctx); err != nil || len(workloads) != 0 {
+		t.Errorf("ListWorkloads() = %v, %v; want none", workloads, err)
+	}
+	if _, err := c.GetWorkload(ctx, "default", "deployment", "web"); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("GetWorkload(unknown) error = %v, want client.ErrNotFound", err)
+	}
+}
+
+func TestClient_PathPrefix(t *testing.T) {
+	h := newServer().Handler()
+	report(t, h, "node1", "nginx:1.27")
+	mux := http.NewServeMux()
+	mux.Handle("/proxy/", http.StripPrefix("/proxy", h))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	c, err := client.New(srv.URL + "/proxy/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pulls, err := c.ListPulls(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pulls) != 1 {
+		t.Fatalf("got %d pulls through the prefix, want 1", len(pulls))
+	}
+	if _, err := c.GetPull(context.Background(), pulls[0].ID); err != nil {
+		t.Errorf("GetPull through the prefix: %v", err)
+	}
+}
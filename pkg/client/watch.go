@@ -0,0 +1,202 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+// maxEventSize bounds a single server-sent event; pulls with many layers can
+// be large.
+const maxEventSize = 4 << 20
+
+// errStreamClosed reports an event stream the server ended cleanly.
+var errStreamClosed = errors.New("pulltrace: event stream closed by server")
+
+// WatchFilter selects the events Watch delivers. Empty fields match anything.
+type WatchFilter struct {
+	// NodeName matches pulls on this node.
+	NodeName string
+	// Namespace matches pulls correlated to at least one pod in this
+	// namespace. Pulls not yet correlated to a pod never match.
+	Namespace string
+	// ImageRef matches pulls of exactly this image reference.
+	ImageRef string
+	// Aggregates also delivers pod and workload events, which carry no
+	// pull and are not matched against the fields above.
+	Aggregates bool
+}
+
+func (f WatchFilter) matches(ev *PullEvent) bool {
+	if ev.Pull == nil {
+		return f.Aggregates && (ev.Pod != nil || ev.Workload != nil)
+	}
+	if f.NodeName != "" && ev.Pull.NodeName != f.NodeName {
+		return false
+	}
+	if f.ImageRef != "" && ev.Pull.ImageRef != f.ImageRef {
+		return false
+	}
+	if f.Namespace == "" {
+		return true
+	}
+	for _, pod := range ev.Pull.Pods {
+		if pod.Namespace == f.Namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch streams pull events matching filter, and pod and workload events if
+// filter.Aggregates is set. The first events describe every
+// pull the server currently knows about. If the stream drops, Watch
+// reconnects with backoff and resumes after the last event it received; if
+// the server can no longer replay from there (e.g. it restarted), it resends
+// the current state of every pull instead, so consumers should treat events
+// as idempotent updates keyed by PullStatus.ID.
+//
+// Watch returns an error only if the initial connection fails. The channel is
+// closed when ctx is cancelled.
+func (c *Client) Watch(ctx context.Context, filter WatchFilter) (<-chan PullEvent, error) {
+	body, err := c.openStream(ctx, "")
+	if err != nil {
+		c.onState(false, err)
+		return nil, err
+	}
+	c.onState(true, nil)
+	ch := make(chan PullEvent, 64)
+	go c.watch(ctx, body, filter, ch)
+	return ch, nil
+}
+
+func (c *Client) openStream(ctx context.Context, lastEventID string) (io.ReadCloser, error) {
+	header := http.Header{"Accept": {"text/event-stream"}}
+	if lastEventID != "" {
+		header.Set("Last-Event-ID", lastEventID)
+	}
+	return c.get(ctx, "/api/v1/events", header)
+}
+
+func (c *Client) watch(ctx context.Context, body io.ReadCloser, filter WatchFilter, ch chan<- PullEvent) {
+	defer close(ch)
+
+	var lastID string
+	attempt := 0
+	for {
+		if body != nil {
+			err := readEvents(body, func(id string, ev PullEvent) error {
+				if id != "" {
+					lastID = id
+				}
+				attempt = 0
+				if !filter.matches(&ev) {
+					return nil
+				}
+				select {
+				case ch <- ev:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			})
+			body.Close()
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				err = errStreamClosed
+			}
+			c.onState(false, err)
+			c.logger.Warn("pulltrace event stream ended, reconnecting", "error", err)
+		}
+
+		delay := c.backoff.delay(attempt)
+		attempt++
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		var err error
+		body, err = c.openStream(ctx, lastID)
+		if err != nil {
+			c.logger.Warn("pulltrace event stream reconnect failed", "attempt", attempt, "error", err)
+			c.onState(false, err)
+			body = nil
+		} else {
+			c.onState(true, nil)
+		}
+	}
+}
+
+// readEvents decodes a server-sent event stream, calling fn with each event
+// and its ID until r ends or fn returns an error. It returns nil at EOF.
+func readEvents(r io.Reader, fn func(id string, ev PullEvent) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxEventSize)
+
+	var id string
+	var data bytes.Buffer
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			if data.Len() == 0 {
+				continue
+			}
+			var ev PullEvent
+			if err := json.Unmarshal(data.Bytes(), &ev); err != nil {
+				return fmt.Errorf("decoding event: %w", err)
+			}
+			data.Reset()
+			if err := fn(id, ev); err != nil {
+				return err
+			}
+			continue
+		}
+
+		field, value, _ := bytes.Cut(line, []byte(":"))
+		value = bytes.TrimPrefix(value, []byte(" "))
+		switch string(field) {
+		case "data":
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.Write(value)
+		case "id":
+			id = string(value)
+		}
+		// Comments (empty field) and other fields are ignored.
+	}
+	return scanner.Err()
+}
+
+// backoff is a jittered exponential reconnect delay.
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+}
+
+var defaultBackoff = backoff{initial: 500 * time.Millisecond, max: 30 * time.Second}
+
+// delay returns the wait before reconnect attempt n (starting at 0): initial
+// doubled n times, capped at max, with ±20% jitter.
+func (b backoff) delay(attempt int) time.Duration {
+	d := b.initial
+	for i := 0; i < attempt && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	jitter := 0.2 * (2*rand.Float64() - 1)
+	return time.Duration(float64(d) * (1 + jitter))
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatchFilter_Namespace(t *testing.T) {
+	f := WatchFilter{Namespace: "prod"}
+	if f.matches(&PullEvent{Pull: &PullStatus{}}) {
+		t.Error("namespace filter matched an uncorrelated pull")
+	}
+	if !f.matches(&PullEvent{Pull: &PullStatus{Pods: []PodCorrelation{{Namespace: "prod"}}}}) {
+		t.Error("namespace filter rejected a pull with a pod in prod")
+	}
+}
+
+func TestWatchFilter_Aggregates(t *testing.T) {
+	pod := &PullEvent{Pod: &PodStatus{}}
+	if (WatchFilter{}).matches(pod) {
+		t.Error("pod event delivered without Aggregates")
+	}
+	f := WatchFilter{NodeName: "node1", Aggregates: true}
+	if !f.matches(pod) || !f.matches(&PullEvent{Workload: &WorkloadStatus{}}) {
+		t.Error("Aggregates rejected a pod or workload event")
+	}
+	if f.matches(&PullEvent{}) {
+		t.Error("Aggregates matched an event without a pull, pod or workload")
+	}
+}
+
+func TestReadEvents(t *testing.T) {
+	stream := ": connected\n\n" +
+		"id: e-1\n" +
+		"data: {\"type\":\"pull.progress\",\"nodeName\":\"node1\",\"pull\":{\"id\":\"a\",\"imageRef\":\"nginx\"}}\n\n" +
+		"event: ignored\n" +
+		"data:{\"type\":\"pull.completed\",\n" +
+		"data: \"nodeName\":\"node2\"}\n\n"
+
+	var ids []string
+	var got []PullEvent
+	err := readEvents(strings.NewReader(stream), func(id string, ev PullEvent) error {
+		ids = append(ids, id)
+		got = append(got, ev)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Pull.ImageRef != "nginx" || got[1].NodeName != "node2" {
+		t.Fatalf("got %+v", got)
+	}
+	// An event without its own id field keeps the last one, per the SSE spec.
+	if ids[0] != "e-1" || ids[1] != "e-1" {
+		t.Errorf("ids = %v", ids)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := backoff{initial: 100 * time.Millisecond, max: time.Second}
+	for attempt, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		want *= time.Millisecond
+		got := b.delay(attempt)
+		if got < want*8/10 || got > want*12/10 {
+			t.Errorf("delay(%d) = %v, want %v ±20%%", attempt, got, want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/d44b/pulltrace/pkg/client"
+)
+
+// swappableHandler lets a test replace the server behind a URL, as a restart
+// would, and records the Last-Event-ID of every event stream request.
+type swappableHandler struct {
+	h atomic.Value // http.Handler
+
+	mu           sync.Mutex
+	lastEventIDs []string
+}
+
+func (s *swappableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/v1/events" {
+		s.mu.Lock()
+		s.lastEventIDs = append(s.lastEventIDs, r.Header.Get("Last-Event-ID"))
+		s.mu.Unlock()
+	}
+	s.h.Load().(http.Handler).ServeHTTP(w, r)
+}
+
+func (s *swappableHandler) streams() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.lastEventIDs...)
+}
+
+func next(t *testing.T, ch <-chan client.PullEvent) client.PullEvent {
+	t.Helper()
+	select {
+	case ev, ok := <-ch:
+		if !ok {
+			t.Fatal("watch channel closed")
+		}
+		return ev
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for an event")
+	}
+	return client.PullEvent{}
+}
+
+func newWatchClient(t *testing.T, url string) *client.Client {
+	t.Helper()
+	c, err := client.New(url, client.WithReconnectBackoff(10*time.Millisecond, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestWatch_SnapshotThenLiveEvents(t *testing.T) {
+	h := newServer().Handler()
+	report(t, h, "node1", "nginx:1.27")
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := newWatchClient(t, srv.URL).Watch(ctx, client.WatchFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ev := next(t, events); ev.Pull.ImageRef != "nginx:1.27" || ev.Type != client.EventPullProgress {
+		t.Fatalf("snapshot event = %+v", ev)
+	}
+
+	report(t, h, "node2", "redis:7")
+	if ev := next(t, events); ev.Pull.ImageRef != "redis:7" || ev.NodeName != "node2" {
+		t.Fatalf("live event = %+v", ev)
+	}
+
+	cancel()
+	for range events {
+	}
+}
+
+func TestWatch_Filter(t *testing.T) {
+	h := newServer().Handler()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := newWatchClient(t, srv.URL).Watch(ctx, client.WatchFilter{NodeName: "node2", ImageRef: "redis:7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report(t, h, "node1", "redis:7")
+	report(t, h, "node2", "nginx:1.27", "redis:7")
+	if ev := next(t, events); ev.NodeName != "node2" || ev.Pull.ImageRef != "redis:7" {
+		t.Fatalf("filter let through %+v", ev)
+	}
+}
+
+func TestWatch_ResumesAfterDisconnect(t *testing.T) {
+	h := newServer().Handler()
+	report(t, h, "node1", "nginx:1.27")
+	sh := &swappableHandler{}
+	sh.h.Store(h)
+	srv := httptest.NewServer(sh)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := newWatchClient(t, srv.URL).Watch(ctx, client.WatchFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next(t, events) // snapshot of nginx
+
+	srv.CloseClientConnections()
+	report(t, h, "node2", "redis:7")
+
+	// Resuming replays only what was missed; a snapshot would repeat nginx.
+	if ev := next(t, events); ev.Pull.ImageRef != "redis:7" {
+		t.Fatalf("after reconnect got %+v, want the missed redis event", ev)
+	}
+	streams := sh.streams()
+	if len(streams) < 2 || streams[0] != "" || streams[len(streams)-1] == "" {
+		t.Errorf("Last-Event-ID per stream = %q, want empty then set", streams)
+	}
+}
+
+func TestWatch_SnapshotAfterServerRestart(t *testing.T) {
+	first := newServer().Handler()
+	report(t, first, "node1", "nginx:1.27")
+	sh := &swappableHandler{}
+	sh.h.Store(first)
+	srv := httptest.NewServer(sh)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := newWatchClient(t, srv.URL).Watch(ctx, client.WatchFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next(t, events)
+
+	// The restarted server has different state and cannot honour the old ID.
+	second := newServer().Handler()
+	report(t, second, "node3", "busybox:1.36")
+	sh.h.Store(second)
+	srv.CloseClientConnections()
+
+	if ev := next(t, events); ev.Pull.ImageRef != "busybox:1.36" {
+		t.Fatalf("after restart got %+v, want a snapshot of the new server", ev)
+	}
+}
+
+func TestWatch_ConnectionStateHandler(t *testing.T) {
+	h := newServer().Handler()
+	report(t, h, "node1", "nginx:1.27")
+	sh := &swappableHandler{}
+	sh.h.Store(h)
+	srv := httptest.NewServer(sh)
+	defer srv.Close()
+
+	states := make(chan bool, 16)
+	c, err := client.New(srv.URL,
+		client.WithReconnectBackoff(10*time.Millisecond, 50*time.Millisecond),
+		client.WithConnectionStateHandler(func(connected bool, err error) {
+			if connected != (err == nil) {
+				t.Errorf("state connected=%v with err=%v", connected, err)
+			}
+			states <- connected
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := c.Watch(ctx, client.WatchFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next(t, events)
+
+	srv.CloseClientConnections()
+	var got []bool
+	for len(got) < 3 {
+		select {
+		case s := <-states:
+			got = append(got, s)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("states = %v, want connect, drop, reconnect", got)
+		}
+	}
+	if !got[0] || got[1] || !got[2] {
+		t.Errorf("states = %v, want [true false true]", got)
+	}
+}
+
+func TestWatch_InitialConnectError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "too many connections", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	_, err := newWatchClient(t, srv.URL).Watch(context.Background(), client.WatchFilter{})
+	if err == nil || !strings.Contains(err.Error(), "too many connections") {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -1,7 +1,7 @@
 import React, { useState, useMemo } from 'react';
 import FilterBar from './components/FilterBar';
 import PullRow from './components/PullRow';
-import { usePulls, useFilters } from './hooks';
+import { usePulls, useFilters, useClusters } from './hooks';
 
 const LogoIcon = ({ size = 20 }) => (
   <svg width={size} height={size} viewBox="0 0 256 256" fill="none" strokeLinecap="round" strokeLinejoin="round">
@@ -33,8 +33,12 @@ function speedToBarPct(bps) {
 }
 
 export default function App() {
-  const { pulls, connected } = usePulls();
+  const { pulls, pods, connected } = usePulls();
   const { filters, setFilter, filterPulls } = useFilters();
+  const clusters = useClusters();
+  const federated = clusters.some((c) => !c.local);
+  const downClusters = useMemo(() => clusters.filter((c) => !c.connected), [clusters]);
+  const downNames = useMemo(() => new Set(downClusters.map((c) => c.name)), [downClusters]);
   const [expandedIds, setExpandedIds] = useState(new Set());
 
   const filteredPulls = useMemo(() => {
@@ -84,6 +88,20 @@ export default function App() {
         </div>
       </header>
 
+      {/* ── Federated cluster outages ── */}
+      {downClusters.length > 0 && (
+        <div className="cluster-banner" role="alert">
+          {downClusters.map((c) => (
+            <div key={c.name}>
+              <strong>{c.name}</strong> is unreachable
+              {c.since && ` since ${new Date(c.since).toLocaleTimeString()}`}
+              {c.error && <span className="cluster-banner-error"> — {c.error}</span>}
+              . Its pulls are shown as last seen.
+            </div>
+          ))}
+        </div>
+      )}
+
       {/* ── Speed panel ── */}
       <div className="speed-panel">
         <div className="speed-hero">
@@ -120,7 +138,7 @@ export default function App() {
       </div>
 
       {/* ── Filters ── */}
-      <FilterBar filters={filters} setFilter={setFilter} />
+      <FilterBar filters={filters} setFilter={setFilter} showCluster={federated} />
 
       {/* ── Pull list ── */}
       {filteredPulls.length === 0 ? (
@@ -138,7 +156,7 @@ export default function App() {
           <div className="table-header">
             <div />
             <div className="th">Image</div>
-            <div className="th">Node</div>
+            <div className="th">{federated ? 'Cluster / Node' : 'Node'}</div>
             <div className="th">Progress</div>
             <div className="th">%</div>
             <div className="th">Speed</div>
@@ -150,8 +168,10 @@ export default function App() {
             <PullRow
               key={pull.id}
               pull={pull}
+              pods={pods}
               expanded={expandedIds.has(pull.id)}
               onToggle={() => toggleExpand(pull.id)}
+              stale={downNames.has(pull.cluster)}
             />
           ))}
         </div>
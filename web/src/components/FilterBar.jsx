@@ -6,6 +6,8 @@ const SearchIcon = () => (
   </svg>
 );
 
+const CLUSTER_FIELD = { key: 'cluster', placeholder: 'Cluster…' };
+
 const FIELDS = [
   { key: 'image',     placeholder: 'Image…'     },
   { key: 'node',      placeholder: 'Node…'      },
@@ -13,10 +15,11 @@ const FIELDS = [
   { key: 'pod',       placeholder: 'Pod…'       },
 ];
 
-export default function FilterBar({ filters, setFilter }) {
+export default function FilterBar({ filters, setFilter, showCluster }) {
+  const fields = showCluster ? [CLUSTER_FIELD, ...FIELDS] : FIELDS;
   return (
     <div className="filter-bar">
-      {FIELDS.map(({ key, placeholder }) => (
+      {fields.map(({ key, placeholder }) => (
         <div className="filter-input-wrap" key={key}>
           <span className="filter-icon"><SearchIcon /></span>
           <input
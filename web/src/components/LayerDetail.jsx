@@ -23,7 +23,7 @@ export default function LayerDetail({ layers }) {
               <div className="layer-bar-wrap">
                 <div className="layer-bar">
                   <div
-                    className={`layer-bar-fill${done ? ' done' : ''}`}
+                    className={`layer-bar-fill${done ? ' done' : layer.stalled ? ' stalled' : ''}`}
                     style={{ width: `${pct}%`, transition: 'width 0.4s ease' }}
                   />
                 </div>
@@ -31,7 +31,9 @@ export default function LayerDetail({ layers }) {
               <span className="layer-bytes">
                 {formatBytes(layer.downloadedBytes)} / {layer.totalKnown ? formatBytes(layer.totalBytes) : '?'}
               </span>
-              {speed && <span className="layer-speed-text">{speed}</span>}
+              {layer.stalled
+                ? <span className="layer-speed-text stalled">stalled</span>
+                : speed && <span className="layer-speed-text">{speed}</span>}
             </div>
           );
         })}
@@ -1,6 +1,7 @@
 import React, { useRef } from 'react';
 import LayerDetail from './LayerDetail';
 import { formatBytes, formatEta, parseImageRef, getPullStatus } from '../utils';
+import { podKey } from '../hooks';
 
 const ChevronIcon = () => (
   <svg width="11" height="11" viewBox="0 0 24 24" fill="none" stroke="currentColor" strokeWidth="2.5" strokeLinecap="round" strokeLinejoin="round">
@@ -16,7 +17,14 @@ function formatSpeed(bps) {
   return `${(bps / Math.pow(k, i)).toFixed(i > 0 ? 1 : 0)} ${units[i]}/s`;
 }
 
-export default function PullRow({ pull, expanded, onToggle }) {
+// Labels for the parts of a pod other than app containers that wait on a pull.
+const POD_KIND_LABELS = {
+  initContainer: 'init',
+  ephemeralContainer: 'debug',
+  imageVolume: 'volume',
+};
+
+export default function PullRow({ pull, pods, expanded, onToggle, stale }) {
   const status = getPullStatus(pull);
   const img = parseImageRef(pull.imageRef);
   const isResolving = pull.imageRef === '__pulling__';
@@ -43,6 +51,7 @@ export default function PullRow({ pull, expanded, onToggle }) {
   const effectiveStatus = (displayPct >= 100 && status !== 'error') ? 'completed' : status;
   const showLive = (effectiveStatus === 'progress' || effectiveStatus === 'unknown') && displayPct < 100;
   const speed = showLive ? formatSpeed(pull.bytesPerSec) : null;
+  const stalled = effectiveStatus === 'stalled';
 
   // Elapsed time: use completedAt if available, otherwise use current time for
   // pulls that reached 100% (so the field is never empty for finished pulls).
@@ -56,7 +65,7 @@ export default function PullRow({ pull, expanded, onToggle }) {
 
   return (
     <>
-      <div className="pull-row" onClick={onToggle} role="button" tabIndex={0}
+      <div className={`pull-row${stale ? ' stale' : ''}`} onClick={onToggle} role="button" tabIndex={0}
         onKeyDown={(e) => e.key === 'Enter' && onToggle()}>
 
         {/* Status dot — uses effectiveStatus so 100% flips green immediately */}
@@ -78,7 +87,10 @@ export default function PullRow({ pull, expanded, onToggle }) {
         </div>
 
         {/* Node */}
-        <div className="row-node">{pull.nodeName || '—'}</div>
+        <div className="row-node">
+          {pull.cluster && <span className="row-cluster">{pull.cluster}/</span>}
+          {pull.nodeName || '—'}
+        </div>
 
         {/* Progress bar — uses effectiveStatus for color, displayPct for width */}
         <div className="row-bar-track">
@@ -92,9 +104,9 @@ export default function PullRow({ pull, expanded, onToggle }) {
             : '—'}
         </div>
 
-        {/* Speed */}
-        <div className={`row-speed${!speed ? ' zero' : ''}`}>
-          {speed || '—'}
+        {/* Speed, or a stalled marker while no layer is moving */}
+        <div className={`row-speed${stalled ? ' stalled' : !speed ? ' zero' : ''}`}>
+          {stalled ? 'Stalled' : speed || '—'}
         </div>
 
         {/* Size */}
@@ -121,10 +133,55 @@ export default function PullRow({ pull, expanded, onToggle }) {
           {pull.pods && pull.pods.length > 0 && (
             <div className="detail-pods">
               <span className="detail-pods-label">Pods</span>
-              {pull.pods.map((pod, i) => (
-                <span className="pod-chip" key={i}>
-                  <span className="pod-ns">{pod.namespace}/</span>
-                  <span className="pod-name">{pod.podName}</span>
+              {pull.pods.map((pod, i) => {
+                // Pods waiting on several images show their combined progress.
+                const agg = pods?.[podKey(pull.cluster, pod.namespace, pod.podName)];
+                return (
+                  <span className="pod-chip" key={i}>
+                    <span className="pod-ns">{pod.namespace}/</span>
+                    <span className="pod-name">{pod.podName}</span>
+                    {POD_KIND_LABELS[pod.kind] && (
+                      <span className="pod-kind" title={pod.container}>{POD_KIND_LABELS[pod.kind]}</span>
+                    )}
+                    {agg && agg.pullCount > 1 && (
+                      <span className="pod-agg">
+                        {agg.completedAt
+                          ? `${agg.pullCount} images`
+                          : `${Math.round(agg.percent)}% of ${agg.pullCount} images${agg.etaSeconds > 0 ? `, ETA ${formatEta(agg.etaSeconds)}` : ''}`}
+                      </span>
+                    )}
+                  </span>
+                );
+              })}
+            </div>
+          )}
+          {pull.digest && (
+            <div className="detail-pods">
+              <span className="detail-pods-label">Digest</span>
+              <span className="kubelet-text" title={pull.digest}>{pull.digest.slice(0, 19)}</span>
+            </div>
+          )}
+          {pull.retries && (
+            <div className="detail-pods">
+              <span className="detail-pods-label">Retries</span>
+              <span className="kubelet-text">
+                {`${pull.retries.attempts} attempts`}
+                {pull.retries.backOffs > 0 && `, ${pull.retries.backOffs} back-offs`}
+                {pull.retries.backoffSeconds > 0 && `, last ${formatEta(pull.retries.backoffSeconds)} apart`}
+              </span>
+            </div>
+          )}
+          {pull.kubelet && (
+            <div className="detail-pods">
+              <span className="detail-pods-label">Kubelet</span>
+              <span className="kubelet-text">
+                {`pulled in ${formatEta(pull.kubelet.durationSeconds)}`}
+                {pull.kubelet.waitingSeconds > 0 && `, queued ${formatEta(pull.kubelet.waitingSeconds)}`}
+                {pull.kubelet.sizeBytes > 0 && `, ${formatBytes(pull.kubelet.sizeBytes)}`}
+              </span>
+              {pull.discrepancies?.map((d) => (
+                <span className="discrepancy" key={d}>
+                  {d === 'missed' ? 'not seen by agent' : `${d} mismatch`}
                 </span>
               ))}
             </div>
@@ -1,7 +1,13 @@
 import { useState, useEffect, useRef, useCallback } from 'react';
 
+// podKey identifies a pod aggregate; pulls look theirs up by the same key.
+export function podKey(cluster, namespace, name) {
+  return `${cluster || ''}|${namespace}/${name}`;
+}
+
 export function usePulls() {
   const [pulls, setPulls] = useState([]);
+  const [pods, setPods] = useState({});
   const [connected, setConnected] = useState(false);
   const eventSourceRef = useRef(null);
 
@@ -41,6 +47,9 @@ export function usePulls() {
               next[idx] = pull;
               return next;
             });
+          } else if (evt.pod) {
+            const pod = evt.pod;
+            setPods((prev) => ({ ...prev, [podKey(pod.cluster, pod.namespace, pod.name)]: pod }));
           }
         } catch {
           // ignore parse errors
@@ -61,11 +70,38 @@ export function usePulls() {
     };
   }, []);
 
-  return { pulls, connected };
+  return { pulls, pods, connected };
+}
+
+// Polls the cluster list so federated downstream outages show up even when
+// no pull events arrive.
+export function useClusters(intervalMs = 5000) {
+  const [clusters, setClusters] = useState([]);
+
+  useEffect(() => {
+    let cancelled = false;
+    function load() {
+      fetch('/api/v1/clusters')
+        .then((res) => res.json())
+        .then((data) => {
+          if (!cancelled) setClusters(data.clusters || []);
+        })
+        .catch(() => {});
+    }
+    load();
+    const timer = setInterval(load, intervalMs);
+    return () => {
+      cancelled = true;
+      clearInterval(timer);
+    };
+  }, [intervalMs]);
+
+  return clusters;
 }
 
 export function useFilters() {
   const [filters, setFilters] = useState({
+    cluster: '',
     namespace: '',
     node: '',
     pod: '',
@@ -79,6 +115,9 @@ export function useFilters() {
   const filterPulls = useCallback(
     (pulls) => {
       return pulls.filter((pull) => {
+        if (filters.cluster && !pull.cluster?.toLowerCase().includes(filters.cluster.toLowerCase())) {
+          return false;
+        }
         if (filters.image && !pull.imageRef?.toLowerCase().includes(filters.image.toLowerCase())) {
           return false;
         }
@@ -53,6 +53,7 @@ export function parseImageRef(ref) {
 export function getPullStatus(pull) {
   if (pull.error) return 'error';
   if (pull.completedAt) return 'completed';
+  if (pull.stalled) return 'stalled';
   if (!pull.totalKnown || pull.imageRef === '__pulling__') return 'unknown';
   return 'progress';
 }